@@ -9,17 +9,25 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/alert"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/baseline"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/config"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/explain"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/redact"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/report"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/rollup"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/schema"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/seasonal"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/selftest"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/silence"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/storage"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/watch"
 )
@@ -54,6 +62,26 @@ func main() {
 		if err := runSelftest(os.Args[2:]); err != nil {
 			exitErr(err)
 		}
+	case "merge":
+		if err := runMerge(os.Args[2:]); err != nil {
+			exitErr(err)
+		}
+	case "compact":
+		if err := runCompact(os.Args[2:]); err != nil {
+			exitErr(err)
+		}
+	case "baseline":
+		if err := runBaseline(os.Args[2:]); err != nil {
+			exitErr(err)
+		}
+	case "silence":
+		if err := runSilence(os.Args[2:]); err != nil {
+			exitErr(err)
+		}
+	case "schema":
+		if err := runSchema(os.Args[2:]); err != nil {
+			exitErr(err)
+		}
 	case "version":
 		fmt.Println(version)
 	case "help", "-h", "--help":
@@ -74,6 +102,11 @@ func usage() {
 	  epagent analyze [flags]
 	  epagent report [flags]
 	  epagent selftest [flags]
+	  epagent merge [flags] <file>...
+	  epagent compact [flags]
+	  epagent baseline export [flags]
+	  epagent silence add|list|expire [flags]
+	  epagent schema [sample|alert|rollup|seasonal|baseline]
 	  epagent version
 
 	Commands:
@@ -82,6 +115,11 @@ func usage() {
 	  analyze   Detect anomalies from collected samples (text or JSON output).
 	  report    Generate a Markdown report with explanations (use --out - for stdout).
 	  selftest  Validate host metric availability and estimate collection overhead.
+	  merge     Combine sample files from many hosts, dedupe, and sort by host and time.
+	  compact   Roll up old raw samples into 1-minute and 1-hour aggregates.
+	  baseline  Export per-metric baselines from samples for --baseline on another host.
+	  silence   Add, list or expire silences that suppress alerts during known events.
+	  schema    Print the JSON Schema for sample, alert, rollup, seasonal model, or baseline records.
 	  version   Print the agent version.
 
 	Run "epagent <command> -h" for command-specific flags.`)
@@ -146,6 +184,11 @@ func runCollect(args []string) error {
 	if err := os.MkdirAll(filepath.Dir(cfg.OutputPath), 0o755); err != nil {
 		return err
 	}
+	if !*truncate {
+		if err := repairSampleFileTail(cfg.OutputPath); err != nil {
+			return err
+		}
+	}
 
 	writer, err := storage.NewWriterWithOptions(cfg.OutputPath, !*truncate)
 	if err != nil {
@@ -154,6 +197,7 @@ func runCollect(args []string) error {
 	defer writer.Close()
 
 	sampler := collector.NewSampler(cfg.HostID, cfg.Labels, cfg.ProcessAttribution, toCollectorMetrics(cfg.Metrics))
+	sampler.SetMounts(cfg.DiskMounts)
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
@@ -198,21 +242,26 @@ func runAnalyze(args []string) error {
 	fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
 	in := fs.String("in", "", "Input JSONL path")
+	configPath := fs.String("config", "", "Path to config file (JSON) for detector, threshold and static-threshold settings")
 	window := fs.Int("window", 0, "Rolling window size override")
 	threshold := fs.Float64("threshold", 0, "Z-score threshold override")
 	format := fs.String("format", "text", "Output format: text|json|ndjson")
-	minSeverity := fs.String("min-severity", "low", "Minimum severity: low|medium|high|critical")
+	minSeverity := fs.String("min-severity", "", "Minimum severity: low|medium|high|critical or a configured severity level (empty = the lowest level)")
 	top := fs.Int("top", 0, "Limit to top N anomalies by absolute z-score (0 = no limit)")
 	last := fs.Duration("last", 0, "Analyze only the last duration of samples (relative to the file's last sample timestamp)")
 	sinceStr := fs.String("since", "", "Include samples at or after this RFC3339 timestamp (e.g. 2026-02-09T00:00:00Z)")
 	untilStr := fs.String("until", "", "Include samples at or before this RFC3339 timestamp (e.g. 2026-02-09T00:01:00Z)")
 	var metricFamilies stringListFlag
 	fs.Var(&metricFamilies, "metric", "Include only these metric families in output (repeatable): cpu|mem|disk|net")
-	var staticThresholds staticThresholdsFlag
-	fs.Var(&staticThresholds, "static-threshold", "Static upper threshold rule (repeatable): metric=value (metric: cpu_percent|mem_used_percent|disk_used_percent|disk_read_bytes_per_sec|disk_write_bytes_per_sec|net_rx_bytes_per_sec|net_tx_bytes_per_sec)")
+	ruleOpts := addRuleFlags(fs)
 	sink := fs.String("sink", "stdout", "Alert sink for --format ndjson: stdout|syslog")
 	syslogTag := fs.String("syslog-tag", "epagent", "Syslog tag (when --sink syslog)")
 	redactMode := fs.String("redact", "", "Redact sensitive fields in output: omit|hash (empty = no redaction)")
+	tolerant := fs.Bool("tolerant", false, "Skip malformed JSONL lines anywhere in the input (a malformed final line is always skipped)")
+	quarantine := fs.String("quarantine", "", "Append skipped malformed lines to this file for later inspection")
+	fleet := fs.Bool("fleet", false, "Analyze each host_id separately and produce a fleet report ranking the worst hosts")
+	partitionLabel := fs.String("partition-label", "", "Also partition fleet analysis by this label key (e.g. service); implies --fleet")
+	saveSeasonal := fs.String("save-seasonal", "", "Train seasonal baselines (per host, by hour of day and hour of week) from the input and write them to this file for --seasonal-model")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -236,7 +285,7 @@ func runAnalyze(args []string) error {
 		return errors.New("cannot combine --last with --since/--until")
 	}
 
-	cfg, err := config.Load("")
+	cfg, err := config.Load(*configPath)
 	if err != nil {
 		return err
 	}
@@ -255,62 +304,82 @@ func runAnalyze(args []string) error {
 		return errors.New("input path is required")
 	}
 
-	samples, err := storage.ReadSamples(inputPath)
+	input, err := loadAnalysisInput(inputPath, *tolerant, *quarantine, *last, *sinceStr, *untilStr)
 	if err != nil {
 		return err
 	}
 
-	if *last > 0 && len(samples) > 0 {
-		maxTS := samples[0].Timestamp
-		for _, s := range samples[1:] {
-			if s.Timestamp.After(maxTS) {
-				maxTS = s.Timestamp
-			}
-		}
-		since := maxTS.Add(-*last)
-		samples, err = report.FilterSamplesByTime(samples, since, maxTS)
-		if err != nil {
-			return err
-		}
-	} else {
-		since, err := parseRFC3339TimeFlag("since", *sinceStr)
-		if err != nil {
-			return err
-		}
-		until, err := parseRFC3339TimeFlag("until", *untilStr)
-		if err != nil {
-			return err
-		}
-		samples, err = report.FilterSamplesByTime(samples, since, until)
-		if err != nil {
-			return err
-		}
-	}
-
 	windowSize, zScoreThreshold := report.NormalizeParams(cfg.WindowSize, cfg.ZScoreThreshold)
-	mergedStaticThresholds := cfg.StaticThresholds
-	if staticThresholds.Any() {
-		mergedStaticThresholds = mergeStaticThresholds(cfg.StaticThresholds, staticThresholds.Values())
+	rules, algorithms, err := ruleOpts.apply(&cfg)
+	if err != nil {
+		return err
 	}
-	result := report.Analyze(samples, windowSize, zScoreThreshold, mergedStaticThresholds)
-	result, err = report.ApplyFilters(result, *minSeverity, *top)
+	model, err := loadSeasonalModel(cfg.SeasonalModel)
 	if err != nil {
 		return err
 	}
-	if metricFamilies.Any() {
-		m, err := config.ParseMetricFamilies(metricFamilies.Values())
-		if err != nil {
+	reference, err := loadBaseline(cfg.Baseline)
+	if err != nil {
+		return err
+	}
+	explainer, err := loadExplainer(cfg.Locale, cfg.ExplanationTemplates)
+	if err != nil {
+		return err
+	}
+	silences, err := loadSilences(cfg.SilencesFile, cfg.Silences)
+	if err != nil {
+		return err
+	}
+	opts := report.Options{
+		WindowSize: windowSize,
+		Threshold:  zScoreThreshold,
+		Rules:      rules,
+		Algorithms: algorithms,
+		Seasonal:   model,
+		Reference:  reference,
+		Rollups:    input.Rollups,
+		Explainer:  explainer,
+		Silences:   silences,
+
+		IncidentWindow: cfg.IncidentWindow,
+		MinSeverities:  cfg.MinSeverities,
+	}
+	if *saveSeasonal != "" {
+		trained := seasonal.Train(input.Samples)
+		if err := seasonal.Save(*saveSeasonal, trained); err != nil {
 			return err
 		}
-		result = report.FilterByMetricFamilies(result, toCollectorMetrics(m))
+		fmt.Fprintf(os.Stderr, "wrote seasonal model for %d host(s) from %d samples to %s\n", len(trained.Hosts), trained.Samples, *saveSeasonal)
 	}
-	if mode != redact.None {
-		result.HostID = redact.HostID(result.HostID, mode)
-		result.Labels = redact.Labels(result.Labels, mode)
-		for i := range result.Anomalies {
-			result.Anomalies[i].Labels = redact.Labels(result.Anomalies[i].Labels, mode)
+	post := resultFilter{minSeverity: *minSeverity, top: *top, families: metricFamilies.Values(), mode: mode}
+	if *fleet || *partitionLabel != "" {
+		fleetResult, err := post.applyFleet(report.AnalyzeFleet(input.Samples, opts, *partitionLabel))
+		if err != nil {
+			return err
 		}
+		fleetResult.SkippedLines = input.SkippedLines
+		switch *format {
+		case "text":
+			fmt.Print(report.FormatFleetSummary(fleetResult))
+			return nil
+		case "json":
+			payload, err := report.FormatFleetJSON(fleetResult)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(payload))
+			return nil
+		case "ndjson":
+			return emitAnomalies(report.FleetAnomalies(fleetResult), "", nil, cfg.IncidentWindow, explainer, *sink, *syslogTag)
+		default:
+			return fmt.Errorf("unknown format: %s (expected text|json|ndjson)", *format)
+		}
+	}
+	result, err := post.apply(report.AnalyzeWithOptions(input.Samples, opts))
+	if err != nil {
+		return err
 	}
+	result.SkippedLines = input.SkippedLines
 	switch *format {
 	case "text":
 		fmt.Println(report.FormatSummary(result))
@@ -323,46 +392,7 @@ func runAnalyze(args []string) error {
 		fmt.Println(string(payload))
 		return nil
 	case "ndjson":
-		var alertSink alert.Sink
-		switch *sink {
-		case "stdout":
-			alertSink = alert.NewStdoutSink(os.Stdout)
-		case "syslog":
-			s, err := alert.NewSyslogSink(*syslogTag)
-			if err != nil {
-				return err
-			}
-			alertSink = s
-			defer alertSink.Close()
-		default:
-			return fmt.Errorf("unknown sink: %s (expected stdout|syslog)", *sink)
-		}
-
-		for _, a := range result.Anomalies {
-			labels := a.Labels
-			if len(labels) == 0 {
-				labels = result.Labels
-			}
-			if err := alertSink.Emit(context.Background(), alert.Alert{
-				Timestamp:     a.Timestamp,
-				HostID:        result.HostID,
-				Labels:        labels,
-				Metric:        a.Name,
-				Value:         a.Value,
-				RuleType:      a.RuleType,
-				Threshold:     a.Threshold,
-				Mean:          a.Mean,
-				Stddev:        a.Stddev,
-				ZScore:        a.ZScore,
-				Severity:      a.Severity,
-				Explanation:   a.Explanation,
-				TopCPUProcess: a.TopCPUProcess,
-				TopMemProcess: a.TopMemProcess,
-			}); err != nil {
-				return err
-			}
-		}
-		return nil
+		return emitAnomalies(result.Anomalies, result.HostID, result.Labels, cfg.IncidentWindow, explainer, *sink, *syslogTag)
 	default:
 		return fmt.Errorf("unknown format: %s (expected text|json|ndjson)", *format)
 	}
@@ -387,15 +417,18 @@ func runWatch(args []string) error {
 	fs.Var(&labels, "label", "Key/value label (repeatable): k=v")
 	window := fs.Int("window", cfg.WindowSize, "Rolling window size")
 	threshold := fs.Float64("threshold", cfg.ZScoreThreshold, "Z-score threshold")
-	minSeverity := fs.String("min-severity", "medium", "Minimum severity to emit: low|medium|high|critical")
+	minSeverity := fs.String("min-severity", "medium", "Minimum severity to emit: low|medium|high|critical or a configured severity level")
 	sink := fs.String("sink", "stdout", "Alert sink: stdout|syslog")
 	syslogTag := fs.String("syslog-tag", "epagent", "Syslog tag (when --sink syslog)")
 	redactMode := fs.String("redact", "", "Redact sensitive fields in alerts: omit|hash (empty = no redaction)")
 	cooldown := fs.Duration("cooldown", 30*time.Second, "Per-metric alert cooldown (0 = no dedupe)")
 	metrics := fs.String("metrics", "", "Comma-separated metric families to enable: cpu,mem,disk,net (empty = config/defaults)")
-	var staticThresholds staticThresholdsFlag
-	fs.Var(&staticThresholds, "static-threshold", "Static upper threshold rule (repeatable): metric=value (metric: cpu_percent|mem_used_percent|disk_used_percent|disk_read_bytes_per_sec|disk_write_bytes_per_sec|net_rx_bytes_per_sec|net_tx_bytes_per_sec)")
+	ruleOpts := addRuleFlags(fs)
 	processAttribution := fs.Bool("process-attribution", cfg.ProcessAttribution, "Capture per-sample top CPU/memory process attribution (can be expensive)")
+	statePath := fs.String("state", "", "Path to checkpoint detector state to and restore it from on startup (empty = no state)")
+	checkpointInterval := fs.Duration("checkpoint-interval", time.Minute, "How often to checkpoint --state (0 = every sample)")
+	stateMaxAge := fs.Duration("state-max-age", time.Hour, "Discard --state older than this on startup (0 = never)")
+	warm := fs.Int("warm", 0, "Warm the baseline from the last N samples of --out when no --state was restored")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -418,6 +451,18 @@ func runWatch(args []string) error {
 	if *cooldown < 0 {
 		return errors.New("cooldown must be greater than or equal to zero")
 	}
+	if *checkpointInterval < 0 {
+		return errors.New("checkpoint-interval must be greater than or equal to zero")
+	}
+	if *stateMaxAge < 0 {
+		return errors.New("state-max-age must be greater than or equal to zero")
+	}
+	if *warm < 0 {
+		return errors.New("warm must be greater than or equal to zero")
+	}
+	if *warm > 0 && (*out == "" || *out == "-") {
+		return errors.New("--warm requires --out to name a sample file")
+	}
 
 	cfg.Interval = *interval
 	cfg.Duration = *duration
@@ -437,21 +482,69 @@ func runWatch(args []string) error {
 		}
 		cfg.Metrics = m
 	}
-	mergedStaticThresholds := cfg.StaticThresholds
-	if staticThresholds.Any() {
-		mergedStaticThresholds = mergeStaticThresholds(cfg.StaticThresholds, staticThresholds.Values())
+	rules, algorithms, err := ruleOpts.apply(&cfg)
+	if err != nil {
+		return err
+	}
+	model, err := loadSeasonalModel(cfg.SeasonalModel)
+	if err != nil {
+		return err
+	}
+	algorithms.Profiles = model.Profiles(cfg.HostID)
+	reference, err := loadBaseline(cfg.Baseline)
+	if err != nil {
+		return err
+	}
+	explainer, err := loadExplainer(cfg.Locale, cfg.ExplanationTemplates)
+	if err != nil {
+		return err
+	}
+	silences, err := silence.NewReloader(cfg.SilencesFile, cfg.Silences)
+	if err != nil {
+		return err
 	}
+	algorithms = reference.Apply(algorithms)
 
 	if cfg.Interval <= 0 {
 		return errors.New("interval must be greater than zero")
 	}
 
+	sampler := collector.NewSampler(cfg.HostID, cfg.Labels, cfg.ProcessAttribution, toCollectorMetrics(cfg.Metrics))
+	sampler.SetMounts(cfg.DiskMounts)
+
+	engine, err := watch.NewEngineWithOptions(watch.EngineOptions{
+		WindowSize:  cfg.WindowSize,
+		Threshold:   cfg.ZScoreThreshold,
+		Rules:       rules,
+		MinSeverity: *minSeverity,
+		Cooldown:    *cooldown,
+		Algorithms:  algorithms,
+
+		MinSeverities:  cfg.MinSeverities,
+		Cooldowns:      cfg.Cooldowns,
+		IncidentWindow: cfg.IncidentWindow,
+		Explainer:      explainer,
+		Silences:       silences.List(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := resumeWatch(engine, cfg.HostID, *statePath, *stateMaxAge, *out, *warm); err != nil {
+		return err
+	}
+
 	var writer watch.SampleWriter
 	var writerCloser interface{ Close() error }
 	if *out != "" {
 		if err := os.MkdirAll(filepath.Dir(*out), 0o755); err != nil {
 			return err
 		}
+		if !*truncate {
+			if err := repairSampleFileTail(*out); err != nil {
+				return err
+			}
+		}
 		w, err := storage.NewWriterWithOptions(*out, !*truncate)
 		if err != nil {
 			return err
@@ -461,13 +554,6 @@ func runWatch(args []string) error {
 		defer writerCloser.Close()
 	}
 
-	sampler := collector.NewSampler(cfg.HostID, cfg.Labels, cfg.ProcessAttribution, toCollectorMetrics(cfg.Metrics))
-
-	engine, err := watch.NewEngine(cfg.WindowSize, cfg.ZScoreThreshold, mergedStaticThresholds, *minSeverity, *cooldown)
-	if err != nil {
-		return err
-	}
-
 	var alertSink alert.Sink
 	switch *sink {
 	case "stdout":
@@ -496,10 +582,188 @@ func runWatch(args []string) error {
 		Interval: cfg.Interval,
 		Duration: cfg.Duration,
 		Writer:   writer,
+
+		StatePath:          *statePath,
+		CheckpointInterval: *checkpointInterval,
+		Silences:           silences,
 	}
 	return runner.Run(ctx)
 }
 
+// resumeWatch restores engine from statePath, or, when there is no usable
+// state, warms it from the last warm samples of the --out file. Stale or
+// mismatched state is reported and ignored rather than failing the watch.
+func resumeWatch(engine *watch.Engine, hostID, statePath string, maxAge time.Duration, outPath string, warm int) error {
+	if statePath != "" {
+		state, err := watch.LoadState(statePath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "warning: ignoring watch state: %v\n", err)
+		default:
+			if err := engine.Restore(state, hostID, time.Now(), maxAge); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: ignoring watch state %s: %v\n", statePath, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "restored watch state from %s (saved %s)\n", statePath, state.SavedAt.Format(time.RFC3339))
+				return nil
+			}
+		}
+	}
+	if warm == 0 {
+		return nil
+	}
+	samples, err := storage.ReadSamplesTail(outPath, warm, hostID)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("warm from %s: %w", outPath, err)
+	}
+	engine.Warm(samples)
+	fmt.Fprintf(os.Stderr, "warmed baseline from %d samples in %s\n", len(samples), outPath)
+	return nil
+}
+
+// emitAnomalies sends one alert per incident, with its anomalies nested, and
+// then one alert per remaining anomaly to the named sink. hostID and labels
+// are fallbacks for anomalies that do not carry their own.
+func emitAnomalies(anomalies []anomaly.Anomaly, hostID string, labels map[string]string, incidentWindow time.Duration, explainer *explain.Explainer, sink, syslogTag string) error {
+	var alertSink alert.Sink
+	switch sink {
+	case "stdout":
+		alertSink = alert.NewStdoutSink(os.Stdout)
+	case "syslog":
+		s, err := alert.NewSyslogSink(syslogTag)
+		if err != nil {
+			return err
+		}
+		alertSink = s
+		defer alertSink.Close()
+	default:
+		return fmt.Errorf("unknown sink: %s (expected stdout|syslog)", sink)
+	}
+
+	incidents, rest := anomaly.GroupIncidents(anomalies, incidentWindow)
+	for _, inc := range incidents {
+		explainer.ExplainIncident(&inc)
+		if err := alertSink.Emit(context.Background(), alert.FromIncident(inc, hostID, labels)); err != nil {
+			return err
+		}
+	}
+	for _, a := range rest {
+		alertLabels := a.Labels
+		if len(alertLabels) == 0 {
+			alertLabels = labels
+		}
+		alertHostID := a.HostID
+		if alertHostID == "" {
+			alertHostID = hostID
+		}
+		if err := alertSink.Emit(context.Background(), alert.FromAnomaly(a, alertHostID, alertLabels)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resultFilter holds the output filters shared by analyze and report.
+type resultFilter struct {
+	minSeverity string
+	top         int
+	families    []string
+	mode        redact.Mode
+}
+
+func (f resultFilter) apply(result report.AnalysisResult) (report.AnalysisResult, error) {
+	result, err := report.ApplyFilters(result, f.minSeverity, f.top)
+	if err != nil {
+		return report.AnalysisResult{}, err
+	}
+	if len(f.families) > 0 {
+		m, err := config.ParseMetricFamilies(f.families)
+		if err != nil {
+			return report.AnalysisResult{}, err
+		}
+		result = report.FilterByMetricFamilies(result, toCollectorMetrics(m))
+	}
+	if f.mode != redact.None {
+		result = redactResult(result, f.mode)
+	}
+	return result, nil
+}
+
+// applyFleet filters every partition, redacts partition identities, and
+// re-ranks so the worst-host order reflects what is shown.
+func (f resultFilter) applyFleet(fleet report.FleetResult) (report.FleetResult, error) {
+	partitions := make([]report.Partition, len(fleet.Partitions))
+	for i, p := range fleet.Partitions {
+		result, err := f.apply(p.Result)
+		if err != nil {
+			return report.FleetResult{}, err
+		}
+		p.Result = result
+		partitions[i] = p
+	}
+	if f.mode != redact.None {
+		// Number partitions by their original key so omit mode stays stable
+		// across runs rather than following the anomaly ranking.
+		keys := make([]string, len(partitions))
+		for i, p := range partitions {
+			keys[i] = p.Key
+		}
+		sort.Strings(keys)
+		index := make(map[string]int, len(keys))
+		for i, k := range keys {
+			index[k] = i + 1
+		}
+		for i := range partitions {
+			p := &partitions[i]
+			n := index[p.Key]
+			p.HostID = redact.HostID(p.HostID, f.mode)
+			if p.LabelValue != "" {
+				p.LabelValue = redact.Labels(map[string]string{fleet.PartitionLabel: p.LabelValue}, f.mode)[fleet.PartitionLabel]
+			}
+			p.Key = redact.HostID(p.Key, f.mode)
+			if p.Key == "" {
+				p.Key = fmt.Sprintf("host-%d", n)
+			}
+		}
+	}
+	report.SortPartitions(partitions)
+	fleet.Partitions = partitions
+	return fleet, nil
+}
+
+func redactResult(result report.AnalysisResult, mode redact.Mode) report.AnalysisResult {
+	result.HostID = redact.HostID(result.HostID, mode)
+	result.Labels = redact.Labels(result.Labels, mode)
+	anomalies := make([]anomaly.Anomaly, len(result.Anomalies))
+	copy(anomalies, result.Anomalies)
+	for i := range anomalies {
+		anomalies[i].HostID = redact.HostID(anomalies[i].HostID, mode)
+		anomalies[i].Labels = redact.Labels(anomalies[i].Labels, mode)
+	}
+	result.Anomalies = anomalies
+	if len(result.HostBaselines) > 0 {
+		hosts := make([]string, 0, len(result.HostBaselines))
+		for host := range result.HostBaselines {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		hostBaselines := make(map[string]map[string]report.MetricStats, len(hosts))
+		for i, host := range hosts {
+			key := redact.HostID(host, mode)
+			if key == "" {
+				// Omit mode: keep hosts apart without revealing them.
+				key = fmt.Sprintf("host-%d", i+1)
+			}
+			hostBaselines[key] = result.HostBaselines[host]
+		}
+		result.HostBaselines = hostBaselines
+	}
+	return result
+}
+
 type redactingSink struct {
 	inner alert.Sink
 	mode  redact.Mode
@@ -513,6 +777,78 @@ func (s *redactingSink) Emit(ctx context.Context, a alert.Alert) error {
 
 func (s *redactingSink) Close() error { return s.inner.Close() }
 
+type analysisInput struct {
+	Samples      []collector.MetricSample
+	Rollups      []rollup.Record
+	SkippedLines []int
+}
+
+// loadAnalysisInput reads samples and rollups from path and applies the
+// --last or --since/--until time window shared by analyze and report.
+func loadAnalysisInput(path string, tolerant bool, quarantinePath string, last time.Duration, sinceStr, untilStr string) (analysisInput, error) {
+	records, stats, err := storage.ReadRecords(path, storage.ReadOptions{
+		Tolerant:       tolerant,
+		QuarantinePath: quarantinePath,
+	})
+	if err != nil {
+		return analysisInput{}, err
+	}
+	if stats.NewerSchema > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d records in %s use a schema newer than %d; reading them best-effort\n", stats.NewerSchema, path, collector.SchemaVersion)
+	}
+	if stats.UnknownRecords > 0 {
+		fmt.Fprintf(os.Stderr, "warning: ignored %d records of unknown type in %s\n", stats.UnknownRecords, path)
+	}
+
+	var since, until time.Time
+	if last > 0 {
+		maxTS := time.Time{}
+		for _, s := range records.Samples {
+			if s.Timestamp.After(maxTS) {
+				maxTS = s.Timestamp
+			}
+		}
+		for _, r := range records.Rollups {
+			if r.End.After(maxTS) {
+				maxTS = r.End
+			}
+		}
+		if !maxTS.IsZero() {
+			since, until = maxTS.Add(-last), maxTS
+		}
+	} else {
+		if since, err = parseRFC3339TimeFlag("since", sinceStr); err != nil {
+			return analysisInput{}, err
+		}
+		if until, err = parseRFC3339TimeFlag("until", untilStr); err != nil {
+			return analysisInput{}, err
+		}
+	}
+	samples, err := report.FilterSamplesByTime(records.Samples, since, until)
+	if err != nil {
+		return analysisInput{}, err
+	}
+	return analysisInput{
+		Samples:      samples,
+		Rollups:      report.FilterRollupsByTime(records.Rollups, since, until),
+		SkippedLines: stats.SkippedLineNumbers(),
+	}, nil
+}
+
+func repairSampleFileTail(path string) error {
+	if path == "-" {
+		return nil
+	}
+	removed, err := storage.RepairTail(path)
+	if err != nil {
+		return fmt.Errorf("repair %s: %w", path, err)
+	}
+	if removed > 0 {
+		fmt.Fprintf(os.Stderr, "warning: removed %d bytes of torn trailing record from %s\n", removed, path)
+	}
+	return nil
+}
+
 func parseMetricFamiliesCSV(csv string) (config.MetricFamilies, error) {
 	parts := strings.Split(csv, ",")
 	enabled := make([]string, 0, len(parts))
@@ -534,19 +870,23 @@ func runReport(args []string) error {
 	fs := flag.NewFlagSet("report", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
 	in := fs.String("in", "", "Input JSONL path")
+	configPath := fs.String("config", "", "Path to config file (JSON) for detector, threshold and static-threshold settings")
 	out := fs.String("out", "endpoint-perf-report.md", "Output markdown path")
 	window := fs.Int("window", 0, "Rolling window size override")
 	threshold := fs.Float64("threshold", 0, "Z-score threshold override")
-	minSeverity := fs.String("min-severity", "low", "Minimum severity: low|medium|high|critical")
+	minSeverity := fs.String("min-severity", "", "Minimum severity: low|medium|high|critical or a configured severity level (empty = the lowest level)")
 	top := fs.Int("top", 0, "Limit to top N anomalies by absolute z-score (0 = no limit)")
 	last := fs.Duration("last", 0, "Report only the last duration of samples (relative to the file's last sample timestamp)")
 	sinceStr := fs.String("since", "", "Include samples at or after this RFC3339 timestamp (e.g. 2026-02-09T00:00:00Z)")
 	untilStr := fs.String("until", "", "Include samples at or before this RFC3339 timestamp (e.g. 2026-02-09T00:01:00Z)")
 	var metricFamilies stringListFlag
 	fs.Var(&metricFamilies, "metric", "Include only these metric families in output (repeatable): cpu|mem|disk|net")
-	var staticThresholds staticThresholdsFlag
-	fs.Var(&staticThresholds, "static-threshold", "Static upper threshold rule (repeatable): metric=value (metric: cpu_percent|mem_used_percent|disk_used_percent|disk_read_bytes_per_sec|disk_write_bytes_per_sec|net_rx_bytes_per_sec|net_tx_bytes_per_sec)")
+	ruleOpts := addRuleFlags(fs)
 	redactMode := fs.String("redact", "", "Redact sensitive fields in output: omit|hash (empty = no redaction)")
+	tolerant := fs.Bool("tolerant", false, "Skip malformed JSONL lines anywhere in the input (a malformed final line is always skipped)")
+	quarantine := fs.String("quarantine", "", "Append skipped malformed lines to this file for later inspection")
+	fleet := fs.Bool("fleet", false, "Analyze each host_id separately and produce a fleet report ranking the worst hosts")
+	partitionLabel := fs.String("partition-label", "", "Also partition fleet analysis by this label key (e.g. service); implies --fleet")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -570,7 +910,7 @@ func runReport(args []string) error {
 		return errors.New("cannot combine --last with --since/--until")
 	}
 
-	cfg, err := config.Load("")
+	cfg, err := config.Load(*configPath)
 	if err != nil {
 		return err
 	}
@@ -589,63 +929,63 @@ func runReport(args []string) error {
 		return errors.New("input path is required")
 	}
 
-	samples, err := storage.ReadSamples(inputPath)
+	input, err := loadAnalysisInput(inputPath, *tolerant, *quarantine, *last, *sinceStr, *untilStr)
 	if err != nil {
 		return err
 	}
 
-	if *last > 0 && len(samples) > 0 {
-		maxTS := samples[0].Timestamp
-		for _, s := range samples[1:] {
-			if s.Timestamp.After(maxTS) {
-				maxTS = s.Timestamp
-			}
-		}
-		since := maxTS.Add(-*last)
-		samples, err = report.FilterSamplesByTime(samples, since, maxTS)
-		if err != nil {
-			return err
-		}
-	} else {
-		since, err := parseRFC3339TimeFlag("since", *sinceStr)
-		if err != nil {
-			return err
-		}
-		until, err := parseRFC3339TimeFlag("until", *untilStr)
-		if err != nil {
-			return err
-		}
-		samples, err = report.FilterSamplesByTime(samples, since, until)
-		if err != nil {
-			return err
-		}
-	}
-
 	windowSize, zScoreThreshold := report.NormalizeParams(cfg.WindowSize, cfg.ZScoreThreshold)
-	mergedStaticThresholds := cfg.StaticThresholds
-	if staticThresholds.Any() {
-		mergedStaticThresholds = mergeStaticThresholds(cfg.StaticThresholds, staticThresholds.Values())
+	rules, algorithms, err := ruleOpts.apply(&cfg)
+	if err != nil {
+		return err
+	}
+	model, err := loadSeasonalModel(cfg.SeasonalModel)
+	if err != nil {
+		return err
+	}
+	reference, err := loadBaseline(cfg.Baseline)
+	if err != nil {
+		return err
 	}
-	result := report.Analyze(samples, windowSize, zScoreThreshold, mergedStaticThresholds)
-	result, err = report.ApplyFilters(result, *minSeverity, *top)
+	explainer, err := loadExplainer(cfg.Locale, cfg.ExplanationTemplates)
 	if err != nil {
 		return err
 	}
-	if metricFamilies.Any() {
-		m, err := config.ParseMetricFamilies(metricFamilies.Values())
+	silences, err := loadSilences(cfg.SilencesFile, cfg.Silences)
+	if err != nil {
+		return err
+	}
+	opts := report.Options{
+		WindowSize: windowSize,
+		Threshold:  zScoreThreshold,
+		Rules:      rules,
+		Algorithms: algorithms,
+		Seasonal:   model,
+		Reference:  reference,
+		Rollups:    input.Rollups,
+		Explainer:  explainer,
+		Silences:   silences,
+
+		IncidentWindow: cfg.IncidentWindow,
+		MinSeverities:  cfg.MinSeverities,
+	}
+	post := resultFilter{minSeverity: *minSeverity, top: *top, families: metricFamilies.Values(), mode: mode}
+	var md string
+	if *fleet || *partitionLabel != "" {
+		fleetResult, err := post.applyFleet(report.AnalyzeFleet(input.Samples, opts, *partitionLabel))
 		if err != nil {
 			return err
 		}
-		result = report.FilterByMetricFamilies(result, toCollectorMetrics(m))
-	}
-	if mode != redact.None {
-		result.HostID = redact.HostID(result.HostID, mode)
-		result.Labels = redact.Labels(result.Labels, mode)
-		for i := range result.Anomalies {
-			result.Anomalies[i].Labels = redact.Labels(result.Anomalies[i].Labels, mode)
+		fleetResult.SkippedLines = input.SkippedLines
+		md = report.FormatFleetMarkdown(fleetResult)
+	} else {
+		result, err := post.apply(report.AnalyzeWithOptions(input.Samples, opts))
+		if err != nil {
+			return err
 		}
+		result.SkippedLines = input.SkippedLines
+		md = report.FormatMarkdown(result)
 	}
-	md := report.FormatMarkdown(result)
 	if *out == "-" {
 		fmt.Print(md)
 		return nil
@@ -719,22 +1059,475 @@ func runSelftest(args []string) error {
 	return nil
 }
 
-func formatSelftestText(r selftest.Result) string {
-	var b strings.Builder
-	fmt.Fprintf(&b, "Selftest: %s/%s at %s\n", r.GOOS, r.GOARCH, r.Timestamp.Format(time.RFC3339))
-	if len(r.EnabledMetrics) > 0 {
-		fmt.Fprintf(&b, "Metrics: %s\n", strings.Join(r.EnabledMetrics, ","))
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	var inputs stringListFlag
+	fs.Var(&inputs, "in", "Input JSONL path (repeatable; positional arguments are also accepted)")
+	out := fs.String("out", "-", "Merged JSONL output path (- = stdout)")
+	splitDir := fs.String("split-dir", "", "Write one <host_id>.jsonl file per host into this directory instead of --out")
+	hostFromFilename := fs.Bool("host-from-filename", false, "Set host_id from the input file name for records that have none")
+	tolerant := fs.Bool("tolerant", false, "Skip malformed JSONL lines anywhere in the inputs (a malformed final line is always skipped)")
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
-	if r.ProcessListOK {
-		fmt.Fprintf(&b, "Process list: ok (count=%d)\n", r.ProcessCount)
-	} else if r.ProcessListError != "" {
-		fmt.Fprintf(&b, "Process list: error (%s)\n", r.ProcessListError)
+	paths := append(inputs.Values(), fs.Args()...)
+	if len(paths) == 0 {
+		return errors.New("at least one input file is required")
 	}
-	for _, c := range r.Checks {
-		if c.OK {
-			fmt.Fprintf(&b, "- %s: ok (runs=%d, median=%s, p95=%s)\n", c.Name, c.Runs, c.MedianTime, c.P95Time)
-		} else {
-			fmt.Fprintf(&b, "- %s: error (%s)\n", c.Name, c.Error)
+
+	sets := make([]storage.Records, 0, len(paths))
+	for _, path := range paths {
+		records, stats, err := storage.ReadRecords(path, storage.ReadOptions{Tolerant: *tolerant})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if len(stats.Skipped) > 0 {
+			fmt.Fprintf(os.Stderr, "warning: skipped %d malformed lines in %s\n", len(stats.Skipped), path)
+		}
+		if *hostFromFilename {
+			base := filepath.Base(path)
+			records = storage.FillHostID(records, strings.TrimSuffix(base, filepath.Ext(base)))
+		}
+		sets = append(sets, records)
+	}
+	merged, stats := storage.Merge(sets...)
+
+	if *splitDir == "" {
+		w, err := storage.NewWriterWithOptions(*out, false)
+		if err != nil {
+			return err
+		}
+		if err := w.WriteRecords(merged); err != nil {
+			w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(*splitDir, 0o755); err != nil {
+			return err
+		}
+		byHost := storage.SplitByHost(merged)
+		owners := map[string]string{}
+		for host := range byHost {
+			name := storage.HostFileName(host)
+			if other, ok := owners[name]; ok {
+				return fmt.Errorf("host ids %q and %q map to the same file name %s", other, host, name)
+			}
+			owners[name] = host
+		}
+		for host, records := range byHost {
+			if err := storage.RewriteRecords(filepath.Join(*splitDir, storage.HostFileName(host)), records); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "merged %d samples and %d rollups from %d hosts (%d duplicate samples, %d duplicate rollups dropped)\n",
+		stats.Samples, stats.Rollups, stats.Hosts, stats.DuplicateSamples, stats.DuplicateRollups)
+	return nil
+}
+
+func runCompact(args []string) error {
+	cfgPath := findFlagStringValue(args, "config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("compact", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	_ = fs.String("config", cfgPath, "Path to config file (JSON)")
+	in := fs.String("in", cfg.OutputPath, "Input JSONL path")
+	out := fs.String("out", "", "Output JSONL path (empty = rewrite --in in place; stop collect and watch first)")
+	rawRetention := fs.Duration("raw-retention", 24*time.Hour, "Keep raw samples newer than this; older samples become 1-minute rollups")
+	minuteRetention := fs.Duration("minute-retention", 7*24*time.Hour, "Keep 1-minute rollups newer than this; older ones become 1-hour rollups (0 = never)")
+	tolerant := fs.Bool("tolerant", false, "Skip malformed JSONL lines anywhere in the input (a malformed final line is always skipped)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return errors.New("input path is required")
+	}
+	if *rawRetention <= 0 {
+		return errors.New("raw-retention must be greater than zero")
+	}
+	if *minuteRetention < 0 {
+		return errors.New("minute-retention must be greater than or equal to zero")
+	}
+	if *minuteRetention > 0 && *minuteRetention < *rawRetention {
+		return errors.New("minute-retention must be greater than or equal to raw-retention")
+	}
+
+	// Compacting in place renames a new file over --in. A collect or watch
+	// still appending to --in would keep writing to the old file, so refuse
+	// to replace it if it grew while it was being compacted.
+	read, err := os.Stat(*in)
+	if err != nil {
+		return err
+	}
+	records, stats, err := storage.ReadRecords(*in, storage.ReadOptions{Tolerant: *tolerant})
+	if err != nil {
+		return err
+	}
+	if stats.UnknownRecords > 0 {
+		return fmt.Errorf("%s contains %d records of unknown type; refusing to rewrite it", *in, stats.UnknownRecords)
+	}
+
+	// Retention is measured from the newest record so compacting an archived
+	// file behaves the same as compacting a live one.
+	newest := time.Time{}
+	for _, s := range records.Samples {
+		if s.Timestamp.After(newest) {
+			newest = s.Timestamp
+		}
+	}
+	for _, r := range records.Rollups {
+		if r.End.After(newest) {
+			newest = r.End
+		}
+	}
+	var minuteBefore time.Time
+	if *minuteRetention > 0 {
+		minuteBefore = newest.Add(-*minuteRetention)
+	}
+	kept, rollups := rollup.Compact(records.Samples, records.Rollups, newest.Add(-*rawRetention), minuteBefore)
+
+	compacted := storage.Records{Samples: kept, Rollups: rollups}
+	target := *out
+	if target == "" {
+		target = *in
+		if err := storage.ReplaceRecords(target, compacted, read); err != nil {
+			if errors.Is(err, storage.ErrChanged) {
+				return fmt.Errorf("%s was written to while it was being compacted; stop collect and watch first or use --out: %w", *in, err)
+			}
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := storage.RewriteRecords(target, compacted); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("compacted %d samples into %d rollups; kept %d raw samples in %s\n", len(records.Samples)-len(kept), len(rollups), len(kept), target)
+	return nil
+}
+
+func runBaseline(args []string) error {
+	if len(args) == 0 || args[0] != "export" {
+		return errors.New("usage: epagent baseline export --in <samples.jsonl> --out <baseline.json>")
+	}
+	fs := flag.NewFlagSet("baseline export", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	in := fs.String("in", "", "Input JSONL path")
+	out := fs.String("out", "", "Output baseline path")
+	hostID := fs.String("host-id", "", "Only use samples from this host_id (empty = pool every host in the input)")
+	last := fs.Duration("last", 0, "Use only the last duration of samples (relative to the file's last sample timestamp)")
+	sinceStr := fs.String("since", "", "Include samples at or after this RFC3339 timestamp")
+	untilStr := fs.String("until", "", "Include samples at or before this RFC3339 timestamp")
+	tolerant := fs.Bool("tolerant", false, "Skip malformed JSONL lines anywhere in the input (a malformed final line is always skipped)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *in == "" {
+		return errors.New("input path is required")
+	}
+	if *out == "" {
+		return errors.New("output path is required")
+	}
+	if *last < 0 {
+		return errors.New("last must be greater than or equal to zero")
+	}
+	if *last > 0 && (*sinceStr != "" || *untilStr != "") {
+		return errors.New("cannot combine --last with --since/--until")
+	}
+	input, err := loadAnalysisInput(*in, *tolerant, "", *last, *sinceStr, *untilStr)
+	if err != nil {
+		return err
+	}
+	samples := input.Samples
+	if *hostID != "" {
+		samples = make([]collector.MetricSample, 0, len(input.Samples))
+		for _, s := range input.Samples {
+			if s.HostID == *hostID {
+				samples = append(samples, s)
+			}
+		}
+	}
+	if len(samples) == 0 {
+		return errors.New("no samples to compute a baseline from")
+	}
+	b := baseline.Compute(samples)
+	if err := baseline.Save(*out, b); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote baseline for %d metrics from %d samples (%d host(s)) to %s\n", len(b.Metrics), b.Samples, len(b.Hosts), *out)
+	return nil
+}
+
+func runSilence(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: epagent silence add|list|expire [flags]")
+	}
+	switch args[0] {
+	case "add":
+		return runSilenceAdd(args[1:])
+	case "list":
+		return runSilenceList(args[1:])
+	case "expire":
+		return runSilenceExpire(args[1:])
+	default:
+		return fmt.Errorf("unknown silence command: %s (expected add|list|expire)", args[0])
+	}
+}
+
+// silenceFlagSet returns a flag set with --config and --file, where --file
+// defaults to the config's silences_file.
+func silenceFlagSet(name string, args []string) (*flag.FlagSet, *string, error) {
+	cfgPath := findFlagStringValue(args, "config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	fs := flag.NewFlagSet("silence "+name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	_ = fs.String("config", cfgPath, "Path to config file (JSON)")
+	file := fs.String("file", cfg.SilencesFile, "Silences file (default config silences_file)")
+	return fs, file, nil
+}
+
+func runSilenceAdd(args []string) error {
+	fs, file, err := silenceFlagSet("add", args)
+	if err != nil {
+		return err
+	}
+	id := fs.String("id", "", "Silence ID (default random)")
+	comment := fs.String("comment", "", "Why the silence exists, e.g. nightly backup")
+	matchers := kvLabelsFlag{kind: "matcher"}
+	fs.Var(&matchers, "match", "Matcher (repeatable): metric=<glob or family>, rule_type=<glob>, host_id=<glob> or <label>=<glob>; all must match (none = every alert)")
+	startStr := fs.String("start", "", "Start as RFC3339 (default now)")
+	endStr := fs.String("end", "", "Expiry as RFC3339")
+	forDuration := fs.Duration("for", 0, "Expire this long after start (alternative to --end)")
+	schedule := fs.String("schedule", "", "Recurring cron schedule (minute hour day-of-month month day-of-week), e.g. '0 2 * * *'")
+	window := fs.Duration("duration", 0, "Length of each recurring window (with --schedule)")
+	timezone := fs.String("timezone", "", "Time zone of --schedule (default UTC)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	now := time.Now().UTC()
+	start, err := parseRFC3339TimeFlag("start", *startStr)
+	if err != nil {
+		return err
+	}
+	if start.IsZero() {
+		start = now
+	}
+	end, err := parseRFC3339TimeFlag("end", *endStr)
+	if err != nil {
+		return err
+	}
+	switch {
+	case *forDuration < 0:
+		return errors.New("for must be greater than or equal to zero")
+	case *forDuration > 0 && !end.IsZero():
+		return errors.New("cannot combine --for with --end")
+	case *forDuration > 0:
+		end = start.Add(*forDuration)
+	case end.IsZero() && *schedule == "":
+		return errors.New("a one-off silence needs --end or --for; only --schedule silences may run without expiry")
+	}
+	s := silence.Silence{
+		ID:        strings.TrimSpace(*id),
+		Comment:   *comment,
+		CreatedAt: now,
+		Matchers:  matchers.m,
+		Start:     start,
+		Schedule:  strings.TrimSpace(*schedule),
+		Timezone:  *timezone,
+	}
+	if s.ID == "" {
+		s.ID = silence.NewID()
+	}
+	if !end.IsZero() {
+		s.End = &end
+	}
+	if *window != 0 {
+		s.Duration = window.String()
+	}
+	existing, err := silence.Load(*file)
+	if err != nil {
+		return err
+	}
+	list := append(existing, s)
+	if err := list.Compile(); err != nil {
+		return err
+	}
+	if err := silence.Save(*file, list); err != nil {
+		return err
+	}
+	fmt.Printf("added silence %s (%s) to %s\n", s.ID, list[len(list)-1].Status(now), *file)
+	return nil
+}
+
+func runSilenceList(args []string) error {
+	fs, file, err := silenceFlagSet("list", args)
+	if err != nil {
+		return err
+	}
+	all := fs.Bool("all", false, "Include expired silences")
+	format := fs.String("format", "text", "Output format: text|json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format: %s (expected text|json)", *format)
+	}
+	list, err := silence.Load(*file)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	shown := make(silence.List, 0, len(list))
+	for _, s := range list {
+		if *all || !s.Expired(now) {
+			shown = append(shown, s)
+		}
+	}
+	if *format == "json" {
+		data, err := json.MarshalIndent(shown, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	fmt.Print(formatSilences(shown, now))
+	return nil
+}
+
+func formatSilences(list silence.List, now time.Time) string {
+	if len(list) == 0 {
+		return "No silences.\n"
+	}
+	var b strings.Builder
+	for _, s := range list {
+		fmt.Fprintf(&b, "%s  %s", s.ID, s.Status(now))
+		if s.Schedule != "" {
+			fmt.Fprintf(&b, "  schedule %q for %s", s.Schedule, s.Duration)
+			if s.Timezone != "" {
+				fmt.Fprintf(&b, " %s", s.Timezone)
+			}
+		}
+		fmt.Fprintf(&b, "  from %s", s.Start.Format(time.RFC3339))
+		if s.End != nil {
+			fmt.Fprintf(&b, " until %s", s.End.Format(time.RFC3339))
+		}
+		if len(s.Matchers) > 0 {
+			keys := make([]string, 0, len(s.Matchers))
+			for k := range s.Matchers {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			parts := make([]string, 0, len(keys))
+			for _, k := range keys {
+				parts = append(parts, k+"="+s.Matchers[k])
+			}
+			fmt.Fprintf(&b, "  match %s", strings.Join(parts, ","))
+		}
+		if s.Comment != "" {
+			fmt.Fprintf(&b, "  # %s", s.Comment)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func runSilenceExpire(args []string) error {
+	fs, file, err := silenceFlagSet("expire", args)
+	if err != nil {
+		return err
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("usage: epagent silence expire [--file path] <id>...")
+	}
+	list, err := silence.Load(*file)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, id := range fs.Args() {
+		i := -1
+		for j, s := range list {
+			if s.ID == id {
+				i = j
+			}
+		}
+		switch {
+		case i < 0:
+			return fmt.Errorf("unknown silence: %s", id)
+		case list[i].Expired(now):
+		case now.Before(list[i].Start):
+			// A silence that has not started yet has nothing to keep.
+			list = append(list[:i], list[i+1:]...)
+		default:
+			end := now
+			list[i].End = &end
+		}
+	}
+	if err := silence.Save(*file, list); err != nil {
+		return err
+	}
+	fmt.Printf("expired %s in %s\n", strings.Join(fs.Args(), ", "), *file)
+	return nil
+}
+
+func runSchema(args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	name := "sample"
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		name = fs.Arg(0)
+	default:
+		return fmt.Errorf("expected at most one schema name (%s)", strings.Join(schema.Names(), "|"))
+	}
+	doc, err := schema.Get(name)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(doc)
+	return err
+}
+
+func formatSelftestText(r selftest.Result) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Selftest: %s/%s at %s\n", r.GOOS, r.GOARCH, r.Timestamp.Format(time.RFC3339))
+	if len(r.EnabledMetrics) > 0 {
+		fmt.Fprintf(&b, "Metrics: %s\n", strings.Join(r.EnabledMetrics, ","))
+	}
+	if r.ProcessListOK {
+		fmt.Fprintf(&b, "Process list: ok (count=%d)\n", r.ProcessCount)
+	} else if r.ProcessListError != "" {
+		fmt.Fprintf(&b, "Process list: error (%s)\n", r.ProcessListError)
+	}
+	for _, c := range r.Checks {
+		if c.OK {
+			fmt.Fprintf(&b, "- %s: ok (runs=%d, median=%s, p95=%s)\n", c.Name, c.Runs, c.MedianTime, c.P95Time)
+		} else {
+			fmt.Fprintf(&b, "- %s: error (%s)\n", c.Name, c.Error)
 		}
 	}
 	return b.String()
@@ -778,6 +1571,8 @@ func exitErr(err error) {
 
 type kvLabelsFlag struct {
 	m map[string]string
+	// kind names the values in errors ("label" when empty).
+	kind string
 }
 
 func (f *kvLabelsFlag) String() string {
@@ -796,16 +1591,20 @@ func (f *kvLabelsFlag) Set(value string) error {
 	if value == "" {
 		return nil
 	}
+	kind := f.kind
+	if kind == "" {
+		kind = "label"
+	}
 	key, val, ok := strings.Cut(value, "=")
 	if !ok {
-		return fmt.Errorf("label must be in k=v form: %q", value)
+		return fmt.Errorf("%s must be in k=v form: %q", kind, value)
 	}
 	key = strings.TrimSpace(key)
 	if key == "" {
-		return fmt.Errorf("label key must be non-empty: %q", value)
+		return fmt.Errorf("%s key must be non-empty: %q", kind, value)
 	}
 	if strings.ContainsAny(key, " \t\r\n") {
-		return fmt.Errorf("label key must not contain whitespace: %q", key)
+		return fmt.Errorf("%s key must not contain whitespace: %q", kind, key)
 	}
 	if f.m == nil {
 		f.m = make(map[string]string)
@@ -869,18 +1668,196 @@ func (f *stringListFlag) Values() []string {
 	return out
 }
 
+// ruleFlags are the rule flags shared by watch, analyze and report. Values
+// given on the command line replace config values for the same metric.
+type ruleFlags struct {
+	detector         *string
+	seasonalModel    *string
+	baseline         *string
+	staticThresholds staticThresholdsFlag
+	percentileRules  percentileRulesFlag
+	sustain          sustainFlag
+	directions       directionsFlag
+	forecasts        forecastsFlag
+	rates            ratesFlag
+	changePoints     changePointsFlag
+	contamination    contaminationFlag
+	expressions      expressionsFlag
+	severityCuts     severityCutsFlag
+	incidentWindow   *time.Duration
+	warmupSamples    *int
+	warmupDuration   *time.Duration
+	locale           *string
+	explanations     stringListFlag
+	silences         *string
+}
+
+func addRuleFlags(fs *flag.FlagSet) *ruleFlags {
+	f := &ruleFlags{}
+	f.detector = fs.String("detector", "", "Default detector algorithm for all metrics: zscore|ewma|holt|mad|percentile|seasonal|seasonal_weekly; ewma and holt take a time half-life as ewma:10m (empty = config detector; per-metric config detectors still apply)")
+	f.seasonalModel = fs.String("seasonal-model", "", "Seed seasonal detectors from this model file (written by analyze --save-seasonal; empty = config seasonal_model)")
+	f.baseline = fs.String("baseline", "", "Start every detector from this baseline file instead of learning from scratch (written by baseline export; empty = config baseline)")
+	fs.Var(&f.staticThresholds, "static-threshold", "Static threshold rule (repeatable): metric=value or metric>value (upper), metric<value (lower floor) (metric: "+staticThresholdMetrics+")")
+	fs.Var(&f.percentileRules, "percentile-rule", "Percentile rule (repeatable): metric=p99+20% (value above rolling p99 by 20%) or metric=p95@5m>85 (p95 over 5m above 85); metric may be a family cpu|mem|disk|net")
+	fs.Var(&f.sustain, "sustain", "Only fire once a rule's condition has held (repeatable): metric[:rule_type]=2m (breaching for 2 minutes) or =3/5 (3 of the last 5 samples); metric may be a family cpu|mem|disk|net")
+	fs.Var(&f.forecasts, "forecast", "Forecast rule (repeatable): metric=24h (predicted to reach 100% within 24h), metric=24h>90 (reach 90%) or metric=24h/72h (fit the last 72h); metric: disk|mem (disk also covers disk_mounts) or disk_used_percent{mount=/var}")
+	fs.Var(&f.rates, "rate-of-change", "Rate-of-change rule for a gauge (repeatable): metric=10/1m (grew 10 or more, in percentage points for percentages, per minute), metric=50%/5m (grew 50% per 5m), metric=-5/1m (fell) or metric=2/1m@10m (averaged over 10m); metric: cpu|mem|disk|disk_free")
+	fs.Var(&f.changePoints, "change-point", "Report lasting level shifts as regime changes (repeatable): metric (default threshold) or metric=8 (CUSUM threshold in standard deviations); metric may be a family cpu|mem|disk|net")
+	fs.Var(&f.contamination, "contamination", "How values the detector flags enter the baseline (repeatable): metric=learn|skip|clamp|downweight; metric may be a family cpu|mem|disk|net")
+	fs.Var(&f.expressions, "expression", "Expression rule over several metrics (repeatable): name=expr or name:severity=expr, e.g. 'pressure:high=cpu_percent > 85 && mem_used_percent > 90 for 2m'; replaces a config expression of the same name")
+	fs.Var(&f.severityCuts, "severity-cuts", "Severity cut-points for a rule type (repeatable): rule_type=3,4,6 sets where each level above the lowest starts (|z| for zscore and change_point, exceed ratio for static_threshold, percentile, forecast and rate_of_change; rate_of_change uses the static_threshold cut-points unless given its own)")
+	f.incidentWindow = fs.Duration("incident-window", 0, "Group anomalies on a host within this long of each other into one incident (0 = config incident_window, default 1m)")
+	f.warmupSamples = fs.Int("warmup-samples", 0, "Hold back baseline, percentile, forecast, change point and rate of change anomalies until a metric has this many samples and its values have varied, or twice as many while flat (0 = config warmup.samples, off by default)")
+	f.warmupDuration = fs.Duration("warmup-duration", 0, "Hold back baseline, percentile, forecast, change point and rate of change anomalies until a metric has been observed this long (0 = config warmup.duration, off by default)")
+	fs.Var(&f.directions, "direction", "Only flag baseline deviations in one direction (repeatable): metric=up|down|both; metric may be a family cpu|mem|disk|net")
+	f.locale = fs.String("locale", "", "Language of explanations and hints: "+strings.Join(explain.Locales(), "|")+" (empty = config explanations.locale, default en)")
+	f.silences = fs.String("silences", "", "Silences file managed with `epagent silence` (empty = config silences_file, default data/silences.json)")
+	fs.Var(&f.explanations, "explanation-templates", "Explanation and hint template files (repeatable or comma-separated), loaded after the config ones so their definitions win")
+	return f
+}
+
+// apply merges the flags into cfg and returns the rules and detector
+// selection to analyze with.
+func (f *ruleFlags) apply(cfg *config.Config) (anomaly.Rules, anomaly.AlgorithmConfig, error) {
+	if f.staticThresholds.Any() {
+		cfg.StaticThresholds = mergeRuleMaps(cfg.StaticThresholds, f.staticThresholds.Values())
+		cfg.StaticLowerThresholds = mergeRuleMaps(cfg.StaticLowerThresholds, f.staticThresholds.LowerValues())
+	}
+	if f.percentileRules.Any() {
+		cfg.PercentileRules = mergeRuleMaps(cfg.PercentileRules, f.percentileRules.Values())
+	}
+	if f.sustain.Any() {
+		cfg.Sustain = mergeRuleMaps(cfg.Sustain, f.sustain.Values())
+	}
+	if f.forecasts.Any() {
+		cfg.ForecastRules = mergeRuleMaps(cfg.ForecastRules, f.forecasts.Values())
+	}
+	if f.rates.Any() {
+		cfg.RateRules = mergeRuleMaps(cfg.RateRules, f.rates.Values())
+	}
+	if f.changePoints.Any() {
+		cfg.ChangePoints = mergeRuleMaps(cfg.ChangePoints, f.changePoints.Values())
+	}
+	if f.directions.Any() {
+		cfg.Directions = mergeRuleMaps(cfg.Directions, f.directions.Values())
+	}
+	if f.contamination.Any() {
+		cfg.Contamination = mergeRuleMaps(cfg.Contamination, f.contamination.Values())
+	}
+	if f.expressions.Any() {
+		expressions, err := f.expressions.merge(cfg.Expressions)
+		if err != nil {
+			return anomaly.Rules{}, anomaly.AlgorithmConfig{}, err
+		}
+		cfg.Expressions = expressions
+	}
+	if f.severityCuts.Any() {
+		cfg.Severity.CutPoints = mergeRuleMaps(cfg.Severity.CutPoints, f.severityCuts.Values())
+	}
+	if *f.detector != "" {
+		cfg.Detector = *f.detector
+	}
+	if *f.seasonalModel != "" {
+		cfg.SeasonalModel = *f.seasonalModel
+	}
+	if *f.baseline != "" {
+		cfg.Baseline = *f.baseline
+	}
+	if *f.locale != "" {
+		locale, err := explain.ParseLocale(*f.locale)
+		if err != nil {
+			return anomaly.Rules{}, anomaly.AlgorithmConfig{}, err
+		}
+		cfg.Locale = locale
+	}
+	if *f.silences != "" {
+		cfg.SilencesFile = *f.silences
+	}
+	if f.explanations.Any() {
+		cfg.ExplanationTemplates = append(cfg.ExplanationTemplates, f.explanations.Values()...)
+	}
+	if *f.incidentWindow < 0 {
+		return anomaly.Rules{}, anomaly.AlgorithmConfig{}, errors.New("incident-window must be greater than or equal to zero")
+	}
+	if *f.incidentWindow > 0 {
+		cfg.IncidentWindow = *f.incidentWindow
+	}
+	if *f.warmupSamples < 0 || *f.warmupDuration < 0 {
+		return anomaly.Rules{}, anomaly.AlgorithmConfig{}, errors.New("warmup-samples and warmup-duration must be greater than or equal to zero")
+	}
+	if *f.warmupSamples > 0 {
+		cfg.Warmup.Samples = *f.warmupSamples
+	}
+	if *f.warmupDuration > 0 {
+		cfg.Warmup.Duration = *f.warmupDuration
+	}
+	algorithms := cfg.Algorithms()
+	if err := algorithms.Validate(); err != nil {
+		return anomaly.Rules{}, anomaly.AlgorithmConfig{}, err
+	}
+	rules := cfg.Rules()
+	if err := rules.Validate(); err != nil {
+		return anomaly.Rules{}, anomaly.AlgorithmConfig{}, err
+	}
+	return rules, algorithms, nil
+}
+
+// loadSeasonalModel reads the model at path, or returns nil when path is empty.
+func loadSeasonalModel(path string) (*seasonal.Model, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return seasonal.Load(path)
+}
+
+// loadExplainer builds the explainer for locale and the template files at
+// paths, or returns nil when neither is set.
+func loadExplainer(locale string, paths []string) (*explain.Explainer, error) {
+	if (locale == "" || locale == explain.DefaultLocale) && len(paths) == 0 {
+		return nil, nil
+	}
+	return explain.New(locale, paths)
+}
+
+// loadSilences returns the config silences followed by those in the file at
+// path, which may be missing.
+func loadSilences(path string, static silence.List) (silence.List, error) {
+	loaded, err := silence.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(silence.List{}, static...), loaded...), nil
+}
+
+// loadBaseline reads the baseline at path, or returns nil when path is empty.
+func loadBaseline(path string) (*baseline.Baseline, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return baseline.Load(path)
+}
+
+const staticThresholdMetrics = "cpu_percent|mem_used_percent|disk_used_percent|disk_free_bytes|disk_read_bytes_per_sec|disk_write_bytes_per_sec|net_rx_bytes_per_sec|net_tx_bytes_per_sec"
+
+// staticThresholdsFlag collects --static-threshold values: metric=value and
+// metric>value set a ceiling, metric<value sets a floor.
 type staticThresholdsFlag struct {
-	m map[string]float64
+	m     map[string]float64
+	lower map[string]float64
 }
 
 func (f *staticThresholdsFlag) String() string {
-	if len(f.m) == 0 {
+	if len(f.m) == 0 && len(f.lower) == 0 {
 		return ""
 	}
-	parts := make([]string, 0, len(f.m))
+	parts := make([]string, 0, len(f.m)+len(f.lower))
 	for k, v := range f.m {
 		parts = append(parts, fmt.Sprintf("%s=%g", k, v))
 	}
+	for k, v := range f.lower {
+		parts = append(parts, fmt.Sprintf("%s<%g", k, v))
+	}
+	sort.Strings(parts)
 	return strings.Join(parts, ",")
 }
 
@@ -889,14 +1866,15 @@ func (f *staticThresholdsFlag) Set(value string) error {
 	if value == "" {
 		return nil
 	}
-	name, rawThreshold, ok := strings.Cut(value, "=")
-	if !ok {
-		return fmt.Errorf("static-threshold must be in metric=value form: %q", value)
+	i := strings.IndexAny(value, "=<>")
+	if i < 0 {
+		return fmt.Errorf("static-threshold must be in metric=value, metric>value or metric<value form: %q", value)
 	}
-	name = strings.TrimSpace(name)
-	rawThreshold = strings.TrimSpace(rawThreshold)
+	name := strings.TrimSpace(value[:i])
+	op := value[i]
+	rawThreshold := strings.TrimSpace(value[i+1:])
 	if name == "" || rawThreshold == "" {
-		return fmt.Errorf("static-threshold must be in metric=value form: %q", value)
+		return fmt.Errorf("static-threshold must be in metric=value, metric>value or metric<value form: %q", value)
 	}
 	parsed, err := strconv.ParseFloat(rawThreshold, 64)
 	if err != nil {
@@ -906,41 +1884,486 @@ func (f *staticThresholdsFlag) Set(value string) error {
 	if err != nil {
 		return err
 	}
-	if f.m == nil {
-		f.m = make(map[string]float64)
+	target := &f.m
+	if op == '<' {
+		target = &f.lower
+	}
+	if *target == nil {
+		*target = make(map[string]float64)
 	}
 	for k, v := range normalized {
-		f.m[k] = v
+		(*target)[k] = v
 	}
 	return nil
 }
 
-func (f *staticThresholdsFlag) Any() bool { return len(f.m) > 0 }
+func (f *staticThresholdsFlag) Any() bool { return len(f.m) > 0 || len(f.lower) > 0 }
 
+// Values returns the upper thresholds.
 func (f *staticThresholdsFlag) Values() map[string]float64 {
-	if len(f.m) == 0 {
+	return cloneThresholdMap(f.m)
+}
+
+// LowerValues returns the lower thresholds (floors).
+func (f *staticThresholdsFlag) LowerValues() map[string]float64 {
+	return cloneThresholdMap(f.lower)
+}
+
+func cloneThresholdMap(in map[string]float64) map[string]float64 {
+	if len(in) == 0 {
 		return nil
 	}
-	out := make(map[string]float64, len(f.m))
-	for k, v := range f.m {
+	out := make(map[string]float64, len(in))
+	for k, v := range in {
 		out[k] = v
 	}
 	return out
 }
 
-func mergeStaticThresholds(base, extra map[string]float64) map[string]float64 {
+// percentileRulesFlag collects --percentile-rule metric=spec values. Rules
+// given on the command line replace config rules for the same metric.
+type percentileRulesFlag struct {
+	specs map[string][]string
+}
+
+func (f *percentileRulesFlag) String() string {
+	if len(f.specs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(f.specs))
+	for k, specs := range f.specs {
+		for _, spec := range specs {
+			parts = append(parts, k+"="+spec)
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (f *percentileRulesFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	name, spec, ok := strings.Cut(value, "=")
+	name, spec = strings.TrimSpace(name), strings.TrimSpace(spec)
+	if !ok || name == "" || spec == "" {
+		return fmt.Errorf("percentile-rule must be in metric=spec form: %q", value)
+	}
+	if _, err := config.ParsePercentileRules(map[string][]string{name: {spec}}); err != nil {
+		return err
+	}
+	if f.specs == nil {
+		f.specs = make(map[string][]string)
+	}
+	f.specs[name] = append(f.specs[name], spec)
+	return nil
+}
+
+func (f *percentileRulesFlag) Any() bool { return len(f.specs) > 0 }
+
+func (f *percentileRulesFlag) Values() map[string][]anomaly.PercentileRule {
+	// Specs were validated in Set.
+	rules, _ := config.ParsePercentileRules(f.specs)
+	return rules
+}
+
+// sustainFlag collects --sustain metric[:rule_type]=spec values.
+type sustainFlag struct {
+	specs map[string]string
+}
+
+func (f *sustainFlag) String() string {
+	if len(f.specs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(f.specs))
+	for k, spec := range f.specs {
+		parts = append(parts, k+"="+spec)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (f *sustainFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	key, spec, ok := strings.Cut(value, "=")
+	key, spec = strings.TrimSpace(key), strings.TrimSpace(spec)
+	if !ok || key == "" || spec == "" {
+		return fmt.Errorf("sustain must be in metric[:rule_type]=spec form: %q", value)
+	}
+	if _, err := config.ParseSustain(map[string]string{key: spec}); err != nil {
+		return err
+	}
+	if f.specs == nil {
+		f.specs = make(map[string]string)
+	}
+	f.specs[key] = spec
+	return nil
+}
+
+func (f *sustainFlag) Any() bool { return len(f.specs) > 0 }
+
+func (f *sustainFlag) Values() map[string]anomaly.Sustain {
+	// Specs were validated in Set.
+	sustain, _ := config.ParseSustain(f.specs)
+	return sustain
+}
+
+// directionsFlag collects --direction metric=up|down|both values.
+type directionsFlag struct {
+	specs map[string]string
+}
+
+func (f *directionsFlag) String() string {
+	if len(f.specs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(f.specs))
+	for k, spec := range f.specs {
+		parts = append(parts, k+"="+spec)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (f *directionsFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	key, spec, ok := strings.Cut(value, "=")
+	key, spec = strings.TrimSpace(key), strings.TrimSpace(spec)
+	if !ok || key == "" || spec == "" {
+		return fmt.Errorf("direction must be in metric=up|down|both form: %q", value)
+	}
+	if _, err := config.ParseDirections(map[string]string{key: spec}); err != nil {
+		return err
+	}
+	if f.specs == nil {
+		f.specs = make(map[string]string)
+	}
+	f.specs[key] = spec
+	return nil
+}
+
+func (f *directionsFlag) Any() bool { return len(f.specs) > 0 }
+
+func (f *directionsFlag) Values() map[string]anomaly.Direction {
+	// Specs were validated in Set.
+	directions, _ := config.ParseDirections(f.specs)
+	return directions
+}
+
+// contaminationFlag collects --contamination metric=policy values.
+type contaminationFlag struct {
+	specs map[string]string
+}
+
+func (f *contaminationFlag) String() string {
+	if len(f.specs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(f.specs))
+	for k, spec := range f.specs {
+		parts = append(parts, k+"="+spec)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (f *contaminationFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	key, spec, ok := strings.Cut(value, "=")
+	key, spec = strings.TrimSpace(key), strings.TrimSpace(spec)
+	if !ok || key == "" || spec == "" {
+		return fmt.Errorf("contamination must be in metric=learn|skip|clamp|downweight form: %q", value)
+	}
+	if _, err := config.ParseContamination(map[string]string{key: spec}); err != nil {
+		return err
+	}
+	if f.specs == nil {
+		f.specs = make(map[string]string)
+	}
+	f.specs[key] = spec
+	return nil
+}
+
+func (f *contaminationFlag) Any() bool { return len(f.specs) > 0 }
+
+func (f *contaminationFlag) Values() map[string]anomaly.Contamination {
+	// Specs were validated in Set.
+	contamination, _ := config.ParseContamination(f.specs)
+	return contamination
+}
+
+// expressionsFlag collects --expression name[:severity]=expr values in
+// order.
+type expressionsFlag struct {
+	specs []config.ExpressionConfig
+}
+
+func (f *expressionsFlag) String() string {
+	parts := make([]string, 0, len(f.specs))
+	for _, e := range f.specs {
+		parts = append(parts, e.Name+"="+e.Expr)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *expressionsFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	key, expr, ok := strings.Cut(value, "=")
+	name, severity, _ := strings.Cut(key, ":")
+	spec := config.ExpressionConfig{Name: strings.TrimSpace(name), Severity: strings.TrimSpace(severity), Expr: strings.TrimSpace(expr)}
+	if !ok || spec.Name == "" || spec.Expr == "" {
+		return fmt.Errorf("expression must be in name=expr or name:severity=expr form: %q", value)
+	}
+	if _, err := config.ParseExpressions([]config.ExpressionConfig{spec}); err != nil {
+		return err
+	}
+	f.specs = append(f.specs, spec)
+	return nil
+}
+
+func (f *expressionsFlag) Any() bool { return len(f.specs) > 0 }
+
+// merge returns base with the flag rules added, a flag rule replacing the
+// base rule of the same name.
+func (f *expressionsFlag) merge(base []anomaly.ExpressionRule) ([]anomaly.ExpressionRule, error) {
+	extra, err := config.ParseExpressions(f.specs)
+	if err != nil {
+		return nil, err
+	}
+	replaced := make(map[string]bool, len(extra))
+	for _, rule := range extra {
+		replaced[rule.Name] = true
+	}
+	out := make([]anomaly.ExpressionRule, 0, len(base)+len(extra))
+	for _, rule := range base {
+		if !replaced[rule.Name] {
+			out = append(out, rule)
+		}
+	}
+	return append(out, extra...), nil
+}
+
+// severityCutsFlag collects --severity-cuts rule_type=c1,c2,... values.
+type severityCutsFlag struct {
+	cuts map[string][]float64
+}
+
+func (f *severityCutsFlag) String() string {
+	if len(f.cuts) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(f.cuts))
+	for ruleType, cuts := range f.cuts {
+		values := make([]string, len(cuts))
+		for i, c := range cuts {
+			values[i] = strconv.FormatFloat(c, 'g', -1, 64)
+		}
+		parts = append(parts, ruleType+"="+strings.Join(values, ","))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+func (f *severityCutsFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	ruleType, spec, ok := strings.Cut(value, "=")
+	ruleType = strings.ToLower(strings.TrimSpace(ruleType))
+	if !ok || ruleType == "" || strings.TrimSpace(spec) == "" {
+		return fmt.Errorf("severity-cuts must be in rule_type=c1,c2,... form: %q", value)
+	}
+	var cuts []float64
+	for _, raw := range strings.Split(spec, ",") {
+		c, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("invalid severity cut-point in %q: %w", value, err)
+		}
+		cuts = append(cuts, c)
+	}
+	if f.cuts == nil {
+		f.cuts = make(map[string][]float64)
+	}
+	f.cuts[ruleType] = cuts
+	return nil
+}
+
+func (f *severityCutsFlag) Any() bool { return len(f.cuts) > 0 }
+
+// Values returns the cut-points; they are checked against the configured
+// levels by anomaly.Rules.Validate.
+func (f *severityCutsFlag) Values() map[string][]float64 { return f.cuts }
+
+// forecastsFlag collects --forecast metric=spec values.
+type forecastsFlag struct {
+	specs map[string]string
+}
+
+func (f *forecastsFlag) String() string {
+	if len(f.specs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(f.specs))
+	for k, spec := range f.specs {
+		parts = append(parts, k+"="+spec)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (f *forecastsFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	// Split at the last "=" so per-mount keys such as
+	// disk_used_percent{mount=/var} keep theirs.
+	i := strings.LastIndex(value, "=")
+	if i < 0 {
+		return fmt.Errorf("forecast must be in metric=spec form: %q", value)
+	}
+	key, spec := strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:])
+	if key == "" || spec == "" {
+		return fmt.Errorf("forecast must be in metric=spec form: %q", value)
+	}
+	if _, err := config.ParseForecastRules(map[string]string{key: spec}); err != nil {
+		return err
+	}
+	if f.specs == nil {
+		f.specs = make(map[string]string)
+	}
+	f.specs[key] = spec
+	return nil
+}
+
+func (f *forecastsFlag) Any() bool { return len(f.specs) > 0 }
+
+func (f *forecastsFlag) Values() map[string]anomaly.ForecastRule {
+	// Specs were validated in Set.
+	rules, _ := config.ParseForecastRules(f.specs)
+	return rules
+}
+
+// ratesFlag collects --rate-of-change metric=spec values.
+type ratesFlag struct {
+	specs map[string]string
+}
+
+func (f *ratesFlag) String() string {
+	if len(f.specs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(f.specs))
+	for k, spec := range f.specs {
+		parts = append(parts, k+"="+spec)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (f *ratesFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	key, spec, ok := strings.Cut(value, "=")
+	key, spec = strings.TrimSpace(key), strings.TrimSpace(spec)
+	if !ok || key == "" || spec == "" {
+		return fmt.Errorf("rate-of-change must be in metric=spec form: %q", value)
+	}
+	if _, err := config.ParseRateRules(map[string]string{key: spec}); err != nil {
+		return err
+	}
+	if f.specs == nil {
+		f.specs = make(map[string]string)
+	}
+	f.specs[key] = spec
+	return nil
+}
+
+func (f *ratesFlag) Any() bool { return len(f.specs) > 0 }
+
+func (f *ratesFlag) Values() map[string]anomaly.RateRule {
+	// Specs were validated in Set.
+	rules, _ := config.ParseRateRules(f.specs)
+	return rules
+}
+
+// changePointsFlag collects --change-point values: metric enables CUSUM
+// change-point detection with the default threshold, metric=8 sets it.
+type changePointsFlag struct {
+	specs map[string]string
+}
+
+func (f *changePointsFlag) String() string {
+	if len(f.specs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(f.specs))
+	for k, spec := range f.specs {
+		parts = append(parts, k+"="+spec)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (f *changePointsFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	key, spec, ok := strings.Cut(value, "=")
+	key, spec = strings.TrimSpace(key), strings.TrimSpace(spec)
+	if !ok {
+		spec = "on"
+	}
+	if key == "" || spec == "" {
+		return fmt.Errorf("change point must be in metric or metric=threshold form: %q", value)
+	}
+	if _, err := config.ParseChangePoints(map[string]string{key: spec}); err != nil {
+		return err
+	}
+	if f.specs == nil {
+		f.specs = make(map[string]string)
+	}
+	f.specs[key] = spec
+	return nil
+}
+
+func (f *changePointsFlag) Any() bool { return len(f.specs) > 0 }
+
+func (f *changePointsFlag) Values() map[string]anomaly.ChangePointRule {
+	// Specs were validated in Set.
+	rules, _ := config.ParseChangePoints(f.specs)
+	return rules
+}
+
+// mergeRuleMaps returns base overlaid with extra, or nil when both are empty.
+func mergeRuleMaps[V any](base, extra map[string]V) map[string]V {
 	if len(base) == 0 && len(extra) == 0 {
 		return nil
 	}
-	out := make(map[string]float64, len(base)+len(extra))
+	out := make(map[string]V, len(base)+len(extra))
 	for k, v := range base {
 		out[k] = v
 	}
 	for k, v := range extra {
 		out[k] = v
 	}
-	if len(out) == 0 {
-		return nil
-	}
 	return out
 }
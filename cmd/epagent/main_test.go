@@ -3,7 +3,12 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/silence"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/watch"
 )
 
 func writeSamplesJSONL(t *testing.T) string {
@@ -113,6 +118,28 @@ func TestAnalyze_LastWorks(t *testing.T) {
 	}
 }
 
+func TestAnalyze_TolerantSkipsMalformedLines(t *testing.T) {
+	in := writeSamplesJSONL(t)
+	data, err := os.ReadFile(in)
+	if err != nil {
+		t.Fatalf("read samples: %v", err)
+	}
+	corrupted := "not-json\n" + string(data)
+	if err := os.WriteFile(in, []byte(corrupted), 0o644); err != nil {
+		t.Fatalf("write samples: %v", err)
+	}
+	if err := runAnalyze([]string{"--in", in, "--format", "json"}); err == nil {
+		t.Fatalf("expected strict read to fail")
+	}
+	quarantine := filepath.Join(t.TempDir(), "bad.jsonl")
+	if err := runAnalyze([]string{"--in", in, "--format", "json", "--tolerant", "--quarantine", quarantine}); err != nil {
+		t.Fatalf("runAnalyze: %v", err)
+	}
+	if _, err := os.Stat(quarantine); err != nil {
+		t.Fatalf("expected quarantine file: %v", err)
+	}
+}
+
 func TestReport_WritesToStdout(t *testing.T) {
 	in := writeSamplesJSONL(t)
 	if err := runReport([]string{"--in", in, "--out", "-", "--window", "5", "--threshold", "3"}); err != nil {
@@ -148,6 +175,86 @@ func TestReport_AcceptsStaticThreshold(t *testing.T) {
 	}
 }
 
+func TestStaticThresholdsFlag_ParsesUpperAndLower(t *testing.T) {
+	var f staticThresholdsFlag
+	for _, v := range []string{"cpu=85", "mem>90", "net_rx<1024"} {
+		if err := f.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+	upper, lower := f.Values(), f.LowerValues()
+	if upper["cpu_percent"] != 85 || upper["mem_used_percent"] != 90 || len(upper) != 2 {
+		t.Fatalf("unexpected upper thresholds: %+v", upper)
+	}
+	if lower["net_rx_bytes_per_sec"] != 1024 || len(lower) != 1 {
+		t.Fatalf("unexpected lower thresholds: %+v", lower)
+	}
+	if err := f.Set("cpu~85"); err == nil {
+		t.Fatal("expected error for missing operator")
+	}
+}
+
+func TestAnalyze_RejectsUnknownDirection(t *testing.T) {
+	in := writeSamplesJSONL(t)
+	if err := runAnalyze([]string{"--in", in, "--direction", "cpu=sideways"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestAnalyze_AcceptsForecast(t *testing.T) {
+	in := writeSamplesJSONL(t)
+	if err := runAnalyze([]string{"--in", in, "--forecast", "disk=24h>90"}); err != nil {
+		t.Fatalf("runAnalyze: %v", err)
+	}
+	if err := runAnalyze([]string{"--in", in, "--forecast", "net=24h"}); err == nil {
+		t.Fatalf("expected error for a non-gauge forecast metric")
+	}
+}
+
+func TestAnalyze_AcceptsChangePoint(t *testing.T) {
+	in := writeSamplesJSONL(t)
+	if err := runAnalyze([]string{"--in", in, "--change-point", "mem", "--change-point", "cpu=8"}); err != nil {
+		t.Fatalf("runAnalyze: %v", err)
+	}
+	if err := runAnalyze([]string{"--in", in, "--change-point", "mem=-1"}); err == nil {
+		t.Fatalf("expected error for a negative threshold")
+	}
+}
+
+func TestAnalyze_SavesAndLoadsSeasonalModel(t *testing.T) {
+	in := writeSamplesJSONL(t)
+	model := filepath.Join(t.TempDir(), "seasonal.json")
+	if err := runAnalyze([]string{"--in", in, "--save-seasonal", model}); err != nil {
+		t.Fatalf("runAnalyze --save-seasonal: %v", err)
+	}
+	if err := runAnalyze([]string{"--in", in, "--detector", "seasonal_weekly", "--seasonal-model", model}); err != nil {
+		t.Fatalf("runAnalyze --seasonal-model: %v", err)
+	}
+	if err := runAnalyze([]string{"--in", in, "--seasonal-model", filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Fatalf("expected error for a missing model")
+	}
+}
+
+func TestBaseline_ExportAndImport(t *testing.T) {
+	in := writeSamplesJSONL(t)
+	out := filepath.Join(t.TempDir(), "golden.json")
+	if err := runBaseline([]string{"export", "--in", in, "--out", out}); err != nil {
+		t.Fatalf("runBaseline export: %v", err)
+	}
+	if err := runAnalyze([]string{"--in", in, "--baseline", out, "--format", "json"}); err != nil {
+		t.Fatalf("runAnalyze --baseline: %v", err)
+	}
+	if err := runReport([]string{"--in", in, "--out", "-", "--baseline", out}); err != nil {
+		t.Fatalf("runReport --baseline: %v", err)
+	}
+	if err := runBaseline([]string{"export", "--in", in, "--out", out, "--host-id", "missing"}); err == nil {
+		t.Fatalf("expected error when no samples match --host-id")
+	}
+	if err := runBaseline([]string{"import"}); err == nil {
+		t.Fatalf("expected error for an unknown subcommand")
+	}
+}
+
 func TestReport_LastCannotCombineUntil(t *testing.T) {
 	in := writeSamplesJSONL(t)
 	if err := runReport([]string{"--in", in, "--out", "-", "--last", "1s", "--until", "2026-02-09T00:00:02Z"}); err == nil {
@@ -167,6 +274,34 @@ func TestWatch_RejectsNegativeDuration(t *testing.T) {
 	}
 }
 
+func TestWatch_WarmRequiresOut(t *testing.T) {
+	if err := runWatch([]string{"--duration", "1s", "--warm", "10"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestResumeWatch_FallsBackToWarmWhenStateIsForeign(t *testing.T) {
+	out := writeSamplesJSONL(t)
+	engine, err := watch.NewEngine(5, 3, nil, "low", 0)
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	if err := watch.SaveState(statePath, watch.State{SchemaVersion: watch.StateVersion, SavedAt: time.Now(), HostID: "other"}); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	if err := resumeWatch(engine, "test", statePath, time.Hour, out, 10); err != nil {
+		t.Fatalf("resumeWatch: %v", err)
+	}
+	state, err := engine.Checkpoint(time.Now())
+	if err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	if state.Prev == nil || state.HostID != "test" || len(state.Evaluator.Detector) == 0 {
+		t.Fatalf("expected engine warmed from %s, got %+v", out, state)
+	}
+}
+
 func TestWatch_RejectsUnknownMetrics(t *testing.T) {
 	if err := runWatch([]string{"--duration", "1s", "--metrics", "nope"}); err == nil {
 		t.Fatalf("expected error")
@@ -214,3 +349,125 @@ func TestKVLabelsFlag_ParseAndMerge(t *testing.T) {
 		t.Fatalf("unexpected merged labels: %+v", merged)
 	}
 }
+
+func TestSchema_RejectsUnknownName(t *testing.T) {
+	if err := runSchema([]string{"nope"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCompact_RollsUpOldSamples(t *testing.T) {
+	in := writeSamplesJSONL(t)
+	if err := runCompact([]string{"--in", in, "--raw-retention", "2s", "--minute-retention", "0"}); err != nil {
+		t.Fatalf("runCompact: %v", err)
+	}
+	data, err := os.ReadFile(in)
+	if err != nil {
+		t.Fatalf("read compacted file: %v", err)
+	}
+	if !strings.Contains(string(data), `"record_type":"rollup"`) {
+		t.Fatalf("expected rollup records after compaction, got: %s", data)
+	}
+	if err := runAnalyze([]string{"--in", in, "--format", "json"}); err != nil {
+		t.Fatalf("runAnalyze on compacted file: %v", err)
+	}
+}
+
+func TestCompact_RejectsMinuteRetentionShorterThanRaw(t *testing.T) {
+	in := writeSamplesJSONL(t)
+	if err := runCompact([]string{"--in", in, "--raw-retention", "2h", "--minute-retention", "1h"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestMerge_SplitsByHost(t *testing.T) {
+	in := writeSamplesJSONL(t)
+	dir := t.TempDir()
+	unlabeled := filepath.Join(dir, "laptop-2.jsonl")
+	if err := os.WriteFile(unlabeled, []byte(`{"timestamp":"2026-02-09T00:00:00Z","cpu_percent":10}`+"\n"), 0o644); err != nil {
+		t.Fatalf("write samples: %v", err)
+	}
+	splitDir := filepath.Join(dir, "split")
+	if err := runMerge([]string{"--split-dir", splitDir, "--host-from-filename", in, in, unlabeled}); err != nil {
+		t.Fatalf("runMerge: %v", err)
+	}
+	for _, name := range []string{"test.jsonl", "laptop-2.jsonl"} {
+		if _, err := os.Stat(filepath.Join(splitDir, name)); err != nil {
+			t.Fatalf("expected split file %s: %v", name, err)
+		}
+	}
+}
+
+func TestMerge_RequiresInput(t *testing.T) {
+	if err := runMerge(nil); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestReport_FleetWritesHostTable(t *testing.T) {
+	in := writeSamplesJSONL(t)
+	out := filepath.Join(t.TempDir(), "fleet.md")
+	if err := runReport([]string{"--in", in, "--out", out, "--partition-label", "service", "--redact", "omit"}); err != nil {
+		t.Fatalf("runReport: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read report: %v", err)
+	}
+	md := string(data)
+	if !strings.Contains(md, "# Endpoint Fleet Report") || !strings.Contains(md, "| host-1 |") {
+		t.Fatalf("expected redacted fleet report, got:\n%s", md)
+	}
+	if strings.Contains(md, "test") {
+		t.Fatalf("expected host id to be redacted, got:\n%s", md)
+	}
+}
+
+func TestSilence_AddListExpire(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "silences.json")
+	if err := runSilence([]string{"add", "--file", file, "--id", "db-investigation", "--match", "host_id=db-7", "--match", "metric=cpu", "--for", "2h", "--comment", "INC-42"}); err != nil {
+		t.Fatalf("silence add: %v", err)
+	}
+	if err := runSilence([]string{"add", "--file", file, "--id", "backup", "--schedule", "0 2 * * *", "--duration", "1h"}); err != nil {
+		t.Fatalf("silence add schedule: %v", err)
+	}
+	if err := runSilence([]string{"list", "--file", file, "--format", "json"}); err != nil {
+		t.Fatalf("silence list: %v", err)
+	}
+	if err := runSilence([]string{"expire", "--file", file, "db-investigation"}); err != nil {
+		t.Fatalf("silence expire: %v", err)
+	}
+	list, err := silence.Load(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != "db-investigation" || !list[0].Expired(time.Now()) || list[0].Matchers["host_id"] != "db-7" || list[1].Expired(time.Now()) {
+		t.Fatalf("unexpected silences: %+v", list)
+	}
+	if formatted := formatSilences(list, time.Now()); !strings.Contains(formatted, "db-investigation  expired") || !strings.Contains(formatted, `schedule "0 2 * * *" for 1h0m0s`) {
+		t.Fatalf("unexpected listing:\n%s", formatted)
+	}
+
+	for _, bad := range [][]string{
+		{"add", "--file", file, "--match", "metric=cpu"},
+		{"add", "--file", file, "--id", "backup", "--schedule", "0 3 * * *", "--duration", "1h"},
+		{"add", "--file", file, "--schedule", "0 3 * * *"},
+		{"add", "--file", file, "--match", "nokey", "--for", "1h"},
+		{"expire", "--file", file, "missing"},
+		{"mute"},
+	} {
+		if err := runSilence(bad); err == nil {
+			t.Fatalf("expected %v to fail", bad)
+		}
+	}
+}
+
+func TestAnalyze_WarmupFlags(t *testing.T) {
+	in := writeSamplesJSONL(t)
+	if err := runAnalyze([]string{"--in", in, "--format", "json", "--warmup-samples", "30", "--warmup-duration", "5m"}); err != nil {
+		t.Fatalf("runAnalyze: %v", err)
+	}
+	if err := runAnalyze([]string{"--in", in, "--warmup-samples", "-1"}); err == nil {
+		t.Fatal("expected negative warmup-samples to be rejected")
+	}
+}
@@ -10,21 +10,151 @@ import (
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
 )
 
+// SchemaVersion is the version of Alert emitted by this build.
+const SchemaVersion = 1
+
 type Alert struct {
+	SchemaVersion int                         `json:"schema_version"`
 	Timestamp     time.Time                   `json:"timestamp"`
 	HostID        string                      `json:"host_id,omitempty"`
 	Labels        map[string]string           `json:"labels,omitempty"`
 	Metric        string                      `json:"metric"`
 	Value         float64                     `json:"value"`
 	RuleType      string                      `json:"rule_type,omitempty"`
+	Direction     string                      `json:"direction,omitempty"`
+	Algorithm     string                      `json:"algorithm,omitempty"`
 	Threshold     float64                     `json:"threshold,omitempty"`
 	Mean          float64                     `json:"mean"`
 	Stddev        float64                     `json:"stddev"`
 	ZScore        float64                     `json:"zscore"`
+	Bounds        *anomaly.Bounds             `json:"bounds,omitempty"`
+	Condition     *anomaly.Condition          `json:"condition,omitempty"`
+	Forecast      *anomaly.Forecast           `json:"forecast,omitempty"`
+	RateOfChange  *anomaly.RateOfChange       `json:"rate_of_change,omitempty"`
+	ChangePoint   *anomaly.ChangePoint        `json:"change_point,omitempty"`
+	Expression    *anomaly.ExpressionMatch    `json:"expression,omitempty"`
 	Severity      string                      `json:"severity"`
+	Score         float64                     `json:"score"`
 	Explanation   string                      `json:"explanation"`
+	Hint          string                      `json:"hint,omitempty"`
 	TopCPUProcess *anomaly.ProcessAttribution `json:"top_cpu_process,omitempty"`
 	TopMemProcess *anomaly.ProcessAttribution `json:"top_mem_process,omitempty"`
+	SilencedBy    string                      `json:"silenced_by,omitempty"`
+	// Incident is set when the alert is part of a correlated incident.
+	Incident *IncidentRef `json:"incident,omitempty"`
+	// Related holds the other alerts of the incident this alert leads.
+	Related []Alert `json:"related,omitempty"`
+}
+
+// IncidentRef summarizes the incident an alert belongs to.
+type IncidentRef struct {
+	ID          string                      `json:"id"`
+	Start       time.Time                   `json:"start"`
+	End         time.Time                   `json:"end"`
+	Metrics     []string                    `json:"metrics"`
+	Severity    string                      `json:"severity"`
+	Score       float64                     `json:"score"`
+	LikelyCause *anomaly.ProcessAttribution `json:"likely_cause,omitempty"`
+	Explanation string                      `json:"explanation"`
+}
+
+// NewIncidentRef snapshots inc for attaching to alerts.
+func NewIncidentRef(inc *anomaly.Incident) *IncidentRef {
+	metrics := make([]string, len(inc.Metrics))
+	copy(metrics, inc.Metrics)
+	return &IncidentRef{
+		ID:          inc.ID,
+		Start:       inc.Start,
+		End:         inc.End,
+		Metrics:     metrics,
+		Severity:    inc.Severity,
+		Score:       inc.Score,
+		LikelyCause: inc.LikelyCause,
+		Explanation: inc.Explanation,
+	}
+}
+
+// FromIncident builds one alert for a correlated incident: the most severe
+// anomaly leads and the others are nested under Related.
+func FromIncident(inc anomaly.Incident, hostID string, labels map[string]string) Alert {
+	if inc.HostID != "" {
+		hostID = inc.HostID
+	}
+	ref := NewIncidentRef(&inc)
+	alerts := make([]Alert, 0, len(inc.Anomalies))
+	for _, a := range inc.Anomalies {
+		alertLabels := a.Labels
+		if len(alertLabels) == 0 {
+			alertLabels = labels
+		}
+		al := FromAnomaly(a, hostID, alertLabels)
+		al.Incident = ref
+		alerts = append(alerts, al)
+	}
+	return Nest(alerts)[0]
+}
+
+// Nest folds alerts that share an incident into a single alert per incident,
+// led by the highest-scoring one (the earliest on ties) with the rest under
+// Related. Alerts without an incident are returned unchanged, in order.
+func Nest(alerts []Alert) []Alert {
+	out := make([]Alert, 0, len(alerts))
+	lead := map[string]int{}
+	for _, a := range alerts {
+		if a.Incident == nil {
+			out = append(out, a)
+			continue
+		}
+		i, ok := lead[a.Incident.ID]
+		if !ok {
+			lead[a.Incident.ID] = len(out)
+			out = append(out, a)
+			continue
+		}
+		primary := out[i]
+		if a.Score > primary.Score {
+			related := primary.Related
+			primary.Related = nil
+			a.Related = append(related, primary)
+			out[i] = a
+			continue
+		}
+		primary.Related = append(primary.Related, a)
+		out[i] = primary
+	}
+	return out
+}
+
+// FromAnomaly builds an alert for an anomaly observed on hostID.
+func FromAnomaly(a anomaly.Anomaly, hostID string, labels map[string]string) Alert {
+	return Alert{
+		SchemaVersion: SchemaVersion,
+		Timestamp:     a.Timestamp,
+		HostID:        hostID,
+		Labels:        labels,
+		Metric:        a.Name,
+		Value:         a.Value,
+		RuleType:      a.RuleType,
+		Direction:     a.Direction,
+		Algorithm:     a.Algorithm,
+		Threshold:     a.Threshold,
+		Mean:          a.Mean,
+		Stddev:        a.Stddev,
+		ZScore:        a.ZScore,
+		Bounds:        a.Bounds,
+		Condition:     a.Condition,
+		Forecast:      a.Forecast,
+		RateOfChange:  a.RateOfChange,
+		ChangePoint:   a.ChangePoint,
+		Expression:    a.Expression,
+		Severity:      a.Severity,
+		Score:         a.Score,
+		Explanation:   a.Explanation,
+		Hint:          a.Hint,
+		TopCPUProcess: a.TopCPUProcess,
+		TopMemProcess: a.TopMemProcess,
+		SilencedBy:    a.SilencedBy,
+	}
 }
 
 type Sink interface {
@@ -51,18 +181,3 @@ func (s *StdoutSink) Emit(_ context.Context, a Alert) error {
 }
 
 func (s *StdoutSink) Close() error { return nil }
-
-func SeverityRank(severity string) (int, bool) {
-	switch severity {
-	case "low":
-		return 1, true
-	case "medium":
-		return 2, true
-	case "high":
-		return 3, true
-	case "critical":
-		return 4, true
-	default:
-		return 0, false
-	}
-}
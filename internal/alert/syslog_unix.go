@@ -5,8 +5,9 @@ package alert
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log/syslog"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/severity"
 )
 
 type SyslogSink struct {
@@ -30,16 +31,25 @@ func (s *SyslogSink) Emit(_ context.Context, a Alert) error {
 	msg := string(payload)
 
 	switch a.Severity {
-	case "critical":
+	case severity.Critical:
 		return s.w.Crit(msg)
-	case "high":
+	case severity.High:
 		return s.w.Err(msg)
-	case "medium":
+	case severity.Medium:
 		return s.w.Warning(msg)
-	case "low":
+	case severity.Low:
 		return s.w.Info(msg)
+	}
+	// Custom levels map onto the same priorities by score quarter.
+	switch {
+	case a.Score >= 0.75*severity.MaxScore:
+		return s.w.Crit(msg)
+	case a.Score >= 0.5*severity.MaxScore:
+		return s.w.Err(msg)
+	case a.Score >= 0.25*severity.MaxScore:
+		return s.w.Warning(msg)
 	default:
-		return s.w.Info(fmt.Sprintf("unknown severity %q: %s", a.Severity, msg))
+		return s.w.Info(msg)
 	}
 }
 
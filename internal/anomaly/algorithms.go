@@ -0,0 +1,249 @@
+package anomaly
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+func init() {
+	Register(AlgorithmZScore, func(p Params) Algorithm { return newZScore(p) })
+	Register(AlgorithmEWMA, func(p Params) Algorithm { return newEWMA(p) })
+	Register(AlgorithmMAD, func(p Params) Algorithm { return newMAD(p) })
+	Register(AlgorithmPercentile, func(p Params) Algorithm { return newPercentile(p) })
+	Register(AlgorithmSeasonal, func(p Params) Algorithm { return newSeasonal(p, false) })
+	Register(AlgorithmSeasonalWeekly, func(p Params) Algorithm { return newSeasonal(p, true) })
+	Register(AlgorithmHolt, func(p Params) Algorithm { return newHolt(p) })
+}
+
+// window keeps the most recent values of a series.
+type window struct {
+	size   int
+	values []float64
+}
+
+func (w *window) add(v float64) {
+	w.values = append(w.values, v)
+	if len(w.values) > w.size {
+		w.values = w.values[len(w.values)-w.size:]
+	}
+}
+
+func (w *window) full() bool { return len(w.values) >= w.size }
+
+func (w *window) sorted() []float64 {
+	out := append([]float64(nil), w.values...)
+	sort.Float64s(out)
+	return out
+}
+
+func symmetricResult(value, expected, spread, threshold float64) Result {
+	return Result{
+		Ready:    true,
+		Score:    (value - expected) / spread,
+		Expected: expected,
+		Spread:   spread,
+		Lower:    expected - threshold*spread,
+		Upper:    expected + threshold*spread,
+	}
+}
+
+// zscore compares a value to the mean and population stddev of a rolling
+// window.
+type zscore struct {
+	threshold float64
+	window    window
+}
+
+func newZScore(p Params) *zscore {
+	return &zscore{threshold: p.Threshold, window: window{size: p.WindowSize}}
+}
+
+func (z *zscore) Score(_ time.Time, value float64) Result {
+	if !z.window.full() {
+		return Result{}
+	}
+	mean, stddev := meanStddev(z.window.values)
+	if stddev <= 0 {
+		return Result{Expected: mean}
+	}
+	return symmetricResult(value, mean, stddev, z.threshold)
+}
+
+func (z *zscore) Learn(_ time.Time, value float64) { z.window.add(value) }
+
+// ewma tracks an exponentially weighted mean and variance. Without a
+// half-life its smoothing factor matches a simple moving average of the
+// window size; with one, each sample's weight decays with the time elapsed
+// since the previous sample, so the baseline covers the same span of time at
+// any sampling interval and tolerates irregular spacing.
+type ewma struct {
+	threshold float64
+	minCount  int
+	alpha     float64
+	halfLife  time.Duration
+	count     int
+	mean      float64
+	variance  float64
+	// last is the timestamp of the last learned sample and learned the time
+	// span covered so far; both are only used with a half-life.
+	last    time.Time
+	learned time.Duration
+}
+
+func newEWMA(p Params) *ewma {
+	return &ewma{
+		threshold: p.Threshold,
+		minCount:  p.WindowSize,
+		alpha:     2 / (float64(p.WindowSize) + 1),
+		halfLife:  p.HalfLife,
+	}
+}
+
+func (e *ewma) Score(_ time.Time, value float64) Result {
+	if !smoothingReady(e.count, e.minCount, e.halfLife, e.learned) {
+		return Result{}
+	}
+	stddev := math.Sqrt(e.variance)
+	if stddev <= 0 {
+		return Result{Expected: e.mean}
+	}
+	return symmetricResult(value, e.mean, stddev, e.threshold)
+}
+
+func (e *ewma) Learn(ts time.Time, value float64) {
+	alpha, elapsed := decay(e.alpha, e.halfLife, e.last, ts)
+	e.learned += elapsed
+	if !ts.IsZero() {
+		e.last = ts
+	}
+	e.count++
+	if e.count == 1 {
+		e.mean = value
+		return
+	}
+	diff := value - e.mean
+	incr := alpha * diff
+	e.mean += incr
+	e.variance = (1 - alpha) * (e.variance + diff*incr)
+}
+
+// minTimedSamples is the fewest samples a half-life smoother scores with, in
+// addition to having seen one half-life of data.
+const minTimedSamples = 5
+
+func smoothingReady(count, minCount int, halfLife, learned time.Duration) bool {
+	if halfLife > 0 {
+		return count >= minTimedSamples && learned >= halfLife
+	}
+	return count >= minCount
+}
+
+// decay returns the weight of a sample at ts following one at last, and the
+// time elapsed between them. Without a half-life, or when either timestamp is
+// missing or out of order, the per-sample weight perSample is used.
+func decay(perSample float64, halfLife time.Duration, last, ts time.Time) (float64, time.Duration) {
+	if halfLife <= 0 || last.IsZero() || ts.IsZero() || !ts.After(last) {
+		return perSample, 0
+	}
+	elapsed := ts.Sub(last)
+	return 1 - math.Exp2(-float64(elapsed)/float64(halfLife)), elapsed
+}
+
+// madScale converts a median absolute deviation to a normal-equivalent
+// standard deviation (1/0.6745), so the modified z-score uses the same
+// threshold and severity cut-points as the classic z-score.
+const madScale = 1.4826
+
+// meanADScale does the same for the mean absolute deviation, which is used
+// when more than half the window is identical and the MAD collapses to zero.
+const meanADScale = 1.2533
+
+// mad computes the modified z-score: distance from the rolling median in units
+// of the median absolute deviation. Unlike the mean and stddev, neither moves
+// much when a spike enters the window, so one burst does not mask the next.
+type mad struct {
+	threshold float64
+	window    window
+}
+
+func newMAD(p Params) *mad {
+	return &mad{threshold: p.Threshold, window: window{size: p.WindowSize}}
+}
+
+func (m *mad) Score(_ time.Time, value float64) Result {
+	if !m.window.full() {
+		return Result{}
+	}
+	median, spread := robustSpread(m.window.values)
+	if spread <= 0 {
+		return Result{Expected: median}
+	}
+	return symmetricResult(value, median, spread, m.threshold)
+}
+
+func (m *mad) Learn(_ time.Time, value float64) { m.window.add(value) }
+
+// robustSpread returns the median of values and a normal-equivalent spread
+// from the MAD, falling back to the mean absolute deviation around the median.
+func robustSpread(values []float64) (median, spread float64) {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	median = quantile(sorted, 0.5)
+	deviations := make([]float64, len(sorted))
+	var sum float64
+	for i, v := range sorted {
+		deviations[i] = math.Abs(v - median)
+		sum += deviations[i]
+	}
+	sort.Float64s(deviations)
+	if dev := quantile(deviations, 0.5); dev > 0 {
+		return median, dev * madScale
+	}
+	return median, sum / float64(len(deviations)) * meanADScale
+}
+
+// interdecileScale converts the p10–p90 range of a normal distribution to its
+// standard deviation (2 × 1.2816).
+const interdecileScale = 2.5631
+
+// percentile compares a value to the rolling median, with the spread taken
+// from the window's p10–p90 range.
+type percentile struct {
+	threshold float64
+	window    window
+}
+
+func newPercentile(p Params) *percentile {
+	return &percentile{threshold: p.Threshold, window: window{size: p.WindowSize}}
+}
+
+func (p *percentile) Score(_ time.Time, value float64) Result {
+	if !p.window.full() {
+		return Result{}
+	}
+	sorted := p.window.sorted()
+	median := quantile(sorted, 0.5)
+	spread := (quantile(sorted, 0.9) - quantile(sorted, 0.1)) / interdecileScale
+	if spread <= 0 {
+		return Result{Expected: median}
+	}
+	return symmetricResult(value, median, spread, p.threshold)
+}
+
+func (p *percentile) Learn(_ time.Time, value float64) { p.window.add(value) }
+
+// quantile returns the linearly interpolated q-quantile of sorted values.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	if lo == hi {
+		return sorted[lo]
+	}
+	frac := pos - float64(lo)
+	return sorted[lo] + (sorted[hi]-sorted[lo])*frac
+}
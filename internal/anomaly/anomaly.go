@@ -3,6 +3,7 @@ package anomaly
 import (
 	"fmt"
 	"math"
+	"strings"
 	"time"
 )
 
@@ -18,83 +19,50 @@ const (
 	RuleTypeStaticThreshold = "static_threshold"
 )
 
+// Bounds is the range of values a detector considered normal when it scored
+// the anomalous value.
+type Bounds struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+}
+
 type Anomaly struct {
-	Name          string
-	Timestamp     time.Time         `json:"timestamp,omitempty"`
-	Labels        map[string]string `json:"labels,omitempty"`
-	Value         float64
-	RuleType      string  `json:"rule_type,omitempty"`
-	Threshold     float64 `json:"threshold,omitempty"`
-	Mean          float64
-	Stddev        float64
-	ZScore        float64
+	Name      string
+	Timestamp time.Time         `json:"timestamp,omitempty"`
+	HostID    string            `json:"host_id,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Value     float64
+	RuleType  string  `json:"rule_type,omitempty"`
+	Direction string  `json:"direction,omitempty"`
+	Algorithm string  `json:"algorithm,omitempty"`
+	Threshold float64 `json:"threshold,omitempty"`
+	Mean      float64
+	Stddev    float64
+	ZScore    float64
+	Bounds    *Bounds `json:"bounds,omitempty"`
+	// Condition is set for sustained rules and says how long the condition
+	// held before the rule fired.
+	Condition *Condition `json:"condition,omitempty"`
+	// Forecast is set for forecast rules.
+	Forecast *Forecast `json:"forecast,omitempty"`
+	// RateOfChange is set for rate-of-change rules.
+	RateOfChange *RateOfChange `json:"rate_of_change,omitempty"`
+	// ChangePoint is set for change-point rules.
+	ChangePoint *ChangePoint `json:"change_point,omitempty"`
+	// Expression is set for expression rules; Name is then the rule name.
+	Expression    *ExpressionMatch `json:"expression,omitempty"`
 	Severity      string
+	Score         float64 `json:"score"`
 	Explanation   string
+	Hint          string              `json:"hint,omitempty"`
 	TopCPUProcess *ProcessAttribution `json:"top_cpu_process,omitempty"`
 	TopMemProcess *ProcessAttribution `json:"top_mem_process,omitempty"`
-}
-
-type Detector struct {
-	windowSize int
-	threshold  float64
-	history    map[string][]float64
-}
-
-func NewDetector(windowSize int, threshold float64) *Detector {
-	if windowSize < 5 {
-		windowSize = 5
-	}
-	if threshold <= 0 {
-		threshold = 3.0
-	}
-	return &Detector{
-		windowSize: windowSize,
-		threshold:  threshold,
-		history:    make(map[string][]float64),
-	}
-}
-
-func (d *Detector) Check(name string, value float64) *Anomaly {
-	history := d.history[name]
-	mean, stddev := meanStddev(history)
-	anomaly := (*Anomaly)(nil)
-	if len(history) >= d.windowSize && stddev > 0 {
-		z := (value - mean) / stddev
-		if math.Abs(z) >= d.threshold {
-			anomaly = &Anomaly{
-				Name:        name,
-				Value:       value,
-				RuleType:    RuleTypeZScore,
-				Mean:        mean,
-				Stddev:      stddev,
-				ZScore:      z,
-				Severity:    severityFromZ(z),
-				Explanation: explain(name, value, mean, z),
-			}
-		}
-	}
-
-	history = append(history, value)
-	if len(history) > d.windowSize {
-		history = history[len(history)-d.windowSize:]
-	}
-	d.history[name] = history
-
-	return anomaly
-}
-
-func severityFromZ(z float64) string {
-	abs := math.Abs(z)
-	switch {
-	case abs >= 6:
-		return "critical"
-	case abs >= 4:
-		return "high"
-	case abs >= 3:
-		return "medium"
-	default:
-		return "low"
-	}
+	// SilencedBy is the ID of the silence that matched the anomaly; reports
+	// keep silenced anomalies, watch does not emit them.
+	SilencedBy string `json:"silenced_by,omitempty"`
+	// Warmup marks an anomaly raised while the metric's baseline was still
+	// warming up; reports keep it, watch does not emit it.
+	Warmup bool `json:"warmup,omitempty"`
 }
 
 func meanStddev(values []float64) (float64, float64) {
@@ -119,22 +87,47 @@ func CheckStaticThreshold(name string, value float64, thresholds map[string]floa
 	if len(thresholds) == 0 {
 		return nil
 	}
-	threshold, ok := thresholds[name]
+	threshold, ok := metricRule(thresholds, name)
 	if !ok || threshold <= 0 || value < threshold {
 		return nil
 	}
 	exceedRatio := (value - threshold) / threshold
-	return &Anomaly{
+	return graded(&Anomaly{
 		Name:        name,
 		Value:       value,
 		RuleType:    RuleTypeStaticThreshold,
+		Direction:   DirectionAbove,
 		Threshold:   threshold,
 		Mean:        threshold,
 		Stddev:      0,
 		ZScore:      exceedRatio,
-		Severity:    severityFromExceedRatio(exceedRatio),
 		Explanation: explainStaticThreshold(name, value, threshold, exceedRatio),
+	})
+}
+
+// CheckStaticLowerThreshold flags values that fall below their floor. The
+// shortfall ratio (how far below, as a fraction of the floor) maps to severity
+// the same way the exceed ratio does for upper thresholds, so a value of zero
+// is critical.
+func CheckStaticLowerThreshold(name string, value float64, thresholds map[string]float64) *Anomaly {
+	if len(thresholds) == 0 {
+		return nil
 	}
+	threshold, ok := metricRule(thresholds, name)
+	if !ok || threshold <= 0 || value >= threshold {
+		return nil
+	}
+	shortfall := (threshold - value) / threshold
+	return graded(&Anomaly{
+		Name:        name,
+		Value:       value,
+		RuleType:    RuleTypeStaticThreshold,
+		Direction:   DirectionBelow,
+		Threshold:   threshold,
+		Mean:        threshold,
+		ZScore:      -shortfall,
+		Explanation: explainStaticLowerThreshold(name, value, threshold, shortfall),
+	})
 }
 
 func SelectHigherSeverity(a, b *Anomaly) *Anomaly {
@@ -144,12 +137,10 @@ func SelectHigherSeverity(a, b *Anomaly) *Anomaly {
 	if b == nil {
 		return a
 	}
-	ar, _ := severityRank(a.Severity)
-	br, _ := severityRank(b.Severity)
 	switch {
-	case br > ar:
+	case b.Score > a.Score:
 		return b
-	case br < ar:
+	case b.Score < a.Score:
 		return a
 	}
 
@@ -171,86 +162,168 @@ func SelectHigherSeverity(a, b *Anomaly) *Anomaly {
 	return a
 }
 
-func severityFromExceedRatio(ratio float64) string {
-	switch {
-	case ratio >= 1.0:
-		return "critical"
-	case ratio >= 0.5:
-		return "high"
-	case ratio >= 0.2:
-		return "medium"
-	default:
-		return "low"
-	}
-}
-
-func severityRank(severity string) (int, bool) {
-	switch severity {
-	case "low":
-		return 1, true
-	case "medium":
-		return 2, true
-	case "high":
-		return 3, true
-	case "critical":
-		return 4, true
-	default:
-		return 0, false
-	}
-}
-
 func explainStaticThreshold(name string, value, threshold, exceedRatio float64) string {
 	return fmt.Sprintf("Static threshold exceeded for %s: value %.2f is above %.2f (%.1f%% over threshold).", name, value, threshold, exceedRatio*100)
 }
 
+func explainStaticLowerThreshold(name string, value, threshold, shortfall float64) string {
+	return fmt.Sprintf("%s fell below its floor: %s is under %s (%.1f%% below threshold).", MetricLabel(name), FormatValue(name, value), FormatValue(name, threshold), shortfall*100)
+}
+
 func explain(name string, value, mean, z float64) string {
 	sigma := math.Abs(z)
 	trendUp := z >= 0
 
-	switch name {
+	var sentence string
+	switch BaseMetric(name) {
 	case "cpu_percent":
 		verb := "spiked"
 		if !trendUp {
 			verb = "dropped"
 		}
-		return fmt.Sprintf("CPU usage %s to %.1f%% (baseline %.1f%%, %.1fσ). Check for runaway processes, background jobs, or throttling.", verb, value, mean, sigma)
+		sentence = fmt.Sprintf("CPU usage %s to %.1f%% (baseline %.1f%%, %.1fσ).", verb, value, mean, sigma)
 	case "mem_used_percent":
 		verb := "rose"
 		if !trendUp {
 			verb = "fell"
 		}
-		return fmt.Sprintf("Memory usage %s to %.1f%% (baseline %.1f%%, %.1fσ). Look for leaks, large caches, or memory pressure.", verb, value, mean, sigma)
+		sentence = fmt.Sprintf("Memory usage %s to %.1f%% (baseline %.1f%%, %.1fσ).", verb, value, mean, sigma)
 	case "disk_used_percent":
 		verb := "rose"
 		if !trendUp {
 			verb = "fell"
 		}
-		return fmt.Sprintf("Disk usage %s to %.1f%% (baseline %.1f%%, %.1fσ). Investigate large writes, logs, or unexpected data growth.", verb, value, mean, sigma)
+		sentence = fmt.Sprintf("%s %s to %.1f%% (baseline %.1f%%, %.1fσ).", MetricLabel(name), verb, value, mean, sigma)
 	case "disk_read_bytes_per_sec":
 		verb := "jumped"
 		if !trendUp {
 			verb = "dropped"
 		}
-		return fmt.Sprintf("Disk read throughput %s to %.0f B/s (baseline %.0f B/s, %.1fσ). Possible causes: scans, backups, or stalled I/O.", verb, value, mean, sigma)
+		sentence = fmt.Sprintf("Disk read throughput %s to %.0f B/s (baseline %.0f B/s, %.1fσ).", verb, value, mean, sigma)
 	case "disk_write_bytes_per_sec":
 		verb := "jumped"
 		if !trendUp {
 			verb = "dropped"
 		}
-		return fmt.Sprintf("Disk write throughput %s to %.0f B/s (baseline %.0f B/s, %.1fσ). Check for log storms, sync jobs, or blocked writes.", verb, value, mean, sigma)
+		sentence = fmt.Sprintf("Disk write throughput %s to %.0f B/s (baseline %.0f B/s, %.1fσ).", verb, value, mean, sigma)
 	case "net_rx_bytes_per_sec":
 		verb := "spiked"
 		if !trendUp {
 			verb = "dropped"
 		}
-		return fmt.Sprintf("Inbound network %s to %.0f B/s (baseline %.0f B/s, %.1fσ). Verify unexpected downloads or large transfers.", verb, value, mean, sigma)
+		sentence = fmt.Sprintf("Inbound network %s to %.0f B/s (baseline %.0f B/s, %.1fσ).", verb, value, mean, sigma)
 	case "net_tx_bytes_per_sec":
 		verb := "spiked"
 		if !trendUp {
 			verb = "dropped"
 		}
-		return fmt.Sprintf("Outbound network %s to %.0f B/s (baseline %.0f B/s, %.1fσ). Look for uploads, backups, or exfil signals.", verb, value, mean, sigma)
+		sentence = fmt.Sprintf("Outbound network %s to %.0f B/s (baseline %.0f B/s, %.1fσ).", verb, value, mean, sigma)
+	case "disk_free_bytes":
+		verb := "rose"
+		if !trendUp {
+			verb = "fell"
+		}
+		sentence = fmt.Sprintf("Free disk space %s to %s (baseline %s, %.1fσ).", verb, FormatValue(name, value), FormatValue(name, mean), sigma)
 	default:
 		return fmt.Sprintf("Metric %s deviated from baseline (%.1fσ).", name, sigma)
 	}
+	return sentence + " " + Hint(name)
+}
+
+// Hint is the built-in remediation hint for a metric, appended to baseline
+// explanations. Unknown metrics have none.
+func Hint(name string) string {
+	switch BaseMetric(name) {
+	case "cpu_percent":
+		return "Check for runaway processes, background jobs, or throttling."
+	case "mem_used_percent":
+		return "Look for leaks, large caches, or memory pressure."
+	case "disk_used_percent":
+		return "Investigate large writes, logs, or unexpected data growth."
+	case "disk_read_bytes_per_sec":
+		return "Possible causes: scans, backups, or stalled I/O."
+	case "disk_write_bytes_per_sec":
+		return "Check for log storms, sync jobs, or blocked writes."
+	case "net_rx_bytes_per_sec":
+		return "Verify unexpected downloads or large transfers."
+	case "net_tx_bytes_per_sec":
+		return "Look for uploads, backups, or exfil signals."
+	case "disk_free_bytes":
+		return "Check for log growth, caches, or large downloads filling the disk."
+	default:
+		return ""
+	}
+}
+
+// MetricLabel is the human-readable name used at the start of explanations.
+// Per-mount metrics name their mount: "Disk usage (/var)".
+func MetricLabel(name string) string {
+	if mount := MetricMount(name); mount != "" {
+		return MetricLabel(BaseMetric(name)) + " (" + mount + ")"
+	}
+	switch name {
+	case "cpu_percent":
+		return "CPU"
+	case "mem_used_percent":
+		return "Memory usage"
+	case "disk_used_percent":
+		return "Disk usage"
+	case "disk_free_bytes":
+		return "Free disk space"
+	case "disk_read_bytes_per_sec":
+		return "Disk read throughput"
+	case "disk_write_bytes_per_sec":
+		return "Disk write throughput"
+	case "net_rx_bytes_per_sec":
+		return "Inbound network"
+	case "net_tx_bytes_per_sec":
+		return "Outbound network"
+	default:
+		return name
+	}
+}
+
+// BaseMetric strips the qualifier from a per-mount metric such as
+// "disk_used_percent{mount=/var}" (see collector.MountMetric), so it is
+// formatted, explained and given rules like the metric it qualifies.
+func BaseMetric(name string) string {
+	if i := strings.IndexByte(name, '{'); i > 0 && strings.HasSuffix(name, "}") {
+		return name[:i]
+	}
+	return name
+}
+
+// MetricMount returns the mount of a per-mount metric, or "".
+func MetricMount(name string) string {
+	base := BaseMetric(name)
+	if base == name {
+		return ""
+	}
+	mount, _ := strings.CutPrefix(name[len(base)+1:len(name)-1], "mount=")
+	return mount
+}
+
+// metricRule returns the rule for name, falling back to the rule for its
+// metric, so a rule for disk_used_percent also covers each mount.
+func metricRule[V any](rules map[string]V, name string) (V, bool) {
+	if v, ok := rules[name]; ok {
+		return v, true
+	}
+	v, ok := rules[BaseMetric(name)]
+	return v, ok
+}
+
+// FormatValue formats v in the unit of metric name.
+func FormatValue(name string, v float64) string {
+	name = BaseMetric(name)
+	if strings.HasSuffix(name, "_percent") {
+		return fmt.Sprintf("%.1f%%", v)
+	}
+	if strings.HasSuffix(name, "_bytes_per_sec") {
+		return fmt.Sprintf("%.0f B/s", v)
+	}
+	if strings.HasSuffix(name, "_bytes") {
+		return fmt.Sprintf("%.0f B", v)
+	}
+	return fmt.Sprintf("%.2f", v)
 }
@@ -1,8 +1,14 @@
 package anomaly
 
 import (
+	"encoding/json"
+	"math"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/severity"
 )
 
 func TestDetectorFlagsAnomaly(t *testing.T) {
@@ -59,10 +65,1147 @@ func TestCheckStaticThresholdFlagsExceededValue(t *testing.T) {
 }
 
 func TestSelectHigherSeverityPrefersHigherSeverity(t *testing.T) {
-	zscore := &Anomaly{RuleType: RuleTypeZScore, Severity: "medium", ZScore: 3.5}
-	static := &Anomaly{RuleType: RuleTypeStaticThreshold, Severity: "high", ZScore: 0.5}
+	zscore := graded(&Anomaly{RuleType: RuleTypeZScore, ZScore: 3.5})
+	static := graded(&Anomaly{RuleType: RuleTypeStaticThreshold, ZScore: 0.5})
+	if zscore.Severity != "medium" || static.Severity != "high" {
+		t.Fatalf("unexpected severities %q and %q", zscore.Severity, static.Severity)
+	}
 	chosen := SelectHigherSeverity(zscore, static)
 	if chosen != static {
 		t.Fatalf("expected higher severity anomaly to be selected")
 	}
 }
+
+func TestEveryRegisteredAlgorithmFlagsSpike(t *testing.T) {
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range RegisteredAlgorithms() {
+		detector := NewDetectorWithAlgorithms(5, 3.0, AlgorithmConfig{Default: name})
+		values := []float64{10, 11, 9, 10, 12, 11, 10, 9, 60}
+		var flagged *Anomaly
+		for i, v := range values {
+			flagged = detector.CheckAt("cpu_percent", base.Add(time.Duration(i)*time.Second), v)
+		}
+		if flagged == nil {
+			t.Fatalf("%s: expected spike to be flagged", name)
+		}
+		if flagged.Algorithm != name || flagged.RuleType != RuleTypeZScore {
+			t.Fatalf("%s: unexpected algorithm/rule type %q/%q", name, flagged.Algorithm, flagged.RuleType)
+		}
+		if flagged.Bounds == nil || flagged.Value <= flagged.Bounds.Upper || flagged.Mean < flagged.Bounds.Lower {
+			t.Fatalf("%s: expected value above bounds around the expected value, got %+v", name, flagged)
+		}
+	}
+}
+
+func TestDetectorUsesPerMetricAlgorithm(t *testing.T) {
+	detector := NewDetectorWithAlgorithms(5, 3.0, AlgorithmConfig{
+		Default:   AlgorithmZScore,
+		PerMetric: map[string]string{"net_rx_bytes_per_sec": AlgorithmMAD},
+	})
+	var cpu, net *Anomaly
+	for _, v := range []float64{10, 11, 9, 10, 12, 60} {
+		cpu = detector.Check("cpu_percent", v)
+		net = detector.Check("net_rx_bytes_per_sec", v)
+	}
+	if cpu == nil || cpu.Algorithm != AlgorithmZScore {
+		t.Fatalf("expected zscore anomaly for cpu, got %+v", cpu)
+	}
+	if net == nil || net.Algorithm != AlgorithmMAD {
+		t.Fatalf("expected mad anomaly for net, got %+v", net)
+	}
+}
+
+func TestAlgorithmConfigValidateRejectsUnknown(t *testing.T) {
+	err := AlgorithmConfig{PerMetric: map[string]string{"cpu_percent": "nope"}}.Validate()
+	if err == nil || !strings.Contains(err.Error(), "unknown detector") {
+		t.Fatalf("expected unknown detector error, got %v", err)
+	}
+	if _, err := NewAlgorithm("", Params{WindowSize: 5, Threshold: 3}); err != nil {
+		t.Fatalf("expected empty name to select zscore: %v", err)
+	}
+}
+
+func TestDetectorRecordsZScoreForUnknownAlgorithms(t *testing.T) {
+	detector := NewDetectorWithAlgorithms(5, 3.0, AlgorithmConfig{PerMetric: map[string]string{"cpu_percent": "nope"}})
+	var a *Anomaly
+	for _, v := range []float64{10, 11, 9, 10, 12, 60} {
+		a = detector.Check("cpu_percent", v)
+	}
+	if a == nil || a.Algorithm != AlgorithmZScore {
+		t.Fatalf("expected the zscore fallback to be recorded, got %+v", a)
+	}
+	state, err := detector.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if got := state["cpu_percent"].Algorithm; got != AlgorithmZScore {
+		t.Fatalf("expected the snapshot to record zscore, got %q", got)
+	}
+}
+
+func TestSeasonalComparesWithinHourOfDay(t *testing.T) {
+	detector := NewDetectorWithAlgorithms(5, 3.0, AlgorithmConfig{Default: AlgorithmSeasonal})
+	day := 24 * time.Hour
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	for d := 0; d < 6; d++ {
+		night := base.Add(time.Duration(d)*day + 2*time.Hour)
+		noon := base.Add(time.Duration(d)*day + 12*time.Hour)
+		// Nightly backups run hot; daytime is quiet.
+		if a := detector.CheckAt("disk_write_bytes_per_sec", night, 9000+float64(d%2)*100); a != nil {
+			t.Fatalf("did not expect the nightly backup to be flagged: %+v", a)
+		}
+		_ = detector.CheckAt("disk_write_bytes_per_sec", noon, 100+float64(d%2)*10)
+	}
+	if a := detector.CheckAt("disk_write_bytes_per_sec", base.Add(6*day+12*time.Hour), 9000); a == nil {
+		t.Fatal("expected backup-sized writes at noon to be flagged")
+	}
+}
+
+// burstyFixture is a quiet throughput series with two bursts close together.
+var burstyFixture = []float64{
+	1000, 1100, 950, 1050, 1000, 980, 1020, 1010, 990, 1000,
+	50000, // first burst
+	1000, 1040, 960,
+	20000, // second burst, still inside the window of the first
+	1000, 1010,
+}
+
+func detectIndexes(t *testing.T, algorithm string, values []float64) []int {
+	t.Helper()
+	detector := NewDetectorWithAlgorithms(10, 3.0, AlgorithmConfig{Default: algorithm})
+	var flagged []int
+	for i, v := range values {
+		if detector.Check("net_rx_bytes_per_sec", v) != nil {
+			flagged = append(flagged, i)
+		}
+	}
+	return flagged
+}
+
+func TestMADDetectsBurstMaskedForZScore(t *testing.T) {
+	zscore := detectIndexes(t, AlgorithmZScore, burstyFixture)
+	mad := detectIndexes(t, AlgorithmMAD, burstyFixture)
+
+	if !reflect.DeepEqual(zscore, []int{10}) {
+		t.Fatalf("expected zscore to flag only the first burst (the second is masked), got %v", zscore)
+	}
+	if !reflect.DeepEqual(mad, []int{10, 14}) {
+		t.Fatalf("expected mad to flag both bursts, got %v", mad)
+	}
+}
+
+func TestMADAgreesWithZScoreOnSteadySeries(t *testing.T) {
+	values := []float64{10, 11, 9, 10, 12, 11, 10, 9, 11, 10, 10, 12, 9, 11, 10}
+	if got := detectIndexes(t, AlgorithmZScore, values); len(got) != 0 {
+		t.Fatalf("zscore: did not expect anomalies, got %v", got)
+	}
+	if got := detectIndexes(t, AlgorithmMAD, values); len(got) != 0 {
+		t.Fatalf("mad: did not expect anomalies, got %v", got)
+	}
+}
+
+func TestMADSeverityMatchesZScoreScale(t *testing.T) {
+	detector := NewDetectorWithAlgorithms(5, 3.0, AlgorithmConfig{Default: AlgorithmMAD})
+	var flagged *Anomaly
+	// Median 10, MAD 1, so 10 + 5×1.4826 is a modified z-score of exactly 5.
+	for _, v := range []float64{9, 10, 10, 11, 12, 10 + 5*madScale} {
+		flagged = detector.Check("cpu_percent", v)
+	}
+	if flagged == nil {
+		t.Fatal("expected anomaly")
+	}
+	if math.Abs(flagged.ZScore-5) > 1e-9 || flagged.Severity != "high" {
+		t.Fatalf("expected modified z-score 5 with severity high, got %v/%q", flagged.ZScore, flagged.Severity)
+	}
+}
+
+func TestMADFallsBackWhenMostValuesAreIdentical(t *testing.T) {
+	// An idle interface reports zero most of the time, so the MAD is zero.
+	values := []float64{0, 0, 0, 0, 0, 0, 400, 0, 300, 0, 50000}
+	flagged := detectIndexes(t, AlgorithmMAD, values)
+	if !reflect.DeepEqual(flagged, []int{10}) {
+		t.Fatalf("expected only the large transfer to be flagged, got %v", flagged)
+	}
+}
+
+func TestParsePercentileRule(t *testing.T) {
+	cases := map[string]PercentileRule{
+		"p99":         {Percentile: 99},
+		"P99+20%":     {Percentile: 99, Margin: 0.2},
+		"p99.9+5%/50": {Percentile: 99.9, Margin: 0.05, Window: 50},
+		"p90/30":      {Percentile: 90, Window: 30},
+		"p95@5m>85":   {Percentile: 95, Over: 5 * time.Minute, Above: 85},
+	}
+	for spec, want := range cases {
+		got, err := ParsePercentileRule(spec)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		if got != want {
+			t.Fatalf("%s: got %+v, want %+v", spec, got, want)
+		}
+	}
+	for _, bad := range []string{"99", "p0", "p101", "p95@5m", "p95+x%", "p95@nope>1", "p95+10%@5m>1"} {
+		if _, err := ParsePercentileRule(bad); err == nil {
+			t.Fatalf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestPercentileRuleFlagsValueAboveRollingPercentile(t *testing.T) {
+	eval := NewPercentileEvaluator(map[string][]PercentileRule{
+		"cpu_percent": {{Percentile: 90, Margin: 0.2, Window: 10}},
+	})
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		if a := eval.Check("cpu_percent", base.Add(time.Duration(i)*time.Second), float64(50+i)); a != nil {
+			t.Fatalf("did not expect anomaly while filling the window: %+v", a)
+		}
+	}
+	// p90 of 50..59 is 58.1; 20% above is 69.72.
+	if a := eval.Check("cpu_percent", base.Add(10*time.Second), 69); a != nil {
+		t.Fatalf("did not expect anomaly within the margin: %+v", a)
+	}
+	a := eval.Check("cpu_percent", base.Add(11*time.Second), 97)
+	if a == nil {
+		t.Fatal("expected percentile anomaly")
+	}
+	if a.RuleType != RuleTypePercentile || !strings.Contains(a.Explanation, "CPU 97.0% is more than 20% above the rolling p90") {
+		t.Fatalf("unexpected anomaly: %+v", a)
+	}
+}
+
+func TestPercentileRuleOverDuration(t *testing.T) {
+	eval := NewPercentileEvaluator(map[string][]PercentileRule{
+		"cpu_percent": {{Percentile: 50, Over: time.Minute, Above: 80}},
+	})
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	var last *Anomaly
+	for i := 0; i <= 12; i++ {
+		// One brief spike to 99 followed by a sustained climb above 80.
+		v := 20.0
+		if i == 2 {
+			v = 99
+		}
+		if i >= 6 {
+			v = 90
+		}
+		last = eval.Check("cpu_percent", base.Add(time.Duration(i)*10*time.Second), v)
+		if i < 8 && last != nil {
+			t.Fatalf("did not expect anomaly at step %d: %+v", i, last)
+		}
+	}
+	if last == nil || last.Mean != 90 || last.Threshold != 80 {
+		t.Fatalf("expected median over the last minute above 80, got %+v", last)
+	}
+}
+
+func TestParseSustain(t *testing.T) {
+	if s, err := ParseSustain("2m"); err != nil || s.For != 2*time.Minute {
+		t.Fatalf("ParseSustain(2m) = %+v, %v", s, err)
+	}
+	if s, err := ParseSustain("3/5"); err != nil || s.Count != 3 || s.Of != 5 {
+		t.Fatalf("ParseSustain(3/5) = %+v, %v", s, err)
+	}
+	for _, bad := range []string{"", "5/3", "0/5", "-1m", "x/5"} {
+		if _, err := ParseSustain(bad); err == nil {
+			t.Fatalf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestEvaluatorNOfMSustain(t *testing.T) {
+	eval := NewEvaluator(5, 100, AlgorithmConfig{}, Rules{
+		StaticThresholds: map[string]float64{"cpu_percent": 85},
+		Sustain:          map[string]Sustain{SustainKey("cpu_percent", RuleTypeStaticThreshold): {Count: 3, Of: 5}},
+	})
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	values := []float64{90, 10, 90, 10, 90}
+	var last *Anomaly
+	for i, v := range values {
+		last = eval.Evaluate("cpu_percent", base.Add(time.Duration(i)*time.Second), v)
+		if i < 4 && last != nil {
+			t.Fatalf("did not expect anomaly at %d: %+v", i, last)
+		}
+	}
+	if last == nil || last.Condition == nil {
+		t.Fatal("expected sustained anomaly on the third breach in five samples")
+	}
+	if c := last.Condition; !c.Start.Equal(base) || c.Breaches != 3 || c.Samples != 5 || c.DurationSeconds != 4 {
+		t.Fatalf("unexpected condition: %+v", c)
+	}
+	if !strings.Contains(last.Explanation, "3 of 5 samples") {
+		t.Fatalf("expected condition in explanation, got %q", last.Explanation)
+	}
+	// Two quiet samples drop the count below three.
+	eval.Evaluate("cpu_percent", base.Add(5*time.Second), 10)
+	eval.Evaluate("cpu_percent", base.Add(6*time.Second), 10)
+	if a := eval.Evaluate("cpu_percent", base.Add(7*time.Second), 90); a != nil {
+		t.Fatalf("expected condition to lapse, got %+v", a)
+	}
+}
+
+func TestCheckStaticLowerThreshold(t *testing.T) {
+	floors := map[string]float64{"net_rx_bytes_per_sec": 1000}
+	if a := CheckStaticLowerThreshold("net_rx_bytes_per_sec", 1500, floors); a != nil {
+		t.Fatalf("did not expect anomaly above floor: %+v", a)
+	}
+	a := CheckStaticLowerThreshold("net_rx_bytes_per_sec", 0, floors)
+	if a == nil {
+		t.Fatal("expected anomaly when throughput drops to zero")
+	}
+	if a.Direction != DirectionBelow || a.Severity != "critical" || a.ZScore != -1 {
+		t.Fatalf("unexpected lower threshold anomaly: %+v", a)
+	}
+	if !strings.Contains(a.Explanation, "fell below its floor") {
+		t.Fatalf("unexpected explanation: %q", a.Explanation)
+	}
+	if a := CheckStaticLowerThreshold("net_rx_bytes_per_sec", 900, floors); a == nil || a.Severity != "low" {
+		t.Fatalf("expected low severity for a 10%% shortfall, got %+v", a)
+	}
+}
+
+func TestEvaluatorDirectionMakesBaselineOneSided(t *testing.T) {
+	run := func(direction Direction, spike float64) *Anomaly {
+		eval := NewEvaluator(5, 3, AlgorithmConfig{}, Rules{
+			Directions: map[string]Direction{"cpu_percent": direction},
+		})
+		base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+		for i, v := range []float64{50, 51, 49, 50, 51} {
+			eval.Evaluate("cpu_percent", base.Add(time.Duration(i)*time.Second), v)
+		}
+		return eval.Evaluate("cpu_percent", base.Add(5*time.Second), spike)
+	}
+	if a := run(DirectionBoth, 5); a == nil || a.Direction != DirectionBelow {
+		t.Fatalf("expected a two-sided detector to flag a drop, got %+v", a)
+	}
+	if a := run(DirectionUp, 5); a != nil {
+		t.Fatalf("expected an upward-only detector to ignore a drop, got %+v", a)
+	}
+	if a := run(DirectionUp, 95); a == nil || a.Direction != DirectionAbove {
+		t.Fatalf("expected an upward-only detector to flag a spike, got %+v", a)
+	}
+	if a := run(DirectionDown, 95); a != nil {
+		t.Fatalf("expected a downward-only detector to ignore a spike, got %+v", a)
+	}
+}
+
+func TestEvaluatorDirectionLearnsIgnoredSide(t *testing.T) {
+	eval := NewEvaluator(5, 3, AlgorithmConfig{
+		Contamination: map[string]Contamination{"cpu_percent": ContaminationSkip},
+	}, Rules{
+		Directions: map[string]Direction{"cpu_percent": DirectionUp},
+	})
+	for _, v := range []float64{50, 51, 49, 50, 51, 5} {
+		if a := eval.Evaluate("cpu_percent", time.Time{}, v); a != nil {
+			t.Fatalf("unexpected anomaly for %v: %+v", v, a)
+		}
+	}
+	// The drop is on the ignored side: it is learned, not excluded as
+	// contamination.
+	if got := eval.Excluded()["cpu_percent"]; got != 0 {
+		t.Fatalf("expected the ignored drop to be learned, %d values excluded", got)
+	}
+}
+
+func TestEvaluatorAppliesMetricRulesToEachMount(t *testing.T) {
+	const mount = "disk_used_percent{mount=/var}"
+	eval := NewEvaluator(5, 3, AlgorithmConfig{}, Rules{
+		StaticThresholds:      map[string]float64{"disk_used_percent": 90},
+		StaticLowerThresholds: map[string]float64{"disk_free_bytes": 1 << 30},
+		Directions:            map[string]Direction{"disk_used_percent": DirectionUp},
+	})
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	for i, v := range []float64{50, 51, 49, 50, 51} {
+		eval.Evaluate(mount, base.Add(time.Duration(i)*time.Second), v)
+	}
+	// The drop is on the ignored side of disk_used_percent's direction.
+	if a := eval.Evaluate(mount, base.Add(5*time.Second), 5); a != nil {
+		t.Fatalf("expected the mount to use its metric's direction, got %+v", a)
+	}
+	if a := CheckStaticThreshold(mount, 95, map[string]float64{"disk_used_percent": 90}); a == nil || a.Name != mount || a.Threshold != 90 {
+		t.Fatalf("expected the mount to use its metric's static threshold, got %+v", a)
+	}
+	if a := eval.Evaluate("disk_free_bytes{mount=/var}", base, 1<<20); a == nil || a.RuleType != RuleTypeStaticThreshold || a.Direction != DirectionBelow {
+		t.Fatalf("expected the mount to use its metric's lower threshold, got %+v", a)
+	}
+	// A per-mount key overrides the metric's rule.
+	if a := CheckStaticThreshold(mount, 95, map[string]float64{"disk_used_percent": 90, mount: 97}); a != nil {
+		t.Fatalf("expected the per-mount threshold to win, got %+v", a)
+	}
+}
+
+func TestParseDirection(t *testing.T) {
+	for in, want := range map[string]Direction{"": DirectionBoth, "both": DirectionBoth, "UP": DirectionUp, "increase": DirectionUp, "down": DirectionDown, "decrease": DirectionDown} {
+		got, err := ParseDirection(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseDirection(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseDirection("sideways"); err == nil {
+		t.Fatal("expected error for unknown direction")
+	}
+}
+
+func TestParseForecastRule(t *testing.T) {
+	cases := map[string]ForecastRule{
+		"24h":       {Horizon: 24 * time.Hour},
+		"24h>90":    {Horizon: 24 * time.Hour, Limit: 90},
+		"6h>95/72h": {Horizon: 6 * time.Hour, Limit: 95, Lookback: 72 * time.Hour},
+		" 30m/2h  ": {Horizon: 30 * time.Minute, Lookback: 2 * time.Hour},
+	}
+	for spec, want := range cases {
+		got, err := ParseForecastRule(spec)
+		if err != nil || got != want {
+			t.Fatalf("ParseForecastRule(%q) = %+v, %v; want %+v", spec, got, err, want)
+		}
+	}
+	for _, spec := range []string{"", "soon", "24h>", "24h>0", "24h/0s", "-1h"} {
+		if _, err := ParseForecastRule(spec); err == nil {
+			t.Fatalf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestForecasterPredictsDiskFull(t *testing.T) {
+	f := NewForecaster(map[string]ForecastRule{"disk_used_percent": {Horizon: 24 * time.Hour, Lookback: 12 * time.Hour}})
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	// 1% per hour from 70%, sampled every minute, with a one-off cleanup dip
+	// that least squares would chase.
+	var last *Anomaly
+	for i := 0; i <= 6*60; i++ {
+		v := 70 + float64(i)/60
+		if i == 200 {
+			v = 40
+		}
+		last = f.Check("disk_used_percent", base.Add(time.Duration(i)*time.Minute), v)
+	}
+	if last == nil || last.Forecast == nil {
+		t.Fatal("expected a forecast anomaly for disk filling in ~24h")
+	}
+	if last.RuleType != RuleTypeForecast || last.Threshold != 100 {
+		t.Fatalf("unexpected forecast anomaly: %+v", last)
+	}
+	if got := last.Forecast.SecondsToLimit / 3600; math.Abs(got-24) > 0.5 {
+		t.Fatalf("expected ~24h to full, got %.2fh", got)
+	}
+	if math.Abs(last.Forecast.SlopePerHour-1) > 0.05 {
+		t.Fatalf("expected ~1%%/h slope, got %.3f", last.Forecast.SlopePerHour)
+	}
+	if !strings.Contains(last.Explanation, "forecast to reach 100.0% in 24h") {
+		t.Fatalf("expected ETA in explanation, got %q", last.Explanation)
+	}
+
+	// A flat series never forecasts exhaustion.
+	flat := NewForecaster(map[string]ForecastRule{"mem_used_percent": {Horizon: 24 * time.Hour, Lookback: time.Hour}})
+	for i := 0; i < 120; i++ {
+		if a := flat.Check("mem_used_percent", base.Add(time.Duration(i)*time.Minute), 60+float64(i%3)); a != nil {
+			t.Fatalf("did not expect forecast for a flat series: %+v", a)
+		}
+	}
+}
+
+func TestForecasterCoversEachMount(t *testing.T) {
+	f := NewForecaster(map[string]ForecastRule{
+		"disk_used_percent":              {Horizon: 24 * time.Hour, Lookback: 2 * time.Hour},
+		"disk_used_percent{mount=/data}": {Horizon: time.Hour, Lookback: 2 * time.Hour},
+	})
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	var root, logs, data *Anomaly
+	for i := 0; i <= 120; i++ {
+		ts := base.Add(time.Duration(i) * time.Minute)
+		root = f.Check("disk_used_percent", ts, 50)
+		logs = f.Check("disk_used_percent{mount=/var/log}", ts, 80+float64(i)/60)
+		data = f.Check("disk_used_percent{mount=/data}", ts, 80+float64(i)/60)
+	}
+	if root != nil {
+		t.Fatalf("expected no forecast for a flat root filesystem, got %+v", root)
+	}
+	// The rule for disk_used_percent forecasts every mount; a per-mount rule
+	// overrides it.
+	if logs == nil || !strings.HasPrefix(logs.Explanation, "Disk usage (/var/log) is rising 1.0% per hour") {
+		t.Fatalf("expected a forecast for /var/log, got %+v", logs)
+	}
+	if data != nil {
+		t.Fatalf("expected the one-hour horizon for /data to hold, got %+v", data)
+	}
+	if BaseMetric("disk_used_percent{mount=/var/log}") != "disk_used_percent" || MetricMount("disk_used_percent") != "" {
+		t.Fatal("unexpected per-mount metric parsing")
+	}
+	rules := SeverityRules{MetricCutPoints: map[string]map[string][]float64{"disk_used_percent": {RuleTypeForecast: {0.1, 0.2, 0.3}}}}
+	if got := rules.Cuts("disk_used_percent{mount=/var/log}", RuleTypeForecast); !reflect.DeepEqual(got, []float64{0.1, 0.2, 0.3}) {
+		t.Fatalf("expected mounts to use their metric's cut-points, got %v", got)
+	}
+}
+
+func TestForecasterSeverityGrowsAsExhaustionNears(t *testing.T) {
+	run := func(slopePerHour float64) *Anomaly {
+		f := NewForecaster(map[string]ForecastRule{"disk_used_percent": {Horizon: 24 * time.Hour, Lookback: 2 * time.Hour}})
+		base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+		var last *Anomaly
+		for i := 0; i <= 120; i++ {
+			last = f.Check("disk_used_percent", base.Add(time.Duration(i)*time.Minute), 50+slopePerHour*float64(i)/60)
+		}
+		return last
+	}
+	if a := run(1); a != nil {
+		t.Fatalf("expected no forecast when full is ~48h away, got %+v", a)
+	}
+	if a := run(2.2); a == nil || a.Severity != "low" {
+		t.Fatalf("expected low severity near the horizon, got %+v", a)
+	}
+	if a := run(10); a == nil || a.Severity != "critical" {
+		t.Fatalf("expected critical severity when full is hours away, got %+v", a)
+	}
+}
+
+func TestFormatETA(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		20 * time.Second:             "under a minute",
+		45 * time.Minute:             "45m",
+		3 * time.Hour:                "3h",
+		5*time.Hour + 12*time.Minute: "5h12m",
+		80 * time.Hour:               "3d8h",
+	} {
+		if got := FormatETA(d); got != want {
+			t.Fatalf("FormatETA(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestSeasonalSeededProfileKnowsTheNightlyBackup(t *testing.T) {
+	var profile SeasonalProfile
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC) // a Sunday
+	for d := 0; d < 14; d++ {
+		for m := 0; m < 60; m += 10 {
+			night := base.Add(time.Duration(d)*24*time.Hour + 2*time.Hour + time.Duration(m)*time.Minute)
+			noon := base.Add(time.Duration(d)*24*time.Hour + 12*time.Hour + time.Duration(m)*time.Minute)
+			profile.Add(night, 9000+float64(m))
+			profile.Add(noon, 100+float64(m%20))
+		}
+	}
+
+	detector := NewDetectorWithAlgorithms(5, 3.0, AlgorithmConfig{
+		Default:  AlgorithmSeasonal,
+		Profiles: map[string]*SeasonalProfile{"disk_write_bytes_per_sec": &profile},
+	})
+	day := base.Add(14 * 24 * time.Hour)
+	if a := detector.CheckAt("disk_write_bytes_per_sec", day.Add(2*time.Hour), 9020); a != nil {
+		t.Fatalf("did not expect the trained nightly backup to be flagged: %+v", a)
+	}
+	if a := detector.CheckAt("disk_write_bytes_per_sec", day.Add(12*time.Hour), 9020); a == nil {
+		t.Fatal("expected backup-sized writes at noon to be flagged")
+	}
+	// Training is copied, not shared.
+	if profile.Hourly[2].Count != 14*6 {
+		t.Fatalf("expected the seed profile to be left untouched, got %+v", profile.Hourly[2])
+	}
+}
+
+func TestSeasonalFallsBackToRollingWindow(t *testing.T) {
+	detector := NewDetectorWithAlgorithms(5, 3.0, AlgorithmConfig{Default: AlgorithmSeasonalWeekly})
+	base := time.Date(2026, 2, 1, 9, 0, 0, 0, time.UTC)
+	// Every sample lands in a fresh hour, so no bucket is ready and the
+	// rolling window has to catch the spike.
+	for i, v := range []float64{50, 51, 49, 50, 51} {
+		_ = detector.CheckAt("cpu_percent", base.Add(time.Duration(i)*time.Hour), v)
+	}
+	if a := detector.CheckAt("cpu_percent", base.Add(5*time.Hour), 95); a == nil {
+		t.Fatal("expected the rolling fallback to flag a spike before buckets fill")
+	}
+}
+
+func TestDetectorSnapshotRestoreForEveryAlgorithm(t *testing.T) {
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range RegisteredAlgorithms() {
+		original := NewDetectorWithAlgorithms(5, 3.0, AlgorithmConfig{Default: name})
+		for i, v := range []float64{10, 11, 9, 10, 12, 11, 10, 9} {
+			original.CheckAt("cpu_percent", base.Add(time.Duration(i)*time.Second), v)
+		}
+		states, err := original.Snapshot()
+		if err != nil {
+			t.Fatalf("%s: Snapshot: %v", name, err)
+		}
+		if states["cpu_percent"].Algorithm != name {
+			t.Fatalf("%s: expected snapshot for cpu_percent, got %+v", name, states)
+		}
+
+		restored := NewDetectorWithAlgorithms(5, 3.0, AlgorithmConfig{Default: name})
+		if err := restored.Restore(states); err != nil {
+			t.Fatalf("%s: Restore: %v", name, err)
+		}
+		if restored.CheckAt("cpu_percent", base.Add(9*time.Second), 60) == nil {
+			t.Fatalf("%s: expected restored detector to flag spike", name)
+		}
+
+		other := AlgorithmZScore
+		if name == AlgorithmZScore {
+			other = AlgorithmMAD
+		}
+		skipped := NewDetectorWithAlgorithms(5, 3.0, AlgorithmConfig{Default: other})
+		if err := skipped.Restore(states); err != nil {
+			t.Fatalf("%s: Restore: %v", name, err)
+		}
+		if skipped.CheckAt("cpu_percent", base.Add(9*time.Second), 60) != nil {
+			t.Fatalf("%s: state from another algorithm must not be restored", name)
+		}
+	}
+}
+
+func TestChangeDetectorReportsStepButNotSpike(t *testing.T) {
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	e := NewEvaluator(10, 3, AlgorithmConfig{}, Rules{ChangePoints: map[string]ChangePointRule{"mem_used_percent": {}}})
+	at := func(i int) time.Time { return base.Add(time.Duration(i) * time.Second) }
+	i := 0
+	observe := func(v float64) *Anomaly {
+		i++
+		return e.Evaluate("mem_used_percent", at(i), v)
+	}
+	for ; i < 20; i++ {
+		e.Learn("mem_used_percent", at(i), 40+float64(i%3))
+	}
+	// A single spike is a zscore anomaly, never a change point.
+	if a := observe(90); a == nil || a.RuleType == RuleTypeChangePoint {
+		t.Fatalf("expected the spike to be a zscore anomaly, got %+v", a)
+	}
+	for j := 0; j < 5; j++ {
+		if a := observe(40 + float64(j%3)); a != nil && a.RuleType == RuleTypeChangePoint {
+			t.Fatalf("unexpected change point after a spike: %+v", a)
+		}
+	}
+
+	var change *Anomaly
+	stepAt := at(i + 1)
+	for j := 0; j < 15 && change == nil; j++ {
+		if a := observe(70 + float64(j%3)); a != nil && a.RuleType == RuleTypeChangePoint {
+			change = a
+		}
+	}
+	if change == nil || change.ChangePoint == nil {
+		t.Fatal("expected a change point after the step")
+	}
+	cp := change.ChangePoint
+	if !cp.ChangedAt.Equal(stepAt) || cp.BeforeMean > 42 || cp.AfterMean < 70 || cp.Samples < changePointMinRun {
+		t.Fatalf("unexpected change point: %+v", cp)
+	}
+	if change.Direction != DirectionAbove || change.Severity != "critical" {
+		t.Fatalf("unexpected direction/severity %q/%q", change.Direction, change.Severity)
+	}
+
+	// The new level is the reference now: staying there is quiet.
+	for j := 0; j < 30; j++ {
+		if a := observe(70 + float64(j%3)); a != nil && a.RuleType == RuleTypeChangePoint {
+			t.Fatalf("unexpected second change point at the new level: %+v", a)
+		}
+	}
+}
+
+func TestParseChangePointRule(t *testing.T) {
+	if r, err := ParseChangePointRule("on"); err != nil || r.threshold() != DefaultChangePointThreshold {
+		t.Fatalf("on: %+v %v", r, err)
+	}
+	if r, err := ParseChangePointRule("8"); err != nil || r.Threshold != 8 || r.String() != "8" {
+		t.Fatalf("8: %+v %v", r, err)
+	}
+	for _, bad := range []string{"-1", "0", "nope"} {
+		if _, err := ParseChangePointRule(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestGroupIncidentsCorrelatesCoOccurringAnomalies(t *testing.T) {
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	rsync := &ProcessAttribution{PID: 42, Name: "rsync"}
+	anomalies := []Anomaly{
+		{Name: "disk_write_bytes_per_sec", Timestamp: base.Add(10 * time.Second), HostID: "a", Severity: "medium", Score: 30, TopCPUProcess: rsync},
+		{Name: "cpu_percent", Timestamp: base, HostID: "a", Severity: "high", Score: 60, TopCPUProcess: rsync},
+		{Name: "net_tx_bytes_per_sec", Timestamp: base.Add(20 * time.Second), HostID: "a", Severity: "critical", Score: 80, TopCPUProcess: &ProcessAttribution{PID: 7, Name: "chrome"}},
+		{Name: "cpu_percent", Timestamp: base.Add(20 * time.Second), HostID: "b", Severity: "low"},
+		{Name: "cpu_percent", Timestamp: base.Add(time.Hour), HostID: "a", Severity: "low"},
+		{Name: "mem_used_percent", Timestamp: base.Add(5 * time.Second), HostID: "a", RuleType: RuleTypeChangePoint, Severity: "critical"},
+	}
+	incidents, rest := GroupIncidents(anomalies, 30*time.Second)
+	if len(incidents) != 1 {
+		t.Fatalf("expected one correlated incident, got %+v", incidents)
+	}
+	inc := incidents[0]
+	want := []string{"cpu_percent", "disk_write_bytes_per_sec", "net_tx_bytes_per_sec"}
+	if !reflect.DeepEqual(inc.Metrics, want) || len(inc.Anomalies) != 3 {
+		t.Fatalf("unexpected metrics %v (%d anomalies)", inc.Metrics, len(inc.Anomalies))
+	}
+	if inc.Severity != "critical" || !inc.Start.Equal(base) || !inc.End.Equal(base.Add(20*time.Second)) || inc.ID != "a-20260201T000000Z" {
+		t.Fatalf("unexpected incident: %+v", inc)
+	}
+	if inc.LikelyCause == nil || inc.LikelyCause.Name != "rsync" || !strings.Contains(inc.Explanation, "rsync (pid 42), the top process in 2 of 3") {
+		t.Fatalf("unexpected likely cause: %+v %q", inc.LikelyCause, inc.Explanation)
+	}
+	// Host b, the isolated hour-later anomaly and the change point stay ungrouped.
+	if len(rest) != 3 {
+		t.Fatalf("expected 3 ungrouped anomalies, got %+v", rest)
+	}
+}
+
+func TestContaminationKeepsLongIncidentFiring(t *testing.T) {
+	baseline := []float64{10, 11, 9, 10, 12, 10, 11, 9, 10, 12}
+	fired := func(policy Contamination) (int, map[string]int) {
+		detector := NewDetectorWithAlgorithms(10, 3, AlgorithmConfig{
+			Contamination: map[string]Contamination{"cpu_percent": policy},
+		})
+		for _, v := range baseline {
+			detector.Check("cpu_percent", v)
+		}
+		n := 0
+		for i := 0; i < 20; i++ {
+			if detector.Check("cpu_percent", 90) != nil {
+				n++
+			}
+		}
+		return n, detector.Excluded()
+	}
+
+	learned, excluded := fired(ContaminationLearn)
+	if learned >= 20 || excluded != nil {
+		t.Fatalf("expected learning to absorb the incident, fired %d times, excluded %v", learned, excluded)
+	}
+	for _, policy := range []Contamination{ContaminationSkip, ContaminationClamp, ContaminationDownweight} {
+		n, excluded := fired(policy)
+		// Clamping still lets the baseline creep toward a lasting level, just
+		// far more slowly than learning the raw values.
+		if n <= learned || (policy != ContaminationClamp && n != 20) {
+			t.Fatalf("%s: fired %d times, learning fired %d", policy, n, learned)
+		}
+		if excluded["cpu_percent"] != n {
+			t.Fatalf("%s: expected %d excluded values, got %v", policy, n, excluded)
+		}
+	}
+}
+
+func TestParseContamination(t *testing.T) {
+	if c, err := ParseContamination(" Skip "); err != nil || c != ContaminationSkip {
+		t.Fatalf("expected skip, got %q, %v", c, err)
+	}
+	if c, err := ParseContamination(""); err != nil || c != ContaminationLearn {
+		t.Fatalf("expected learn for empty policy, got %q, %v", c, err)
+	}
+	if _, err := ParseContamination("drop"); err == nil {
+		t.Fatal("expected error for unknown policy")
+	}
+}
+
+func TestHalfLifeEWMAIsIndependentOfInterval(t *testing.T) {
+	start := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	// The same ten minutes of a gently oscillating metric, sampled every
+	// second and every 30 seconds, should give the same baseline.
+	expected := func(interval time.Duration) Result {
+		model, err := NewAlgorithm("ewma:2m", Params{WindowSize: 30, Threshold: 3})
+		if err != nil {
+			t.Fatalf("NewAlgorithm: %v", err)
+		}
+		for ts := start; ts.Before(start.Add(10 * time.Minute)); ts = ts.Add(interval) {
+			minutes := ts.Sub(start).Minutes()
+			model.Learn(ts, 50+5*math.Sin(minutes))
+		}
+		return model.Score(start.Add(10*time.Minute), 50)
+	}
+	fast, slow := expected(time.Second), expected(30*time.Second)
+	if !fast.Ready || !slow.Ready {
+		t.Fatalf("expected both baselines ready after five half-lives: %+v %+v", fast, slow)
+	}
+	if math.Abs(fast.Expected-slow.Expected) > 0.5 {
+		t.Fatalf("expected similar means at 1s and 30s, got %.2f and %.2f", fast.Expected, slow.Expected)
+	}
+}
+
+func TestHoltFollowsTrendButFlagsDeparture(t *testing.T) {
+	start := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	for _, spec := range []string{AlgorithmHolt, "holt:1m"} {
+		detector := NewDetectorWithAlgorithms(10, 3, AlgorithmConfig{Default: spec})
+		// Memory climbing 0.1% per 10s sample with a little noise, at an
+		// irregular interval.
+		ts := start
+		for i := 0; i < 60; i++ {
+			ts = ts.Add(time.Duration(8+i%5) * time.Second)
+			value := 40 + 0.01*ts.Sub(start).Seconds() + 0.05*float64(i%3)
+			if a := detector.CheckAt("mem_used_percent", ts, value); a != nil {
+				t.Fatalf("%s: did not expect the steady climb to be flagged at sample %d: %+v", spec, i, a)
+			}
+		}
+		ts = ts.Add(10 * time.Second)
+		if detector.CheckAt("mem_used_percent", ts, 40+0.01*ts.Sub(start).Seconds()+5) == nil {
+			t.Fatalf("%s: expected a jump off the trend to be flagged", spec)
+		}
+	}
+}
+
+func TestParseAlgorithmHalfLife(t *testing.T) {
+	if err := ValidateAlgorithm("EWMA:10m"); err != nil {
+		t.Fatalf("expected ewma:10m to be valid: %v", err)
+	}
+	for _, spec := range []string{"zscore:10m", "ewma:", "holt:-1m", "ewma:soon"} {
+		if err := ValidateAlgorithm(spec); err == nil {
+			t.Fatalf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestDetectorUsesPerMetricParams(t *testing.T) {
+	detector := NewDetectorWithAlgorithms(5, 3, AlgorithmConfig{
+		MetricParams: map[string]Params{"disk_used_percent": {Threshold: 50}},
+	})
+	var cpu, disk *Anomaly
+	for _, v := range []float64{10, 11, 9, 10, 12, 60} {
+		cpu = detector.Check("cpu_percent", v)
+		disk = detector.Check("disk_used_percent", v)
+	}
+	if cpu == nil {
+		t.Fatal("expected the global threshold to flag cpu_percent")
+	}
+	if disk != nil {
+		t.Fatalf("expected the per-metric threshold to keep disk_used_percent quiet, got %+v", disk)
+	}
+	got := AlgorithmConfig{MetricParams: map[string]Params{"cpu_percent": {WindowSize: 2}}}.ParamsFor("cpu_percent", Params{WindowSize: 30, Threshold: 3})
+	if got.WindowSize != 5 || got.Threshold != 3 {
+		t.Fatalf("expected the window raised to 5 and the threshold inherited, got %+v", got)
+	}
+}
+
+func TestParseExpressionRule(t *testing.T) {
+	resolve := func(name string) (string, bool) {
+		switch name {
+		case "cpu", "cpu_percent":
+			return "cpu_percent", true
+		case "mem_used_percent":
+			return "mem_used_percent", true
+		}
+		return "", false
+	}
+	rule, err := ParseExpressionRule("pressure", "High", "cpu > 85 && (mem_used_percent > 90 || !(cpu_percent - mem_used_percent < 10))", resolve)
+	if err != nil {
+		t.Fatalf("ParseExpressionRule: %v", err)
+	}
+	if rule.Severity != "high" || !reflect.DeepEqual(rule.Metrics(), []string{"cpu_percent", "mem_used_percent"}) {
+		t.Fatalf("unexpected rule: %+v", rule)
+	}
+	if !rule.Matches(map[string]float64{"cpu_percent": 90, "mem_used_percent": 95}) {
+		t.Fatal("expected the rule to match")
+	}
+	if rule.Matches(map[string]float64{"cpu_percent": 90}) {
+		t.Fatal("expected a missing metric not to match")
+	}
+	for _, bad := range []string{
+		"gpu_percent > 10",
+		"cpu_percent + 1",
+		"cpu_percent > 85 &&",
+		"cpu_percent > 85 for soon",
+		"(cpu_percent > 85",
+		"cpu_percent > 85 > 1",
+		"cpu_percent >> 85",
+	} {
+		if _, err := ParseExpressionRule("bad", "", bad, resolve); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+	urgent, err := ParseExpressionRule("urgent", "urgent", "cpu_percent > 1", resolve)
+	if err != nil {
+		t.Fatalf("ParseExpressionRule: %v", err)
+	}
+	if err := (Rules{Expressions: []ExpressionRule{urgent}}).Validate(); err == nil {
+		t.Fatal("expected a severity outside the scale to be rejected")
+	}
+}
+
+func TestExpressionEvaluatorHonorsForClause(t *testing.T) {
+	rule, err := ParseExpressionRule("pressure", "", "cpu_percent > 85 && mem_used_percent > 90 for 20s", func(name string) (string, bool) { return name, true })
+	if err != nil {
+		t.Fatalf("ParseExpressionRule: %v", err)
+	}
+	evaluator := NewExpressionEvaluator(Rules{Expressions: []ExpressionRule{rule}})
+	start := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	var fired []int
+	for i, mem := range []float64{95, 95, 95, 80, 95, 95, 95, 95} {
+		got := evaluator.Check(start.Add(time.Duration(i)*10*time.Second), map[string]float64{"cpu_percent": 90, "mem_used_percent": mem})
+		if len(got) > 0 {
+			fired = append(fired, i)
+			a := got[0]
+			if a.Name != "pressure" || a.RuleType != RuleTypeExpression || a.Severity != DefaultExpressionSeverity || a.Condition == nil {
+				t.Fatalf("unexpected anomaly: %+v", a)
+			}
+			if a.Expression == nil || a.Expression.Values["mem_used_percent"] != mem {
+				t.Fatalf("expected the matched values, got %+v", a.Expression)
+			}
+		}
+	}
+	if !reflect.DeepEqual(fired, []int{2, 6, 7}) {
+		t.Fatalf("expected the rule to fire once the condition held for 20s, fired at %v", fired)
+	}
+}
+
+func TestSeverityRulesUseCustomLevelsAndPerMetricCutPoints(t *testing.T) {
+	scale, err := severity.NewScale([]string{"info", "warn", "page"})
+	if err != nil {
+		t.Fatalf("NewScale: %v", err)
+	}
+	rules := SeverityRules{Scale: scale}
+	if err := rules.Validate(); err == nil {
+		t.Fatal("expected custom levels without matching cut-points to be rejected")
+	}
+	rules.CutPoints = map[string][]float64{
+		RuleTypeZScore:          {3, 5},
+		RuleTypeChangePoint:     {3, 5},
+		RuleTypeStaticThreshold: {0.1, 0.3},
+		RuleTypePercentile:      {0.1, 0.3},
+		RuleTypeForecast:        {0.1, 0.3},
+	}
+	rules.MetricCutPoints = map[string]map[string][]float64{"disk_used_percent": {RuleTypeStaticThreshold: {0.01, 0.05}}}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	evaluator := NewEvaluator(5, 3, AlgorithmConfig{}, Rules{
+		StaticThresholds: map[string]float64{"cpu_percent": 80, "disk_used_percent": 80},
+		Severity:         rules,
+	})
+	cpu := evaluator.Evaluate("cpu_percent", time.Time{}, 84)
+	disk := evaluator.Evaluate("disk_used_percent", time.Time{}, 84)
+	if cpu == nil || cpu.Severity != "info" || disk == nil || disk.Severity != "page" {
+		t.Fatalf("unexpected severities: %+v %+v", cpu, disk)
+	}
+	if disk.Score <= cpu.Score {
+		t.Fatalf("expected the page to outscore the info, got %.2f and %.2f", disk.Score, cpu.Score)
+	}
+}
+
+func TestWarmupNeedsSamplesDurationAndVariance(t *testing.T) {
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	w := Warmup{Samples: 3, Duration: time.Minute}
+	state := &WarmupState{}
+	for i := 0; i < 4; i++ {
+		state.observe(base.Add(time.Duration(i)*30*time.Second), 10)
+	}
+	if !w.warming(state, base.Add(2*time.Minute)) {
+		t.Fatal("expected a flat series to keep warming up")
+	}
+	state.observe(base.Add(2*time.Minute), 11)
+	if w.warming(state, base.Add(150*time.Second)) {
+		t.Fatal("expected warm-up to end after enough samples, time and variance")
+	}
+	if !(Warmup{Samples: 3, Duration: time.Hour}).warming(state, base.Add(150*time.Second)) {
+		t.Fatal("expected the duration to be required")
+	}
+	flat := &WarmupState{}
+	for i := 0; i < 6; i++ {
+		flat.observe(base.Add(time.Duration(i)*30*time.Second), 10)
+	}
+	if !w.warming(flat, base.Add(110*time.Second)) {
+		t.Fatal("expected a flat series to keep warming up for twice the duration")
+	}
+	if w.warming(flat, base.Add(2*time.Minute)) {
+		t.Fatal("expected a flat series to leave warm-up after twice the samples and duration")
+	}
+	if (Warmup{}).warming(&WarmupState{}, base) {
+		t.Fatal("expected the zero value to disable warm-up")
+	}
+	if err := (Warmup{Samples: -1}).Validate(); err == nil {
+		t.Fatal("expected negative samples to be rejected")
+	}
+}
+
+func TestEvaluatorHoldsBackLearnedRulesDuringWarmup(t *testing.T) {
+	evaluator := NewEvaluator(5, 3, AlgorithmConfig{}, Rules{
+		StaticThresholds: map[string]float64{"cpu_percent": 90},
+		Warmup:           Warmup{Samples: 8},
+	})
+	for _, v := range []float64{10, 11, 9, 10, 12} {
+		evaluator.Evaluate("cpu_percent", time.Time{}, v)
+	}
+	held := evaluator.Evaluate("cpu_percent", time.Time{}, 60)
+	if held == nil || !held.Warmup || held.RuleType != RuleTypeZScore {
+		t.Fatalf("expected a held-back zscore anomaly, got %+v", held)
+	}
+	// A static threshold fires during warm-up and wins over held-back rules.
+	static := evaluator.Evaluate("cpu_percent", time.Time{}, 95)
+	if static == nil || static.Warmup || static.RuleType != RuleTypeStaticThreshold {
+		t.Fatalf("expected the static threshold to fire, got %+v", static)
+	}
+	for _, v := range []float64{10, 11, 9, 10, 12} {
+		evaluator.Evaluate("cpu_percent", time.Time{}, v)
+	}
+	if a := evaluator.Evaluate("cpu_percent", time.Time{}, 60); a == nil || a.Warmup {
+		t.Fatalf("expected an anomaly after warm-up, got %+v", a)
+	}
+
+	state, err := evaluator.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	restored := NewEvaluator(5, 3, AlgorithmConfig{}, Rules{Warmup: Warmup{Samples: 8}})
+	if err := restored.Restore(state); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if got := restored.warmup["cpu_percent"]; got == nil || got.Samples != 13 || restored.rules.Warmup.warming(got, time.Time{}) {
+		t.Fatalf("expected restored warm-up progress, got %+v", got)
+	}
+	// A state that fails to restore leaves the evaluator as it was.
+	corrupt := EvaluatorState{
+		Detector: map[string]AlgorithmState{"cpu_percent": {Algorithm: AlgorithmZScore, State: json.RawMessage(`{"values":"x"}`)}},
+		Warmup:   state.Warmup,
+	}
+	untouched := NewEvaluator(5, 3, AlgorithmConfig{}, Rules{Warmup: Warmup{Samples: 8}})
+	if err := untouched.Restore(corrupt); err == nil {
+		t.Fatal("expected a corrupt detector state to fail")
+	}
+	if len(untouched.warmup) != 0 || len(untouched.detector.models) != 0 {
+		t.Fatalf("expected a failed restore to leave the evaluator untouched, got warm-up %+v", untouched.warmup)
+	}
+
+	// Rate-of-change rules learn a history window too and are held back.
+	rates := NewEvaluator(5, 3, AlgorithmConfig{}, Rules{
+		RateRules: map[string]RateRule{"mem_used_percent": {Change: 5, Per: time.Minute}},
+		Warmup:    Warmup{Samples: 4},
+	})
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	rates.Evaluate("mem_used_percent", base, 30)
+	if a := rates.Evaluate("mem_used_percent", base.Add(time.Minute), 50); a == nil || !a.Warmup || a.RuleType != RuleTypeRateOfChange {
+		t.Fatalf("expected a held-back rate-of-change anomaly, got %+v", a)
+	}
+	rates.Evaluate("mem_used_percent", base.Add(2*time.Minute), 50)
+	rates.Evaluate("mem_used_percent", base.Add(3*time.Minute), 50)
+	if a := rates.Evaluate("mem_used_percent", base.Add(4*time.Minute), 70); a == nil || a.Warmup || a.RuleType != RuleTypeRateOfChange {
+		t.Fatalf("expected a rate-of-change anomaly after warm-up, got %+v", a)
+	}
+}
+
+func TestEvaluatorReportsASpikeAfterAFlatWarmup(t *testing.T) {
+	evaluator := NewEvaluator(5, 3, AlgorithmConfig{}, Rules{
+		RateRules: map[string]RateRule{"mem_used_percent": {Change: 5, Per: time.Minute}},
+		Warmup:    Warmup{Samples: 4, Duration: 3 * time.Minute},
+	})
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		evaluator.Evaluate("mem_used_percent", base.Add(time.Duration(i)*time.Minute), 40)
+	}
+	if a := evaluator.Evaluate("mem_used_percent", base.Add(6*time.Minute), 60); a == nil || !a.Warmup {
+		t.Fatalf("expected a spike before twice the warm-up samples to be held back, got %+v", a)
+	}
+	evaluator = NewEvaluator(5, 3, AlgorithmConfig{}, Rules{
+		RateRules: map[string]RateRule{"mem_used_percent": {Change: 5, Per: time.Minute}},
+		Warmup:    Warmup{Samples: 4, Duration: 3 * time.Minute},
+	})
+	for i := 0; i < 10; i++ {
+		evaluator.Evaluate("mem_used_percent", base.Add(time.Duration(i)*time.Minute), 40)
+	}
+	if a := evaluator.Evaluate("mem_used_percent", base.Add(10*time.Minute), 60); a == nil || a.Warmup || a.RuleType != RuleTypeRateOfChange {
+		t.Fatalf("expected the first spike of a flat series to fire after warm-up, got %+v", a)
+	}
+}
+
+func TestParseRateRule(t *testing.T) {
+	cases := map[string]RateRule{
+		"10/1m":     {Change: 10, Per: time.Minute},
+		"+10/1m":    {Change: 10, Per: time.Minute},
+		"50%/5m":    {Change: 50, Percent: true, Per: 5 * time.Minute},
+		"-5/1m":     {Change: 5, Per: time.Minute, Falling: true},
+		" 2/1m@10m": {Change: 2, Per: time.Minute, Lookback: 10 * time.Minute},
+	}
+	for spec, want := range cases {
+		got, err := ParseRateRule(spec)
+		if err != nil || got != want {
+			t.Fatalf("ParseRateRule(%q) = %+v, %v; want %+v", spec, got, err, want)
+		}
+		if again, err := ParseRateRule(got.String()); err != nil || again != got {
+			t.Fatalf("String() of %q does not round-trip: %q", spec, got.String())
+		}
+	}
+	for _, spec := range []string{"", "10", "10/", "0/1m", "-0/1m", "x/1m", "10/0s", "10/1m@0s", "10/1m@soon"} {
+		if _, err := ParseRateRule(spec); err == nil {
+			t.Fatalf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestRateEvaluatorFlagsFastGrowth(t *testing.T) {
+	r := NewRateEvaluator(map[string]RateRule{
+		"mem_used_percent": {Change: 10, Per: time.Minute},
+		"disk_free_bytes":  {Change: 20, Percent: true, Per: time.Minute, Falling: true},
+	})
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	var last *Anomaly
+	// Memory climbs 0.5pp per 5s sample until 30s, then 2pp per sample;
+	// nothing fires until a full minute of history is available.
+	mem := 40.0
+	for i := 0; i <= 12; i++ {
+		if i > 0 {
+			mem += 0.5
+			if i > 6 {
+				mem += 1.5
+			}
+		}
+		last = r.Check("mem_used_percent", base.Add(time.Duration(i)*5*time.Second), mem)
+		if i < 12 && last != nil {
+			t.Fatalf("sample %d: unexpected anomaly before the lookback filled or the rate rose: %+v", i, last)
+		}
+	}
+	if last == nil || last.RuleType != RuleTypeRateOfChange || last.Direction != DirectionAbove || last.RateOfChange == nil {
+		t.Fatalf("expected a rate-of-change anomaly, got %+v", last)
+	}
+	if got := last.RateOfChange.Change; math.Abs(got-15) > 1e-9 || last.RateOfChange.ElapsedSeconds != 60 {
+		t.Fatalf("expected +15pp over 60s, got %+v", last.RateOfChange)
+	}
+	if !strings.HasPrefix(last.Explanation, "Memory usage grew 15.0pp in 60s (40.0% to 55.0%), faster than 10.0pp per 60s.") {
+		t.Fatalf("unexpected explanation: %q", last.Explanation)
+	}
+	if last.Severity != "high" {
+		t.Fatalf("expected a 50%% exceed ratio to be high, got %s", last.Severity)
+	}
+
+	// Free space halving within a minute trips the falling percent rule.
+	gb := float64(1 << 30)
+	if a := r.Check("disk_free_bytes", base, 10*gb); a != nil {
+		t.Fatalf("unexpected anomaly: %+v", a)
+	}
+	a := r.Check("disk_free_bytes", base.Add(time.Minute), 5*gb)
+	if a == nil || a.Direction != DirectionBelow || a.RateOfChange.Change != -50 || !strings.Contains(a.Explanation, "fell 50.0% in 60s") {
+		t.Fatalf("expected a falling rate-of-change anomaly, got %+v", a)
+	}
+}
+
+func TestRateOfChangeCompetesWithOtherRules(t *testing.T) {
+	evaluator := NewEvaluator(5, 3, AlgorithmConfig{}, Rules{
+		StaticThresholds: map[string]float64{"mem_used_percent": 60},
+		RateRules:        map[string]RateRule{"mem_used_percent": {Change: 5, Per: time.Minute}},
+	})
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	var last *Anomaly
+	for i, v := range []float64{30, 50, 30, 50, 30, 50, 61} {
+		last = evaluator.Evaluate("mem_used_percent", base.Add(time.Duration(i)*time.Minute), v)
+	}
+	// +11pp in a minute is more than twice the limit: critical, while the
+	// static threshold is barely crossed and the noisy baseline sees ~2σ.
+	if last == nil || last.RuleType != RuleTypeRateOfChange || last.Severity != "critical" {
+		t.Fatalf("expected the rate-of-change rule to win, got %+v", last)
+	}
+
+	// Custom levels without rate_of_change cut-points grade it like a static
+	// threshold.
+	scale, err := severity.NewScale([]string{"info", "page"})
+	if err != nil {
+		t.Fatalf("NewScale: %v", err)
+	}
+	rules := SeverityRules{Scale: scale, CutPoints: map[string][]float64{
+		RuleTypeZScore: {4}, RuleTypeChangePoint: {4}, RuleTypeStaticThreshold: {0.5}, RuleTypePercentile: {0.5}, RuleTypeForecast: {0.5},
+	}}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	a := &Anomaly{Name: "mem_used_percent", RuleType: RuleTypeRateOfChange, ZScore: 0.6}
+	rules.Grade(a)
+	if a.Severity != "page" {
+		t.Fatalf("expected static threshold cut-points, got %s", a.Severity)
+	}
+	rules.CutPoints[RuleTypeRateOfChange] = []float64{1}
+	rules.Grade(a)
+	if a.Severity != "info" {
+		t.Fatalf("expected rate_of_change cut-points to win, got %s", a.Severity)
+	}
+}
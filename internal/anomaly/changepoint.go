@@ -0,0 +1,216 @@
+package anomaly
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const RuleTypeChangePoint = "change_point"
+
+const (
+	// DefaultChangePointThreshold is the CUSUM decision threshold, in
+	// reference standard deviations, used when a rule does not set one.
+	DefaultChangePointThreshold = 5.0
+
+	// changePointDrift is the CUSUM allowance: deviations under half a
+	// standard deviation do not accumulate.
+	changePointDrift = 0.5
+	// changePointClip caps each sample's contribution so one spike cannot
+	// pass for a level shift on its own.
+	changePointClip = 3.0
+	// changePointMinRun is the number of samples a new level must hold
+	// before it is reported.
+	changePointMinRun = 3
+)
+
+// ChangePointRule enables CUSUM change-point detection for a metric. A level
+// shift is reported once the cumulative deviation from the reference level
+// passes Threshold standard deviations.
+type ChangePointRule struct {
+	Threshold float64
+}
+
+func (r ChangePointRule) Validate() error {
+	if math.IsNaN(r.Threshold) || math.IsInf(r.Threshold, 0) || r.Threshold < 0 {
+		return errors.New("change-point threshold must be greater than or equal to zero")
+	}
+	return nil
+}
+
+func (r ChangePointRule) threshold() float64 {
+	if r.Threshold == 0 {
+		return DefaultChangePointThreshold
+	}
+	return r.Threshold
+}
+
+// String renders the rule in the syntax accepted by ParseChangePointRule.
+func (r ChangePointRule) String() string {
+	if r.Threshold == 0 {
+		return "on"
+	}
+	return strconv.FormatFloat(r.Threshold, 'g', -1, 64)
+}
+
+// ParseChangePointRule parses "on" (the default threshold) or a threshold in
+// standard deviations such as "8".
+func ParseChangePointRule(spec string) (ChangePointRule, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	switch spec {
+	case "", "on", "true", "default":
+		return ChangePointRule{}, nil
+	}
+	threshold, err := strconv.ParseFloat(spec, 64)
+	if err != nil || threshold <= 0 {
+		return ChangePointRule{}, fmt.Errorf("change-point rule must be on or a threshold greater than zero: %q", spec)
+	}
+	rule := ChangePointRule{Threshold: threshold}
+	return rule, rule.Validate()
+}
+
+// ChangePoint describes a level shift: the metric moved from BeforeMean to
+// AfterMean around ChangedAt and has held the new level for Samples samples.
+type ChangePoint struct {
+	ChangedAt  time.Time `json:"changed_at"`
+	BeforeMean float64   `json:"before_mean"`
+	AfterMean  float64   `json:"after_mean"`
+	Samples    int       `json:"samples"`
+}
+
+// ChangeDetector applies change-point rules to each metric with a two-sided
+// CUSUM against a reference level learned from the first windowSize samples.
+// After a shift is reported the new level becomes the reference.
+type ChangeDetector struct {
+	windowSize int
+	rules      map[string]ChangePointRule
+	states     map[string]*cusum
+}
+
+func NewChangeDetector(windowSize int, rules map[string]ChangePointRule) *ChangeDetector {
+	return &ChangeDetector{windowSize: windowSize, rules: rules, states: make(map[string]*cusum)}
+}
+
+// Check records value and returns a change-point anomaly when the metric has
+// settled at a new level.
+func (c *ChangeDetector) Check(name string, ts time.Time, value float64) *Anomaly {
+	if c == nil {
+		return nil
+	}
+	rule, ok := c.rules[name]
+	if !ok {
+		return nil
+	}
+	s, ok := c.states[name]
+	if !ok {
+		s = &cusum{}
+		c.states[name] = s
+	}
+	if !s.ready {
+		s.reference = append(s.reference, value)
+		s.calibrate(c.windowSize)
+		return nil
+	}
+
+	z := (value - s.mean) / s.stddev
+	z = math.Max(-changePointClip, math.Min(changePointClip, z))
+	s.up.step(z-changePointDrift, ts, value)
+	s.down.step(-z-changePointDrift, ts, value)
+
+	threshold := rule.threshold()
+	before, stddev := s.mean, s.stddev
+	var values []float64
+	var times []time.Time
+	for _, r := range []*cusumRun{&s.up, &s.down} {
+		if r.sum <= threshold {
+			continue
+		}
+		if v, t := r.trim(before); len(v) >= changePointMinRun {
+			values, times = v, t
+		}
+	}
+	if values == nil {
+		return nil
+	}
+
+	after, _ := meanStddev(values)
+	changedAt, samples := times[0], len(values)
+	// The new level is the reference from here on.
+	*s = cusum{reference: append([]float64(nil), values...)}
+	s.calibrate(c.windowSize)
+
+	shift := (after - before) / stddev
+	return graded(&Anomaly{
+		Name:      name,
+		Value:     value,
+		RuleType:  RuleTypeChangePoint,
+		Direction: directionOf(shift),
+		Threshold: threshold,
+		Mean:      before,
+		Stddev:    stddev,
+		ZScore:    shift,
+		ChangePoint: &ChangePoint{
+			ChangedAt:  changedAt,
+			BeforeMean: before,
+			AfterMean:  after,
+			Samples:    samples,
+		},
+		Explanation: explainChangePoint(name, before, after, shift, changedAt, samples),
+	})
+}
+
+func explainChangePoint(name string, before, after, shift float64, changedAt time.Time, samples int) string {
+	return fmt.Sprintf("%s shifted from %s to %s around %s (%.1fσ) and has held the new level for %d samples.",
+		MetricLabel(name), FormatValue(name, before), FormatValue(name, after), changedAt.UTC().Format(time.RFC3339), shift, samples)
+}
+
+// cusum is the change-point state of one metric.
+type cusum struct {
+	reference []float64
+	ready     bool
+	mean      float64
+	stddev    float64
+	up, down  cusumRun
+}
+
+// calibrate fixes the reference level once enough samples are collected. A
+// perfectly flat reference gets a small floor so shifts stay measurable.
+func (s *cusum) calibrate(windowSize int) {
+	if len(s.reference) < windowSize {
+		return
+	}
+	s.mean, s.stddev = meanStddev(s.reference)
+	s.stddev = math.Max(s.stddev, 1e-3*math.Max(math.Abs(s.mean), 1))
+	s.reference, s.ready = nil, true
+}
+
+// cusumRun is one side of the CUSUM and the samples since it last sat at zero.
+type cusumRun struct {
+	sum    float64
+	times  []time.Time
+	values []float64
+}
+
+func (r *cusumRun) step(increment float64, ts time.Time, value float64) {
+	r.sum = math.Max(0, r.sum+increment)
+	if r.sum == 0 {
+		r.times, r.values = r.times[:0], r.values[:0]
+		return
+	}
+	r.times = append(r.times, ts)
+	r.values = append(r.values, value)
+}
+
+// trim drops leading samples that are still closer to the old level than to
+// the new one; noise can start a run a sample or two before the shift.
+func (r *cusumRun) trim(before float64) ([]float64, []time.Time) {
+	after, _ := meanStddev(r.values)
+	i := 0
+	for i < len(r.values)-1 && math.Abs(r.values[i]-before) < math.Abs(r.values[i]-after) {
+		i++
+	}
+	return r.values[i:], r.times[i:]
+}
@@ -0,0 +1,57 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Contamination decides how a value the detector flags enters the metric's
+// baseline. Learning every value lets a long incident become the new normal
+// within one window; the other policies keep alerts firing for as long as
+// the incident lasts.
+type Contamination string
+
+const (
+	// ContaminationLearn learns flagged values as-is (the default).
+	ContaminationLearn Contamination = "learn"
+	// ContaminationSkip leaves flagged values out of the baseline.
+	ContaminationSkip Contamination = "skip"
+	// ContaminationClamp learns the bound the value crossed instead.
+	ContaminationClamp Contamination = "clamp"
+	// ContaminationDownweight learns the value pulled toward the expected
+	// value: at the threshold it counts like the bound and its influence
+	// falls with the square of the score beyond it.
+	ContaminationDownweight Contamination = "downweight"
+)
+
+// ParseContamination parses a policy name; empty means learn.
+func ParseContamination(s string) (Contamination, error) {
+	switch c := Contamination(strings.ToLower(strings.TrimSpace(s))); c {
+	case "":
+		return ContaminationLearn, nil
+	case ContaminationLearn, ContaminationSkip, ContaminationClamp, ContaminationDownweight:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown contamination policy: %s (expected learn|skip|clamp|downweight)", s)
+	}
+}
+
+// learnable returns the value to learn for a flagged result, or false when
+// the value must not be learned at all.
+func (c Contamination) learnable(value float64, r Result, threshold float64) (float64, bool) {
+	switch c {
+	case ContaminationSkip:
+		return 0, false
+	case ContaminationClamp:
+		if r.Score > 0 {
+			return r.Upper, true
+		}
+		return r.Lower, true
+	case ContaminationDownweight:
+		weight := threshold / math.Abs(r.Score)
+		return r.Expected + (value-r.Expected)*weight*weight, true
+	default:
+		return value, true
+	}
+}
@@ -0,0 +1,344 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AlgorithmZScore     = "zscore"
+	AlgorithmEWMA       = "ewma"
+	AlgorithmMAD        = "mad"
+	AlgorithmPercentile = "percentile"
+	AlgorithmSeasonal   = "seasonal"
+	// AlgorithmSeasonalWeekly buckets by hour of week (day of week and hour).
+	AlgorithmSeasonalWeekly = "seasonal_weekly"
+	// AlgorithmHolt smooths the level and the trend of a metric.
+	AlgorithmHolt = "holt"
+)
+
+// minDetectorWindow is the smallest window a detector learns from.
+const minDetectorWindow = 5
+
+// halfLifeAlgorithms take a time-based half-life ("ewma:10m").
+var halfLifeAlgorithms = map[string]bool{AlgorithmEWMA: true, AlgorithmHolt: true}
+
+// Params are the detector settings shared by every algorithm.
+type Params struct {
+	WindowSize int
+	Threshold  float64
+	// HalfLife is set from a "name:half-life" detector spec. Smoothing
+	// algorithms decay by elapsed time instead of sample count when it is set.
+	HalfLife time.Duration
+}
+
+// Result is an algorithm's verdict on one value. Score is a signed deviation
+// in standard-deviation-equivalent units so that the z-score threshold and
+// severity cut-points apply to every algorithm.
+type Result struct {
+	// Ready is false until the algorithm has learned enough to score.
+	Ready    bool
+	Score    float64
+	Expected float64
+	Spread   float64
+	Lower    float64
+	Upper    float64
+}
+
+// Algorithm learns the baseline of a single metric series.
+type Algorithm interface {
+	// Score evaluates value against the baseline learned so far without
+	// changing it.
+	Score(ts time.Time, value float64) Result
+	// Learn adds value to the baseline.
+	Learn(ts time.Time, value float64)
+}
+
+// Factory builds a fresh Algorithm for one metric series.
+type Factory func(Params) Algorithm
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes an algorithm selectable by name. It panics if name is empty
+// or already registered.
+func Register(name string, factory Factory) {
+	name = normalizeAlgorithmName(name)
+	if name == "" || factory == nil {
+		panic("anomaly: Register requires a name and factory")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("anomaly: algorithm registered twice: " + name)
+	}
+	registry[name] = factory
+}
+
+// RegisteredAlgorithms returns the sorted names of all registered algorithms.
+func RegisteredAlgorithms() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewAlgorithm builds the algorithm named by spec, either a registered name or
+// "name:half-life" for ewma and holt. An empty spec selects zscore.
+func NewAlgorithm(spec string, p Params) (Algorithm, error) {
+	factory, halfLife, err := lookupAlgorithm(spec)
+	if err != nil {
+		return nil, err
+	}
+	if halfLife > 0 {
+		p.HalfLife = halfLife
+	}
+	return factory(p), nil
+}
+
+// ValidateAlgorithm reports an error if spec does not name a registered
+// algorithm or gives a half-life to one that does not take it.
+func ValidateAlgorithm(spec string) error {
+	_, _, err := lookupAlgorithm(spec)
+	return err
+}
+
+func lookupAlgorithm(spec string) (Factory, time.Duration, error) {
+	name, rawHalfLife, hasHalfLife := strings.Cut(normalizeAlgorithmName(spec), ":")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = AlgorithmZScore
+	}
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown detector: %s (expected %s)", name, strings.Join(RegisteredAlgorithms(), "|"))
+	}
+	if !hasHalfLife {
+		return factory, 0, nil
+	}
+	if !halfLifeAlgorithms[name] {
+		return nil, 0, fmt.Errorf("detector %s does not take a half-life (only ewma and holt do)", name)
+	}
+	halfLife, err := time.ParseDuration(strings.TrimSpace(rawHalfLife))
+	if err != nil || halfLife <= 0 {
+		return nil, 0, fmt.Errorf("invalid half-life for %s: %q (expected a positive duration such as 10m)", name, rawHalfLife)
+	}
+	return factory, halfLife, nil
+}
+
+func normalizeAlgorithmName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// AlgorithmConfig selects the algorithm used for each metric. Default applies
+// to metrics without a PerMetric entry; empty means zscore.
+type AlgorithmConfig struct {
+	Default   string
+	PerMetric map[string]string
+	// Profiles seed seasonal algorithms with trained per-bucket baselines,
+	// keyed by metric. Other algorithms ignore them.
+	Profiles map[string]*SeasonalProfile
+	// References seed every algorithm with an imported baseline, keyed by
+	// metric, so detection starts with the first sample.
+	References map[string]*Reference
+	// Contamination controls, per metric, how values the detector flags enter
+	// the baseline (learned as-is when unset).
+	Contamination map[string]Contamination
+	// MetricParams override the detector's window size and threshold per
+	// metric; zero fields keep the detector's value.
+	MetricParams map[string]Params
+}
+
+func (c AlgorithmConfig) Validate() error {
+	if err := ValidateAlgorithm(c.Default); err != nil {
+		return err
+	}
+	for metric, p := range c.MetricParams {
+		if p.WindowSize < 0 || p.Threshold < 0 {
+			return fmt.Errorf("%s: window size and threshold must not be negative", metric)
+		}
+	}
+	for metric, policy := range c.Contamination {
+		if _, err := ParseContamination(string(policy)); err != nil {
+			return fmt.Errorf("%s: %w", metric, err)
+		}
+	}
+	for metric, name := range c.PerMetric {
+		if err := ValidateAlgorithm(name); err != nil {
+			return fmt.Errorf("%s: %w", metric, err)
+		}
+	}
+	return nil
+}
+
+// For returns the normalized algorithm name used for metric. Names that do
+// not validate return zscore, the algorithm the detector falls back to.
+func (c AlgorithmConfig) For(metric string) string {
+	name := c.Default
+	if n, ok := c.PerMetric[metric]; ok && strings.TrimSpace(n) != "" {
+		name = n
+	}
+	name = normalizeAlgorithmName(name)
+	if name == "" || ValidateAlgorithm(name) != nil {
+		return AlgorithmZScore
+	}
+	return name
+}
+
+// ParamsFor returns base with metric's MetricParams applied. Windows below
+// five samples are raised to five, as for the detector-wide window.
+func (c AlgorithmConfig) ParamsFor(metric string, base Params) Params {
+	p, ok := c.MetricParams[metric]
+	if !ok {
+		return base
+	}
+	if p.WindowSize > 0 {
+		base.WindowSize = max(p.WindowSize, minDetectorWindow)
+	}
+	if p.Threshold > 0 {
+		base.Threshold = p.Threshold
+	}
+	return base
+}
+
+// IsDefault reports whether every metric uses zscore.
+func (c AlgorithmConfig) IsDefault() bool {
+	if c.For("") != AlgorithmZScore {
+		return false
+	}
+	for metric := range c.PerMetric {
+		if c.For(metric) != AlgorithmZScore {
+			return false
+		}
+	}
+	return true
+}
+
+// Detector scores each metric with its configured algorithm and flags values
+// whose absolute score reaches the threshold.
+type Detector struct {
+	params     Params
+	algorithms AlgorithmConfig
+	models     map[string]Algorithm
+	// excluded counts, per metric, flagged values the contamination policy
+	// kept out of the baseline or reduced before learning.
+	excluded map[string]int
+}
+
+func NewDetector(windowSize int, threshold float64) *Detector {
+	return NewDetectorWithAlgorithms(windowSize, threshold, AlgorithmConfig{})
+}
+
+// NewDetectorWithAlgorithms builds a detector that uses algorithms to choose a
+// model per metric. Unknown algorithm names fall back to zscore, and
+// anomalies and snapshots record zscore for them; call
+// AlgorithmConfig.Validate first to reject them.
+func NewDetectorWithAlgorithms(windowSize int, threshold float64, algorithms AlgorithmConfig) *Detector {
+	if windowSize < minDetectorWindow {
+		windowSize = minDetectorWindow
+	}
+	if threshold <= 0 {
+		threshold = 3.0
+	}
+	return &Detector{
+		params:     Params{WindowSize: windowSize, Threshold: threshold},
+		algorithms: algorithms,
+		models:     make(map[string]Algorithm),
+		excluded:   make(map[string]int),
+	}
+}
+
+func (d *Detector) Check(name string, value float64) *Anomaly {
+	return d.CheckAt(name, time.Time{}, value)
+}
+
+// CheckAt scores value for metric name observed at ts, learns it according to
+// the metric's contamination policy, and returns an anomaly if the score
+// reaches the threshold. Algorithms that ignore time accept a zero ts.
+func (d *Detector) CheckAt(name string, ts time.Time, value float64) *Anomaly {
+	return d.checkAt(name, ts, value, DirectionBoth)
+}
+
+// checkAt is CheckAt for a detector limited to dir. Values on the side dir
+// ignores are not flagged, so they are learned as-is rather than kept out of
+// the baseline by the contamination policy.
+func (d *Detector) checkAt(name string, ts time.Time, value float64, dir Direction) *Anomaly {
+	model := d.model(name)
+	result := model.Score(ts, value)
+	threshold := d.algorithms.ParamsFor(name, d.params).Threshold
+
+	if !result.Ready || math.Abs(result.Score) < threshold || !dir.Allows(result.Score) {
+		model.Learn(ts, value)
+		return nil
+	}
+	learned, ok := d.algorithms.Contamination[name].learnable(value, result, threshold)
+	if ok {
+		model.Learn(ts, learned)
+	}
+	if !ok || learned != value {
+		d.excluded[name]++
+	}
+	return graded(&Anomaly{
+		Name:        name,
+		Value:       value,
+		RuleType:    RuleTypeZScore,
+		Direction:   directionOf(result.Score),
+		Algorithm:   d.algorithms.For(name),
+		Mean:        result.Expected,
+		Stddev:      result.Spread,
+		ZScore:      result.Score,
+		Bounds:      &Bounds{Lower: result.Lower, Upper: result.Upper},
+		Explanation: explain(name, value, result.Expected, result.Score),
+		Hint:        Hint(name),
+	})
+}
+
+func (d *Detector) model(name string) Algorithm {
+	if m, ok := d.models[name]; ok {
+		return m
+	}
+	m := d.newModel(name)
+	d.models[name] = m
+	return m
+}
+
+// newModel builds a fresh, seeded model for name without keeping it.
+func (d *Detector) newModel(name string) Algorithm {
+	params := d.algorithms.ParamsFor(name, d.params)
+	m, err := NewAlgorithm(d.algorithms.For(name), params)
+	if err != nil {
+		m, _ = NewAlgorithm(AlgorithmZScore, params)
+	}
+	if seeder, ok := m.(SeasonalSeeder); ok && d.algorithms.Profiles[name] != nil {
+		seeder.SeedSeasonal(*d.algorithms.Profiles[name])
+	}
+	if seeder, ok := m.(ReferenceSeeder); ok && d.algorithms.References[name] != nil {
+		seeder.SeedReference(*d.algorithms.References[name])
+	}
+	return m
+}
+
+// Excluded returns, per metric, how many flagged values the contamination
+// policy kept out of the baseline or reduced before learning.
+func (d *Detector) Excluded() map[string]int {
+	if len(d.excluded) == 0 {
+		return nil
+	}
+	out := make(map[string]int, len(d.excluded))
+	for name, n := range d.excluded {
+		out[name] = n
+	}
+	return out
+}
@@ -0,0 +1,61 @@
+package anomaly
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	DirectionAbove = "above"
+	DirectionBelow = "below"
+)
+
+// Direction limits a baseline rule to one side of the expected value.
+type Direction string
+
+const (
+	DirectionBoth Direction = ""
+	DirectionUp   Direction = "up"
+	DirectionDown Direction = "down"
+)
+
+// ParseDirection accepts up|increase, down|decrease or both.
+func ParseDirection(s string) (Direction, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "both":
+		return DirectionBoth, nil
+	case "up", "increase", "above":
+		return DirectionUp, nil
+	case "down", "decrease", "below":
+		return DirectionDown, nil
+	default:
+		return DirectionBoth, fmt.Errorf("unknown direction: %s (expected up|down|both)", s)
+	}
+}
+
+func (d Direction) String() string {
+	if d == DirectionBoth {
+		return "both"
+	}
+	return string(d)
+}
+
+// Allows reports whether an anomaly with the given signed score is on a side
+// the direction accepts.
+func (d Direction) Allows(score float64) bool {
+	switch d {
+	case DirectionUp:
+		return score > 0
+	case DirectionDown:
+		return score < 0
+	default:
+		return true
+	}
+}
+
+func directionOf(score float64) string {
+	if score < 0 {
+		return DirectionBelow
+	}
+	return DirectionAbove
+}
@@ -0,0 +1,200 @@
+package anomaly
+
+import (
+	"fmt"
+	"time"
+)
+
+// Rules configures every rule an Evaluator applies on top of the baseline
+// detector.
+type Rules struct {
+	// StaticThresholds are ceilings (value >= threshold fires) and
+	// StaticLowerThresholds are floors (value < threshold fires).
+	StaticThresholds      map[string]float64
+	StaticLowerThresholds map[string]float64
+	PercentileRules       map[string][]PercentileRule
+	// ForecastRules predict when a metric reaches its limit (disk or memory
+	// exhaustion).
+	ForecastRules map[string]ForecastRule
+	// RateRules flag gauges that change too fast (a memory leak, a runaway
+	// log) before they reach any threshold.
+	RateRules map[string]RateRule
+	// ChangePoints report lasting level shifts (a regression after a rollout)
+	// that the rolling baseline absorbs within one window.
+	ChangePoints map[string]ChangePointRule
+	// Directions makes the baseline detector one-sided per metric, e.g. only
+	// alert on CPU increases or throughput drops.
+	Directions map[string]Direction
+	// Sustain qualifies rules per metric ("cpu_percent") or per metric and
+	// rule type ("cpu_percent:static_threshold"); see SustainKey.
+	Sustain map[string]Sustain
+	// Expressions combine several metrics of one sample. They are checked by
+	// an ExpressionEvaluator, not per metric by the Evaluator.
+	Expressions []ExpressionRule
+	// Severity grades every anomaly; the zero value uses the default levels
+	// and cut-points.
+	Severity SeverityRules
+	// Warmup holds back anomalies from learned rules while a metric's
+	// baseline is still forming; see Warmup.
+	Warmup Warmup
+}
+
+// Validate checks the warm-up, the severity cut-points and that every
+// expression rule's severity is a configured level.
+func (r Rules) Validate() error {
+	if err := r.Warmup.Validate(); err != nil {
+		return err
+	}
+	if err := r.Severity.Validate(); err != nil {
+		return err
+	}
+	for _, rule := range r.Expressions {
+		if _, err := r.Severity.Scale.Parse(rule.Severity); err != nil {
+			return fmt.Errorf("expression %s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// Evaluator runs the baseline detector and every configured rule for one
+// series of samples (one host) and picks the most severe result.
+type Evaluator struct {
+	detector    *Detector
+	rules       Rules
+	percentiles *PercentileEvaluator
+	forecaster  *Forecaster
+	rates       *RateEvaluator
+	changes     *ChangeDetector
+	sustained   map[string]*sustainState
+	warmup      map[string]*WarmupState
+}
+
+func NewEvaluator(windowSize int, threshold float64, algorithms AlgorithmConfig, rules Rules) *Evaluator {
+	detector := NewDetectorWithAlgorithms(windowSize, threshold, algorithms)
+	return &Evaluator{
+		detector:    detector,
+		rules:       rules,
+		percentiles: NewPercentileEvaluator(rules.PercentileRules),
+		forecaster:  NewForecaster(rules.ForecastRules),
+		rates:       NewRateEvaluator(rules.RateRules),
+		changes:     NewChangeDetector(detector.params.WindowSize, rules.ChangePoints),
+		sustained:   make(map[string]*sustainState),
+		warmup:      make(map[string]*WarmupState),
+	}
+}
+
+// Learn feeds value into the baselines without evaluating rules.
+func (e *Evaluator) Learn(name string, ts time.Time, value float64) {
+	_ = e.detector.CheckAt(name, ts, value)
+	_ = e.percentiles.Check(name, ts, value)
+	_ = e.forecaster.Check(name, ts, value)
+	_ = e.rates.Check(name, ts, value)
+	_ = e.changes.Check(name, ts, value)
+	e.warmupState(name).observe(ts, value)
+}
+
+// Seen counts value toward the metric's warm-up without learning it, for
+// samples a caller skips (analyze skips the first sample of each host, which
+// watch learns), so warm-up ends on the same sample either way.
+func (e *Evaluator) Seen(name string, ts time.Time, value float64) {
+	e.warmupState(name).observe(ts, value)
+}
+
+// Excluded returns the detector's per-metric count of flagged values kept out
+// of the baseline; see Detector.Excluded.
+func (e *Evaluator) Excluded() map[string]int { return e.detector.Excluded() }
+
+// Evaluate scores value for metric name observed at ts, learns it, and returns
+// the most severe anomaly among the rules whose conditions hold. While the
+// metric is warming up, anomalies from learned rules are marked Warmup and
+// only returned when no other rule fired; see Warmup.
+func (e *Evaluator) Evaluate(name string, ts time.Time, value float64) *Anomaly {
+	state := e.warmupState(name)
+	warming := e.rules.Warmup.warming(state, ts)
+	state.observe(ts, value)
+
+	candidates := []struct {
+		ruleType string
+		anomaly  *Anomaly
+	}{
+		{RuleTypeZScore, e.checkBaseline(name, ts, value)},
+		{RuleTypeStaticThreshold, SelectHigherSeverity(
+			CheckStaticThreshold(name, value, e.rules.StaticThresholds),
+			CheckStaticLowerThreshold(name, value, e.rules.StaticLowerThresholds),
+		)},
+		{RuleTypePercentile, e.percentiles.Check(name, ts, value)},
+		{RuleTypeForecast, e.forecaster.Check(name, ts, value)},
+		{RuleTypeRateOfChange, e.rates.Check(name, ts, value)},
+	}
+	var worst, held *Anomaly
+	for _, c := range candidates {
+		e.rules.Severity.Grade(c.anomaly)
+		a := e.sustain(name, c.ruleType, ts, c.anomaly)
+		if a != nil && warming && warmupLearned(c.ruleType) {
+			a.Warmup = true
+			held = SelectHigherSeverity(held, a)
+			continue
+		}
+		worst = SelectHigherSeverity(worst, a)
+	}
+	// A change point is a one-off event once the new level has already held,
+	// so it is not subject to sustain qualifiers.
+	change := e.changes.Check(name, ts, value)
+	e.rules.Severity.Grade(change)
+	if change != nil && warming {
+		change.Warmup = true
+		held = SelectHigherSeverity(held, change)
+	} else {
+		worst = SelectHigherSeverity(worst, change)
+	}
+	if worst == nil {
+		return held
+	}
+	return worst
+}
+
+func (e *Evaluator) warmupState(name string) *WarmupState {
+	state, ok := e.warmup[name]
+	if !ok {
+		state = &WarmupState{}
+		e.warmup[name] = state
+	}
+	return state
+}
+
+// checkBaseline runs the detector limited to the metric's direction. Values
+// on the side it ignores are learned like any other normal value.
+func (e *Evaluator) checkBaseline(name string, ts time.Time, value float64) *Anomaly {
+	dir, _ := metricRule(e.rules.Directions, name)
+	return e.detector.checkAt(name, ts, value, dir)
+}
+
+// sustain applies the Sustain qualifier for name and ruleType, if any. The
+// breach history is updated on every sample so gaps reset the condition.
+func (e *Evaluator) sustain(name, ruleType string, ts time.Time, a *Anomaly) *Anomaly {
+	var rule Sustain
+	ok := false
+	// Per-mount metrics fall back to the qualifiers of the metric they
+	// qualify.
+	for _, key := range []string{SustainKey(name, ruleType), name, SustainKey(BaseMetric(name), ruleType), BaseMetric(name)} {
+		if rule, ok = e.rules.Sustain[key]; ok {
+			break
+		}
+	}
+	if !ok {
+		return a
+	}
+	key := SustainKey(name, ruleType)
+	state, ok := e.sustained[key]
+	if !ok {
+		state = &sustainState{rule: rule}
+		e.sustained[key] = state
+	}
+	condition, held := state.observe(ts, a != nil)
+	if !held {
+		return nil
+	}
+	a.Condition = condition
+	a.Explanation += " " + ConditionText(condition)
+	return a
+}
@@ -0,0 +1,481 @@
+package anomaly
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/severity"
+)
+
+// RuleTypeExpression marks anomalies raised by expression rules.
+const RuleTypeExpression = "expression"
+
+// DefaultExpressionSeverity is the severity of an expression rule that does
+// not set one.
+const DefaultExpressionSeverity = severity.Medium
+
+// ExpressionRule fires when a boolean expression over several metrics of one
+// sample holds, optionally for a while:
+//
+//	cpu_percent > 85 && mem_used_percent > 90 for 2m
+//
+// Expressions compare metrics and numbers with > >= < <= == !=, combine
+// comparisons with && || ! and parentheses, and may use + - * / on either
+// side. The optional trailing "for" clause takes a Sustain spec ("2m" or
+// "3/5"). A sample that lacks a referenced metric never matches.
+type ExpressionRule struct {
+	Name     string
+	Severity string
+	Source   string
+	expr     *exprNode
+	sustain  *Sustain
+	metrics  []string
+}
+
+// ExpressionMatch is attached to anomalies raised by expression rules.
+type ExpressionMatch struct {
+	Expression string             `json:"expression"`
+	Values     map[string]float64 `json:"values"`
+}
+
+// MetricResolver maps a metric name as written in an expression to the
+// derived metric name, reporting false for unknown metrics.
+type MetricResolver func(name string) (string, bool)
+
+var forClause = regexp.MustCompile(`\s+for\s+(\S+)\s*$`)
+
+// ParseExpressionRule parses source into a rule named name. Every metric the
+// expression references is passed through resolve, so unknown metrics are
+// rejected when the rule is loaded rather than silently never matching. The
+// severity is checked against the configured levels by Rules.Validate.
+func ParseExpressionRule(name, severity, source string, resolve MetricResolver) (ExpressionRule, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ExpressionRule{}, errors.New("expression rule needs a name")
+	}
+	severity = strings.ToLower(strings.TrimSpace(severity))
+	if severity == "" {
+		severity = DefaultExpressionSeverity
+	}
+	rule := ExpressionRule{Name: name, Severity: severity, Source: strings.TrimSpace(source)}
+	body := rule.Source
+	if m := forClause.FindStringSubmatchIndex(body); m != nil {
+		spec := body[m[2]:m[3]]
+		sustain, err := ParseSustain(spec)
+		if err != nil {
+			return ExpressionRule{}, fmt.Errorf("expression %s: for clause: %w", name, err)
+		}
+		rule.sustain = &sustain
+		body = body[:m[0]]
+	}
+	tokens, err := lexExpression(body)
+	if err != nil {
+		return ExpressionRule{}, fmt.Errorf("expression %s: %w", name, err)
+	}
+	p := &exprParser{tokens: tokens, resolve: resolve, seen: map[string]bool{}}
+	rule.expr, err = p.parse()
+	if err != nil {
+		return ExpressionRule{}, fmt.Errorf("expression %s: %w", name, err)
+	}
+	if !rule.expr.boolean() {
+		return ExpressionRule{}, fmt.Errorf("expression %s: must be a comparison, e.g. cpu_percent > 85", name)
+	}
+	for metric := range p.seen {
+		rule.metrics = append(rule.metrics, metric)
+	}
+	sort.Strings(rule.metrics)
+	return rule, nil
+}
+
+// Metrics returns the sorted derived metric names the rule references.
+func (r ExpressionRule) Metrics() []string { return r.metrics }
+
+// Matches reports whether the expression holds for values, ignoring the for
+// clause.
+func (r ExpressionRule) Matches(values map[string]float64) bool {
+	if r.expr == nil {
+		return false
+	}
+	held, ok := r.expr.truth(values)
+	return ok && held
+}
+
+// ExpressionEvaluator checks expression rules against whole samples and
+// tracks their for clauses.
+type ExpressionEvaluator struct {
+	rules     []ExpressionRule
+	severity  SeverityRules
+	sustained map[string]*sustainState
+}
+
+// NewExpressionEvaluator checks rules.Expressions and scores their anomalies
+// on rules.Severity.
+func NewExpressionEvaluator(rules Rules) *ExpressionEvaluator {
+	return &ExpressionEvaluator{rules: rules.Expressions, severity: rules.Severity, sustained: make(map[string]*sustainState)}
+}
+
+// Check evaluates every rule against the derived metrics of one sample and
+// returns an anomaly per rule whose condition holds.
+func (e *ExpressionEvaluator) Check(ts time.Time, metrics map[string]float64) []Anomaly {
+	var out []Anomaly
+	for _, rule := range e.rules {
+		matched := rule.Matches(metrics)
+		var condition *Condition
+		if rule.sustain != nil {
+			state, ok := e.sustained[rule.Name]
+			if !ok {
+				state = &sustainState{rule: *rule.sustain}
+				e.sustained[rule.Name] = state
+			}
+			var held bool
+			condition, held = state.observe(ts, matched)
+			matched = held
+		}
+		if !matched {
+			continue
+		}
+		values := make(map[string]float64, len(rule.metrics))
+		for _, metric := range rule.metrics {
+			values[metric] = metrics[metric]
+		}
+		a := Anomaly{
+			Name:        rule.Name,
+			Timestamp:   ts,
+			RuleType:    RuleTypeExpression,
+			Condition:   condition,
+			Expression:  &ExpressionMatch{Expression: rule.Source, Values: values},
+			Severity:    rule.Severity,
+			Explanation: explainExpression(rule, values, condition),
+		}
+		e.severity.Grade(&a)
+		out = append(out, a)
+	}
+	return out
+}
+
+func explainExpression(rule ExpressionRule, values map[string]float64, condition *Condition) string {
+	parts := make([]string, 0, len(rule.metrics))
+	for _, metric := range rule.metrics {
+		parts = append(parts, fmt.Sprintf("%s %s", MetricLabel(metric), FormatValue(metric, values[metric])))
+	}
+	held := "holds"
+	if condition != nil {
+		held = fmt.Sprintf("has held since %s", condition.Start.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("Rule %s (%s) %s: %s.", rule.Name, rule.Source, held, strings.Join(parts, ", "))
+}
+
+// exprNode is a parsed expression. Leaves are numbers and metrics; inner
+// nodes are operators.
+type exprNode struct {
+	op          string
+	value       float64
+	metric      string
+	left, right *exprNode
+}
+
+func (n *exprNode) boolean() bool {
+	switch n.op {
+	case "&&", "||", "!", ">", ">=", "<", "<=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+// number evaluates an arithmetic node; ok is false when a metric is missing.
+func (n *exprNode) number(values map[string]float64) (float64, bool) {
+	switch n.op {
+	case "num":
+		return n.value, true
+	case "metric":
+		v, ok := values[n.metric]
+		return v, ok
+	case "neg":
+		v, ok := n.left.number(values)
+		return -v, ok
+	}
+	l, ok := n.left.number(values)
+	if !ok {
+		return 0, false
+	}
+	r, ok := n.right.number(values)
+	if !ok {
+		return 0, false
+	}
+	switch n.op {
+	case "+":
+		return l + r, true
+	case "-":
+		return l - r, true
+	case "*":
+		return l * r, true
+	default:
+		if r == 0 {
+			return math.NaN(), true
+		}
+		return l / r, true
+	}
+}
+
+// truth evaluates a boolean node; ok is false when a metric is missing.
+func (n *exprNode) truth(values map[string]float64) (bool, bool) {
+	switch n.op {
+	case "!":
+		v, ok := n.left.truth(values)
+		return !v, ok
+	case "&&", "||":
+		l, ok := n.left.truth(values)
+		if !ok {
+			return false, false
+		}
+		r, ok := n.right.truth(values)
+		if !ok {
+			return false, false
+		}
+		if n.op == "&&" {
+			return l && r, true
+		}
+		return l || r, true
+	}
+	l, ok := n.left.number(values)
+	if !ok {
+		return false, false
+	}
+	r, ok := n.right.number(values)
+	if !ok {
+		return false, false
+	}
+	switch n.op {
+	case ">":
+		return l > r, true
+	case ">=":
+		return l >= r, true
+	case "<":
+		return l < r, true
+	case "<=":
+		return l <= r, true
+	case "==":
+		return l == r, true
+	default:
+		return l != r, true
+	}
+}
+
+type exprToken struct {
+	kind string // "num", "ident" or the operator itself
+	text string
+}
+
+func lexExpression(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c >= '0' && c <= '9' || c == '.':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' || src[j] == 'e' || src[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: "num", text: src[i:j]})
+			i = j
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: "ident", text: src[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", ">=", "<=", "==", "!=", ">", "<", "!", "+", "-", "*", "/", "(", ")"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+			tokens = append(tokens, exprToken{kind: op, text: op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// exprParser is a recursive-descent parser over the grammar
+//
+//	or      = and { "||" and }
+//	and     = not { "&&" not }
+//	not     = "!" not | compare
+//	compare = sum [ (">" | ">=" | "<" | "<=" | "==" | "!=") sum ]
+//	sum     = product { ("+" | "-") product }
+//	product = unary { ("*" | "/") unary }
+//	unary   = "-" unary | number | metric | "(" or ")"
+type exprParser struct {
+	tokens  []exprToken
+	pos     int
+	resolve MetricResolver
+	seen    map[string]bool
+}
+
+func (p *exprParser) parse() (*exprNode, error) {
+	if len(p.tokens) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return n, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos].kind
+}
+
+func (p *exprParser) or() (*exprNode, error) {
+	return p.binary(p.and, true, "||")
+}
+
+func (p *exprParser) and() (*exprNode, error) {
+	return p.binary(p.not, true, "&&")
+}
+
+func (p *exprParser) not() (*exprNode, error) {
+	if p.peek() != "!" {
+		return p.compare()
+	}
+	p.pos++
+	operand, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	if !operand.boolean() {
+		return nil, errors.New("! needs a comparison")
+	}
+	return &exprNode{op: "!", left: operand}, nil
+}
+
+func (p *exprParser) compare() (*exprNode, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peek(); op {
+	case ">", ">=", "<", "<=", "==", "!=":
+		p.pos++
+		right, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if left.boolean() || right.boolean() {
+			return nil, fmt.Errorf("%s compares numbers, not conditions", op)
+		}
+		return &exprNode{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) sum() (*exprNode, error) {
+	return p.binary(p.product, false, "+", "-")
+}
+
+func (p *exprParser) product() (*exprNode, error) {
+	return p.binary(p.unary, false, "*", "/")
+}
+
+// binary parses a left-associative chain of ops over next. Logical chains
+// need boolean operands and arithmetic chains numeric ones.
+func (p *exprParser) binary(next func() (*exprNode, error), logical bool, ops ...string) (*exprNode, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		matched := false
+		for _, candidate := range ops {
+			if op == candidate {
+				matched = true
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		p.pos++
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if left.boolean() != logical || right.boolean() != logical {
+			if logical {
+				return nil, fmt.Errorf("%s joins comparisons, e.g. cpu_percent > 85 %s mem_used_percent > 90", op, op)
+			}
+			return nil, fmt.Errorf("%s needs numbers, not conditions", op)
+		}
+		left = &exprNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) unary() (*exprNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch tok.kind {
+	case "-":
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if operand.boolean() {
+			return nil, errors.New("- needs a number, not a condition")
+		}
+		return &exprNode{op: "neg", left: operand}, nil
+	case "num":
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tok.text)
+		}
+		return &exprNode{op: "num", value: v}, nil
+	case "ident":
+		metric := tok.text
+		if p.resolve != nil {
+			resolved, ok := p.resolve(tok.text)
+			if !ok {
+				return nil, fmt.Errorf("unknown metric: %s", tok.text)
+			}
+			metric = resolved
+		}
+		p.seen[metric] = true
+		return &exprNode{op: "metric", metric: metric}, nil
+	case "(":
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return inner, nil
+	default:
+		return nil, fmt.Errorf("unexpected %q", tok.text)
+	}
+}
@@ -0,0 +1,276 @@
+package anomaly
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const RuleTypeForecast = "forecast"
+
+const (
+	// DefaultForecastLimit is the value a forecast rule treats as exhausted
+	// when Limit is not set (100% used).
+	DefaultForecastLimit = 100.0
+	// DefaultForecastLookback is how much history a forecast rule fits when
+	// Lookback is not set.
+	DefaultForecastLookback = 24 * time.Hour
+
+	// forecastPoints caps the fitted history; samples are thinned to one per
+	// Lookback/forecastPoints so the pairwise fit stays cheap.
+	forecastPoints = 120
+	// minForecastPoints is the history needed before a trend is trusted.
+	minForecastPoints = 10
+)
+
+// ForecastRule fits a trend over the last Lookback of a metric and fires when
+// the metric is predicted to reach Limit within Horizon.
+type ForecastRule struct {
+	Horizon  time.Duration
+	Limit    float64
+	Lookback time.Duration
+}
+
+func (r ForecastRule) Validate() error {
+	if r.Horizon <= 0 {
+		return errors.New("forecast horizon must be greater than zero")
+	}
+	if math.IsNaN(r.Limit) || math.IsInf(r.Limit, 0) || r.Limit < 0 {
+		return errors.New("forecast limit must be greater than or equal to zero")
+	}
+	if r.Lookback < 0 {
+		return errors.New("forecast lookback must be greater than or equal to zero")
+	}
+	return nil
+}
+
+func (r ForecastRule) limit() float64 {
+	if r.Limit == 0 {
+		return DefaultForecastLimit
+	}
+	return r.Limit
+}
+
+func (r ForecastRule) lookback() time.Duration {
+	if r.Lookback == 0 {
+		return DefaultForecastLookback
+	}
+	return r.Lookback
+}
+
+// String renders the rule in the syntax accepted by ParseForecastRule.
+func (r ForecastRule) String() string {
+	s := r.Horizon.String()
+	if r.Limit != 0 {
+		s += ">" + strconv.FormatFloat(r.Limit, 'g', -1, 64)
+	}
+	if r.Lookback != 0 {
+		s += "/" + r.Lookback.String()
+	}
+	return s
+}
+
+// ParseForecastRule parses "24h" (predicted to reach 100 within 24 hours),
+// "24h>90" (reach 90) or "24h>90/72h" (fitting the last 72 hours instead of
+// the default 24).
+func ParseForecastRule(spec string) (ForecastRule, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	rest, rawLookback, hasLookback := strings.Cut(spec, "/")
+	rawHorizon, rawLimit, hasLimit := strings.Cut(rest, ">")
+	var rule ForecastRule
+	var err error
+	if rule.Horizon, err = time.ParseDuration(strings.TrimSpace(rawHorizon)); err != nil {
+		return ForecastRule{}, fmt.Errorf("forecast rule must be <horizon>[><limit>][/<lookback>]: %q", spec)
+	}
+	if hasLimit {
+		if rule.Limit, err = strconv.ParseFloat(strings.TrimSpace(rawLimit), 64); err != nil {
+			return ForecastRule{}, fmt.Errorf("invalid limit in %q", spec)
+		}
+		if rule.Limit <= 0 {
+			return ForecastRule{}, fmt.Errorf("forecast limit must be greater than zero in %q", spec)
+		}
+	}
+	if hasLookback {
+		if rule.Lookback, err = time.ParseDuration(strings.TrimSpace(rawLookback)); err != nil {
+			return ForecastRule{}, fmt.Errorf("invalid lookback in %q: %w", spec, err)
+		}
+		if rule.Lookback <= 0 {
+			return ForecastRule{}, fmt.Errorf("forecast lookback must be greater than zero in %q", spec)
+		}
+	}
+	return rule, rule.Validate()
+}
+
+// Forecast is the trend behind a forecast anomaly.
+type Forecast struct {
+	// ETA is when the metric is predicted to reach the limit.
+	ETA            time.Time `json:"eta"`
+	SecondsToLimit float64   `json:"seconds_to_limit"`
+	SlopePerHour   float64   `json:"slope_per_hour"`
+	HorizonSeconds float64   `json:"horizon_seconds"`
+}
+
+// Forecaster applies forecast rules to each metric.
+type Forecaster struct {
+	rules  map[string]ForecastRule
+	trends map[string]*trend
+}
+
+func NewForecaster(rules map[string]ForecastRule) *Forecaster {
+	return &Forecaster{rules: rules, trends: make(map[string]*trend)}
+}
+
+// Check records value and returns an anomaly if the metric is predicted to
+// reach the rule's limit within its horizon. The trend is refitted whenever the
+// thinned history gains a point and extrapolated from ts in between.
+func (f *Forecaster) Check(name string, ts time.Time, value float64) *Anomaly {
+	if f == nil {
+		return nil
+	}
+	rule, ok := metricRule(f.rules, name)
+	if !ok {
+		return nil
+	}
+	t, ok := f.trends[name]
+	if !ok {
+		t = &trend{lookback: rule.lookback()}
+		f.trends[name] = t
+	}
+	if t.add(ts, value) && len(t.times) >= minForecastPoints {
+		t.slope, t.intercept = theilSen(t.times, t.values)
+		t.origin, t.fitted = t.times[0], true
+	}
+	if !t.fitted || t.slope <= 0 {
+		return nil
+	}
+	slope := t.slope
+	fitted := t.intercept + slope*(ts.Sub(t.base).Seconds()-t.origin)
+
+	limit := rule.limit()
+	secondsToLimit := math.Max(0, (limit-fitted)/slope)
+	if value >= limit {
+		secondsToLimit = 0
+	}
+	horizon := rule.Horizon.Seconds()
+	if secondsToLimit > horizon {
+		return nil
+	}
+
+	eta := ts.Add(time.Duration(secondsToLimit * float64(time.Second)))
+	// Urgency reads like an exceed ratio: 0 at the edge of the horizon, 1 when
+	// exhaustion is half a horizon away.
+	urgency := horizon/math.Max(secondsToLimit, 1) - 1
+	return graded(&Anomaly{
+		Name:      name,
+		Value:     value,
+		RuleType:  RuleTypeForecast,
+		Direction: DirectionAbove,
+		Threshold: limit,
+		Mean:      fitted,
+		ZScore:    urgency,
+		Forecast: &Forecast{
+			ETA:            eta,
+			SecondsToLimit: secondsToLimit,
+			SlopePerHour:   slope * 3600,
+			HorizonSeconds: horizon,
+		},
+		Explanation: explainForecast(name, fitted, limit, slope*3600, secondsToLimit, eta),
+	})
+}
+
+func explainForecast(name string, fitted, limit, slopePerHour, secondsToLimit float64, eta time.Time) string {
+	if secondsToLimit == 0 {
+		return fmt.Sprintf("%s has reached %s and is still rising (%s per hour).", MetricLabel(name), FormatValue(name, limit), FormatValue(name, slopePerHour))
+	}
+	return fmt.Sprintf("%s is rising %s per hour from %s and is forecast to reach %s in %s (around %s).",
+		MetricLabel(name), FormatValue(name, slopePerHour), FormatValue(name, fitted), FormatValue(name, limit),
+		FormatETA(time.Duration(secondsToLimit*float64(time.Second))), eta.UTC().Format(time.RFC3339))
+}
+
+// FormatETA renders d at minute resolution, using days past 48 hours.
+func FormatETA(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Minute {
+		return "under a minute"
+	}
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%dd%dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+	}
+	hours, minutes := d/time.Hour, d%time.Hour/time.Minute
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%dm", minutes)
+	case minutes == 0:
+		return fmt.Sprintf("%dh", hours)
+	default:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	}
+}
+
+// trend keeps a thinned history of one metric: at most one point per
+// lookback/forecastPoints, covering the last lookback.
+type trend struct {
+	lookback time.Duration
+	base     time.Time
+	times    []float64 // seconds since base
+	values   []float64
+
+	// The latest fit: value = intercept + slope*(seconds since base - origin).
+	fitted    bool
+	origin    float64
+	slope     float64
+	intercept float64
+}
+
+// add records the sample if it is far enough from the previous point and
+// reports whether it did.
+func (t *trend) add(ts time.Time, v float64) bool {
+	if t.base.IsZero() {
+		t.base = ts
+	}
+	at := ts.Sub(t.base).Seconds()
+	spacing := (t.lookback / forecastPoints).Seconds()
+	if n := len(t.times); n > 0 && at-t.times[n-1] < spacing {
+		return false
+	}
+	t.times = append(t.times, at)
+	t.values = append(t.values, v)
+	cutoff := at - t.lookback.Seconds()
+	drop := 0
+	for drop < len(t.times) && t.times[drop] < cutoff {
+		drop++
+	}
+	t.times = t.times[drop:]
+	t.values = t.values[drop:]
+	return true
+}
+
+// theilSen fits y = intercept + slope*(x - x[0]) using the median of pairwise
+// slopes, which ignores up to ~29% of outlying points (a cache purge, a log
+// rotation) that would skew least squares.
+func theilSen(xs, ys []float64) (slope, intercept float64) {
+	slopes := make([]float64, 0, len(xs)*(len(xs)-1)/2)
+	for i := range xs {
+		for j := i + 1; j < len(xs); j++ {
+			if dx := xs[j] - xs[i]; dx > 0 {
+				slopes = append(slopes, (ys[j]-ys[i])/dx)
+			}
+		}
+	}
+	if len(slopes) == 0 {
+		return 0, 0
+	}
+	sort.Float64s(slopes)
+	slope = quantile(slopes, 0.5)
+
+	residuals := make([]float64, len(xs))
+	for i := range xs {
+		residuals[i] = ys[i] - slope*(xs[i]-xs[0])
+	}
+	sort.Float64s(residuals)
+	return slope, quantile(residuals, 0.5)
+}
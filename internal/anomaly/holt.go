@@ -0,0 +1,87 @@
+package anomaly
+
+import (
+	"math"
+	"time"
+)
+
+// holt is Holt's linear (double exponential) smoothing: it tracks a level and
+// a trend, scores each value against the level projected to its timestamp,
+// and takes the spread from exponentially weighted residuals. A metric that
+// climbs steadily, such as memory during a slow leak, is expected to keep
+// climbing, so only departures from the trend are flagged.
+//
+// Without a half-life the level uses the same per-sample factor as ewma and
+// the trend half of it, with one sample as the unit of time. With a half-life
+// both decay by elapsed time (the trend over twice the half-life), the trend
+// is per second, and irregular spacing is projected correctly.
+type holt struct {
+	threshold float64
+	minCount  int
+	alpha     float64
+	beta      float64
+	halfLife  time.Duration
+	count     int
+	level     float64
+	trend     float64
+	variance  float64
+	last      time.Time
+	learned   time.Duration
+}
+
+func newHolt(p Params) *holt {
+	return &holt{
+		threshold: p.Threshold,
+		minCount:  p.WindowSize,
+		alpha:     2 / (float64(p.WindowSize) + 1),
+		beta:      2 / (2*float64(p.WindowSize) + 1),
+		halfLife:  p.HalfLife,
+	}
+}
+
+// steps returns the time from the last sample to ts in trend units: seconds
+// with a half-life, otherwise one step per sample.
+func (h *holt) steps(ts time.Time) float64 {
+	if h.halfLife <= 0 || h.last.IsZero() || ts.IsZero() {
+		return 1
+	}
+	if !ts.After(h.last) {
+		return 0
+	}
+	return ts.Sub(h.last).Seconds()
+}
+
+func (h *holt) Score(ts time.Time, value float64) Result {
+	if !smoothingReady(h.count, h.minCount, h.halfLife, h.learned) {
+		return Result{}
+	}
+	expected := h.level + h.trend*h.steps(ts)
+	stddev := math.Sqrt(h.variance)
+	if stddev <= 0 {
+		return Result{Expected: expected}
+	}
+	return symmetricResult(value, expected, stddev, h.threshold)
+}
+
+func (h *holt) Learn(ts time.Time, value float64) {
+	steps := h.steps(ts)
+	alpha, elapsed := decay(h.alpha, h.halfLife, h.last, ts)
+	beta, _ := decay(h.beta, 2*h.halfLife, h.last, ts)
+	h.learned += elapsed
+	if !ts.IsZero() {
+		h.last = ts
+	}
+	h.count++
+	if h.count == 1 {
+		h.level = value
+		return
+	}
+	projected := h.level + h.trend*steps
+	residual := value - projected
+	level := projected + alpha*residual
+	if steps > 0 {
+		h.trend += beta * ((level-h.level)/steps - h.trend)
+	}
+	h.level = level
+	h.variance = (1 - alpha) * (h.variance + alpha*residual*residual)
+}
@@ -0,0 +1,202 @@
+package anomaly
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultIncidentWindow is how close in time anomalies on one host must be to
+// count as one incident when no window is configured.
+const DefaultIncidentWindow = time.Minute
+
+// Incident groups anomalies on one host that occurred within the incident
+// window of each other, such as CPU, disk writes and network transmit all
+// spiking while a backup runs.
+type Incident struct {
+	ID       string    `json:"id"`
+	HostID   string    `json:"host_id,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Metrics  []string  `json:"metrics"`
+	Severity string    `json:"severity"`
+	// Score is the highest score among the anomalies.
+	Score float64 `json:"score"`
+	// LikelyCause is the process attributed to most of the anomalies, if any.
+	LikelyCause *ProcessAttribution `json:"likely_cause,omitempty"`
+	Explanation string              `json:"explanation"`
+	// Anomalies are the incident's anomalies, filled in by GroupIncidents.
+	// IncidentTracker only keeps running counts, so an incident that stays
+	// open for the life of a watch does not grow.
+	Anomalies []Anomaly `json:"anomalies"`
+
+	// count is the number of anomalies added and causes the number of them
+	// attributed to each process.
+	count  int
+	causes map[string]int
+}
+
+// Correlated reports whether more than one metric moved in the incident.
+func (i *Incident) Correlated() bool { return len(i.Metrics) > 1 }
+
+// AnomalyCount is the number of anomalies added to the incident.
+func (i *Incident) AnomalyCount() int { return i.count }
+
+// CauseCount is the number of anomalies LikelyCause was attributed to.
+func (i *Incident) CauseCount() int {
+	if i.LikelyCause == nil {
+		return 0
+	}
+	return i.causes[i.LikelyCause.Name]
+}
+
+// IncidentTracker assigns anomalies to incidents as they are observed. An
+// anomaly joins the open incident of its host when it is no more than window
+// after the incident's last anomaly; otherwise it opens a new one.
+type IncidentTracker struct {
+	window time.Duration
+	open   map[string]*Incident
+}
+
+func NewIncidentTracker(window time.Duration) *IncidentTracker {
+	if window <= 0 {
+		window = DefaultIncidentWindow
+	}
+	return &IncidentTracker{window: window, open: make(map[string]*Incident)}
+}
+
+// Add records an anomaly and returns the incident it belongs to. The
+// returned incident is updated by later calls while it stays open.
+func (t *IncidentTracker) Add(a Anomaly) *Incident {
+	inc, ok := t.open[a.HostID]
+	if !ok || a.Timestamp.Sub(inc.End) > t.window {
+		inc = &Incident{
+			ID:     incidentID(a.HostID, a.Timestamp),
+			HostID: a.HostID,
+			Start:  a.Timestamp,
+		}
+		t.open[a.HostID] = inc
+	}
+	if a.Timestamp.After(inc.End) {
+		inc.End = a.Timestamp
+	}
+	inc.count++
+	if !containsString(inc.Metrics, a.Name) {
+		inc.Metrics = append(inc.Metrics, a.Name)
+	}
+	if inc.Severity == "" || a.Score > inc.Score {
+		inc.Severity, inc.Score = a.Severity, a.Score
+	}
+	inc.attribute(a)
+	inc.Explanation = explainIncident(inc)
+	return inc
+}
+
+// attribute counts a's process toward the likely cause: the top memory
+// process for memory metrics and the top CPU process otherwise. The first
+// process to be counted most often wins.
+func (i *Incident) attribute(a Anomaly) {
+	p := a.TopCPUProcess
+	if strings.HasPrefix(a.Name, "mem_") {
+		p = a.TopMemProcess
+	}
+	if p == nil || p.Name == "" {
+		return
+	}
+	if i.causes == nil {
+		i.causes = make(map[string]int)
+	}
+	i.causes[p.Name]++
+	if i.LikelyCause == nil || i.causes[p.Name] > i.causes[i.LikelyCause.Name] {
+		attributed := *p
+		i.LikelyCause = &attributed
+	}
+}
+
+// GroupIncidents groups anomalies into incidents per host, in time order.
+// Change points are lasting shifts rather than co-occurring events and are
+// never grouped; they are returned with the anomalies that did not correlate
+// with another metric.
+func GroupIncidents(anomalies []Anomaly, window time.Duration) (incidents []Incident, rest []Anomaly) {
+	ordered := make([]Anomaly, 0, len(anomalies))
+	for _, a := range anomalies {
+		if a.RuleType == RuleTypeChangePoint {
+			rest = append(rest, a)
+			continue
+		}
+		ordered = append(ordered, a)
+	}
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Timestamp.Before(ordered[j].Timestamp) })
+
+	tracker := NewIncidentTracker(window)
+	var all []*Incident
+	seen := map[*Incident]bool{}
+	for _, a := range ordered {
+		inc := tracker.Add(a)
+		inc.Anomalies = append(inc.Anomalies, a)
+		if !seen[inc] {
+			seen[inc] = true
+			all = append(all, inc)
+		}
+	}
+	for _, inc := range all {
+		if inc.Correlated() {
+			incidents = append(incidents, *inc)
+			continue
+		}
+		rest = append(rest, inc.Anomalies...)
+	}
+	return incidents, rest
+}
+
+func incidentID(hostID string, start time.Time) string {
+	if hostID == "" {
+		hostID = "local"
+	}
+	return fmt.Sprintf("%s-%s", hostID, start.UTC().Format("20060102T150405Z"))
+}
+
+// explainIncident names the metrics that moved together and the process most
+// often attributed to them.
+func explainIncident(inc *Incident) string {
+	labels := make([]string, len(inc.Metrics))
+	for i, name := range inc.Metrics {
+		labels[i] = MetricLabel(name)
+	}
+	together := joinLabels(labels)
+	if len(labels) > 1 {
+		together += " moved together"
+	} else {
+		together += " was anomalous"
+	}
+
+	best := inc.LikelyCause
+	if best == nil {
+		return together + "; no process was attributed (enable process attribution to name a likely cause)."
+	}
+	return fmt.Sprintf("%s; likely cause: %s (pid %d), the top process in %d of %d anomalies.",
+		together, best.Name, best.PID, inc.CauseCount(), inc.AnomalyCount())
+}
+
+func joinLabels(labels []string) string {
+	switch len(labels) {
+	case 0:
+		return ""
+	case 1:
+		return labels[0]
+	case 2:
+		return labels[0] + " and " + labels[1]
+	default:
+		return strings.Join(labels[:len(labels)-1], ", ") + " and " + labels[len(labels)-1]
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,282 @@
+package anomaly
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const RuleTypePercentile = "percentile"
+
+// DefaultPercentileWindow is the number of samples a rolling percentile rule
+// keeps when Window is not set.
+const DefaultPercentileWindow = 120
+
+// PercentileRule compares a metric with a percentile of its own recent values.
+//
+// Without Over, the rule fires when a value exceeds the rolling Percentile of
+// the previous Window samples by more than Margin (0.2 = 20%). With Over, it
+// fires when the Percentile of the values seen during the last Over exceeds
+// the absolute Above.
+type PercentileRule struct {
+	Percentile float64
+	Margin     float64
+	Window     int
+	Over       time.Duration
+	Above      float64
+}
+
+func (r PercentileRule) Validate() error {
+	if math.IsNaN(r.Percentile) || r.Percentile <= 0 || r.Percentile > 100 {
+		return errors.New("percentile must be in (0, 100]")
+	}
+	if math.IsNaN(r.Margin) || math.IsInf(r.Margin, 0) || r.Margin < 0 {
+		return errors.New("percentile margin must be greater than or equal to zero")
+	}
+	if r.Window < 0 {
+		return errors.New("percentile window must be greater than or equal to zero")
+	}
+	if r.Over < 0 {
+		return errors.New("percentile duration must be greater than or equal to zero")
+	}
+	if r.Over > 0 {
+		if math.IsNaN(r.Above) || math.IsInf(r.Above, 0) || r.Above <= 0 {
+			return errors.New("percentile rules with a duration need an absolute limit greater than zero")
+		}
+		if r.Margin != 0 {
+			return errors.New("percentile rules take either a margin or a duration and limit, not both")
+		}
+	} else if r.Above != 0 {
+		return errors.New("percentile rules with an absolute limit need a duration")
+	}
+	return nil
+}
+
+// String renders the rule in the syntax accepted by ParsePercentileRule.
+func (r PercentileRule) String() string {
+	p := "p" + strconv.FormatFloat(r.Percentile, 'g', -1, 64)
+	if r.Over > 0 {
+		return fmt.Sprintf("%s@%s>%s", p, r.Over, strconv.FormatFloat(r.Above, 'g', -1, 64))
+	}
+	s := p
+	if r.Margin > 0 {
+		s += "+" + strconv.FormatFloat(r.Margin*100, 'g', -1, 64) + "%"
+	}
+	if r.Window > 0 {
+		s += "/" + strconv.Itoa(r.Window)
+	}
+	return s
+}
+
+// ParsePercentileRule parses "p99", "p99+20%", "p99+20%/300" (rolling
+// percentile of the last 300 samples, exceeded by 20%) or "p95@5m>85"
+// (p95 over the last five minutes above 85).
+func ParsePercentileRule(spec string) (PercentileRule, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	rest, ok := strings.CutPrefix(spec, "p")
+	if !ok {
+		return PercentileRule{}, fmt.Errorf("percentile rule must start with p<N>: %q", spec)
+	}
+	end := strings.IndexAny(rest, "+/@")
+	if end < 0 {
+		end = len(rest)
+	}
+	var rule PercentileRule
+	var err error
+	if rule.Percentile, err = strconv.ParseFloat(rest[:end], 64); err != nil {
+		return PercentileRule{}, fmt.Errorf("invalid percentile in %q", spec)
+	}
+	rest = rest[end:]
+
+	if over, ok := strings.CutPrefix(rest, "@"); ok {
+		rawOver, rawAbove, ok := strings.Cut(over, ">")
+		if !ok {
+			return PercentileRule{}, fmt.Errorf("percentile rule with a duration must be p<N>@<duration>><value>: %q", spec)
+		}
+		if rule.Over, err = time.ParseDuration(rawOver); err != nil {
+			return PercentileRule{}, fmt.Errorf("invalid duration in %q: %w", spec, err)
+		}
+		if rule.Above, err = strconv.ParseFloat(rawAbove, 64); err != nil {
+			return PercentileRule{}, fmt.Errorf("invalid limit in %q", spec)
+		}
+		return rule, rule.Validate()
+	}
+
+	if margin, ok := strings.CutPrefix(rest, "+"); ok {
+		rawMargin, window, _ := strings.Cut(margin, "/")
+		rawMargin = strings.TrimSuffix(rawMargin, "%")
+		pct, err := strconv.ParseFloat(rawMargin, 64)
+		if err != nil {
+			return PercentileRule{}, fmt.Errorf("invalid margin in %q", spec)
+		}
+		rule.Margin = pct / 100
+		rest = ""
+		if window != "" {
+			rest = "/" + window
+		}
+	}
+	if window, ok := strings.CutPrefix(rest, "/"); ok {
+		if rule.Window, err = strconv.Atoi(window); err != nil {
+			return PercentileRule{}, fmt.Errorf("invalid window in %q", spec)
+		}
+		rest = ""
+	}
+	if rest != "" {
+		return PercentileRule{}, fmt.Errorf("unexpected %q in percentile rule %q", rest, spec)
+	}
+	return rule, rule.Validate()
+}
+
+// PercentileEvaluator applies percentile rules to each metric, keeping a
+// sorted rolling window per rule.
+type PercentileEvaluator struct {
+	rules  map[string][]PercentileRule
+	states map[string][]*rollingQuantile
+}
+
+func NewPercentileEvaluator(rules map[string][]PercentileRule) *PercentileEvaluator {
+	return &PercentileEvaluator{rules: rules, states: make(map[string][]*rollingQuantile)}
+}
+
+// Check evaluates every rule for name and returns the most severe anomaly.
+func (e *PercentileEvaluator) Check(name string, ts time.Time, value float64) *Anomaly {
+	if e == nil || len(e.rules[name]) == 0 {
+		return nil
+	}
+	rules := e.rules[name]
+	states, ok := e.states[name]
+	if !ok {
+		states = make([]*rollingQuantile, len(rules))
+		for i, r := range rules {
+			states[i] = newRollingQuantile(r)
+		}
+		e.states[name] = states
+	}
+
+	var worst *Anomaly
+	for i, r := range rules {
+		worst = SelectHigherSeverity(worst, checkPercentileRule(name, ts, value, r, states[i]))
+	}
+	return worst
+}
+
+func checkPercentileRule(name string, ts time.Time, value float64, r PercentileRule, state *rollingQuantile) *Anomaly {
+	if r.Over > 0 {
+		state.add(ts, value)
+		if !state.spans(ts) {
+			return nil
+		}
+		estimate := state.quantile(r.Percentile / 100)
+		if estimate <= r.Above {
+			return nil
+		}
+		ratio := (estimate - r.Above) / r.Above
+		return graded(&Anomaly{
+			Name:        name,
+			Value:       value,
+			RuleType:    RuleTypePercentile,
+			Direction:   DirectionAbove,
+			Threshold:   r.Above,
+			Mean:        estimate,
+			ZScore:      ratio,
+			Explanation: fmt.Sprintf("%s p%s over the last %s is %s, above %s.", MetricLabel(name), formatPercentile(r.Percentile), r.Over, FormatValue(name, estimate), FormatValue(name, r.Above)),
+		})
+	}
+
+	ready := state.full()
+	estimate := state.quantile(r.Percentile / 100)
+	state.add(ts, value)
+	limit := estimate * (1 + r.Margin)
+	if !ready || limit <= 0 || value <= limit {
+		return nil
+	}
+	ratio := (value - limit) / limit
+	explanation := fmt.Sprintf("%s %s is above the rolling p%s (%s).", MetricLabel(name), FormatValue(name, value), formatPercentile(r.Percentile), FormatValue(name, estimate))
+	if r.Margin > 0 {
+		explanation = fmt.Sprintf("%s %s is more than %.0f%% above the rolling p%s (%s).", MetricLabel(name), FormatValue(name, value), r.Margin*100, formatPercentile(r.Percentile), FormatValue(name, estimate))
+	}
+	return graded(&Anomaly{
+		Name:        name,
+		Value:       value,
+		RuleType:    RuleTypePercentile,
+		Direction:   DirectionAbove,
+		Threshold:   limit,
+		Mean:        estimate,
+		ZScore:      ratio,
+		Explanation: explanation,
+	})
+}
+
+func formatPercentile(p float64) string {
+	return strconv.FormatFloat(p, 'g', -1, 64)
+}
+
+// rollingQuantile keeps a window of values both in arrival order (for
+// eviction) and sorted (for O(log n) lookups and O(n) updates).
+type rollingQuantile struct {
+	maxCount int
+	maxAge   time.Duration
+	first    time.Time
+	times    []time.Time
+	values   []float64
+	sorted   []float64
+}
+
+func newRollingQuantile(r PercentileRule) *rollingQuantile {
+	q := &rollingQuantile{maxAge: r.Over}
+	if r.Over == 0 {
+		q.maxCount = r.Window
+		if q.maxCount <= 0 {
+			q.maxCount = DefaultPercentileWindow
+		}
+	}
+	return q
+}
+
+func (q *rollingQuantile) add(ts time.Time, v float64) {
+	if q.first.IsZero() {
+		q.first = ts
+	}
+	q.times = append(q.times, ts)
+	q.values = append(q.values, v)
+	i := sort.SearchFloat64s(q.sorted, v)
+	q.sorted = append(q.sorted, 0)
+	copy(q.sorted[i+1:], q.sorted[i:])
+	q.sorted[i] = v
+
+	for len(q.values) > 0 && q.expired(ts) {
+		q.removeOldest()
+	}
+}
+
+func (q *rollingQuantile) expired(now time.Time) bool {
+	if q.maxCount > 0 && len(q.values) > q.maxCount {
+		return true
+	}
+	return q.maxAge > 0 && now.Sub(q.times[0]) > q.maxAge
+}
+
+func (q *rollingQuantile) removeOldest() {
+	v := q.values[0]
+	q.times = q.times[1:]
+	q.values = q.values[1:]
+	i := sort.SearchFloat64s(q.sorted, v)
+	q.sorted = append(q.sorted[:i], q.sorted[i+1:]...)
+}
+
+func (q *rollingQuantile) full() bool {
+	return q.maxCount > 0 && len(q.values) >= q.maxCount
+}
+
+// spans reports whether the window has seen at least maxAge of history.
+func (q *rollingQuantile) spans(now time.Time) bool {
+	return len(q.values) > 0 && now.Sub(q.first) >= q.maxAge
+}
+
+func (q *rollingQuantile) quantile(p float64) float64 {
+	return quantile(q.sorted, p)
+}
@@ -0,0 +1,231 @@
+package anomaly
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const RuleTypeRateOfChange = "rate_of_change"
+
+// RateRule fires when a gauge changes faster than Change per Per, measured
+// between the current sample and the sample Lookback earlier. Change is in
+// the metric's unit (percentage points for percentages) or, with Percent,
+// relative to the earlier value. Rules watch for growth unless Falling is
+// set.
+type RateRule struct {
+	Change   float64
+	Percent  bool
+	Per      time.Duration
+	Lookback time.Duration
+	Falling  bool
+}
+
+func (r RateRule) Validate() error {
+	if math.IsNaN(r.Change) || math.IsInf(r.Change, 0) || r.Change <= 0 {
+		return errors.New("rate of change must be greater than zero")
+	}
+	if r.Per <= 0 {
+		return errors.New("rate of change time unit must be greater than zero")
+	}
+	if r.Lookback < 0 {
+		return errors.New("rate of change lookback must be greater than or equal to zero")
+	}
+	return nil
+}
+
+func (r RateRule) lookback() time.Duration {
+	if r.Lookback == 0 {
+		return r.Per
+	}
+	return r.Lookback
+}
+
+// String renders the rule in the syntax accepted by ParseRateRule.
+func (r RateRule) String() string {
+	s := strconv.FormatFloat(r.Change, 'g', -1, 64)
+	if r.Falling {
+		s = "-" + s
+	}
+	if r.Percent {
+		s += "%"
+	}
+	s += "/" + r.Per.String()
+	if r.Lookback != 0 {
+		s += "@" + r.Lookback.String()
+	}
+	return s
+}
+
+// ParseRateRule parses "10/1m" (grew by 10 or more per minute, in the
+// metric's unit), "50%/5m" (grew by 50% of its earlier value per 5 minutes),
+// "-5/1m" (fell by 5 per minute) or "2/1m@10m" (grew 2 per minute on average
+// over the last 10 minutes; the lookback defaults to the time unit).
+func ParseRateRule(spec string) (RateRule, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	rest, rawLookback, hasLookback := strings.Cut(spec, "@")
+	rawChange, rawPer, ok := strings.Cut(rest, "/")
+	if !ok {
+		return RateRule{}, fmt.Errorf("rate of change rule must be [-]<change>[%%]/<time unit>[@<lookback>]: %q", spec)
+	}
+	var rule RateRule
+	rawChange = strings.TrimSpace(rawChange)
+	switch {
+	case strings.HasPrefix(rawChange, "-"):
+		rule.Falling, rawChange = true, rawChange[1:]
+	case strings.HasPrefix(rawChange, "+"):
+		rawChange = rawChange[1:]
+	}
+	rawChange, rule.Percent = strings.CutSuffix(rawChange, "%")
+	var err error
+	if rule.Change, err = strconv.ParseFloat(strings.TrimSpace(rawChange), 64); err != nil {
+		return RateRule{}, fmt.Errorf("invalid change in %q", spec)
+	}
+	if rule.Per, err = time.ParseDuration(strings.TrimSpace(rawPer)); err != nil {
+		return RateRule{}, fmt.Errorf("invalid time unit in %q: %w", spec, err)
+	}
+	if hasLookback {
+		if rule.Lookback, err = time.ParseDuration(strings.TrimSpace(rawLookback)); err != nil {
+			return RateRule{}, fmt.Errorf("invalid lookback in %q: %w", spec, err)
+		}
+		if rule.Lookback <= 0 {
+			return RateRule{}, fmt.Errorf("rate of change lookback must be greater than zero in %q", spec)
+		}
+	}
+	return rule, rule.Validate()
+}
+
+// RateOfChange is the change behind a rate-of-change anomaly.
+type RateOfChange struct {
+	// Since is the earlier sample the change is measured from.
+	Since time.Time `json:"since"`
+	// Change is the signed change since then, in the metric's unit or, with
+	// Percent, as a percentage of the earlier value.
+	Change         float64 `json:"change"`
+	Percent        bool    `json:"percent,omitempty"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	// Rate is the change per PerSeconds, signed like Change.
+	Rate       float64 `json:"rate"`
+	PerSeconds float64 `json:"per_seconds"`
+}
+
+type ratePoint struct {
+	ts    time.Time
+	value float64
+}
+
+// RateEvaluator applies rate-of-change rules to each metric.
+type RateEvaluator struct {
+	rules   map[string]RateRule
+	history map[string][]ratePoint
+}
+
+func NewRateEvaluator(rules map[string]RateRule) *RateEvaluator {
+	return &RateEvaluator{rules: rules, history: make(map[string][]ratePoint)}
+}
+
+// Check records value and returns an anomaly if the metric changed faster
+// than its rule allows since the sample one lookback earlier. Nothing fires
+// until the history covers the lookback.
+func (r *RateEvaluator) Check(name string, ts time.Time, value float64) *Anomaly {
+	if r == nil {
+		return nil
+	}
+	rule, ok := r.rules[name]
+	if !ok {
+		return nil
+	}
+	lookback := rule.lookback()
+	// Keep the newest point at least one lookback old as the anchor.
+	points := append(r.history[name], ratePoint{ts: ts, value: value})
+	cutoff := ts.Add(-lookback)
+	drop := 0
+	for drop+1 < len(points) && !points[drop+1].ts.After(cutoff) {
+		drop++
+	}
+	points = points[drop:]
+	r.history[name] = points
+
+	anchor := points[0]
+	elapsed := ts.Sub(anchor.ts)
+	if elapsed < lookback || elapsed <= 0 {
+		return nil
+	}
+	change := value - anchor.value
+	if rule.Percent {
+		if anchor.value == 0 {
+			return nil
+		}
+		change = change / math.Abs(anchor.value) * 100
+	}
+	rate := change * rule.Per.Seconds() / elapsed.Seconds()
+	magnitude := rate
+	direction := DirectionAbove
+	if rule.Falling {
+		magnitude, direction = -rate, DirectionBelow
+	}
+	if magnitude < rule.Change {
+		return nil
+	}
+	return graded(&Anomaly{
+		Name:      name,
+		Value:     value,
+		RuleType:  RuleTypeRateOfChange,
+		Direction: direction,
+		Threshold: rule.Change,
+		Mean:      anchor.value,
+		ZScore:    magnitude/rule.Change - 1,
+		RateOfChange: &RateOfChange{
+			Since:          anchor.ts,
+			Change:         change,
+			Percent:        rule.Percent,
+			ElapsedSeconds: elapsed.Seconds(),
+			Rate:           rate,
+			PerSeconds:     rule.Per.Seconds(),
+		},
+		Explanation: explainRateOfChange(name, value, anchor.value, change, elapsed, rule),
+		Hint:        Hint(name),
+	})
+}
+
+// explainRateOfChange reads like "Memory usage grew 12.3pp in 60s (40.0% to
+// 52.3%), faster than 10pp per 60s."
+func explainRateOfChange(name string, value, from, change float64, elapsed time.Duration, rule RateRule) string {
+	verb := "grew"
+	if change < 0 {
+		verb = "fell"
+	}
+	sentence := fmt.Sprintf("%s %s %s in %s (%s to %s), faster than %s per %s.",
+		MetricLabel(name), verb, FormatChange(name, math.Abs(change), rule.Percent), FormatSpan(elapsed),
+		FormatValue(name, from), FormatValue(name, value),
+		FormatChange(name, rule.Change, rule.Percent), FormatSpan(rule.Per))
+	if hint := Hint(name); hint != "" {
+		sentence += " " + hint
+	}
+	return sentence
+}
+
+// FormatChange formats a change of metric name: relative changes as a
+// percentage, percentages in percentage points, anything else in its unit.
+func FormatChange(name string, v float64, relative bool) string {
+	switch {
+	case relative:
+		return fmt.Sprintf("%.1f%%", v)
+	case strings.HasSuffix(BaseMetric(name), "_percent"):
+		return fmt.Sprintf("%.1fpp", v)
+	default:
+		return FormatValue(name, v)
+	}
+}
+
+// FormatSpan renders d in seconds below two minutes ("60s") and like
+// FormatETA above.
+func FormatSpan(d time.Duration) string {
+	if d < 2*time.Minute {
+		return fmt.Sprintf("%ds", int64(d.Round(time.Second)/time.Second))
+	}
+	return FormatETA(d)
+}
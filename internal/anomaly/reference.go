@@ -0,0 +1,63 @@
+package anomaly
+
+import "math"
+
+// Reference is an imported baseline for one metric, typically exported from a
+// known-good machine with `epagent baseline export`.
+type Reference struct {
+	Mean   float64
+	Stddev float64
+	// Quantiles are evenly spaced from the minimum (first) to the maximum
+	// (last) of the reference distribution.
+	Quantiles []float64
+}
+
+// ReferenceSeeder is implemented by algorithms that can start from a Reference
+// instead of an empty history. Seeded values are replaced by live ones as the
+// metric is observed.
+type ReferenceSeeder interface {
+	SeedReference(Reference)
+}
+
+// resample returns n values spread across the reference distribution, so a
+// rolling window filled with them has roughly the reference's centre and
+// spread.
+func (r Reference) resample(n int) []float64 {
+	out := make([]float64, n)
+	q := r.Quantiles
+	for i := range out {
+		if len(q) == 0 {
+			out[i] = r.Mean
+			continue
+		}
+		pos := (float64(i) + 0.5) / float64(n) * float64(len(q)-1)
+		lo := int(math.Floor(pos))
+		hi := int(math.Ceil(pos))
+		out[i] = q[lo] + (q[hi]-q[lo])*(pos-float64(lo))
+	}
+	return out
+}
+
+func (w *window) seed(r Reference) {
+	w.values = r.resample(w.size)
+}
+
+func (z *zscore) SeedReference(r Reference)     { z.window.seed(r) }
+func (m *mad) SeedReference(r Reference)        { m.window.seed(r) }
+func (p *percentile) SeedReference(r Reference) { p.window.seed(r) }
+func (s *seasonal) SeedReference(r Reference)   { s.rolling.SeedReference(r) }
+
+func (e *ewma) SeedReference(r Reference) {
+	e.count = e.minCount
+	e.learned = e.halfLife
+	e.mean = r.Mean
+	e.variance = r.Stddev * r.Stddev
+}
+
+func (h *holt) SeedReference(r Reference) {
+	h.count = h.minCount
+	h.learned = h.halfLife
+	h.level = r.Mean
+	h.trend = 0
+	h.variance = r.Stddev * r.Stddev
+}
@@ -0,0 +1,100 @@
+package anomaly
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	hoursPerDay  = 24
+	hoursPerWeek = 7 * hoursPerDay
+)
+
+// SeasonalStats is a running mean and variance (Welford) for one time bucket.
+type SeasonalStats struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	// M2 is the sum of squared deviations from Mean.
+	M2 float64 `json:"m2"`
+}
+
+func (s *SeasonalStats) Add(v float64) {
+	s.Count++
+	delta := v - s.Mean
+	s.Mean += delta / float64(s.Count)
+	s.M2 += delta * (v - s.Mean)
+}
+
+// Stddev is the population standard deviation of the values added so far.
+func (s SeasonalStats) Stddev() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return math.Sqrt(s.M2 / float64(s.Count))
+}
+
+// SeasonalProfile is the baseline of one metric per UTC hour of day and per
+// UTC hour of week (Sunday 00:00 is bucket 0).
+type SeasonalProfile struct {
+	Hourly [hoursPerDay]SeasonalStats  `json:"hourly"`
+	Weekly [hoursPerWeek]SeasonalStats `json:"weekly"`
+}
+
+func (p *SeasonalProfile) Add(ts time.Time, v float64) {
+	hour, week := seasonalBuckets(ts)
+	p.Hourly[hour].Add(v)
+	p.Weekly[week].Add(v)
+}
+
+func seasonalBuckets(ts time.Time) (hour, week int) {
+	ts = ts.UTC()
+	return ts.Hour(), int(ts.Weekday())*hoursPerDay + ts.Hour()
+}
+
+// SeasonalSeeder is implemented by algorithms that can start from a trained
+// SeasonalProfile instead of an empty history.
+type SeasonalSeeder interface {
+	SeedSeasonal(SeasonalProfile)
+}
+
+// seasonal compares a value with the baseline of its time bucket: hour of
+// week when weekly is set and that bucket has enough history, then hour of
+// day, then a rolling z-score over recent samples while the buckets are still
+// learning.
+type seasonal struct {
+	threshold float64
+	minCount  int
+	weekly    bool
+	profile   SeasonalProfile
+	rolling   *zscore
+}
+
+func newSeasonal(p Params, weekly bool) *seasonal {
+	return &seasonal{threshold: p.Threshold, minCount: p.WindowSize, weekly: weekly, rolling: newZScore(p)}
+}
+
+func (s *seasonal) SeedSeasonal(p SeasonalProfile) { s.profile = p }
+
+func (s *seasonal) Score(ts time.Time, value float64) Result {
+	hour, week := seasonalBuckets(ts)
+	buckets := []SeasonalStats{s.profile.Hourly[hour]}
+	if s.weekly {
+		buckets = []SeasonalStats{s.profile.Weekly[week], s.profile.Hourly[hour]}
+	}
+	for _, b := range buckets {
+		if b.Count < s.minCount {
+			continue
+		}
+		stddev := b.Stddev()
+		if stddev <= 0 {
+			return Result{Expected: b.Mean}
+		}
+		return symmetricResult(value, b.Mean, stddev, s.threshold)
+	}
+	return s.rolling.Score(ts, value)
+}
+
+func (s *seasonal) Learn(ts time.Time, value float64) {
+	s.profile.Add(ts, value)
+	s.rolling.Learn(ts, value)
+}
@@ -0,0 +1,127 @@
+package anomaly
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/severity"
+)
+
+// DefaultCutPoints are where medium, high and critical start for each graded
+// rule type. Baseline detectors and change points are graded by the absolute
+// z-score; static thresholds, percentile rules and forecasts by how far the
+// value (or the forecast urgency) exceeds the limit, as a fraction of it.
+var DefaultCutPoints = map[string][]float64{
+	RuleTypeZScore:          {3, 4, 6},
+	RuleTypeChangePoint:     {3, 4, 6},
+	RuleTypeStaticThreshold: {0.2, 0.5, 1},
+	RuleTypePercentile:      {0.2, 0.5, 1},
+	RuleTypeForecast:        {0.2, 0.5, 1},
+}
+
+// inheritedCutPoints are rule types graded with another rule type's
+// cut-points unless they are given their own. Rate-of-change rules are graded
+// by how far the rate exceeds its limit, like a static threshold, and custom
+// levels set up before they existed keep validating.
+var inheritedCutPoints = map[string]string{
+	RuleTypeRateOfChange: RuleTypeStaticThreshold,
+}
+
+// SeverityRules grade anomalies. Every anomaly carries its magnitude in
+// ZScore; the cut-points of its metric and rule type turn that into a level
+// of Scale and a score.
+type SeverityRules struct {
+	Scale severity.Scale
+	// CutPoints override DefaultCutPoints per rule type.
+	CutPoints map[string][]float64
+	// MetricCutPoints override CutPoints per metric, then rule type.
+	MetricCutPoints map[string]map[string][]float64
+}
+
+// GradedRuleTypes returns the rule types graded by cut-points, sorted.
+func GradedRuleTypes() []string {
+	out := make([]string, 0, len(DefaultCutPoints)+len(inheritedCutPoints))
+	for ruleType := range DefaultCutPoints {
+		out = append(out, ruleType)
+	}
+	for ruleType := range inheritedCutPoints {
+		out = append(out, ruleType)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func isGraded(ruleType string) bool {
+	_, ok := DefaultCutPoints[ruleType]
+	_, inherited := inheritedCutPoints[ruleType]
+	return ok || inherited
+}
+
+// Cuts returns the cut-points for metric and ruleType.
+func (r SeverityRules) Cuts(metric, ruleType string) []float64 {
+	if cuts, ok := r.MetricCutPoints[metric][ruleType]; ok {
+		return cuts
+	}
+	if cuts, ok := r.MetricCutPoints[BaseMetric(metric)][ruleType]; ok {
+		return cuts
+	}
+	if cuts, ok := r.CutPoints[ruleType]; ok {
+		return cuts
+	}
+	if parent, ok := inheritedCutPoints[ruleType]; ok {
+		return r.Cuts(metric, parent)
+	}
+	return DefaultCutPoints[ruleType]
+}
+
+// Validate checks that every rule type has one cut-point per level above the
+// lowest, so custom levels need cut-points for every rule type.
+func (r SeverityRules) Validate() error {
+	for ruleType := range r.CutPoints {
+		if !isGraded(ruleType) {
+			return fmt.Errorf("unknown severity rule type: %s (expected %s)", ruleType, joinRuleTypes())
+		}
+	}
+	for metric, byType := range r.MetricCutPoints {
+		for ruleType, cuts := range byType {
+			if !isGraded(ruleType) {
+				return fmt.Errorf("unknown severity rule type for %s: %s (expected %s)", metric, ruleType, joinRuleTypes())
+			}
+			if err := r.Scale.ValidateCuts(cuts); err != nil {
+				return fmt.Errorf("severity cut-points for %s %s: %w", metric, ruleType, err)
+			}
+		}
+	}
+	for _, ruleType := range GradedRuleTypes() {
+		if err := r.Scale.ValidateCuts(r.Cuts("", ruleType)); err != nil {
+			return fmt.Errorf("severity cut-points for %s: %w", ruleType, err)
+		}
+	}
+	return nil
+}
+
+// Grade sets a's severity and score from its magnitude. Rule types without
+// cut-points, such as expressions, keep their severity and get the score in
+// the middle of its band.
+func (r SeverityRules) Grade(a *Anomaly) {
+	if a == nil {
+		return
+	}
+	if !isGraded(a.RuleType) {
+		a.Score = r.Scale.Score(a.Severity)
+		return
+	}
+	a.Severity, a.Score = r.Scale.Grade(a.ZScore, r.Cuts(a.Name, a.RuleType))
+}
+
+// graded grades a with the default rules, for detectors and rule checks used
+// on their own; the Evaluator grades again with the configured rules.
+func graded(a *Anomaly) *Anomaly {
+	SeverityRules{}.Grade(a)
+	return a
+}
+
+func joinRuleTypes() string {
+	return strings.Join(GradedRuleTypes(), "|")
+}
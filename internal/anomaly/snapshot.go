@@ -0,0 +1,211 @@
+package anomaly
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Snapshotter is implemented by algorithms whose learned baseline can be saved
+// and restored, so a restarted watch does not start blind.
+type Snapshotter interface {
+	Snapshot() (json.RawMessage, error)
+	Restore(json.RawMessage) error
+}
+
+// AlgorithmState is the saved baseline of one metric.
+type AlgorithmState struct {
+	Algorithm string          `json:"algorithm"`
+	State     json.RawMessage `json:"state"`
+}
+
+// EvaluatorState is the saved baseline of every metric an Evaluator has seen.
+// Rule state (percentile windows, forecast trends, sustain history) is not
+// included and is relearned.
+type EvaluatorState struct {
+	Detector map[string]AlgorithmState `json:"detector"`
+	// Warmup is each metric's warm-up progress, so a restart does not warm
+	// up again.
+	Warmup map[string]WarmupState `json:"warmup,omitempty"`
+}
+
+// Snapshot saves the baseline of every metric whose algorithm supports it.
+func (d *Detector) Snapshot() (map[string]AlgorithmState, error) {
+	out := make(map[string]AlgorithmState, len(d.models))
+	for name, m := range d.models {
+		s, ok := m.(Snapshotter)
+		if !ok {
+			continue
+		}
+		raw, err := s.Snapshot()
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", name, err)
+		}
+		out[name] = AlgorithmState{Algorithm: d.algorithms.For(name), State: raw}
+	}
+	return out, nil
+}
+
+// Restore loads saved baselines. Metrics saved under a different algorithm
+// than the one now configured are skipped and learn from scratch. Baselines
+// are restored into fresh models that replace the current ones only when
+// every metric restored, so an error leaves the detector untouched.
+func (d *Detector) Restore(states map[string]AlgorithmState) error {
+	restored := make(map[string]Algorithm, len(states))
+	for name, st := range states {
+		if st.Algorithm != d.algorithms.For(name) {
+			continue
+		}
+		m := d.newModel(name)
+		s, ok := m.(Snapshotter)
+		if !ok {
+			continue
+		}
+		if err := s.Restore(st.State); err != nil {
+			return fmt.Errorf("restore %s: %w", name, err)
+		}
+		restored[name] = m
+	}
+	for name, m := range restored {
+		d.models[name] = m
+	}
+	return nil
+}
+
+// Snapshot saves the detector baselines and warm-up progress of every metric.
+func (e *Evaluator) Snapshot() (EvaluatorState, error) {
+	detector, err := e.detector.Snapshot()
+	if err != nil {
+		return EvaluatorState{}, err
+	}
+	state := EvaluatorState{Detector: detector}
+	for name, w := range e.warmup {
+		if state.Warmup == nil {
+			state.Warmup = make(map[string]WarmupState, len(e.warmup))
+		}
+		state.Warmup[name] = *w
+	}
+	return state, nil
+}
+
+// Restore loads a state saved by Snapshot. Warm-up progress is only loaded
+// once the detector baselines restored, so a failed restore leaves the
+// evaluator untouched.
+func (e *Evaluator) Restore(s EvaluatorState) error {
+	if err := e.detector.Restore(s.Detector); err != nil {
+		return err
+	}
+	for name, w := range s.Warmup {
+		w := w
+		e.warmup[name] = &w
+	}
+	return nil
+}
+
+type windowState struct {
+	Values []float64 `json:"values"`
+}
+
+func (w *window) snapshot() (json.RawMessage, error) {
+	return json.Marshal(windowState{Values: w.values})
+}
+
+func (w *window) restore(raw json.RawMessage) error {
+	var s windowState
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return err
+	}
+	w.values = nil
+	for _, v := range s.Values {
+		w.add(v)
+	}
+	return nil
+}
+
+func (z *zscore) Snapshot() (json.RawMessage, error)     { return z.window.snapshot() }
+func (z *zscore) Restore(raw json.RawMessage) error      { return z.window.restore(raw) }
+func (m *mad) Snapshot() (json.RawMessage, error)        { return m.window.snapshot() }
+func (m *mad) Restore(raw json.RawMessage) error         { return m.window.restore(raw) }
+func (p *percentile) Snapshot() (json.RawMessage, error) { return p.window.snapshot() }
+func (p *percentile) Restore(raw json.RawMessage) error  { return p.window.restore(raw) }
+
+type ewmaState struct {
+	Count    int     `json:"count"`
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	// Last and LearnedSeconds are only set with a half-life.
+	Last           time.Time `json:"last,omitempty"`
+	LearnedSeconds float64   `json:"learned_seconds,omitempty"`
+}
+
+func (e *ewma) Snapshot() (json.RawMessage, error) {
+	return json.Marshal(ewmaState{
+		Count:          e.count,
+		Mean:           e.mean,
+		Variance:       e.variance,
+		Last:           e.last,
+		LearnedSeconds: e.learned.Seconds(),
+	})
+}
+
+func (e *ewma) Restore(raw json.RawMessage) error {
+	var s ewmaState
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return err
+	}
+	e.count, e.mean, e.variance = s.Count, s.Mean, s.Variance
+	e.last, e.learned = s.Last, time.Duration(s.LearnedSeconds*float64(time.Second))
+	return nil
+}
+
+type holtState struct {
+	Count          int       `json:"count"`
+	Level          float64   `json:"level"`
+	Trend          float64   `json:"trend"`
+	Variance       float64   `json:"variance"`
+	Last           time.Time `json:"last,omitempty"`
+	LearnedSeconds float64   `json:"learned_seconds,omitempty"`
+}
+
+func (h *holt) Snapshot() (json.RawMessage, error) {
+	return json.Marshal(holtState{
+		Count:          h.count,
+		Level:          h.level,
+		Trend:          h.trend,
+		Variance:       h.variance,
+		Last:           h.last,
+		LearnedSeconds: h.learned.Seconds(),
+	})
+}
+
+func (h *holt) Restore(raw json.RawMessage) error {
+	var s holtState
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return err
+	}
+	h.count, h.level, h.trend, h.variance = s.Count, s.Level, s.Trend, s.Variance
+	h.last, h.learned = s.Last, time.Duration(s.LearnedSeconds*float64(time.Second))
+	return nil
+}
+
+type seasonalState struct {
+	Profile SeasonalProfile `json:"profile"`
+	Rolling json.RawMessage `json:"rolling"`
+}
+
+func (s *seasonal) Snapshot() (json.RawMessage, error) {
+	rolling, err := s.rolling.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(seasonalState{Profile: s.profile, Rolling: rolling})
+}
+
+func (s *seasonal) Restore(raw json.RawMessage) error {
+	var st seasonalState
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return err
+	}
+	s.profile = st.Profile
+	return s.rolling.Restore(st.Rolling)
+}
@@ -0,0 +1,141 @@
+package anomaly
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sustain qualifies a rule so it only fires once its condition has held:
+// either Count of the last Of samples breached, or every sample breached for
+// at least For.
+type Sustain struct {
+	Count int
+	Of    int
+	For   time.Duration
+}
+
+func (s Sustain) Validate() error {
+	switch {
+	case s.For < 0:
+		return errors.New("sustain duration must be greater than or equal to zero")
+	case s.For > 0 && (s.Count != 0 || s.Of != 0):
+		return errors.New("sustain takes either N/M samples or a duration, not both")
+	case s.For == 0 && (s.Count <= 0 || s.Of < s.Count):
+		return errors.New("sustain must be a duration or N/M with 0 < N <= M")
+	}
+	return nil
+}
+
+func (s Sustain) String() string {
+	if s.For > 0 {
+		return s.For.String()
+	}
+	return fmt.Sprintf("%d/%d", s.Count, s.Of)
+}
+
+// ParseSustain parses "2m" (breaching for at least two minutes) or "3/5"
+// (three of the last five samples breaching).
+func ParseSustain(spec string) (Sustain, error) {
+	spec = strings.TrimSpace(spec)
+	var s Sustain
+	if rawCount, rawOf, ok := strings.Cut(spec, "/"); ok {
+		var err error
+		if s.Count, err = strconv.Atoi(strings.TrimSpace(rawCount)); err != nil {
+			return Sustain{}, fmt.Errorf("invalid sustain count in %q", spec)
+		}
+		if s.Of, err = strconv.Atoi(strings.TrimSpace(rawOf)); err != nil {
+			return Sustain{}, fmt.Errorf("invalid sustain window in %q", spec)
+		}
+	} else {
+		d, err := time.ParseDuration(spec)
+		if err != nil {
+			return Sustain{}, fmt.Errorf("sustain must be a duration (2m) or N/M samples (3/5): %q", spec)
+		}
+		s.For = d
+	}
+	return s, s.Validate()
+}
+
+// SustainKey builds the key for a Sustain that only applies to one rule type
+// of a metric. A bare metric name applies to every rule type.
+func SustainKey(metric, ruleType string) string {
+	if ruleType == "" {
+		return metric
+	}
+	return metric + ":" + ruleType
+}
+
+// Condition describes how long a sustained rule's condition has held when it
+// fired.
+type Condition struct {
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Breaches        int       `json:"breaches"`
+	Samples         int       `json:"samples"`
+}
+
+// ConditionText is the built-in English sentence appended to the explanation
+// of a sustained anomaly. explain replaces it with the "condition" template.
+func ConditionText(c *Condition) string {
+	return fmt.Sprintf("Condition held since %s (%s, %d of %d samples).",
+		c.Start.Format(time.RFC3339),
+		time.Duration(c.DurationSeconds*float64(time.Second)),
+		c.Breaches, c.Samples)
+}
+
+// sustainState tracks the recent breach history of one metric and rule type.
+type sustainState struct {
+	rule     Sustain
+	breaches []bool
+	times    []time.Time
+	// runStart is the first sample of the current unbroken breach run.
+	runStart time.Time
+	runCount int
+}
+
+// observe records whether the sample at ts breached and reports whether the
+// rule's condition now holds.
+func (s *sustainState) observe(ts time.Time, breached bool) (*Condition, bool) {
+	if s.rule.For > 0 {
+		if !breached {
+			s.runStart, s.runCount = time.Time{}, 0
+			return nil, false
+		}
+		if s.runCount == 0 {
+			s.runStart = ts
+		}
+		s.runCount++
+		held := ts.Sub(s.runStart)
+		if held < s.rule.For {
+			return nil, false
+		}
+		return &Condition{Start: s.runStart, DurationSeconds: held.Seconds(), Breaches: s.runCount, Samples: s.runCount}, true
+	}
+
+	s.breaches = append(s.breaches, breached)
+	s.times = append(s.times, ts)
+	if len(s.breaches) > s.rule.Of {
+		s.breaches = s.breaches[1:]
+		s.times = s.times[1:]
+	}
+	if !breached {
+		return nil, false
+	}
+	count := 0
+	var start time.Time
+	for i, b := range s.breaches {
+		if b {
+			if count == 0 {
+				start = s.times[i]
+			}
+			count++
+		}
+	}
+	if count < s.rule.Count {
+		return nil, false
+	}
+	return &Condition{Start: start, DurationSeconds: ts.Sub(start).Seconds(), Breaches: count, Samples: len(s.breaches)}, true
+}
@@ -0,0 +1,78 @@
+package anomaly
+
+import (
+	"fmt"
+	"time"
+)
+
+// Warmup holds back anomalies from the rules that learn a metric's history
+// (the baseline detector, percentile, forecast, change point and rate of
+// change rules) until the metric has been observed for Samples samples and
+// for Duration, and its values have varied. A window that has only just
+// filled, or has only seen a flat line, scores small changes as large
+// deviations. A metric that stays flat is held back for at most twice
+// Samples and Duration, so the first change of a constant series still
+// fires. Static thresholds and expressions compare against fixed limits and
+// are never held back. The zero value disables warm-up.
+type Warmup struct {
+	Samples  int
+	Duration time.Duration
+}
+
+// Enabled reports whether w holds anything back.
+func (w Warmup) Enabled() bool { return w.Samples > 0 || w.Duration > 0 }
+
+// Validate rejects negative settings.
+func (w Warmup) Validate() error {
+	if w.Samples < 0 {
+		return fmt.Errorf("warm-up samples must be greater than or equal to zero")
+	}
+	if w.Duration < 0 {
+		return fmt.Errorf("warm-up duration must be greater than or equal to zero")
+	}
+	return nil
+}
+
+// warmupLearned reports whether ruleType learns from the metric's history
+// and is therefore held back during warm-up.
+func warmupLearned(ruleType string) bool {
+	switch ruleType {
+	case RuleTypeZScore, RuleTypePercentile, RuleTypeForecast, RuleTypeChangePoint, RuleTypeRateOfChange:
+		return true
+	}
+	return false
+}
+
+// WarmupState is the warm-up progress of one metric.
+type WarmupState struct {
+	Samples int       `json:"samples"`
+	First   time.Time `json:"first,omitempty"`
+	Value   float64   `json:"value"`
+	Varied  bool      `json:"varied,omitempty"`
+}
+
+func (s *WarmupState) observe(ts time.Time, value float64) {
+	if s.Samples == 0 {
+		s.First, s.Value = ts, value
+	} else if value != s.Value {
+		s.Varied = true
+	}
+	s.Samples++
+}
+
+// warming reports whether a value observed at ts is still in the warm-up of
+// a metric with progress s, which covers the samples before it. A zero ts
+// (samples without timestamps) does not count against Duration.
+func (w Warmup) warming(s *WarmupState, ts time.Time) bool {
+	if !w.Enabled() {
+		return false
+	}
+	samples, duration := max(w.Samples, 1), w.Duration
+	if !s.Varied {
+		samples, duration = 2*samples, 2*duration
+	}
+	if s.Samples < samples {
+		return true
+	}
+	return duration > 0 && !ts.IsZero() && !s.First.IsZero() && ts.Sub(s.First) < duration
+}
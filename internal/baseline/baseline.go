@@ -0,0 +1,177 @@
+package baseline
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/fsutil"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/rollup"
+)
+
+// SchemaVersion is the version of Baseline written by this build.
+const SchemaVersion = 1
+
+// quantileSteps is the number of intervals between the exported quantiles
+// (every 5th percentile, minimum and maximum included).
+const quantileSteps = 20
+
+// Baseline is a portable reference for what normal looks like, written by
+// `epagent baseline export` and loaded by watch, analyze and report with
+// --baseline. Hosts lists the machines it was computed from; it applies to
+// any host.
+type Baseline struct {
+	SchemaVersion int                `json:"schema_version"`
+	CreatedAt     time.Time          `json:"created_at"`
+	Hosts         []string           `json:"hosts"`
+	From          time.Time          `json:"from"`
+	To            time.Time          `json:"to"`
+	Samples       int                `json:"samples"`
+	Metrics       map[string]*Metric `json:"metrics"`
+}
+
+// Metric is the reference distribution of one metric.
+type Metric struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+	// Quantiles holds every 5th percentile from p0 to p100.
+	Quantiles []float64                `json:"quantiles"`
+	Seasonal  *anomaly.SeasonalProfile `json:"seasonal,omitempty"`
+}
+
+// Compute builds a baseline from raw samples, deriving rates per host exactly
+// as analysis does and pooling the result across hosts.
+func Compute(samples []collector.MetricSample) Baseline {
+	b := Baseline{SchemaVersion: SchemaVersion, CreatedAt: time.Now().UTC(), Hosts: []string{}, Metrics: map[string]*Metric{}}
+	if len(samples) == 0 {
+		return b
+	}
+	ordered := append([]collector.MetricSample(nil), samples...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Timestamp.Before(ordered[j].Timestamp) })
+	b.From = ordered[0].Timestamp
+	b.To = ordered[len(ordered)-1].Timestamp
+	b.Samples = len(ordered)
+
+	prevByHost := map[string]*collector.MetricSample{}
+	values := map[string][]float64{}
+	profiles := map[string]*anomaly.SeasonalProfile{}
+	for i := range ordered {
+		current := ordered[i]
+		prev, seen := prevByHost[current.HostID]
+		if !seen {
+			b.Hosts = append(b.Hosts, current.HostID)
+		}
+		prevByHost[current.HostID] = &ordered[i]
+		for name, value := range collector.DeriveMetrics(prev, current) {
+			values[name] = append(values[name], value)
+			p, ok := profiles[name]
+			if !ok {
+				p = &anomaly.SeasonalProfile{}
+				profiles[name] = p
+			}
+			p.Add(current.Timestamp, value)
+		}
+	}
+	sort.Strings(b.Hosts)
+	for name, v := range values {
+		m := describe(v)
+		m.Seasonal = profiles[name]
+		b.Metrics[name] = m
+	}
+	return b
+}
+
+func describe(values []float64) *Metric {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	mean := sum / float64(len(sorted))
+	var variance float64
+	for _, v := range sorted {
+		variance += (v - mean) * (v - mean)
+	}
+	m := &Metric{
+		Count:     len(sorted),
+		Mean:      mean,
+		Stddev:    math.Sqrt(variance / float64(len(sorted))),
+		Min:       sorted[0],
+		Max:       sorted[len(sorted)-1],
+		P50:       rollup.Percentile(sorted, 50),
+		P90:       rollup.Percentile(sorted, 90),
+		P95:       rollup.Percentile(sorted, 95),
+		P99:       rollup.Percentile(sorted, 99),
+		Quantiles: make([]float64, quantileSteps+1),
+	}
+	for i := range m.Quantiles {
+		m.Quantiles[i] = rollup.Percentile(sorted, float64(i)*100/quantileSteps)
+	}
+	return m
+}
+
+// Apply returns algorithms seeded from the baseline: every metric starts from
+// the reference distribution, and seasonal detectors from its hourly and
+// weekly buckets unless algorithms already carries a profile for the metric
+// (a seasonal model trained on the host itself is more specific).
+func (b *Baseline) Apply(algorithms anomaly.AlgorithmConfig) anomaly.AlgorithmConfig {
+	if b == nil || len(b.Metrics) == 0 {
+		return algorithms
+	}
+	references := make(map[string]*anomaly.Reference, len(b.Metrics))
+	profiles := make(map[string]*anomaly.SeasonalProfile, len(b.Metrics))
+	for name, p := range algorithms.Profiles {
+		profiles[name] = p
+	}
+	for name, m := range b.Metrics {
+		references[name] = &anomaly.Reference{Mean: m.Mean, Stddev: m.Stddev, Quantiles: m.Quantiles}
+		if _, ok := profiles[name]; !ok && m.Seasonal != nil {
+			profiles[name] = m.Seasonal
+		}
+	}
+	algorithms.References = references
+	algorithms.Profiles = profiles
+	return algorithms
+}
+
+// Load reads a baseline written by Save.
+func Load(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("baseline %s: %w", path, err)
+	}
+	if b.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("baseline %s has schema_version %d; this build reads up to %d", path, b.SchemaVersion, SchemaVersion)
+	}
+	return &b, nil
+}
+
+// Save writes the baseline atomically (see fsutil.WriteFileAtomic).
+func Save(path string, b Baseline) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(path, append(data, '\n'), 0o644)
+}
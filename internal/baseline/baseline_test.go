@@ -0,0 +1,73 @@
+package baseline
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+)
+
+func goldenSamples() []collector.MetricSample {
+	base := time.Date(2026, 2, 2, 1, 0, 0, 0, time.UTC)
+	families := &collector.MetricFamilies{CPU: true}
+	var samples []collector.MetricSample
+	for i := 0; i < 40; i++ {
+		host := "golden-a"
+		if i%2 == 1 {
+			host = "golden-b"
+		}
+		samples = append(samples, collector.MetricSample{
+			Timestamp:      base.Add(time.Duration(i) * time.Second),
+			HostID:         host,
+			CPUPercent:     float64(10 + i%5),
+			MetricFamilies: families,
+		})
+	}
+	return samples
+}
+
+func TestComputePoolsHosts(t *testing.T) {
+	b := Compute(goldenSamples())
+	if b.Samples != 40 || len(b.Hosts) != 2 || b.Hosts[0] != "golden-a" {
+		t.Fatalf("unexpected baseline: samples=%d hosts=%v", b.Samples, b.Hosts)
+	}
+	cpu := b.Metrics["cpu_percent"]
+	if cpu == nil || cpu.Count != 40 || cpu.Mean != 12 || cpu.Min != 10 || cpu.Max != 14 {
+		t.Fatalf("unexpected cpu stats: %+v", cpu)
+	}
+	if len(cpu.Quantiles) != quantileSteps+1 || cpu.Quantiles[0] != 10 || cpu.Quantiles[quantileSteps] != 14 {
+		t.Fatalf("unexpected quantiles: %v", cpu.Quantiles)
+	}
+	if cpu.Seasonal == nil || cpu.Seasonal.Hourly[1].Count != 40 {
+		t.Fatalf("expected seasonal buckets, got %+v", cpu.Seasonal)
+	}
+}
+
+func TestApplySeedsDetectorsFromFirstSample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golden", "baseline.json")
+	if err := Save(path, Compute(goldenSamples())); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	b, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, name := range anomaly.RegisteredAlgorithms() {
+		algorithms := b.Apply(anomaly.AlgorithmConfig{Default: name})
+		detector := anomaly.NewDetectorWithAlgorithms(5, 3, algorithms)
+		ts := time.Date(2026, 3, 1, 1, 0, 0, 0, time.UTC)
+		if a := detector.CheckAt("cpu_percent", ts, 12); a != nil {
+			t.Fatalf("%s: typical value flagged: %+v", name, a)
+		}
+		if a := detector.CheckAt("cpu_percent", ts.Add(time.Second), 90); a == nil {
+			t.Fatalf("%s: expected a spike on a fresh host to be flagged", name)
+		}
+	}
+
+	var nilBaseline *Baseline
+	if got := nilBaseline.Apply(anomaly.AlgorithmConfig{Default: "mad"}); got.References != nil || got.Default != "mad" {
+		t.Fatalf("nil baseline must leave algorithms unchanged, got %+v", got)
+	}
+}
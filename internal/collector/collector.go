@@ -20,20 +20,38 @@ type ProcessAttribution struct {
 	RSSBytes   uint64  `json:"rss_bytes"`
 }
 
+// SchemaVersion is the version of MetricSample written by this build. Bump it
+// whenever a field is added with a new meaning or an existing field changes
+// meaning, and teach storage how to upgrade older records.
+const SchemaVersion = 1
+
 type MetricSample struct {
-	Timestamp       time.Time           `json:"timestamp"`
-	HostID          string              `json:"host_id"`
-	Labels          map[string]string   `json:"labels,omitempty"`
-	CPUPercent      float64             `json:"cpu_percent"`
-	MemUsedPercent  float64             `json:"mem_used_percent"`
-	DiskUsedPercent float64             `json:"disk_used_percent"`
-	DiskReadBytes   uint64              `json:"disk_read_bytes"`
-	DiskWriteBytes  uint64              `json:"disk_write_bytes"`
-	NetRxBytes      uint64              `json:"net_rx_bytes"`
-	NetTxBytes      uint64              `json:"net_tx_bytes"`
-	TopCPUProcess   *ProcessAttribution `json:"top_cpu_process,omitempty"`
-	TopMemProcess   *ProcessAttribution `json:"top_mem_process,omitempty"`
-	MetricFamilies  *MetricFamilies     `json:"metric_families,omitempty"`
+	SchemaVersion   int               `json:"schema_version"`
+	Timestamp       time.Time         `json:"timestamp"`
+	HostID          string            `json:"host_id"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	CPUPercent      float64           `json:"cpu_percent"`
+	MemUsedPercent  float64           `json:"mem_used_percent"`
+	DiskUsedPercent float64           `json:"disk_used_percent"`
+	// DiskFreeBytes is nil in samples written before it was recorded.
+	DiskFreeBytes  *uint64             `json:"disk_free_bytes,omitempty"`
+	DiskReadBytes  uint64              `json:"disk_read_bytes"`
+	DiskWriteBytes uint64              `json:"disk_write_bytes"`
+	NetRxBytes     uint64              `json:"net_rx_bytes"`
+	NetTxBytes     uint64              `json:"net_tx_bytes"`
+	TopCPUProcess  *ProcessAttribution `json:"top_cpu_process,omitempty"`
+	TopMemProcess  *ProcessAttribution `json:"top_mem_process,omitempty"`
+	MetricFamilies *MetricFamilies     `json:"metric_families,omitempty"`
+	// Mounts is the usage of the mount points configured in disk_mounts;
+	// the root filesystem stays in DiskUsedPercent and DiskFreeBytes.
+	Mounts []MountUsage `json:"mounts,omitempty"`
+}
+
+// MountUsage is the filesystem usage of one mount point.
+type MountUsage struct {
+	Mount       string  `json:"mount"`
+	UsedPercent float64 `json:"used_percent"`
+	FreeBytes   uint64  `json:"free_bytes"`
 }
 
 type MetricFamilies struct {
@@ -52,12 +70,19 @@ type Sampler struct {
 	labels             map[string]string
 	processAttribution bool
 	metrics            MetricFamilies
+	mounts             []string
 }
 
 func NewSampler(hostID string, labels map[string]string, processAttribution bool, metrics MetricFamilies) *Sampler {
 	return &Sampler{hostID: hostID, labels: cloneLabels(labels), processAttribution: processAttribution, metrics: metrics}
 }
 
+// SetMounts sets the mount points sampled besides the root filesystem when
+// the disk family is enabled.
+func (s *Sampler) SetMounts(mounts []string) {
+	s.mounts = append([]string(nil), mounts...)
+}
+
 func (s *Sampler) Sample(ctx context.Context) (MetricSample, error) {
 	cpuPercent := 0.0
 	if s.metrics.CPU {
@@ -80,6 +105,8 @@ func (s *Sampler) Sample(ctx context.Context) (MetricSample, error) {
 	}
 
 	diskUsedPercent := 0.0
+	var diskFreeBytes *uint64
+	var mounts []MountUsage
 	var readBytes uint64
 	var writeBytes uint64
 	if s.metrics.Disk {
@@ -92,6 +119,17 @@ func (s *Sampler) Sample(ctx context.Context) (MetricSample, error) {
 			return MetricSample{}, err
 		}
 		diskUsedPercent = usage.UsedPercent
+		free := usage.Free
+		diskFreeBytes = &free
+		for _, mount := range s.mounts {
+			// A mount that is missing (an unplugged volume) is left out of
+			// this sample rather than failing it.
+			usage, err := disk.UsageWithContext(ctx, mount)
+			if err != nil {
+				continue
+			}
+			mounts = append(mounts, MountUsage{Mount: mount, UsedPercent: usage.UsedPercent, FreeBytes: usage.Free})
+		}
 
 		ioCounters, err := disk.IOCountersWithContext(ctx)
 		if err != nil {
@@ -123,12 +161,14 @@ func (s *Sampler) Sample(ctx context.Context) (MetricSample, error) {
 	}
 
 	return MetricSample{
+		SchemaVersion:   SchemaVersion,
 		Timestamp:       time.Now().UTC(),
 		HostID:          s.hostID,
 		Labels:          cloneLabels(s.labels),
 		CPUPercent:      cpuPercent,
 		MemUsedPercent:  memUsedPercent,
 		DiskUsedPercent: diskUsedPercent,
+		DiskFreeBytes:   diskFreeBytes,
 		DiskReadBytes:   readBytes,
 		DiskWriteBytes:  writeBytes,
 		NetRxBytes:      rxBytes,
@@ -136,6 +176,7 @@ func (s *Sampler) Sample(ctx context.Context) (MetricSample, error) {
 		TopCPUProcess:   topCPUProcess,
 		TopMemProcess:   topMemProcess,
 		MetricFamilies:  &MetricFamilies{CPU: s.metrics.CPU, Mem: s.metrics.Mem, Disk: s.metrics.Disk, Net: s.metrics.Net},
+		Mounts:          mounts,
 	}, nil
 }
 
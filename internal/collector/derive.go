@@ -0,0 +1,67 @@
+package collector
+
+// Families returns the metric families recorded on the sample, treating a
+// missing value as every family enabled.
+func (s MetricSample) Families() MetricFamilies {
+	if s.MetricFamilies == nil {
+		return DefaultMetricFamilies()
+	}
+	return *s.MetricFamilies
+}
+
+// MountMetric names metric for one mount point, such as
+// "disk_used_percent{mount=/var}"; anomaly.BaseMetric strips the mount again.
+func MountMetric(metric, mount string) string {
+	return metric + "{mount=" + mount + "}"
+}
+
+// DeriveMetrics returns the analyzable metrics for current. Gauge metrics come
+// straight from the sample; byte-counter rates need the previous sample from
+// the same host and are omitted when prev is nil.
+func DeriveMetrics(prev *MetricSample, current MetricSample) map[string]float64 {
+	families := current.Families()
+	metrics := map[string]float64{}
+	if families.CPU {
+		metrics["cpu_percent"] = current.CPUPercent
+	}
+	if families.Mem {
+		metrics["mem_used_percent"] = current.MemUsedPercent
+	}
+	if families.Disk {
+		metrics["disk_used_percent"] = current.DiskUsedPercent
+		if current.DiskFreeBytes != nil {
+			metrics["disk_free_bytes"] = float64(*current.DiskFreeBytes)
+		}
+		for _, m := range current.Mounts {
+			metrics[MountMetric("disk_used_percent", m.Mount)] = m.UsedPercent
+			metrics[MountMetric("disk_free_bytes", m.Mount)] = float64(m.FreeBytes)
+		}
+	}
+	if prev == nil {
+		return metrics
+	}
+
+	prevFamilies := prev.Families()
+	dt := current.Timestamp.Sub(prev.Timestamp).Seconds()
+	if dt <= 0 {
+		dt = 1
+	}
+	if families.Disk && prevFamilies.Disk {
+		metrics["disk_read_bytes_per_sec"] = float64(counterDelta(current.DiskReadBytes, prev.DiskReadBytes)) / dt
+		metrics["disk_write_bytes_per_sec"] = float64(counterDelta(current.DiskWriteBytes, prev.DiskWriteBytes)) / dt
+	}
+	if families.Net && prevFamilies.Net {
+		metrics["net_rx_bytes_per_sec"] = float64(counterDelta(current.NetRxBytes, prev.NetRxBytes)) / dt
+		metrics["net_tx_bytes_per_sec"] = float64(counterDelta(current.NetTxBytes, prev.NetTxBytes)) / dt
+	}
+	return metrics
+}
+
+// counterDelta treats a counter going backwards (reboot, interface reset) as no
+// traffic rather than a huge wrapped value.
+func counterDelta(current, previous uint64) uint64 {
+	if current < previous {
+		return 0
+	}
+	return current - previous
+}
@@ -9,6 +9,12 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/explain"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/severity"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/silence"
 )
 
 type Duration struct {
@@ -33,29 +39,120 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 }
 
 type Config struct {
-	Interval           time.Duration      `json:"-"`
-	Duration           time.Duration      `json:"-"`
-	WindowSize         int                `json:"window_size"`
-	ZScoreThreshold    float64            `json:"zscore_threshold"`
-	StaticThresholds   map[string]float64 `json:"-"`
-	OutputPath         string             `json:"output_path"`
-	HostID             string             `json:"host_id"`
-	Labels             map[string]string  `json:"-"`
-	ProcessAttribution bool               `json:"process_attribution"`
-	Metrics            MetricFamilies     `json:"-"`
+	Interval              time.Duration                       `json:"-"`
+	Duration              time.Duration                       `json:"-"`
+	WindowSize            int                                 `json:"window_size"`
+	ZScoreThreshold       float64                             `json:"zscore_threshold"`
+	StaticThresholds      map[string]float64                  `json:"-"`
+	StaticLowerThresholds map[string]float64                  `json:"-"`
+	Directions            map[string]anomaly.Direction        `json:"-"`
+	Contamination         map[string]anomaly.Contamination    `json:"-"`
+	PercentileRules       map[string][]anomaly.PercentileRule `json:"-"`
+	ForecastRules         map[string]anomaly.ForecastRule     `json:"-"`
+	RateRules             map[string]anomaly.RateRule         `json:"-"`
+	ChangePoints          map[string]anomaly.ChangePointRule  `json:"-"`
+	Sustain               map[string]anomaly.Sustain          `json:"-"`
+	Expressions           []anomaly.ExpressionRule            `json:"-"`
+	Severity              anomaly.SeverityRules               `json:"-"`
+	IncidentWindow        time.Duration                       `json:"-"`
+	Warmup                anomaly.Warmup                      `json:"-"`
+	MetricParams          map[string]anomaly.Params           `json:"-"`
+	MinSeverities         map[string]string                   `json:"-"`
+	Cooldowns             map[string]time.Duration            `json:"-"`
+	Detector              string                              `json:"detector"`
+	Detectors             map[string]string                   `json:"-"`
+	SeasonalModel         string                              `json:"seasonal_model"`
+	Baseline              string                              `json:"baseline"`
+	Locale                string                              `json:"-"`
+	ExplanationTemplates  []string                            `json:"-"`
+	Silences              silence.List                        `json:"-"`
+	SilencesFile          string                              `json:"-"`
+	OutputPath            string                              `json:"output_path"`
+	HostID                string                              `json:"host_id"`
+	Labels                map[string]string                   `json:"-"`
+	ProcessAttribution    bool                                `json:"process_attribution"`
+	Metrics               MetricFamilies                      `json:"-"`
+	DiskMounts            []string                            `json:"disk_mounts"`
 }
 
 type fileConfig struct {
-	Interval           Duration           `json:"interval"`
-	Duration           Duration           `json:"duration"`
-	WindowSize         int                `json:"window_size"`
-	ZScoreThreshold    float64            `json:"zscore_threshold"`
-	StaticThresholds   map[string]float64 `json:"static_thresholds"`
-	OutputPath         string             `json:"output_path"`
-	HostID             string             `json:"host_id"`
-	Labels             map[string]string  `json:"labels"`
-	ProcessAttribution *bool              `json:"process_attribution"`
-	EnabledMetrics     *[]string          `json:"enabled_metrics"`
+	Interval              Duration              `json:"interval"`
+	Duration              Duration              `json:"duration"`
+	WindowSize            int                   `json:"window_size"`
+	ZScoreThreshold       float64               `json:"zscore_threshold"`
+	StaticThresholds      map[string]float64    `json:"static_thresholds"`
+	StaticLowerThresholds map[string]float64    `json:"static_lower_thresholds"`
+	Directions            map[string]string     `json:"directions"`
+	Contamination         map[string]string     `json:"contamination"`
+	PercentileRules       map[string][]string   `json:"percentile_rules"`
+	Forecasts             map[string]string     `json:"forecasts"`
+	RateOfChange          map[string]string     `json:"rate_of_change"`
+	ChangePoints          map[string]string     `json:"change_points"`
+	Sustain               map[string]string     `json:"sustain"`
+	Expressions           []ExpressionConfig    `json:"expressions"`
+	Severity              *SeverityConfig       `json:"severity"`
+	IncidentWindow        Duration              `json:"incident_window"`
+	Warmup                *WarmupConfig         `json:"warmup"`
+	Rules                 map[string]MetricRule `json:"rules"`
+	Detector              string                `json:"detector"`
+	Detectors             map[string]string     `json:"detectors"`
+	SeasonalModel         string                `json:"seasonal_model"`
+	Baseline              string                `json:"baseline"`
+	Explanations          *ExplanationsConfig   `json:"explanations"`
+	Silences              silence.List          `json:"silences"`
+	SilencesFile          string                `json:"silences_file"`
+	OutputPath            string                `json:"output_path"`
+	HostID                string                `json:"host_id"`
+	Labels                map[string]string     `json:"labels"`
+	ProcessAttribution    *bool                 `json:"process_attribution"`
+	EnabledMetrics        *[]string             `json:"enabled_metrics"`
+	DiskMounts            []string              `json:"disk_mounts"`
+}
+
+// MetricRule is one entry of the `rules` config block: detection settings for
+// a metric (or every metric of a family) that override the global ones.
+// Unset fields keep the global setting; cut_points override the severity
+// cut-points per rule type.
+type MetricRule struct {
+	WindowSize           int                  `json:"window_size"`
+	ZScoreThreshold      float64              `json:"zscore_threshold"`
+	Detector             string               `json:"detector"`
+	MinSeverity          string               `json:"min_severity"`
+	Cooldown             *Duration            `json:"cooldown"`
+	StaticThreshold      float64              `json:"static_threshold"`
+	StaticLowerThreshold float64              `json:"static_lower_threshold"`
+	CutPoints            map[string][]float64 `json:"cut_points"`
+}
+
+// SeverityConfig is the `severity` config block: the severity levels, least
+// severe first, and the cut-points per rule type where each level above the
+// lowest starts.
+type SeverityConfig struct {
+	Levels    []string             `json:"levels"`
+	CutPoints map[string][]float64 `json:"cut_points"`
+}
+
+// WarmupConfig is the `warmup` config block: how many samples and how long a
+// metric is observed before anomalies from learned rules are emitted.
+type WarmupConfig struct {
+	Samples  int      `json:"samples"`
+	Duration Duration `json:"duration"`
+}
+
+// ExplanationsConfig is the `explanations` config block: the built-in locale
+// of explanations and hints, and text/template files whose definitions
+// override them per metric and rule type (see package explain).
+type ExplanationsConfig struct {
+	Locale    string   `json:"locale"`
+	Templates []string `json:"templates"`
+}
+
+// ExpressionConfig is one entry of the `expressions` config block: a named
+// rule over several metrics (see anomaly.ParseExpressionRule).
+type ExpressionConfig struct {
+	Name     string `json:"name"`
+	Expr     string `json:"expr"`
+	Severity string `json:"severity"`
 }
 
 type MetricFamilies struct {
@@ -71,7 +168,10 @@ func Default() Config {
 		Duration:           0,
 		WindowSize:         30,
 		ZScoreThreshold:    3.0,
+		Detector:           anomaly.AlgorithmZScore,
+		IncidentWindow:     anomaly.DefaultIncidentWindow,
 		OutputPath:         filepath.Join("data", "metrics.jsonl"),
+		SilencesFile:       filepath.Join("data", "silences.json"),
 		HostID:             "",
 		Labels:             nil,
 		ProcessAttribution: true,
@@ -106,6 +206,12 @@ func Load(path string) (Config, error) {
 	if fc.WindowSize != 0 {
 		cfg.WindowSize = fc.WindowSize
 	}
+	if fc.IncidentWindow.Duration < 0 {
+		return cfg, errors.New("incident_window must be greater than or equal to zero")
+	}
+	if fc.IncidentWindow.Duration != 0 {
+		cfg.IncidentWindow = fc.IncidentWindow.Duration
+	}
 	if fc.ZScoreThreshold != 0 {
 		cfg.ZScoreThreshold = fc.ZScoreThreshold
 	}
@@ -116,6 +222,121 @@ func Load(path string) (Config, error) {
 		}
 		cfg.StaticThresholds = thresholds
 	}
+	if fc.StaticLowerThresholds != nil {
+		thresholds, err := ParseStaticThresholds(fc.StaticLowerThresholds)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.StaticLowerThresholds = thresholds
+	}
+	if fc.Directions != nil {
+		directions, err := ParseDirections(fc.Directions)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Directions = directions
+	}
+	if fc.Contamination != nil {
+		contamination, err := ParseContamination(fc.Contamination)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Contamination = contamination
+	}
+	if fc.PercentileRules != nil {
+		rules, err := ParsePercentileRules(fc.PercentileRules)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.PercentileRules = rules
+	}
+	if fc.Forecasts != nil {
+		rules, err := ParseForecastRules(fc.Forecasts)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.ForecastRules = rules
+	}
+	if fc.RateOfChange != nil {
+		rules, err := ParseRateRules(fc.RateOfChange)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.RateRules = rules
+	}
+	if fc.ChangePoints != nil {
+		rules, err := ParseChangePoints(fc.ChangePoints)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.ChangePoints = rules
+	}
+	if fc.Sustain != nil {
+		sustain, err := ParseSustain(fc.Sustain)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Sustain = sustain
+	}
+	if fc.Detector != "" {
+		if err := anomaly.ValidateAlgorithm(fc.Detector); err != nil {
+			return cfg, err
+		}
+		cfg.Detector = strings.ToLower(strings.TrimSpace(fc.Detector))
+	}
+	if fc.Detectors != nil {
+		detectors, err := ParseDetectors(fc.Detectors)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Detectors = detectors
+	}
+	if fc.Severity != nil {
+		scale, err := severity.NewScale(fc.Severity.Levels)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Severity = anomaly.SeverityRules{Scale: scale, CutPoints: normalizeCutPoints(fc.Severity.CutPoints)}
+	}
+	if fc.Expressions != nil {
+		expressions, err := ParseExpressions(fc.Expressions)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Expressions = expressions
+	}
+	if err := cfg.applyMetricRules(fc.Rules); err != nil {
+		return cfg, err
+	}
+	if fc.Warmup != nil {
+		cfg.Warmup = anomaly.Warmup{Samples: fc.Warmup.Samples, Duration: fc.Warmup.Duration.Duration}
+	}
+	if err := cfg.Rules().Validate(); err != nil {
+		return cfg, err
+	}
+	if fc.SeasonalModel != "" {
+		cfg.SeasonalModel = fc.SeasonalModel
+	}
+	if fc.Baseline != "" {
+		cfg.Baseline = fc.Baseline
+	}
+	if fc.Silences != nil {
+		if err := fc.Silences.Compile(); err != nil {
+			return cfg, err
+		}
+		cfg.Silences = fc.Silences
+	}
+	if fc.SilencesFile != "" {
+		cfg.SilencesFile = fc.SilencesFile
+	}
+	if fc.Explanations != nil {
+		locale, err := explain.ParseLocale(fc.Explanations.Locale)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Locale = locale
+		cfg.ExplanationTemplates = fc.Explanations.Templates
+	}
 	if fc.OutputPath != "" {
 		cfg.OutputPath = fc.OutputPath
 	}
@@ -135,9 +356,39 @@ func Load(path string) (Config, error) {
 		}
 		cfg.Metrics = m
 	}
+	if fc.DiskMounts != nil {
+		mounts, err := ParseDiskMounts(fc.DiskMounts)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.DiskMounts = mounts
+	}
 	return cfg, nil
 }
 
+// ParseDiskMounts validates the mount points sampled besides the root
+// filesystem. Each becomes disk_used_percent{mount=...} and
+// disk_free_bytes{mount=...}.
+func ParseDiskMounts(in []string) ([]string, error) {
+	out := make([]string, 0, len(in))
+	seen := make(map[string]bool, len(in))
+	for _, raw := range in {
+		mount := strings.TrimSpace(raw)
+		if mount == "" {
+			return nil, errors.New("disk_mounts entries must not be empty")
+		}
+		if strings.ContainsAny(mount, "{}") {
+			return nil, fmt.Errorf("invalid disk mount: %s", mount)
+		}
+		if seen[mount] {
+			return nil, fmt.Errorf("duplicate disk mount: %s", mount)
+		}
+		seen[mount] = true
+		out = append(out, mount)
+	}
+	return out, nil
+}
+
 func ParseMetricFamilies(enabled []string) (MetricFamilies, error) {
 	if enabled == nil {
 		// Field not provided: use defaults.
@@ -209,12 +460,396 @@ func ParseStaticThresholds(in map[string]float64) (map[string]float64, error) {
 	return out, nil
 }
 
+// ParseDirections parses one-sided detection settings (up, down or both) keyed
+// by metric family or name.
+func ParseDirections(in map[string]string) (map[string]anomaly.Direction, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]anomaly.Direction)
+	for rawName, spec := range in {
+		names, ok := expandMetricKey(rawName)
+		if !ok {
+			return nil, fmt.Errorf("unknown direction metric: %s (expected a metric family cpu|mem|disk|net or a metric name)", rawName)
+		}
+		direction, err := anomaly.ParseDirection(spec)
+		if err != nil {
+			return nil, fmt.Errorf("direction for %s: %w", rawName, err)
+		}
+		for _, name := range names {
+			out[name] = direction
+		}
+	}
+	return out, nil
+}
+
+// ParseContamination parses per-metric contamination policies (learn, skip,
+// clamp or downweight) keyed by metric family or name.
+func ParseContamination(in map[string]string) (map[string]anomaly.Contamination, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]anomaly.Contamination)
+	for rawName, spec := range in {
+		names, ok := expandMetricKey(rawName)
+		if !ok {
+			return nil, fmt.Errorf("unknown contamination metric: %s (expected a metric family cpu|mem|disk|net or a metric name)", rawName)
+		}
+		policy, err := anomaly.ParseContamination(spec)
+		if err != nil {
+			return nil, fmt.Errorf("contamination for %s: %w", rawName, err)
+		}
+		for _, name := range names {
+			out[name] = policy
+		}
+	}
+	return out, nil
+}
+
+// ParseChangePoints parses change-point specs (see
+// anomaly.ParseChangePointRule) keyed by metric name or by metric family.
+func ParseChangePoints(in map[string]string) (map[string]anomaly.ChangePointRule, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]anomaly.ChangePointRule)
+	for rawName, spec := range in {
+		names, ok := expandMetricKey(rawName)
+		if !ok {
+			return nil, fmt.Errorf("unknown change-point metric: %s (expected a metric family cpu|mem|disk|net or a metric name)", rawName)
+		}
+		rule, err := anomaly.ParseChangePointRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("change point for %s: %w", rawName, err)
+		}
+		for _, name := range names {
+			out[name] = rule
+		}
+	}
+	return out, nil
+}
+
+// ParsePercentileRules parses rule specs (see anomaly.ParsePercentileRule)
+// keyed by metric name or by metric family; a family key applies the rules to
+// every metric in the family.
+func ParsePercentileRules(in map[string][]string) (map[string][]anomaly.PercentileRule, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(map[string][]anomaly.PercentileRule)
+	for rawName, specs := range in {
+		names, ok := expandMetricKey(rawName)
+		if !ok {
+			return nil, fmt.Errorf("unknown percentile rule metric: %s (expected a metric family cpu|mem|disk|net or a metric name)", rawName)
+		}
+		for _, spec := range specs {
+			rule, err := anomaly.ParsePercentileRule(spec)
+			if err != nil {
+				return nil, fmt.Errorf("percentile rule for %s: %w", rawName, err)
+			}
+			for _, name := range names {
+				out[name] = append(out[name], rule)
+			}
+		}
+	}
+	return out, nil
+}
+
+// ParseForecastRules parses forecast specs (see anomaly.ParseForecastRule)
+// keyed by disk or mem, or by the metric names disk_used_percent and
+// mem_used_percent; forecasts need a gauge that fills up.
+func ParseForecastRules(in map[string]string) (map[string]anomaly.ForecastRule, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]anomaly.ForecastRule, len(in))
+	for rawName, spec := range in {
+		name, ok := normalizeForecastMetricName(rawName)
+		if !ok {
+			return nil, fmt.Errorf("unknown forecast metric: %s (expected disk|mem|disk_used_percent|mem_used_percent, or disk_used_percent{mount=<path>} for one mount)", rawName)
+		}
+		rule, err := anomaly.ParseForecastRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("forecast for %s: %w", rawName, err)
+		}
+		out[name] = rule
+	}
+	return out, nil
+}
+
+// normalizeForecastMetricName resolves a forecast key. A rule for
+// disk_used_percent also forecasts every mount in disk_mounts;
+// "disk_used_percent{mount=/var}" (or "disk{mount=/var}") overrides it for
+// one mount.
+func normalizeForecastMetricName(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	base, mount := anomaly.BaseMetric(s), anomaly.MetricMount(s)
+	name, ok := normalizeStaticThresholdMetricName(base)
+	if !ok || (name != "disk_used_percent" && name != "mem_used_percent") {
+		return "", false
+	}
+	if base == s {
+		return name, true
+	}
+	if name != "disk_used_percent" || strings.TrimSpace(mount) == "" {
+		return "", false
+	}
+	return collector.MountMetric(name, strings.TrimSpace(mount)), true
+}
+
+// rateMetrics are the gauges rate-of-change rules apply to; throughput
+// metrics are already rates of their counters.
+var rateMetrics = map[string]bool{
+	"cpu_percent":       true,
+	"mem_used_percent":  true,
+	"disk_used_percent": true,
+	"disk_free_bytes":   true,
+}
+
+// ParseRateRules parses rate-of-change specs (see anomaly.ParseRateRule)
+// keyed by a gauge: cpu, mem, disk, disk_free or their metric names.
+func ParseRateRules(in map[string]string) (map[string]anomaly.RateRule, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]anomaly.RateRule, len(in))
+	for rawName, spec := range in {
+		name, ok := normalizeStaticThresholdMetricName(rawName)
+		if !ok || !rateMetrics[name] {
+			return nil, fmt.Errorf("unknown rate of change metric: %s (expected a gauge cpu|mem|disk|disk_free or its metric name)", rawName)
+		}
+		rule, err := anomaly.ParseRateRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("rate of change for %s: %w", rawName, err)
+		}
+		out[name] = rule
+	}
+	return out, nil
+}
+
+// ParseSustain parses sustain qualifiers (see anomaly.ParseSustain) keyed by
+// metric family or name, optionally suffixed with ":<rule_type>" to qualify a
+// single rule type (zscore, static_threshold, percentile, forecast or
+// rate_of_change).
+func ParseSustain(in map[string]string) (map[string]anomaly.Sustain, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]anomaly.Sustain)
+	for rawKey, spec := range in {
+		rawName, ruleType, _ := strings.Cut(rawKey, ":")
+		ruleType = strings.ToLower(strings.TrimSpace(ruleType))
+		switch ruleType {
+		case "", anomaly.RuleTypeZScore, anomaly.RuleTypeStaticThreshold, anomaly.RuleTypePercentile, anomaly.RuleTypeForecast, anomaly.RuleTypeRateOfChange:
+		default:
+			return nil, fmt.Errorf("unknown sustain rule type: %s (expected zscore|static_threshold|percentile|forecast|rate_of_change)", ruleType)
+		}
+		names, ok := expandMetricKey(rawName)
+		if !ok {
+			return nil, fmt.Errorf("unknown sustain metric: %s (expected a metric family cpu|mem|disk|net or a metric name)", rawName)
+		}
+		sustain, err := anomaly.ParseSustain(spec)
+		if err != nil {
+			return nil, fmt.Errorf("sustain for %s: %w", rawKey, err)
+		}
+		for _, name := range names {
+			out[anomaly.SustainKey(name, ruleType)] = sustain
+		}
+	}
+	return out, nil
+}
+
+// expandMetricKey resolves a metric family (cpu, mem, disk, net) to its
+// derived metrics, or a single metric name or alias to itself.
+func expandMetricKey(key string) ([]string, bool) {
+	switch normalizeMetricName(key) {
+	case "cpu":
+		return []string{"cpu_percent"}, true
+	case "mem":
+		return []string{"mem_used_percent"}, true
+	case "disk":
+		return []string{"disk_used_percent", "disk_free_bytes", "disk_read_bytes_per_sec", "disk_write_bytes_per_sec"}, true
+	case "net":
+		return []string{"net_rx_bytes_per_sec", "net_tx_bytes_per_sec"}, true
+	}
+	name, ok := normalizeStaticThresholdMetricName(key)
+	if !ok {
+		return nil, false
+	}
+	return []string{name}, true
+}
+
+// ParseDetectors validates a metric -> detector algorithm map, normalizing
+// metric aliases the same way as static thresholds.
+func ParseDetectors(in map[string]string) (map[string]string, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string, len(in))
+	for rawName, algorithm := range in {
+		name, ok := normalizeStaticThresholdMetricName(rawName)
+		if !ok {
+			return nil, fmt.Errorf("unknown detector metric: %s", rawName)
+		}
+		if err := anomaly.ValidateAlgorithm(algorithm); err != nil {
+			return nil, fmt.Errorf("detector for %s: %w", name, err)
+		}
+		out[name] = strings.ToLower(strings.TrimSpace(algorithm))
+	}
+	return out, nil
+}
+
+// ParseExpressions parses expression rules. Names must be unique and must not
+// shadow a metric, since anomalies, cooldowns and minimum severities are keyed
+// by them.
+func ParseExpressions(in []ExpressionConfig) ([]anomaly.ExpressionRule, error) {
+	out := make([]anomaly.ExpressionRule, 0, len(in))
+	seen := make(map[string]bool, len(in))
+	for _, e := range in {
+		rule, err := anomaly.ParseExpressionRule(e.Name, e.Severity, e.Expr, normalizeStaticThresholdMetricName)
+		if err != nil {
+			return nil, err
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("duplicate expression name: %s", rule.Name)
+		}
+		if _, ok := expandMetricKey(rule.Name); ok {
+			return nil, fmt.Errorf("expression name %s shadows a metric", rule.Name)
+		}
+		seen[rule.Name] = true
+		out = append(out, rule)
+	}
+	return out, nil
+}
+
+// applyMetricRules folds the `rules` block into the per-metric settings. A
+// rule wins over the same metric's entry in static_thresholds,
+// static_lower_thresholds and detectors.
+func (c *Config) applyMetricRules(rules map[string]MetricRule) error {
+	for rawName, rule := range rules {
+		names, ok := expandMetricKey(rawName)
+		if !ok {
+			return fmt.Errorf("unknown rules metric: %s (expected a metric family cpu|mem|disk|net or a metric name)", rawName)
+		}
+		if rule.WindowSize < 0 {
+			return fmt.Errorf("rules.%s.window_size must be greater than or equal to zero", rawName)
+		}
+		if rule.ZScoreThreshold < 0 {
+			return fmt.Errorf("rules.%s.zscore_threshold must be greater than or equal to zero", rawName)
+		}
+		if rule.Detector != "" {
+			if err := anomaly.ValidateAlgorithm(rule.Detector); err != nil {
+				return fmt.Errorf("rules.%s.detector: %w", rawName, err)
+			}
+		}
+		minSeverity := strings.TrimSpace(rule.MinSeverity)
+		if minSeverity != "" {
+			level, err := c.Severity.Scale.Parse(minSeverity)
+			if err != nil {
+				return fmt.Errorf("rules.%s.min_severity: %w", rawName, err)
+			}
+			minSeverity = level
+		}
+		if rule.Cooldown != nil && rule.Cooldown.Duration < 0 {
+			return fmt.Errorf("rules.%s.cooldown must be greater than or equal to zero", rawName)
+		}
+		for _, name := range names {
+			if rule.WindowSize > 0 || rule.ZScoreThreshold > 0 {
+				c.MetricParams = setRule(c.MetricParams, name, anomaly.Params{WindowSize: rule.WindowSize, Threshold: rule.ZScoreThreshold})
+			}
+			if rule.Detector != "" {
+				c.Detectors = setRule(c.Detectors, name, strings.ToLower(strings.TrimSpace(rule.Detector)))
+			}
+			if minSeverity != "" {
+				c.MinSeverities = setRule(c.MinSeverities, name, minSeverity)
+			}
+			if rule.Cooldown != nil {
+				c.Cooldowns = setRule(c.Cooldowns, name, rule.Cooldown.Duration)
+			}
+			if len(rule.CutPoints) > 0 {
+				c.Severity.MetricCutPoints = setRule(c.Severity.MetricCutPoints, name, normalizeCutPoints(rule.CutPoints))
+			}
+		}
+		if rule.StaticThreshold == 0 && rule.StaticLowerThreshold == 0 {
+			continue
+		}
+		// A threshold is a limit in one metric's unit, so a family key
+		// resolves to a single metric the same way static_thresholds does
+		// ("disk" is disk_used_percent) rather than to every metric in it.
+		name, ok := normalizeStaticThresholdMetricName(rawName)
+		if !ok {
+			return fmt.Errorf("rules.%s: static thresholds need a metric name, not the %s family (e.g. %s)", rawName, rawName, names[0])
+		}
+		if rule.StaticThreshold != 0 {
+			if _, err := ParseStaticThresholds(map[string]float64{name: rule.StaticThreshold}); err != nil {
+				return fmt.Errorf("rules.%s.static_threshold: %w", rawName, err)
+			}
+			c.StaticThresholds = setRule(c.StaticThresholds, name, rule.StaticThreshold)
+		}
+		if rule.StaticLowerThreshold != 0 {
+			if _, err := ParseStaticThresholds(map[string]float64{name: rule.StaticLowerThreshold}); err != nil {
+				return fmt.Errorf("rules.%s.static_lower_threshold: %w", rawName, err)
+			}
+			c.StaticLowerThresholds = setRule(c.StaticLowerThresholds, name, rule.StaticLowerThreshold)
+		}
+	}
+	return nil
+}
+
+// normalizeCutPoints lowercases the rule types of cut-points; unknown types
+// are rejected by anomaly.SeverityRules.Validate.
+func normalizeCutPoints(in map[string][]float64) map[string][]float64 {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string][]float64, len(in))
+	for ruleType, cuts := range in {
+		out[strings.ToLower(strings.TrimSpace(ruleType))] = append([]float64(nil), cuts...)
+	}
+	return out
+}
+
+func setRule[V any](m map[string]V, name string, v V) map[string]V {
+	if m == nil {
+		m = make(map[string]V)
+	}
+	m[name] = v
+	return m
+}
+
+// Rules returns the rule set for anomaly.NewEvaluator.
+func (c Config) Rules() anomaly.Rules {
+	return anomaly.Rules{
+		StaticThresholds:      c.StaticThresholds,
+		StaticLowerThresholds: c.StaticLowerThresholds,
+		PercentileRules:       c.PercentileRules,
+		ForecastRules:         c.ForecastRules,
+		RateRules:             c.RateRules,
+		ChangePoints:          c.ChangePoints,
+		Directions:            c.Directions,
+		Sustain:               c.Sustain,
+		Expressions:           c.Expressions,
+		Severity:              c.Severity,
+		Warmup:                c.Warmup,
+	}
+}
+
+// Algorithms returns the detector selection for anomaly.Detector.
+func (c Config) Algorithms() anomaly.AlgorithmConfig {
+	return anomaly.AlgorithmConfig{
+		Default:       c.Detector,
+		PerMetric:     c.Detectors,
+		Contamination: c.Contamination,
+		MetricParams:  c.MetricParams,
+	}
+}
+
 type StaticThresholdMetricError struct {
 	Name string
 }
 
 func (e *StaticThresholdMetricError) Error() string {
-	return "unknown static threshold metric: " + e.Name + " (expected cpu_percent|mem_used_percent|disk_used_percent|disk_read_bytes_per_sec|disk_write_bytes_per_sec|net_rx_bytes_per_sec|net_tx_bytes_per_sec)"
+	return "unknown static threshold metric: " + e.Name + " (expected cpu_percent|mem_used_percent|disk_used_percent|disk_free_bytes|disk_read_bytes_per_sec|disk_write_bytes_per_sec|net_rx_bytes_per_sec|net_tx_bytes_per_sec)"
 }
 
 func normalizeStaticThresholdMetricName(s string) (string, bool) {
@@ -226,6 +861,8 @@ func normalizeStaticThresholdMetricName(s string) (string, bool) {
 		return "mem_used_percent", true
 	case "disk", "disk_used", "disk_used_percent":
 		return "disk_used_percent", true
+	case "disk_free", "disk_free_bytes":
+		return "disk_free_bytes", true
 	case "disk_read", "disk_read_bps", "disk_read_bytes_per_sec":
 		return "disk_read_bytes_per_sec", true
 	case "disk_write", "disk_write_bps", "disk_write_bytes_per_sec":
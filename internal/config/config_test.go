@@ -3,7 +3,12 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
 )
 
 func TestParseMetricFamilies(t *testing.T) {
@@ -116,3 +121,469 @@ func TestLoadRespectsStaticThresholds(t *testing.T) {
 		t.Fatalf("expected net_tx_bytes_per_sec threshold 4096, got %+v", cfg.StaticThresholds)
 	}
 }
+
+func TestLoadParsesDetectors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.json")
+	payload := `{"detector":"ewma","detectors":{"net_rx":"MAD"}}`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	algorithms := cfg.Algorithms()
+	if got := algorithms.For("cpu_percent"); got != "ewma" {
+		t.Fatalf("expected ewma default, got %q", got)
+	}
+	if got := algorithms.For("net_rx_bytes_per_sec"); got != "mad" {
+		t.Fatalf("expected mad for net_rx_bytes_per_sec, got %q", got)
+	}
+}
+
+func TestLoadRejectsUnknownDetector(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.json")
+	if err := os.WriteFile(path, []byte(`{"detectors":{"cpu":"nope"}}`), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestParsePercentileRulesExpandsFamilies(t *testing.T) {
+	rules, err := ParsePercentileRules(map[string][]string{
+		"net": {"p99+20%"},
+		"cpu": {"p95@5m>85"},
+	})
+	if err != nil {
+		t.Fatalf("ParsePercentileRules: %v", err)
+	}
+	for _, name := range []string{"net_rx_bytes_per_sec", "net_tx_bytes_per_sec", "cpu_percent"} {
+		if len(rules[name]) != 1 {
+			t.Fatalf("expected one rule for %s, got %+v", name, rules)
+		}
+	}
+	if _, err := ParsePercentileRules(map[string][]string{"gpu": {"p99"}}); err == nil {
+		t.Fatalf("expected error for unknown metric")
+	}
+}
+
+func TestParseSustain(t *testing.T) {
+	sustain, err := ParseSustain(map[string]string{"cpu": "2m", "net:zscore": "3/5"})
+	if err != nil {
+		t.Fatalf("ParseSustain: %v", err)
+	}
+	if got := sustain["cpu_percent"]; got.For != 2*time.Minute {
+		t.Fatalf("expected cpu_percent sustain of 2m, got %+v", got)
+	}
+	if got := sustain["net_tx_bytes_per_sec:zscore"]; got.Count != 3 || got.Of != 5 {
+		t.Fatalf("expected net_tx zscore sustain of 3/5, got %+v", got)
+	}
+	if _, err := ParseSustain(map[string]string{"cpu:nope": "2m"}); err == nil {
+		t.Fatalf("expected error for unknown rule type")
+	}
+}
+
+func TestLoadRespectsLowerThresholdsAndDirections(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.json")
+	payload := `{"static_lower_thresholds":{"net_rx":1024,"disk_free":5368709120},"directions":{"cpu":"up","net":"down"}}`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.StaticLowerThresholds["net_rx_bytes_per_sec"] != 1024 || cfg.StaticLowerThresholds["disk_free_bytes"] != 5368709120 {
+		t.Fatalf("unexpected lower thresholds: %+v", cfg.StaticLowerThresholds)
+	}
+	want := map[string]anomaly.Direction{
+		"cpu_percent":          anomaly.DirectionUp,
+		"net_rx_bytes_per_sec": anomaly.DirectionDown,
+		"net_tx_bytes_per_sec": anomaly.DirectionDown,
+	}
+	if !reflect.DeepEqual(cfg.Directions, want) {
+		t.Fatalf("unexpected directions: %+v", cfg.Directions)
+	}
+	rules := cfg.Rules()
+	if rules.StaticLowerThresholds["net_rx_bytes_per_sec"] != 1024 || rules.Directions["cpu_percent"] != anomaly.DirectionUp {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+}
+
+func TestParseDirectionsRejectsUnknownDirection(t *testing.T) {
+	if _, err := ParseDirections(map[string]string{"cpu": "sideways"}); err == nil {
+		t.Fatal("expected error for unknown direction")
+	}
+}
+
+func TestParseForecastRules(t *testing.T) {
+	rules, err := ParseForecastRules(map[string]string{"disk": "24h", "mem_used_percent": "6h>95"})
+	if err != nil {
+		t.Fatalf("ParseForecastRules: %v", err)
+	}
+	if rules["disk_used_percent"].Horizon != 24*time.Hour || rules["mem_used_percent"].Limit != 95 {
+		t.Fatalf("unexpected forecast rules: %+v", rules)
+	}
+	if _, err := ParseForecastRules(map[string]string{"net_rx": "24h"}); err == nil {
+		t.Fatal("expected error for a metric that cannot fill up")
+	}
+
+	rules, err = ParseForecastRules(map[string]string{"disk{mount=/var}": "12h"})
+	if err != nil {
+		t.Fatalf("ParseForecastRules: %v", err)
+	}
+	if rules["disk_used_percent{mount=/var}"].Horizon != 12*time.Hour {
+		t.Fatalf("expected a per-mount forecast rule: %+v", rules)
+	}
+	for _, bad := range []string{"mem{mount=/var}", "disk{mount=}"} {
+		if _, err := ParseForecastRules(map[string]string{bad: "24h"}); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestParseDiskMounts(t *testing.T) {
+	mounts, err := ParseDiskMounts([]string{" /var ", "/data"})
+	if err != nil || !reflect.DeepEqual(mounts, []string{"/var", "/data"}) {
+		t.Fatalf("unexpected mounts %v (%v)", mounts, err)
+	}
+	for _, bad := range [][]string{{""}, {"/var", "/var"}, {"/a{b}"}} {
+		if _, err := ParseDiskMounts(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestParseRateRules(t *testing.T) {
+	rules, err := ParseRateRules(map[string]string{"mem": "10/1m", "disk_free": "-20%/5m"})
+	if err != nil {
+		t.Fatalf("ParseRateRules: %v", err)
+	}
+	want := map[string]anomaly.RateRule{
+		"mem_used_percent": {Change: 10, Per: time.Minute},
+		"disk_free_bytes":  {Change: 20, Percent: true, Per: 5 * time.Minute, Falling: true},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Fatalf("unexpected rate rules: %+v", rules)
+	}
+	if _, err := ParseRateRules(map[string]string{"net_rx": "10/1m"}); err == nil {
+		t.Fatal("expected error for a throughput metric")
+	}
+	if _, err := ParseSustain(map[string]string{"mem:rate_of_change": "2m"}); err != nil {
+		t.Fatalf("expected rate_of_change to be a sustain rule type: %v", err)
+	}
+}
+
+func TestParseChangePoints(t *testing.T) {
+	rules, err := ParseChangePoints(map[string]string{"mem": "on", "cpu_percent": "8"})
+	if err != nil {
+		t.Fatalf("ParseChangePoints: %v", err)
+	}
+	want := map[string]anomaly.ChangePointRule{
+		"mem_used_percent": {},
+		"cpu_percent":      {Threshold: 8},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Fatalf("unexpected change points: %+v", rules)
+	}
+	if _, err := ParseChangePoints(map[string]string{"nope": "on"}); err == nil {
+		t.Fatal("expected error for unknown metric")
+	}
+	if _, err := ParseChangePoints(map[string]string{"mem": "-2"}); err == nil {
+		t.Fatal("expected error for negative threshold")
+	}
+}
+
+func TestLoadIncidentWindow(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.json")
+	if err := os.WriteFile(path, []byte(`{"incident_window":"2m"}`), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.IncidentWindow != 2*time.Minute {
+		t.Fatalf("expected 2m incident window, got %s", cfg.IncidentWindow)
+	}
+	if Default().IncidentWindow != anomaly.DefaultIncidentWindow {
+		t.Fatalf("expected default incident window, got %s", Default().IncidentWindow)
+	}
+}
+
+func TestParseContamination(t *testing.T) {
+	policies, err := ParseContamination(map[string]string{"net": "skip", "cpu_percent": "clamp"})
+	if err != nil {
+		t.Fatalf("ParseContamination: %v", err)
+	}
+	want := map[string]anomaly.Contamination{
+		"net_rx_bytes_per_sec": anomaly.ContaminationSkip,
+		"net_tx_bytes_per_sec": anomaly.ContaminationSkip,
+		"cpu_percent":          anomaly.ContaminationClamp,
+	}
+	if !reflect.DeepEqual(policies, want) {
+		t.Fatalf("unexpected policies: %+v", policies)
+	}
+	if _, err := ParseContamination(map[string]string{"cpu": "drop"}); err == nil {
+		t.Fatal("expected error for unknown policy")
+	}
+}
+
+func TestLoadParsesHalfLifeDetectors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.json")
+	if err := os.WriteFile(path, []byte(`{"detector":"ewma:10m","detectors":{"mem":"Holt:30m"}}`), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	algorithms := cfg.Algorithms()
+	if algorithms.For("cpu_percent") != "ewma:10m" || algorithms.For("mem_used_percent") != "holt:30m" {
+		t.Fatalf("unexpected detectors: %+v", algorithms)
+	}
+	if err := os.WriteFile(path, []byte(`{"detectors":{"cpu":"mad:10m"}}`), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected error for a half-life on mad")
+	}
+}
+
+func TestLoadAppliesMetricRules(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.json")
+	payload := `{
+  "static_thresholds": {"disk_used_percent": 90},
+  "rules": {
+    "disk_used_percent": {"window_size": 120, "zscore_threshold": 4, "min_severity": "High", "cooldown": "10m", "static_threshold": 95},
+    "net": {"detector": "mad", "cooldown": "0s"}
+  }
+}`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := cfg.MetricParams["disk_used_percent"]; got.WindowSize != 120 || got.Threshold != 4 {
+		t.Fatalf("unexpected metric params: %+v", cfg.MetricParams)
+	}
+	if cfg.MinSeverities["disk_used_percent"] != "high" || cfg.StaticThresholds["disk_used_percent"] != 95 {
+		t.Fatalf("expected rule to override min severity and static threshold: %+v %+v", cfg.MinSeverities, cfg.StaticThresholds)
+	}
+	wantCooldowns := map[string]time.Duration{
+		"disk_used_percent":    10 * time.Minute,
+		"net_rx_bytes_per_sec": 0,
+		"net_tx_bytes_per_sec": 0,
+	}
+	if !reflect.DeepEqual(cfg.Cooldowns, wantCooldowns) {
+		t.Fatalf("unexpected cooldowns: %+v", cfg.Cooldowns)
+	}
+	if cfg.Algorithms().For("net_tx_bytes_per_sec") != "mad" {
+		t.Fatalf("expected mad for net_tx_bytes_per_sec: %+v", cfg.Detectors)
+	}
+
+	// Family keys apply thresholds to one metric, like static_thresholds,
+	// and everything else to the whole family.
+	payload = `{"rules": {"disk": {"static_threshold": 90, "static_lower_threshold": 5, "window_size": 60}}}`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if cfg, err = Load(path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	wantThresholds := map[string]float64{"disk_used_percent": 90}
+	if !reflect.DeepEqual(cfg.StaticThresholds, wantThresholds) || !reflect.DeepEqual(cfg.StaticLowerThresholds, map[string]float64{"disk_used_percent": 5}) {
+		t.Fatalf("expected thresholds on disk_used_percent only: %+v %+v", cfg.StaticThresholds, cfg.StaticLowerThresholds)
+	}
+	if cfg.MetricParams["disk_write_bytes_per_sec"].WindowSize != 60 {
+		t.Fatalf("expected window_size on the whole disk family: %+v", cfg.MetricParams)
+	}
+
+	for _, bad := range []string{
+		`{"rules":{"net":{"static_threshold":1000}}}`,
+		`{"rules":{"gpu":{"window_size":10}}}`,
+		`{"rules":{"cpu":{"min_severity":"urgent"}}}`,
+		`{"rules":{"cpu":{"detector":"nope"}}}`,
+		`{"rules":{"cpu":{"cooldown":"-1s"}}}`,
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestLoadExpressions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.json")
+	payload := `{"expressions": [{"name": "memory_pressure", "expr": "cpu > 85 && mem_used_percent > 90 for 2m", "severity": "high"}]}`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	rules := cfg.Rules().Expressions
+	if len(rules) != 1 || rules[0].Name != "memory_pressure" || rules[0].Severity != "high" {
+		t.Fatalf("unexpected expressions: %+v", rules)
+	}
+	if !reflect.DeepEqual(rules[0].Metrics(), []string{"cpu_percent", "mem_used_percent"}) {
+		t.Fatalf("expected metric aliases to resolve, got %v", rules[0].Metrics())
+	}
+
+	for _, bad := range []string{
+		`{"expressions":[{"name":"a","expr":"gpu > 1"}]}`,
+		`{"expressions":[{"name":"a","expr":"cpu > 1"},{"name":"a","expr":"mem > 1"}]}`,
+		`{"expressions":[{"name":"cpu_percent","expr":"cpu > 1"}]}`,
+		`{"expressions":[{"expr":"cpu > 1"}]}`,
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestLoadSeverity(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.json")
+	payload := `{
+  "severity": {
+    "levels": ["info", "warn", "page"],
+    "cut_points": {"zscore": [3, 5], "change_point": [3, 5], "static_threshold": [0.1, 0.3], "percentile": [0.1, 0.3], "Forecast": [0.1, 0.3]}
+  },
+  "rules": {"disk": {"min_severity": "Warn", "cut_points": {"static_threshold": [0.01, 0.05]}}},
+  "expressions": [{"name": "pressure", "expr": "cpu > 85 && mem > 90", "severity": "page"}]
+}`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	rules := cfg.Rules().Severity
+	if rules.Scale.String() != "info|warn|page" || !reflect.DeepEqual(rules.Cuts("cpu_percent", anomaly.RuleTypeForecast), []float64{0.1, 0.3}) {
+		t.Fatalf("unexpected severity rules: %+v", rules)
+	}
+	if !reflect.DeepEqual(rules.Cuts("disk_free_bytes", anomaly.RuleTypeStaticThreshold), []float64{0.01, 0.05}) || cfg.MinSeverities["disk_used_percent"] != "warn" {
+		t.Fatalf("expected the disk rule to set cut-points and min severity: %+v %+v", rules.MetricCutPoints, cfg.MinSeverities)
+	}
+
+	for _, bad := range []string{
+		`{"severity":{"levels":["info","page"]}}`,
+		`{"severity":{"cut_points":{"zscore":[4,3,6]}}}`,
+		`{"severity":{"cut_points":{"spike":[3,4,6]}}}`,
+		`{"rules":{"cpu":{"cut_points":{"zscore":[3,4]}}}}`,
+		`{"rules":{"cpu":{"min_severity":"page"}}}`,
+		`{"expressions":[{"name":"a","expr":"cpu > 1","severity":"urgent"}]}`,
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestLoadExplanations(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.json")
+	payload := `{"explanations": {"locale": " DE ", "templates": ["runbooks.tmpl", "team.tmpl"]}}`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Locale != "de" || !reflect.DeepEqual(cfg.ExplanationTemplates, []string{"runbooks.tmpl", "team.tmpl"}) {
+		t.Fatalf("unexpected explanations: %q %v", cfg.Locale, cfg.ExplanationTemplates)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"explanations": {"locale": "klingon"}}`), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "unknown locale: klingon") {
+		t.Fatalf("expected an unknown locale error, got %v", err)
+	}
+}
+
+func TestLoadSilences(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.json")
+	payload := `{
+  "silences_file": "state/silences.json",
+  "silences": [
+    {"id": "nightly-backup", "schedule": "0 2 * * *", "duration": "1h", "matchers": {"metric": "disk", "host_id": "db-*"}},
+    {"id": "patch-window", "start": "2026-05-01T22:00:00Z", "end": "2026-05-02T02:00:00Z"}
+  ]
+}`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.SilencesFile != "state/silences.json" || len(cfg.Silences) != 2 {
+		t.Fatalf("unexpected silences: %q %+v", cfg.SilencesFile, cfg.Silences)
+	}
+	if !cfg.Silences[0].Active(time.Date(2026, 5, 1, 2, 30, 0, 0, time.UTC)) || !cfg.Silences[1].Active(time.Date(2026, 5, 1, 23, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the silences to be compiled: %+v", cfg.Silences)
+	}
+	if Default().SilencesFile != filepath.Join("data", "silences.json") {
+		t.Fatalf("unexpected default silences file: %q", Default().SilencesFile)
+	}
+
+	for _, bad := range []string{
+		`{"silences":[{"schedule":"0 2 * * *","duration":"1h"}]}`,
+		`{"silences":[{"id":"a","schedule":"0 25 * * *","duration":"1h"}]}`,
+		`{"silences":[{"id":"a"},{"id":"a"}]}`,
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestLoadWarmup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfg.json")
+	if err := os.WriteFile(path, []byte(`{"warmup": {"samples": 60, "duration": "10m"}}`), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if want := (anomaly.Warmup{Samples: 60, Duration: 10 * time.Minute}); cfg.Warmup != want || cfg.Rules().Warmup != want {
+		t.Fatalf("unexpected warm-up: %+v", cfg.Warmup)
+	}
+	if err := os.WriteFile(path, []byte(`{"warmup": {"samples": -5}}`), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected negative warm-up samples to be rejected")
+	}
+}
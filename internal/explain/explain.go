@@ -0,0 +1,434 @@
+// Package explain renders anomaly explanations and remediation hints from Go
+// text/template files, so teams can point hints at their own runbooks and read
+// explanations in another language.
+//
+// Templates are looked up by name, most specific first. Explanations use
+// "<metric>:<rule_type>", "<metric>", then "<rule_type>"; hints use the same
+// names prefixed with "hint:", then "hint". A "label:<metric>" template
+// renames a metric in .Label. A "condition" template replaces the sentence
+// sustained anomalies append when no explanation template matched, and an
+// "incident" template renders incident summaries from IncidentData.
+// Anomalies and incidents without a matching template keep the built-in
+// English text. Templates can call value, label, values, list, fixed,
+// percent, abs, time, duration, eta, span and change (see funcs).
+package explain
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+)
+
+// DefaultLocale is the built-in English text, which needs no templates.
+const DefaultLocale = "en"
+
+//go:embed locales/*.tmpl
+var localeFS embed.FS
+
+// Data is what templates are executed with. Explanation and Hint start out as
+// the built-in English text; when a hint template matches, Hint is the
+// rendered hint by the time the explanation template runs.
+type Data struct {
+	Metric        string
+	Label         string
+	RuleType      string
+	Direction     string
+	Algorithm     string
+	Severity      string
+	Score         float64
+	Value         float64
+	Baseline      float64
+	Stddev        float64
+	ZScore        float64
+	Sigma         float64
+	Threshold     float64
+	Bounds        *anomaly.Bounds
+	Condition     *anomaly.Condition
+	Forecast      *anomaly.Forecast
+	RateOfChange  *anomaly.RateOfChange
+	ChangePoint   *anomaly.ChangePoint
+	Expression    *anomaly.ExpressionMatch
+	Timestamp     time.Time
+	HostID        string
+	Labels        map[string]string
+	TopCPUProcess *anomaly.ProcessAttribution
+	TopMemProcess *anomaly.ProcessAttribution
+	Explanation   string
+	Hint          string
+}
+
+// IncidentData is what the "incident" template is executed with.
+// Explanation starts out as the built-in English summary.
+type IncidentData struct {
+	ID       string
+	HostID   string
+	Start    time.Time
+	End      time.Time
+	Severity string
+	Score    float64
+	Metrics  []string
+	// MetricLabels are the labels of Metrics, in the same order.
+	MetricLabels []string
+	Correlated   bool
+	LikelyCause  *anomaly.ProcessAttribution
+	// Anomalies is the number of anomalies in the incident and
+	// CauseAnomalies the number LikelyCause was the top process in.
+	Anomalies      int
+	CauseAnomalies int
+	Explanation    string
+}
+
+// Explainer rewrites anomaly explanations and hints from templates. A nil
+// Explainer leaves anomalies unchanged.
+type Explainer struct {
+	locale string
+	tmpl   *template.Template
+}
+
+// Locales returns the built-in locales, sorted.
+func Locales() []string {
+	out := []string{DefaultLocale}
+	entries, _ := localeFS.ReadDir("locales")
+	for _, entry := range entries {
+		out = append(out, strings.TrimSuffix(entry.Name(), ".tmpl"))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ParseLocale normalizes locale and checks that it is built in. An empty
+// locale is DefaultLocale.
+func ParseLocale(locale string) (string, error) {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if locale == "" {
+		return DefaultLocale, nil
+	}
+	for _, l := range Locales() {
+		if l == locale {
+			return locale, nil
+		}
+	}
+	return "", fmt.Errorf("unknown locale: %s (expected %s)", locale, strings.Join(Locales(), "|"))
+}
+
+// New loads the built-in templates of locale, then the template files at
+// paths, whose definitions replace built-in ones of the same name. Every
+// template is executed once against sample data so mistakes such as unknown
+// fields are reported here rather than on the first anomaly.
+func New(locale string, paths []string) (*Explainer, error) {
+	locale, err := ParseLocale(locale)
+	if err != nil {
+		return nil, err
+	}
+	e := &Explainer{locale: locale}
+	e.tmpl = template.New("explain").Funcs(e.funcs())
+	if locale != DefaultLocale {
+		if _, err := e.tmpl.ParseFS(localeFS, path.Join("locales", locale+".tmpl")); err != nil {
+			return nil, fmt.Errorf("locale %s: %w", locale, err)
+		}
+	}
+	for _, p := range paths {
+		if _, err := e.tmpl.ParseFiles(p); err != nil {
+			return nil, fmt.Errorf("explanation templates: %w", err)
+		}
+	}
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Locale returns the locale the explainer was built for.
+func (e *Explainer) Locale() string {
+	if e == nil {
+		return DefaultLocale
+	}
+	return e.locale
+}
+
+// Explain rewrites a's explanation and hint from the matching templates. When
+// only a hint template matches, the built-in hint in the explanation is
+// replaced (or the hint appended). A template that fails to execute leaves
+// the built-in text in place.
+func (e *Explainer) Explain(a *anomaly.Anomaly) {
+	if e == nil || a == nil {
+		return
+	}
+	data := e.data(a)
+	hint, hinted := e.render(lookupNames("hint:", a), data)
+	if hinted {
+		data.Hint = hint
+	}
+	if text, ok := e.render(lookupNames("", a), data); ok {
+		a.Explanation = text
+	} else {
+		if hinted {
+			a.Explanation = replaceHint(a.Explanation, a.Hint, hint)
+		}
+		if a.Condition != nil {
+			if text, ok := e.render([]string{"condition"}, data); ok {
+				a.Explanation = replaceSentence(a.Explanation, anomaly.ConditionText(a.Condition), text)
+			}
+		}
+	}
+	if hinted {
+		a.Hint = hint
+	}
+}
+
+// ExplainIncident rewrites inc's summary from the "incident" template. A nil
+// Explainer, a missing template or one that fails to execute leaves the
+// built-in English summary in place.
+func (e *Explainer) ExplainIncident(inc *anomaly.Incident) {
+	if e == nil || inc == nil {
+		return
+	}
+	labels := make([]string, len(inc.Metrics))
+	for i, metric := range inc.Metrics {
+		labels[i] = e.label(metric)
+	}
+	data := IncidentData{
+		ID:             inc.ID,
+		HostID:         inc.HostID,
+		Start:          inc.Start,
+		End:            inc.End,
+		Severity:       inc.Severity,
+		Score:          inc.Score,
+		Metrics:        inc.Metrics,
+		MetricLabels:   labels,
+		Correlated:     inc.Correlated(),
+		LikelyCause:    inc.LikelyCause,
+		Anomalies:      inc.AnomalyCount(),
+		CauseAnomalies: inc.CauseCount(),
+		Explanation:    inc.Explanation,
+	}
+	if text, ok := e.render([]string{"incident"}, data); ok {
+		inc.Explanation = text
+	}
+}
+
+func (e *Explainer) data(a *anomaly.Anomaly) Data {
+	return Data{
+		Metric:        a.Name,
+		Label:         e.label(a.Name),
+		RuleType:      a.RuleType,
+		Direction:     a.Direction,
+		Algorithm:     a.Algorithm,
+		Severity:      a.Severity,
+		Score:         a.Score,
+		Value:         a.Value,
+		Baseline:      a.Mean,
+		Stddev:        a.Stddev,
+		ZScore:        a.ZScore,
+		Sigma:         math.Abs(a.ZScore),
+		Threshold:     a.Threshold,
+		Bounds:        a.Bounds,
+		Condition:     a.Condition,
+		Forecast:      a.Forecast,
+		RateOfChange:  a.RateOfChange,
+		ChangePoint:   a.ChangePoint,
+		Expression:    a.Expression,
+		Timestamp:     a.Timestamp,
+		HostID:        a.HostID,
+		Labels:        a.Labels,
+		TopCPUProcess: a.TopCPUProcess,
+		TopMemProcess: a.TopMemProcess,
+		Explanation:   a.Explanation,
+		Hint:          a.Hint,
+	}
+}
+
+// label is the "label:<metric>" template, or the built-in English label.
+// Per-mount metrics without their own template use their metric's label
+// followed by the mount.
+func (e *Explainer) label(metric string) string {
+	if text, ok := e.render([]string{"label:" + metric}, Data{Metric: metric}); ok {
+		return text
+	}
+	if mount := anomaly.MetricMount(metric); mount != "" {
+		return e.label(anomaly.BaseMetric(metric)) + " (" + mount + ")"
+	}
+	return anomaly.MetricLabel(metric)
+}
+
+// render executes the first defined template of names. Whitespace runs are
+// collapsed, so templates can span several lines.
+func (e *Explainer) render(names []string, data any) (string, bool) {
+	for _, name := range names {
+		t := e.tmpl.Lookup(name)
+		if t == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return "", false
+		}
+		return strings.Join(strings.Fields(buf.String()), " "), true
+	}
+	return "", false
+}
+
+// check executes every template against sample data with every optional
+// section set: the "incident" template against IncidentData, the others
+// against Data.
+func (e *Explainer) check() error {
+	now := time.Now().UTC()
+	process := &anomaly.ProcessAttribution{PID: 1, Name: "sample", CPUPercent: 50, RSSBytes: 1 << 20}
+	sample := Data{
+		Metric:        "cpu_percent",
+		Label:         "CPU",
+		RuleType:      anomaly.RuleTypeZScore,
+		Direction:     anomaly.DirectionAbove,
+		Severity:      "high",
+		Score:         60,
+		Value:         95,
+		Baseline:      20,
+		Stddev:        5,
+		ZScore:        15,
+		Sigma:         15,
+		Threshold:     90,
+		Bounds:        &anomaly.Bounds{Lower: 10, Upper: 30},
+		Condition:     &anomaly.Condition{Start: now, DurationSeconds: 60, Breaches: 3, Samples: 3},
+		Forecast:      &anomaly.Forecast{ETA: now, SecondsToLimit: 3600, SlopePerHour: 1, HorizonSeconds: 7200},
+		RateOfChange:  &anomaly.RateOfChange{Since: now, Change: 12, ElapsedSeconds: 60, Rate: 12, PerSeconds: 60},
+		ChangePoint:   &anomaly.ChangePoint{ChangedAt: now, BeforeMean: 20, AfterMean: 40, Samples: 5},
+		Expression:    &anomaly.ExpressionMatch{Expression: "cpu > 90", Values: map[string]float64{"cpu_percent": 95}},
+		Timestamp:     now,
+		HostID:        "host",
+		Labels:        map[string]string{"env": "prod"},
+		TopCPUProcess: process,
+		TopMemProcess: process,
+		Explanation:   "explanation",
+		Hint:          "hint",
+	}
+	incident := IncidentData{
+		ID:             "host-20260101T000000Z",
+		HostID:         "host",
+		Start:          now,
+		End:            now,
+		Severity:       "high",
+		Score:          60,
+		Metrics:        []string{"cpu_percent", "mem_used_percent"},
+		MetricLabels:   []string{"CPU", "Memory usage"},
+		Correlated:     true,
+		LikelyCause:    process,
+		Anomalies:      3,
+		CauseAnomalies: 2,
+		Explanation:    "explanation",
+	}
+	for _, t := range e.tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		var data any = sample
+		if t.Name() == "incident" {
+			data = incident
+		}
+		if err := t.Execute(&bytes.Buffer{}, data); err != nil {
+			return fmt.Errorf("explanation template %s: %w", t.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (e *Explainer) funcs() template.FuncMap {
+	return template.FuncMap{
+		"value": anomaly.FormatValue,
+		"label": e.label,
+		"values": func(values map[string]float64) string {
+			metrics := make([]string, 0, len(values))
+			for metric := range values {
+				metrics = append(metrics, metric)
+			}
+			sort.Strings(metrics)
+			parts := make([]string, 0, len(metrics))
+			for _, metric := range metrics {
+				parts = append(parts, e.label(metric)+" "+anomaly.FormatValue(metric, values[metric]))
+			}
+			return strings.Join(parts, ", ")
+		},
+		"list":  joinList,
+		"fixed": func(decimals int, v float64) string { return fmt.Sprintf("%.*f", decimals, v) },
+		"percent": func(ratio float64) string {
+			return fmt.Sprintf("%.1f%%", ratio*100)
+		},
+		"abs":  math.Abs,
+		"time": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+		"duration": func(seconds float64) string {
+			return time.Duration(seconds * float64(time.Second)).String()
+		},
+		"eta": func(seconds float64) string {
+			return anomaly.FormatETA(time.Duration(seconds * float64(time.Second)))
+		},
+		"span": func(seconds float64) string {
+			return anomaly.FormatSpan(time.Duration(seconds * float64(time.Second)))
+		},
+		"change": func(metric string, v float64, relative bool) string {
+			return anomaly.FormatChange(metric, math.Abs(v), relative)
+		},
+	}
+}
+
+// lookupNames lists the template names for a, most specific first.
+// Per-mount metrics fall back to the templates of their metric.
+func lookupNames(prefix string, a *anomaly.Anomaly) []string {
+	names := []string{
+		prefix + a.Name + ":" + a.RuleType,
+		prefix + a.Name,
+	}
+	if base := anomaly.BaseMetric(a.Name); base != a.Name {
+		names = append(names, prefix+base+":"+a.RuleType, prefix+base)
+	}
+	names = append(names, prefix+a.RuleType)
+	if prefix != "" {
+		names = append(names, strings.TrimSuffix(prefix, ":"))
+	}
+	return names
+}
+
+// replaceHint swaps the built-in hint at the end of explanation for hint.
+func replaceHint(explanation, builtin, hint string) string {
+	if builtin != "" && strings.Contains(explanation, builtin) {
+		if hint == "" {
+			return strings.Replace(explanation, " "+builtin, "", 1)
+		}
+		return strings.Replace(explanation, builtin, hint, 1)
+	}
+	if hint == "" {
+		return explanation
+	}
+	return explanation + " " + hint
+}
+
+// replaceSentence swaps the built-in sentence in explanation for text, or
+// removes it when text is empty.
+func replaceSentence(explanation, builtin, text string) string {
+	if !strings.Contains(explanation, builtin) {
+		return explanation
+	}
+	if text == "" {
+		return strings.TrimSpace(strings.Replace(explanation, builtin, "", 1))
+	}
+	return strings.Replace(explanation, builtin, text, 1)
+}
+
+// joinList joins items as a sentence list with conjunction before the last
+// item: "CPU, Memory usage and Inbound network".
+func joinList(conjunction string, items []string) string {
+	switch len(items) {
+	case 0:
+		return ""
+	case 1:
+		return items[0]
+	default:
+		return strings.Join(items[:len(items)-1], ", ") + " " + conjunction + " " + items[len(items)-1]
+	}
+}
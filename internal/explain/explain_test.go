@@ -0,0 +1,229 @@
+package explain
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+)
+
+func cpuSpike() *anomaly.Anomaly {
+	hint := anomaly.Hint("cpu_percent")
+	return &anomaly.Anomaly{
+		Name:          "cpu_percent",
+		Value:         95,
+		RuleType:      anomaly.RuleTypeZScore,
+		Direction:     anomaly.DirectionAbove,
+		Mean:          20,
+		Stddev:        5,
+		ZScore:        15,
+		Severity:      "critical",
+		Explanation:   "CPU usage spiked to 95.0% (baseline 20.0%, 15.0σ). " + hint,
+		Hint:          hint,
+		HostID:        "host-a",
+		Labels:        map[string]string{"team": "payments"},
+		TopCPUProcess: &anomaly.ProcessAttribution{PID: 42, Name: "java", CPUPercent: 80},
+	}
+}
+
+func writeTemplates(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "runbooks.tmpl")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write templates: %v", err)
+	}
+	return path
+}
+
+func TestDefaultLocaleKeepsBuiltInText(t *testing.T) {
+	e, err := New("", nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	a := cpuSpike()
+	want := a.Explanation
+	e.Explain(a)
+	if a.Explanation != want || a.Hint != anomaly.Hint("cpu_percent") {
+		t.Fatalf("expected the built-in text, got %q / %q", a.Explanation, a.Hint)
+	}
+	var nilExplainer *Explainer
+	nilExplainer.Explain(a)
+	if a.Explanation != want {
+		t.Fatalf("expected a nil explainer to be a no-op, got %q", a.Explanation)
+	}
+}
+
+func TestHintTemplateReplacesBuiltInHint(t *testing.T) {
+	path := writeTemplates(t, `
+{{define "hint:cpu_percent"}}
+See https://runbooks.example/cpu?team={{index .Labels "team"}}{{with .TopCPUProcess}} ({{.Name}}, pid {{.PID}}){{end}}.
+{{end}}`)
+	e, err := New("en", []string{path})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	a := cpuSpike()
+	e.Explain(a)
+	wantHint := "See https://runbooks.example/cpu?team=payments (java, pid 42)."
+	if a.Hint != wantHint {
+		t.Fatalf("unexpected hint: %q", a.Hint)
+	}
+	if a.Explanation != "CPU usage spiked to 95.0% (baseline 20.0%, 15.0σ). "+wantHint {
+		t.Fatalf("unexpected explanation: %q", a.Explanation)
+	}
+
+	static := anomaly.CheckStaticThreshold("cpu_percent", 95, map[string]float64{"cpu_percent": 90})
+	static.Labels = map[string]string{"team": "payments"}
+	e.Explain(static)
+	if !strings.HasSuffix(static.Explanation, "(5.6% over threshold). See https://runbooks.example/cpu?team=payments.") {
+		t.Fatalf("expected the hint appended to the static explanation, got %q", static.Explanation)
+	}
+}
+
+func TestMostSpecificTemplateWins(t *testing.T) {
+	path := writeTemplates(t, `
+{{define "zscore"}}{{.Label}} is {{fixed 1 .Sigma}}σ off.{{end}}
+{{define "cpu_percent:zscore"}}{{.Label}} at {{value .Metric .Value}} on {{.HostID}} vs {{value .Metric .Baseline}}. {{.Hint}}{{end}}
+{{define "label:cpu_percent"}}Processor{{end}}`)
+	e, err := New("", []string{path})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	a := cpuSpike()
+	e.Explain(a)
+	if want := "Processor at 95.0% on host-a vs 20.0%. " + anomaly.Hint("cpu_percent"); a.Explanation != want {
+		t.Fatalf("expected %q, got %q", want, a.Explanation)
+	}
+	mem := cpuSpike()
+	mem.Name = "mem_used_percent"
+	e.Explain(mem)
+	if mem.Explanation != "Memory usage is 15.0σ off." {
+		t.Fatalf("expected the rule type template, got %q", mem.Explanation)
+	}
+}
+
+func TestPerMountMetricsUseTheirMetricsTemplates(t *testing.T) {
+	e, err := New("de", nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	a := &anomaly.Anomaly{Name: "disk_used_percent{mount=/var}", RuleType: anomaly.RuleTypeForecast, Mean: 80, Threshold: 100,
+		Forecast: &anomaly.Forecast{ETA: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), SecondsToLimit: 5400, SlopePerHour: 10}}
+	e.Explain(a)
+	if !strings.HasPrefix(a.Explanation, "Festplattenbelegung (/var) steigt um 10.0% pro Stunde") {
+		t.Fatalf("expected the localized forecast with the mount, got %q", a.Explanation)
+	}
+}
+
+func TestBuiltInLocalesRenderEveryRuleType(t *testing.T) {
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	anomalies := func() []*anomaly.Anomaly {
+		return []*anomaly.Anomaly{
+			cpuSpike(),
+			anomaly.CheckStaticThreshold("mem_used_percent", 96, map[string]float64{"mem_used_percent": 80}),
+			anomaly.CheckStaticLowerThreshold("disk_free_bytes", 0, map[string]float64{"disk_free_bytes": 1 << 30}),
+			{Name: "cpu_percent", RuleType: anomaly.RuleTypePercentile, Value: 90, Mean: 70, Threshold: 80},
+			{Name: "disk_used_percent", RuleType: anomaly.RuleTypeForecast, Mean: 80, Threshold: 95,
+				Forecast: &anomaly.Forecast{ETA: ts, SecondsToLimit: 5400, SlopePerHour: 10}},
+			{Name: "mem_used_percent", RuleType: anomaly.RuleTypeRateOfChange, Value: 52.3, Mean: 40, Threshold: 10,
+				RateOfChange: &anomaly.RateOfChange{Since: ts, Change: 12.3, ElapsedSeconds: 60, Rate: 12.3, PerSeconds: 60}},
+			{Name: "net_tx_bytes_per_sec", RuleType: anomaly.RuleTypeChangePoint, ZScore: 8,
+				ChangePoint: &anomaly.ChangePoint{ChangedAt: ts, BeforeMean: 100, AfterMean: 900, Samples: 6}},
+			{Name: "busy_and_full", RuleType: anomaly.RuleTypeExpression,
+				Expression: &anomaly.ExpressionMatch{Expression: "cpu > 90 && mem > 90", Values: map[string]float64{"cpu_percent": 95, "mem_used_percent": 93}},
+				Condition:  &anomaly.Condition{Start: ts, DurationSeconds: 120, Breaches: 3, Samples: 3}},
+		}
+	}
+	want := map[string]string{
+		"de": "CPU-Auslastung ist auf 95.0% gestiegen (Basiswert 20.0%, 15.0σ). Auf hängende Prozesse, Hintergrundjobs oder Drosselung prüfen.",
+		"es": "Uso de CPU subió a 95.0% (línea base 20.0%, 15.0σ). Revise procesos descontrolados, tareas en segundo plano o limitación de CPU.",
+	}
+	for _, locale := range Locales() {
+		if locale == DefaultLocale {
+			continue
+		}
+		e, err := New(locale, nil)
+		if err != nil {
+			t.Fatalf("New(%s): %v", locale, err)
+		}
+		for i, a := range anomalies() {
+			builtin := a.Explanation
+			e.Explain(a)
+			if a.Explanation == "" || a.Explanation == builtin || strings.Contains(a.Explanation, "<no value>") {
+				t.Fatalf("%s: rule %s not localized: %q", locale, a.RuleType, a.Explanation)
+			}
+			if i == 0 && a.Explanation != want[locale] {
+				t.Fatalf("%s: expected %q, got %q", locale, want[locale], a.Explanation)
+			}
+		}
+	}
+}
+
+func TestBuiltInLocalesRenderIncidents(t *testing.T) {
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	java := &anomaly.ProcessAttribution{PID: 42, Name: "java"}
+	incidents, _ := anomaly.GroupIncidents([]anomaly.Anomaly{
+		{Name: "cpu_percent", Timestamp: ts, Severity: "high", TopCPUProcess: java},
+		{Name: "disk_write_bytes_per_sec", Timestamp: ts.Add(10 * time.Second), Severity: "medium", TopCPUProcess: java},
+		{Name: "net_tx_bytes_per_sec", Timestamp: ts.Add(20 * time.Second), Severity: "low"},
+	}, time.Minute)
+	if len(incidents) != 1 {
+		t.Fatalf("expected one incident, got %+v", incidents)
+	}
+	want := map[string]string{
+		"en": "CPU, Disk write throughput and Outbound network moved together; likely cause: java (pid 42), the top process in 2 of 3 anomalies.",
+		"de": "CPU-Auslastung, Schreibdurchsatz und Ausgehender Netzwerkverkehr haben sich gemeinsam bewegt; wahrscheinliche Ursache: java (PID 42), der oberste Prozess in 2 von 3 Anomalien.",
+		"es": "Uso de CPU, Escritura de disco y Tráfico de red saliente variaron a la vez; causa probable: java (pid 42), el proceso principal en 2 de 3 anomalías.",
+	}
+	for _, locale := range Locales() {
+		e, err := New(locale, nil)
+		if err != nil {
+			t.Fatalf("New(%s): %v", locale, err)
+		}
+		inc := incidents[0]
+		e.ExplainIncident(&inc)
+		if inc.Explanation != want[locale] {
+			t.Fatalf("%s: expected %q, got %q", locale, want[locale], inc.Explanation)
+		}
+	}
+
+	// Without an attributed process the summary says so.
+	alone := anomaly.Incident{Metrics: []string{"mem_used_percent"}}
+	e, _ := New("de", nil)
+	e.ExplainIncident(&alone)
+	if !strings.HasPrefix(alone.Explanation, "Speicherauslastung war auffällig; kein Prozess zugeordnet") {
+		t.Fatalf("unexpected summary: %q", alone.Explanation)
+	}
+}
+
+func TestConditionTemplateReplacesBuiltInSustainSentence(t *testing.T) {
+	path := writeTemplates(t, `{{define "condition"}}{{with .Condition}}Seit {{duration .DurationSeconds}} ({{.Breaches}}/{{.Samples}}).{{end}}{{end}}`)
+	e, err := New("", []string{path})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	a := cpuSpike()
+	a.Condition = &anomaly.Condition{Start: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), DurationSeconds: 120, Breaches: 3, Samples: 4}
+	a.Explanation += " " + anomaly.ConditionText(a.Condition)
+	e.Explain(a)
+	want := "CPU usage spiked to 95.0% (baseline 20.0%, 15.0σ). " + anomaly.Hint("cpu_percent") + " Seit 2m0s (3/4)."
+	if a.Explanation != want {
+		t.Fatalf("expected %q, got %q", want, a.Explanation)
+	}
+}
+
+func TestNewRejectsBadTemplatesAndLocales(t *testing.T) {
+	if _, err := New("fr", nil); err == nil || err.Error() != "unknown locale: fr (expected de|en|es)" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	path := writeTemplates(t, `{{define "hint"}}See {{.Runbook}}.{{end}}`)
+	if _, err := New("", []string{path}); err == nil || !strings.Contains(err.Error(), "Runbook") {
+		t.Fatalf("expected an unknown field error, got %v", err)
+	}
+	if _, err := New("", []string{filepath.Join(t.TempDir(), "missing.tmpl")}); err == nil {
+		t.Fatal("expected a missing file error")
+	}
+}
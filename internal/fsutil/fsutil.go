@@ -0,0 +1,48 @@
+// Package fsutil holds the file helpers shared by the packages that persist
+// state: watch checkpoints, baselines, seasonal models and silences.
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic replaces path with data. It writes a uniquely named
+// temporary file next to path, syncs it and renames it over path, so readers
+// and a crash see either the old file or the new one, and concurrent writers
+// never share a temporary file. The parent directory must exist.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	// Sync the directory so the rename itself survives a crash. Not every
+	// platform can open a directory for syncing; the file is in place either
+	// way.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicReplacesFileAndLeavesNoTemp(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	if err := os.WriteFile(path, []byte("old"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	// A leftover from an old build's fixed temp name must not be clobbered
+	// or block the write.
+	if err := os.WriteFile(path+".tmp", []byte("other"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := WriteFileAtomic(path, []byte("new\n"), 0o644); err != nil {
+		t.Fatalf("WriteFileAtomic: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "new\n" {
+		t.Fatalf("expected the new contents, got %q, %v", data, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o644 {
+		t.Fatalf("expected mode 0644, got %o", perm)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected only the file and the unrelated .tmp, got %v", entries)
+	}
+}
+
+func TestWriteFileAtomicFailsWithoutDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "state.json")
+	if err := WriteFileAtomic(path, []byte("x"), 0o644); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+}
@@ -0,0 +1,87 @@
+package report
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/baseline"
+)
+
+// Drift compares a metric in the analyzed samples with an imported baseline.
+// Shift is the change in mean measured in reference standard deviations;
+// Drifted is set when its magnitude reaches the z-score threshold.
+type Drift struct {
+	Metric        string  `json:"metric"`
+	ReferenceMean float64 `json:"reference_mean"`
+	Mean          float64 `json:"mean"`
+	ReferenceP95  float64 `json:"reference_p95"`
+	P95           float64 `json:"p95"`
+	Shift         float64 `json:"shift"`
+	Drifted       bool    `json:"drifted"`
+}
+
+func computeDrift(ref *baseline.Baseline, current map[string]MetricStats, threshold float64) []Drift {
+	if ref == nil {
+		return nil
+	}
+	var out []Drift
+	for _, name := range orderedMetricNames(current) {
+		r, ok := ref.Metrics[name]
+		if !ok {
+			continue
+		}
+		cur := current[name]
+		d := Drift{
+			Metric:        name,
+			ReferenceMean: r.Mean,
+			Mean:          cur.Mean,
+			ReferenceP95:  r.P95,
+			P95:           cur.P95,
+		}
+		switch diff := cur.Mean - r.Mean; {
+		case r.Stddev > 0:
+			d.Shift = diff / r.Stddev
+		case diff != 0:
+			// A perfectly flat reference: any change is as far off as it gets.
+			d.Shift = math.Copysign(math.Inf(1), diff)
+		}
+		d.Drifted = math.Abs(d.Shift) >= threshold
+		if math.IsInf(d.Shift, 0) {
+			// JSON has no infinity; report the threshold-sized shift instead.
+			d.Shift = math.Copysign(threshold, d.Shift)
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+func countDrifted(drift []Drift) int {
+	n := 0
+	for _, d := range drift {
+		if d.Drifted {
+			n++
+		}
+	}
+	return n
+}
+
+func writeDriftTable(b *strings.Builder, drift []Drift) {
+	b.WriteString("| Metric | Baseline mean | Mean | Baseline P95 | P95 | Shift | |\n")
+	b.WriteString("| --- | ---: | ---: | ---: | ---: | ---: | --- |\n")
+	for _, d := range drift {
+		flag := ""
+		if d.Drifted {
+			flag = "drifted"
+		}
+		fmt.Fprintf(b, "| %s | %s | %s | %s | %s | %+.2fσ | %s |\n",
+			d.Metric,
+			formatMetricValue(d.Metric, d.ReferenceMean),
+			formatMetricValue(d.Metric, d.Mean),
+			formatMetricValue(d.Metric, d.ReferenceP95),
+			formatMetricValue(d.Metric, d.P95),
+			d.Shift,
+			flag,
+		)
+	}
+}
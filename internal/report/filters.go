@@ -14,13 +14,14 @@ func ApplyFilters(result AnalysisResult, minSeverity string, top int) (AnalysisR
 		out.TotalAnomalies = len(out.Anomalies)
 	}
 
-	if minSeverity == "" {
-		minSeverity = "low"
+	scale := out.Severity
+	if strings.TrimSpace(minSeverity) == "" {
+		minSeverity = scale.Lowest()
 	}
-	minSeverity = strings.ToLower(strings.TrimSpace(minSeverity))
-	minRank, ok := severityRank(minSeverity)
+	minRank, ok := scale.Rank(minSeverity)
 	if !ok {
-		return AnalysisResult{}, fmt.Errorf("unknown severity: %s (expected low|medium|high|critical)", minSeverity)
+		_, err := scale.Parse(minSeverity)
+		return AnalysisResult{}, err
 	}
 	if top < 0 {
 		return AnalysisResult{}, fmt.Errorf("top must be greater than or equal to zero")
@@ -28,7 +29,7 @@ func ApplyFilters(result AnalysisResult, minSeverity string, top int) (AnalysisR
 
 	filtered := make([]anomaly.Anomaly, 0, len(out.Anomalies))
 	for _, a := range out.Anomalies {
-		rank, ok := severityRank(a.Severity)
+		rank, ok := scale.Rank(a.Severity)
 		if !ok {
 			continue
 		}
@@ -38,25 +39,10 @@ func ApplyFilters(result AnalysisResult, minSeverity string, top int) (AnalysisR
 	}
 
 	if top > 0 && len(filtered) > top {
-		sort.Slice(filtered, func(i, j int) bool { return abs(filtered[i].ZScore) > abs(filtered[j].ZScore) })
+		sort.Slice(filtered, func(i, j int) bool { return worseAnomaly(filtered[i], filtered[j]) })
 		filtered = filtered[:top]
 	}
 
 	out.Anomalies = filtered
 	return out, nil
 }
-
-func severityRank(severity string) (int, bool) {
-	switch severity {
-	case "low":
-		return 1, true
-	case "medium":
-		return 2, true
-	case "high":
-		return 3, true
-	case "critical":
-		return 4, true
-	default:
-		return 0, false
-	}
-}
@@ -0,0 +1,373 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/rollup"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/severity"
+)
+
+// Partition is the analysis of one host (and optionally one value of the
+// partition label) within a fleet.
+type Partition struct {
+	Key        string
+	HostID     string
+	LabelValue string
+	Result     AnalysisResult
+}
+
+// SeverityCounts counts the partition's anomalies by severity.
+func (p Partition) SeverityCounts() map[string]int {
+	counts := make(map[string]int, p.Result.Severity.Len())
+	for _, a := range p.Result.Anomalies {
+		counts[a.Severity]++
+	}
+	return counts
+}
+
+type FleetResult struct {
+	PartitionLabel string
+	Samples        int
+	Hosts          int
+	FirstTimestamp time.Time
+	LastTimestamp  time.Time
+	// SkippedLines lists input line numbers that were dropped as malformed;
+	// it is set by the caller.
+	SkippedLines []int
+	// Partitions are ordered worst first: by the count of the most severe
+	// level (critical), then of each lower level, then by key.
+	Partitions []Partition
+	// Severity is the scale the partitions were graded on.
+	Severity severity.Scale
+}
+
+// AnalyzeFleet partitions samples by host_id and, when partitionLabel is set,
+// by that label's value, then analyzes each partition independently.
+func AnalyzeFleet(samples []collector.MetricSample, opts Options, partitionLabel string) FleetResult {
+	fleet := FleetResult{PartitionLabel: partitionLabel, Samples: len(samples), Severity: opts.Rules.Severity.Scale}
+
+	type group struct {
+		host, label string
+		samples     []collector.MetricSample
+		rollups     []rollup.Record
+	}
+	groups := map[string]*group{}
+	groupFor := func(host string, labels map[string]string) *group {
+		labelValue := ""
+		if partitionLabel != "" {
+			labelValue = labels[partitionLabel]
+		}
+		key := partitionKey(host, partitionLabel, labelValue)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{host: host, label: labelValue}
+			groups[key] = g
+		}
+		return g
+	}
+	hosts := map[string]bool{}
+	for _, s := range samples {
+		g := groupFor(s.HostID, s.Labels)
+		g.samples = append(g.samples, s)
+		hosts[s.HostID] = true
+		if fleet.FirstTimestamp.IsZero() || s.Timestamp.Before(fleet.FirstTimestamp) {
+			fleet.FirstTimestamp = s.Timestamp
+		}
+		if s.Timestamp.After(fleet.LastTimestamp) {
+			fleet.LastTimestamp = s.Timestamp
+		}
+	}
+	for _, r := range opts.Rollups {
+		g := groupFor(r.HostID, r.Labels)
+		g.rollups = append(g.rollups, r)
+		hosts[r.HostID] = true
+	}
+	fleet.Hosts = len(hosts)
+
+	for key, g := range groups {
+		partOpts := opts
+		partOpts.Rollups = g.rollups
+		fleet.Partitions = append(fleet.Partitions, Partition{
+			Key:        key,
+			HostID:     g.host,
+			LabelValue: g.label,
+			Result:     AnalyzeWithOptions(g.samples, partOpts),
+		})
+	}
+	SortPartitions(fleet.Partitions)
+	return fleet
+}
+
+// SortPartitions orders partitions worst first. Call it again after filtering
+// anomalies so the ranking reflects what is shown.
+func SortPartitions(partitions []Partition) {
+	counts := make(map[string]map[string]int, len(partitions))
+	for _, p := range partitions {
+		counts[p.Key] = p.SeverityCounts()
+	}
+	sort.SliceStable(partitions, func(i, j int) bool {
+		ci, cj := counts[partitions[i].Key], counts[partitions[j].Key]
+		for _, sev := range severestFirst(partitions[i].Result.Severity) {
+			if ci[sev] != cj[sev] {
+				return ci[sev] > cj[sev]
+			}
+		}
+		return partitions[i].Key < partitions[j].Key
+	})
+}
+
+func partitionKey(host, label, value string) string {
+	if host == "" {
+		host = "(no host)"
+	}
+	if label == "" {
+		return host
+	}
+	if value == "" {
+		value = "(none)"
+	}
+	return fmt.Sprintf("%s %s=%s", host, label, value)
+}
+
+// FleetAnomalies returns every partition's anomalies in timestamp order.
+func FleetAnomalies(fleet FleetResult) []anomaly.Anomaly {
+	var out []anomaly.Anomaly
+	for _, p := range fleet.Partitions {
+		out = append(out, p.Result.Anomalies...)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Timestamp.Before(out[j].Timestamp) })
+	return out
+}
+
+func fleetAnomalyCount(fleet FleetResult) int {
+	n := 0
+	for _, p := range fleet.Partitions {
+		n += len(p.Result.Anomalies)
+	}
+	return n
+}
+
+func worstPartitions(fleet FleetResult, limit int) []Partition {
+	out := make([]Partition, 0, limit)
+	for _, p := range fleet.Partitions {
+		if len(out) == limit {
+			break
+		}
+		if len(p.Result.Anomalies) == 0 {
+			break
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func FormatFleetSummary(fleet FleetResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Samples: %d\n", fleet.Samples)
+	fmt.Fprintf(&b, "Hosts: %d\n", fleet.Hosts)
+	if fleet.PartitionLabel != "" {
+		fmt.Fprintf(&b, "Partitions: %d (by host_id and %s)\n", len(fleet.Partitions), fleet.PartitionLabel)
+	}
+	if !fleet.FirstTimestamp.IsZero() {
+		fmt.Fprintf(&b, "Duration: %s\n", fleet.LastTimestamp.Sub(fleet.FirstTimestamp))
+	}
+	if len(fleet.SkippedLines) > 0 {
+		fmt.Fprintf(&b, "Skipped lines: %d (%s)\n", len(fleet.SkippedLines), formatLineNumbers(fleet.SkippedLines))
+	}
+	fmt.Fprintf(&b, "Anomalies: %d\n", fleetAnomalyCount(fleet))
+	worst := worstPartitions(fleet, 5)
+	if len(worst) == 0 {
+		return b.String()
+	}
+	b.WriteString("Worst hosts:\n")
+	for i, p := range worst {
+		fmt.Fprintf(&b, "%d. %s: %s\n", i+1, p.Key, formatSeverityCounts(p.SeverityCounts(), fleet.Severity))
+	}
+	return b.String()
+}
+
+func FormatFleetMarkdown(fleet FleetResult) string {
+	var b strings.Builder
+	b.WriteString("# Endpoint Fleet Report\n\n")
+	b.WriteString("## Summary\n")
+	fmt.Fprintf(&b, "- Samples: %d\n", fleet.Samples)
+	fmt.Fprintf(&b, "- Hosts: %d\n", fleet.Hosts)
+	if fleet.PartitionLabel != "" {
+		fmt.Fprintf(&b, "- Partitions: %d (by host_id and `%s`)\n", len(fleet.Partitions), fleet.PartitionLabel)
+	}
+	if !fleet.FirstTimestamp.IsZero() {
+		fmt.Fprintf(&b, "- First sample: %s\n", fleet.FirstTimestamp.Format(time.RFC3339))
+		fmt.Fprintf(&b, "- Last sample: %s\n", fleet.LastTimestamp.Format(time.RFC3339))
+	}
+	if len(fleet.SkippedLines) > 0 {
+		fmt.Fprintf(&b, "- Skipped malformed lines: %d (%s)\n", len(fleet.SkippedLines), formatLineNumbers(fleet.SkippedLines))
+	}
+	fmt.Fprintf(&b, "- Anomalies: %d\n\n", fleetAnomalyCount(fleet))
+
+	levels := severestFirst(fleet.Severity)
+	worst := worstPartitions(fleet, 10)
+	b.WriteString("## Worst Hosts\n")
+	if len(worst) == 0 {
+		b.WriteString("No anomalies detected on any host.\n\n")
+	} else {
+		fmt.Fprintf(&b, "| Rank | Host | %s | Top anomaly |\n", severityHeader(levels))
+		fmt.Fprintf(&b, "| ---: | --- | %s | --- |\n", repeatCell("---:", len(levels)))
+		for i, p := range worst {
+			fmt.Fprintf(&b, "| %d | %s | %s | %s |\n",
+				i+1, p.Key, severityCells(p.SeverityCounts(), levels), formatTopAnomalyCell(p.Result.Anomalies))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Hosts\n")
+	fmt.Fprintf(&b, "| Host | Samples | Duration | Anomalies | %s | CPU mean | Mem mean |\n", severityHeader(levels))
+	fmt.Fprintf(&b, "| --- | ---: | --- | ---: | %s | ---: | ---: |\n", repeatCell("---:", len(levels)))
+	for _, p := range fleet.Partitions {
+		fmt.Fprintf(&b, "| %s | %d | %s | %d | %s | %s | %s |\n",
+			p.Key,
+			p.Result.Samples,
+			p.Result.Duration,
+			len(p.Result.Anomalies),
+			severityCells(p.SeverityCounts(), levels),
+			formatBaselineMean(p.Result.Baselines, "cpu_percent"),
+			formatBaselineMean(p.Result.Baselines, "mem_used_percent"),
+		)
+	}
+	return b.String()
+}
+
+type fleetPartitionJSON struct {
+	Key            string             `json:"key"`
+	HostID         string             `json:"host_id,omitempty"`
+	LabelValue     string             `json:"label_value,omitempty"`
+	Rank           int                `json:"rank"`
+	SeverityCounts map[string]int     `json:"severity_counts"`
+	Result         analysisResultJSON `json:"result"`
+}
+
+func FormatFleetJSON(fleet FleetResult) ([]byte, error) {
+	type fleetJSON struct {
+		PartitionLabel string               `json:"partition_label,omitempty"`
+		Samples        int                  `json:"samples"`
+		Hosts          int                  `json:"hosts"`
+		FirstTimestamp string               `json:"first_timestamp,omitempty"`
+		LastTimestamp  string               `json:"last_timestamp,omitempty"`
+		SkippedRecords int                  `json:"skipped_records"`
+		SkippedLines   []int                `json:"skipped_lines,omitempty"`
+		Anomalies      int                  `json:"anomalies"`
+		WorstHosts     []string             `json:"worst_hosts"`
+		Partitions     []fleetPartitionJSON `json:"partitions"`
+	}
+	out := fleetJSON{
+		PartitionLabel: fleet.PartitionLabel,
+		Samples:        fleet.Samples,
+		Hosts:          fleet.Hosts,
+		SkippedRecords: len(fleet.SkippedLines),
+		SkippedLines:   fleet.SkippedLines,
+		Anomalies:      fleetAnomalyCount(fleet),
+		WorstHosts:     []string{},
+		Partitions:     make([]fleetPartitionJSON, 0, len(fleet.Partitions)),
+	}
+	if !fleet.FirstTimestamp.IsZero() {
+		out.FirstTimestamp = fleet.FirstTimestamp.Format(time.RFC3339)
+		out.LastTimestamp = fleet.LastTimestamp.Format(time.RFC3339)
+	}
+	for _, p := range worstPartitions(fleet, 10) {
+		out.WorstHosts = append(out.WorstHosts, p.Key)
+	}
+	for i, p := range fleet.Partitions {
+		out.Partitions = append(out.Partitions, fleetPartitionJSON{
+			Key:            p.Key,
+			HostID:         p.HostID,
+			LabelValue:     p.LabelValue,
+			Rank:           i + 1,
+			SeverityCounts: p.SeverityCounts(),
+			Result:         toAnalysisResultJSON(p.Result),
+		})
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// severestFirst lists the levels of scale from most to least severe.
+func severestFirst(scale severity.Scale) []string {
+	levels := scale.Levels()
+	for i, j := 0, len(levels)-1; i < j; i, j = i+1, j-1 {
+		levels[i], levels[j] = levels[j], levels[i]
+	}
+	return levels
+}
+
+func severityHeader(levels []string) string {
+	titles := make([]string, len(levels))
+	for i, level := range levels {
+		titles[i] = strings.ToUpper(level[:1]) + level[1:]
+	}
+	return strings.Join(titles, " | ")
+}
+
+func severityCells(counts map[string]int, levels []string) string {
+	cells := make([]string, len(levels))
+	for i, level := range levels {
+		cells[i] = strconv.Itoa(counts[level])
+	}
+	return strings.Join(cells, " | ")
+}
+
+func repeatCell(cell string, n int) string {
+	cells := make([]string, n)
+	for i := range cells {
+		cells[i] = cell
+	}
+	return strings.Join(cells, " | ")
+}
+
+func formatSeverityCounts(counts map[string]int, scale severity.Scale) string {
+	levels := severestFirst(scale)
+	parts := make([]string, 0, len(levels))
+	for _, sev := range levels {
+		if counts[sev] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[sev], sev))
+		}
+	}
+	if len(parts) == 0 {
+		return "no anomalies"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatTopAnomalyCell(anomalies []anomaly.Anomaly) string {
+	if len(anomalies) == 0 {
+		return ""
+	}
+	top := anomalies[0]
+	for _, a := range anomalies[1:] {
+		if worseAnomaly(a, top) {
+			top = a
+		}
+	}
+	return fmt.Sprintf("%s %s (%s)", top.Name, formatMetricValue(top.Name, top.Value), top.Severity)
+}
+
+// worseAnomaly reports whether a should rank ahead of b: higher score (and so
+// severity) first, then larger magnitude.
+func worseAnomaly(a, b anomaly.Anomaly) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return abs(a.ZScore) > abs(b.ZScore)
+}
+
+func formatBaselineMean(baselines map[string]MetricStats, name string) string {
+	stats, ok := baselines[name]
+	if !ok {
+		return "-"
+	}
+	return formatMetricValue(name, stats.Mean)
+}
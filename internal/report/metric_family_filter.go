@@ -23,6 +23,18 @@ func FilterByMetricFamilies(result AnalysisResult, families collector.MetricFami
 		out.Baselines = filtered
 	}
 
+	if h := out.History; h != nil && len(h.Baselines) > 0 {
+		filtered := make(map[string]MetricStats, len(h.Baselines))
+		for name, stats := range h.Baselines {
+			if metricEnabledByFamily(name, families) {
+				filtered[name] = stats
+			}
+		}
+		history := *h
+		history.Baselines = filtered
+		out.History = &history
+	}
+
 	if len(out.Anomalies) > 0 {
 		filtered := make([]anomaly.Anomaly, 0, len(out.Anomalies))
 		for _, a := range out.Anomalies {
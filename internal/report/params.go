@@ -0,0 +1,107 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/severity"
+)
+
+// MetricParams are the effective detection settings of one metric after
+// per-metric rules are applied.
+type MetricParams struct {
+	WindowSize           int     `json:"window_size"`
+	ZScoreThreshold      float64 `json:"zscore_threshold"`
+	Detector             string  `json:"detector"`
+	MinSeverity          string  `json:"min_severity,omitempty"`
+	StaticThreshold      float64 `json:"static_threshold,omitempty"`
+	StaticLowerThreshold float64 `json:"static_lower_threshold,omitempty"`
+}
+
+// effectiveParams returns the settings of every metric in baselines when at
+// least one metric has its own window, threshold, detector or minimum
+// severity, and nil when all of them use the global settings.
+func effectiveParams(baselines map[string]MetricStats, windowSize int, threshold float64, opts Options) map[string]MetricParams {
+	if len(opts.Algorithms.MetricParams) == 0 && len(opts.MinSeverities) == 0 && len(perMetricAlgorithms(opts.Algorithms)) == 0 {
+		return nil
+	}
+	base := anomaly.Params{WindowSize: windowSize, Threshold: threshold}
+	out := make(map[string]MetricParams, len(baselines))
+	for name := range baselines {
+		p := opts.Algorithms.ParamsFor(name, base)
+		out[name] = MetricParams{
+			WindowSize:           p.WindowSize,
+			ZScoreThreshold:      p.Threshold,
+			Detector:             opts.Algorithms.For(name),
+			MinSeverity:          opts.MinSeverities[name],
+			StaticThreshold:      opts.Rules.StaticThresholds[name],
+			StaticLowerThreshold: opts.Rules.StaticLowerThresholds[name],
+		}
+	}
+	return out
+}
+
+// belowMinSeverity reports whether a is less severe than its metric's
+// minimum in minSeverities.
+func belowMinSeverity(a *anomaly.Anomaly, minSeverities map[string]string, scale severity.Scale) bool {
+	minimum, ok := minSeverities[a.Name]
+	if !ok {
+		return false
+	}
+	minRank, ok := scale.Rank(minimum)
+	if !ok {
+		return false
+	}
+	rank, _ := scale.Rank(a.Severity)
+	return rank < minRank
+}
+
+func writeParamsTable(b *strings.Builder, params map[string]MetricParams) {
+	b.WriteString("| Metric | Window | Threshold | Detector | Min severity | Static | Floor |\n")
+	b.WriteString("| --- | --- | --- | --- | --- | --- | --- |\n")
+	for _, name := range sortedParamNames(params) {
+		p := params[name]
+		fmt.Fprintf(b, "| %s | %d | %.2f | %s | %s | %s | %s |\n",
+			name, p.WindowSize, p.ZScoreThreshold, p.Detector,
+			orDash(p.MinSeverity), formatOptionalValue(name, p.StaticThreshold), formatOptionalValue(name, p.StaticLowerThreshold))
+	}
+}
+
+func formatParamsInline(name string, p MetricParams) string {
+	parts := []string{fmt.Sprintf("window %d", p.WindowSize), fmt.Sprintf("threshold %.2f", p.ZScoreThreshold), p.Detector}
+	if p.MinSeverity != "" {
+		parts = append(parts, "min "+p.MinSeverity)
+	}
+	if p.StaticThreshold != 0 {
+		parts = append(parts, "static "+formatMetricValue(name, p.StaticThreshold))
+	}
+	if p.StaticLowerThreshold != 0 {
+		parts = append(parts, "floor "+formatMetricValue(name, p.StaticLowerThreshold))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func sortedParamNames(params map[string]MetricParams) []string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func formatOptionalValue(name string, v float64) string {
+	if v == 0 {
+		return "-"
+	}
+	return formatMetricValue(name, v)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -5,11 +5,18 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/baseline"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/explain"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/rollup"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/seasonal"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/severity"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/silence"
 )
 
 const (
@@ -23,6 +30,46 @@ type MetricStats struct {
 	Stddev float64 `json:"stddev"`
 	Min    float64 `json:"min"`
 	Max    float64 `json:"max"`
+	P95    float64 `json:"p95"`
+}
+
+// Options configures AnalyzeWithOptions.
+type Options struct {
+	WindowSize int
+	Threshold  float64
+	// Rules are the static, percentile, direction and sustain rules applied
+	// on top of the baseline detector.
+	Rules anomaly.Rules
+	// Algorithms selects the detector algorithm per metric (zscore when empty).
+	Algorithms anomaly.AlgorithmConfig
+	// Seasonal seeds seasonal detectors with baselines trained earlier (see
+	// `analyze --save-seasonal`), per host.
+	Seasonal *seasonal.Model
+	// Reference is an imported baseline (see `epagent baseline export`). It
+	// seeds every detector and the result reports drift from it.
+	Reference *baseline.Baseline
+	// IncidentWindow groups anomalies on one host this close together into
+	// incidents (anomaly.DefaultIncidentWindow when zero).
+	IncidentWindow time.Duration
+	// MinSeverities drops anomalies below a per-metric minimum severity.
+	MinSeverities map[string]string
+	// Rollups are compacted history (see `epagent compact`). They do not feed
+	// the rolling detector; they provide long-range baselines.
+	Rollups []rollup.Record
+	// Explainer rewrites explanations and hints from templates; nil keeps
+	// the built-in English text.
+	Explainer *explain.Explainer
+	// Silences mark matching anomalies with SilencedBy; they are kept.
+	Silences silence.List
+}
+
+// History summarizes long-range baselines computed from rollup records.
+type History struct {
+	From      time.Time
+	To        time.Time
+	Rollups   int
+	Samples   int
+	Baselines map[string]MetricStats
 }
 
 type AnalysisResult struct {
@@ -30,21 +77,60 @@ type AnalysisResult struct {
 	Duration        time.Duration
 	WindowSize      int
 	ZScoreThreshold float64
+	Algorithms      anomaly.AlgorithmConfig
 	HostID          string
 	Labels          map[string]string
 	TotalAnomalies  int
 	Anomalies       []anomaly.Anomaly
 	Baselines       map[string]MetricStats
-	FirstTimestamp  time.Time
-	LastTimestamp   time.Time
+	// Hosts counts distinct host_id values; HostBaselines is only set when
+	// the input mixes more than one host.
+	Hosts          int
+	HostBaselines  map[string]map[string]MetricStats
+	FirstTimestamp time.Time
+	LastTimestamp  time.Time
+	History        *History
+	// IncidentWindow is Options.IncidentWindow; reports group anomalies into
+	// incidents when they are formatted, after any filtering.
+	IncidentWindow time.Duration
+	// Explainer is Options.Explainer; it also renders incident summaries.
+	Explainer *explain.Explainer
+	// Drift compares the analyzed samples with Options.Reference.
+	Drift []Drift
+	// BaselineExcluded counts, per metric, flagged values the contamination
+	// policy kept out of the baseline or reduced before learning.
+	BaselineExcluded map[string]int
+	// MetricParams are the effective settings per metric; only set when some
+	// metric overrides the global window, threshold, detector or severity.
+	MetricParams map[string]MetricParams
+	// Severity is the scale the anomalies were graded on; filters and fleet
+	// rankings use its levels.
+	Severity severity.Scale
+	// SkippedLines lists input line numbers that were dropped as malformed
+	// while reading samples. It is populated by the caller, not Analyze.
+	SkippedLines []int
 }
 
 func Analyze(samples []collector.MetricSample, windowSize int, threshold float64, staticThresholds map[string]float64) AnalysisResult {
-	windowSize, threshold = NormalizeParams(windowSize, threshold)
+	return AnalyzeWithOptions(samples, Options{
+		WindowSize: windowSize,
+		Threshold:  threshold,
+		Rules:      anomaly.Rules{StaticThresholds: staticThresholds},
+	})
+}
+
+func AnalyzeWithOptions(samples []collector.MetricSample, opts Options) AnalysisResult {
+	windowSize, threshold := NormalizeParams(opts.WindowSize, opts.Threshold)
+	rules := opts.Rules
 	result := AnalysisResult{
 		Samples:         len(samples),
 		WindowSize:      windowSize,
 		ZScoreThreshold: threshold,
+		Algorithms:      opts.Algorithms,
+		History:         summarizeHistory(opts.Rollups),
+		IncidentWindow:  opts.IncidentWindow,
+		Explainer:       opts.Explainer,
+		Severity:        rules.Severity.Scale,
 	}
 	if len(samples) == 0 {
 		return result
@@ -59,76 +145,67 @@ func Analyze(samples []collector.MetricSample, windowSize int, threshold float64
 	result.HostID = stableHostID(ordered)
 	result.Labels = stableLabels(ordered)
 
-	detector := anomaly.NewDetector(windowSize, threshold)
 	result.FirstTimestamp = ordered[0].Timestamp
 	result.LastTimestamp = ordered[len(ordered)-1].Timestamp
 	result.Duration = result.LastTimestamp.Sub(result.FirstTimestamp)
 
-	metricValues := map[string][]float64{}
-	firstFamilies := collector.DefaultMetricFamilies()
-	if ordered[0].MetricFamilies != nil {
-		firstFamilies = *ordered[0].MetricFamilies
-	}
-	if firstFamilies.CPU {
-		metricValues["cpu_percent"] = []float64{ordered[0].CPUPercent}
-	}
-	if firstFamilies.Mem {
-		metricValues["mem_used_percent"] = []float64{ordered[0].MemUsedPercent}
-	}
-	if firstFamilies.Disk {
-		metricValues["disk_used_percent"] = []float64{ordered[0].DiskUsedPercent}
-	}
-
-	prev := ordered[0]
-	for i := 1; i < len(ordered); i++ {
+	// Files merged from several machines interleave hosts, so rates, detector
+	// history and baselines are all tracked per host_id.
+	evaluators := map[string]*anomaly.Evaluator{}
+	expressions := map[string]*anomaly.ExpressionEvaluator{}
+	prevByHost := map[string]*collector.MetricSample{}
+	hostValues := map[string]map[string][]float64{}
+	for i := range ordered {
 		current := ordered[i]
-		currentFamilies := collector.DefaultMetricFamilies()
-		if current.MetricFamilies != nil {
-			currentFamilies = *current.MetricFamilies
-		}
-		prevFamilies := collector.DefaultMetricFamilies()
-		if prev.MetricFamilies != nil {
-			prevFamilies = *prev.MetricFamilies
-		}
-		dt := current.Timestamp.Sub(prev.Timestamp).Seconds()
-		if dt <= 0 {
-			dt = 1
-		}
+		prev := prevByHost[current.HostID]
+		prevByHost[current.HostID] = &ordered[i]
+		metrics := collector.DeriveMetrics(prev, current)
 
-		metrics := map[string]float64{}
-		if currentFamilies.CPU {
-			metrics["cpu_percent"] = current.CPUPercent
+		values, ok := hostValues[current.HostID]
+		if !ok {
+			values = map[string][]float64{}
+			hostValues[current.HostID] = values
 		}
-		if currentFamilies.Mem {
-			metrics["mem_used_percent"] = current.MemUsedPercent
-		}
-		if currentFamilies.Disk {
-			metrics["disk_used_percent"] = current.DiskUsedPercent
-			if prevFamilies.Disk {
-				metrics["disk_read_bytes_per_sec"] = float64(delta(current.DiskReadBytes, prev.DiskReadBytes)) / dt
-				metrics["disk_write_bytes_per_sec"] = float64(delta(current.DiskWriteBytes, prev.DiskWriteBytes)) / dt
+		evaluator, ok := evaluators[current.HostID]
+		if !ok {
+			algorithms := opts.Algorithms
+			if opts.Seasonal != nil {
+				algorithms.Profiles = opts.Seasonal.Profiles(current.HostID)
 			}
-		}
-		if currentFamilies.Net && prevFamilies.Net {
-			metrics["net_rx_bytes_per_sec"] = float64(delta(current.NetRxBytes, prev.NetRxBytes)) / dt
-			metrics["net_tx_bytes_per_sec"] = float64(delta(current.NetTxBytes, prev.NetTxBytes)) / dt
+			algorithms = opts.Reference.Apply(algorithms)
+			evaluator = anomaly.NewEvaluator(windowSize, threshold, algorithms, rules)
+			evaluators[current.HostID] = evaluator
+			expressions[current.HostID] = anomaly.NewExpressionEvaluator(rules)
 		}
 
 		for name, value := range metrics {
-			metricValues[name] = append(metricValues[name], value)
-			zScoreAnomaly := detector.Check(name, value)
-			staticAnomaly := anomaly.CheckStaticThreshold(name, value, staticThresholds)
-			if a := anomaly.SelectHigherSeverity(zScoreAnomaly, staticAnomaly); a != nil {
-				a.Timestamp = current.Timestamp
-				a.Labels = cloneLabels(current.Labels)
-				a.TopCPUProcess = toAnomalyProcess(current.TopCPUProcess)
-				a.TopMemProcess = toAnomalyProcess(current.TopMemProcess)
-				result.Anomalies = append(result.Anomalies, *a)
+			values[name] = append(values[name], value)
+			if prev == nil {
+				// First sample for this host only contributes to baselines.
+				// It still counts toward warm-up, as in watch.
+				evaluator.Seen(name, current.Timestamp, value)
+				continue
+			}
+			if a := evaluator.Evaluate(name, current.Timestamp, value); a != nil && !belowMinSeverity(a, opts.MinSeverities, rules.Severity.Scale) {
+				result.Anomalies = append(result.Anomalies, withSampleContext(*a, current, opts))
+			}
+		}
+		if prev == nil {
+			continue
+		}
+		for _, a := range expressions[current.HostID].Check(current.Timestamp, metrics) {
+			if !belowMinSeverity(&a, opts.MinSeverities, rules.Severity.Scale) {
+				result.Anomalies = append(result.Anomalies, withSampleContext(a, current, opts))
 			}
 		}
-		prev = current
 	}
 
+	metricValues := map[string][]float64{}
+	for _, values := range hostValues {
+		for name, v := range values {
+			metricValues[name] = append(metricValues[name], v...)
+		}
+	}
 	result.Baselines = make(map[string]MetricStats, len(metricValues))
 	for name, values := range metricValues {
 		if len(values) == 0 {
@@ -136,11 +213,93 @@ func Analyze(samples []collector.MetricSample, windowSize int, threshold float64
 		}
 		result.Baselines[name] = computeStats(values)
 	}
+	result.Hosts = len(hostValues)
+	if len(hostValues) > 1 {
+		result.HostBaselines = make(map[string]map[string]MetricStats, len(hostValues))
+		for host, values := range hostValues {
+			stats := make(map[string]MetricStats, len(values))
+			for name, v := range values {
+				stats[name] = computeStats(v)
+			}
+			result.HostBaselines[host] = stats
+		}
+	}
+
+	result.Drift = computeDrift(opts.Reference, result.Baselines, threshold)
+	result.MetricParams = effectiveParams(result.Baselines, windowSize, threshold, opts)
+	for _, evaluator := range evaluators {
+		for name, n := range evaluator.Excluded() {
+			if result.BaselineExcluded == nil {
+				result.BaselineExcluded = map[string]int{}
+			}
+			result.BaselineExcluded[name] += n
+		}
+	}
 
 	result.TotalAnomalies = len(result.Anomalies)
 	return result
 }
 
+// withSampleContext stamps a with the time, host, labels and attributed
+// processes of the sample it was raised on, explains it with them, and marks
+// it when a silence matches.
+func withSampleContext(a anomaly.Anomaly, s collector.MetricSample, opts Options) anomaly.Anomaly {
+	a.Timestamp = s.Timestamp
+	a.HostID = s.HostID
+	a.Labels = cloneLabels(s.Labels)
+	a.TopCPUProcess = toAnomalyProcess(s.TopCPUProcess)
+	a.TopMemProcess = toAnomalyProcess(s.TopMemProcess)
+	opts.Explainer.Explain(&a)
+	a.SilencedBy = opts.Silences.Match(&a)
+	return a
+}
+
+// groupIncidents groups anomalies into incidents with result's window and
+// renders their summaries with result's explainer.
+func groupIncidents(anomalies []anomaly.Anomaly, result AnalysisResult) ([]anomaly.Incident, []anomaly.Anomaly) {
+	incidents, rest := anomaly.GroupIncidents(anomalies, result.IncidentWindow)
+	for i := range incidents {
+		result.Explainer.ExplainIncident(&incidents[i])
+	}
+	return incidents, rest
+}
+
+func summarizeHistory(records []rollup.Record) *History {
+	if len(records) == 0 {
+		return nil
+	}
+	h := &History{Rollups: len(records), From: records[0].Start, To: records[0].End}
+	parts := map[string][]rollup.Aggregate{}
+	for _, r := range records {
+		if r.Start.Before(h.From) {
+			h.From = r.Start
+		}
+		if r.End.After(h.To) {
+			h.To = r.End
+		}
+		h.Samples += r.Samples
+		for name, agg := range r.Metrics {
+			parts[name] = append(parts[name], agg)
+		}
+	}
+	h.Baselines = make(map[string]MetricStats, len(parts))
+	for name, aggs := range parts {
+		agg := rollup.Combine(aggs)
+		if agg.Count == 0 {
+			continue
+		}
+		h.Baselines[name] = MetricStats{
+			Count:  agg.Count,
+			Mean:   agg.Mean,
+			Stddev: agg.Stddev,
+			Min:    agg.Min,
+			Max:    agg.Max,
+			P95:    agg.P95,
+		}
+	}
+	return h
+}
+
 func NormalizeParams(windowSize int, threshold float64) (int, float64) {
 	if windowSize < minWindowSize {
 		windowSize = minWindowSize
@@ -151,21 +310,22 @@ func NormalizeParams(windowSize int, threshold float64) (int, float64) {
 	return windowSize, threshold
 }
 
-func delta(current, previous uint64) uint64 {
-	if current < previous {
-		return 0
-	}
-	return current - previous
-}
-
 func FormatSummary(result AnalysisResult) string {
 	var b strings.Builder
 	fmt.Fprintf(&b, "Samples: %d\n", result.Samples)
+	if len(result.SkippedLines) > 0 {
+		fmt.Fprintf(&b, "Skipped lines: %d (%s)\n", len(result.SkippedLines), formatLineNumbers(result.SkippedLines))
+	}
 	if result.Samples == 0 {
+		if h := result.History; h != nil {
+			fmt.Fprintf(&b, "Long-range baselines: %d metrics from %d rollups (%s to %s)\n", len(h.Baselines), h.Rollups, h.From.Format(time.RFC3339), h.To.Format(time.RFC3339))
+		}
 		return b.String()
 	}
 	if result.HostID != "" {
 		fmt.Fprintf(&b, "Host: %s\n", result.HostID)
+	} else if result.Hosts > 1 {
+		fmt.Fprintf(&b, "Hosts: %d\n", result.Hosts)
 	}
 	if len(result.Labels) > 0 {
 		fmt.Fprintf(&b, "Labels: %s\n", formatLabelsInline(result.Labels))
@@ -173,19 +333,65 @@ func FormatSummary(result AnalysisResult) string {
 	fmt.Fprintf(&b, "Duration: %s\n", result.Duration)
 	fmt.Fprintf(&b, "Window size: %d\n", result.WindowSize)
 	fmt.Fprintf(&b, "Z-score threshold: %.2f\n", result.ZScoreThreshold)
+	if !result.Algorithms.IsDefault() {
+		fmt.Fprintf(&b, "Detectors: %s\n", formatAlgorithms(result.Algorithms))
+	}
+	if len(result.MetricParams) > 0 {
+		b.WriteString("Per-metric parameters:\n")
+		for _, name := range sortedParamNames(result.MetricParams) {
+			fmt.Fprintf(&b, "- %s: %s\n", name, formatParamsInline(name, result.MetricParams[name]))
+		}
+	}
 	if len(result.Baselines) > 0 {
 		fmt.Fprintf(&b, "Baselines: %d metrics\n", len(result.Baselines))
 	}
+	if h := result.History; h != nil {
+		fmt.Fprintf(&b, "Long-range baselines: %d metrics from %d rollups (%s to %s)\n", len(h.Baselines), h.Rollups, h.From.Format(time.RFC3339), h.To.Format(time.RFC3339))
+	}
+	if len(result.Drift) > 0 {
+		fmt.Fprintf(&b, "Drift from baseline: %d of %d metrics\n", countDrifted(result.Drift), len(result.Drift))
+		for _, d := range result.Drift {
+			if d.Drifted {
+				fmt.Fprintf(&b, "- %s: mean %s vs baseline %s (%+.2fσ)\n", d.Metric, formatMetricValue(d.Metric, d.Mean), formatMetricValue(d.Metric, d.ReferenceMean), d.Shift)
+			}
+		}
+	}
 	fmt.Fprintf(&b, "Anomalies: %d\n", len(result.Anomalies))
 	if result.TotalAnomalies > 0 && result.TotalAnomalies != len(result.Anomalies) {
 		fmt.Fprintf(&b, "Anomalies total: %d\n", result.TotalAnomalies)
 	}
-	if len(result.Anomalies) == 0 {
+	if n := countSilenced(result.Anomalies); n > 0 {
+		fmt.Fprintf(&b, "Silenced: %d\n", n)
+	}
+	if n := countWarmup(result.Anomalies); n > 0 {
+		fmt.Fprintf(&b, "Held back during warm-up: %d\n", n)
+	}
+	spikes, changes := splitRegimeChanges(result.Anomalies)
+	if len(changes) > 0 {
+		fmt.Fprintf(&b, "Regime changes: %d\n", len(changes))
+		for _, a := range changes {
+			cp := a.ChangePoint
+			fmt.Fprintf(&b, "- %s: %s -> %s at %s (%s)\n", a.Name, formatMetricValue(a.Name, cp.BeforeMean), formatMetricValue(a.Name, cp.AfterMean), cp.ChangedAt.Format(time.RFC3339), a.Severity)
+		}
+	}
+	if len(spikes) == 0 {
 		return b.String()
 	}
-	sorted := make([]anomaly.Anomaly, len(result.Anomalies))
-	copy(sorted, result.Anomalies)
-	sort.Slice(sorted, func(i, j int) bool { return abs(sorted[i].ZScore) > abs(sorted[j].ZScore) })
+	incidents, rest := groupIncidents(spikes, result)
+	if len(incidents) > 0 {
+		fmt.Fprintf(&b, "Incidents: %d\n", len(incidents))
+		for _, inc := range incidents {
+			fmt.Fprintf(&b, "- %s (%s): %s\n", inc.Start.Format(time.RFC3339), inc.Severity, inc.Explanation)
+			for _, a := range inc.Anomalies {
+				fmt.Fprintf(&b, "  - %s: %s (%s, %s)\n", a.Name, formatAnomalyValue(a), a.RuleType, a.Severity)
+			}
+		}
+	}
+	if len(rest) == 0 {
+		return b.String()
+	}
+	sorted := rest
+	sort.Slice(sorted, func(i, j int) bool { return worseAnomaly(sorted[i], sorted[j]) })
 	top := sorted
 	if len(top) > 5 {
 		top = top[:5]
@@ -193,15 +399,61 @@ func FormatSummary(result AnalysisResult) string {
 	b.WriteString("Top anomalies:\n")
 	for _, a := range top {
 		if a.RuleType == anomaly.RuleTypeStaticThreshold {
-			fmt.Fprintf(&b, "- %s: %s (static threshold %s, %s)%s\n",
+			kind := "static threshold"
+			if a.Direction == anomaly.DirectionBelow {
+				kind = "static floor"
+			}
+			fmt.Fprintf(&b, "- %s: %s (%s %s, %s)%s\n",
 				a.Name,
 				formatMetricValue(a.Name, a.Value),
+				kind,
 				formatMetricValue(a.Name, a.Threshold),
 				a.Severity,
 				formatAnomalyContextInline(a),
 			)
 			continue
 		}
+		if a.RuleType == anomaly.RuleTypePercentile {
+			fmt.Fprintf(&b, "- %s: %s (percentile rule %s, %s)%s\n",
+				a.Name,
+				formatMetricValue(a.Name, a.Value),
+				formatMetricValue(a.Name, a.Threshold),
+				a.Severity,
+				formatAnomalyContextInline(a),
+			)
+			continue
+		}
+		if a.RuleType == anomaly.RuleTypeExpression && a.Expression != nil {
+			fmt.Fprintf(&b, "- %s: %s (expression %s, %s)%s\n",
+				a.Name,
+				formatAnomalyValue(a),
+				a.Expression.Expression,
+				a.Severity,
+				formatAnomalyContextInline(a),
+			)
+			continue
+		}
+		if a.RuleType == anomaly.RuleTypeForecast && a.Forecast != nil {
+			fmt.Fprintf(&b, "- %s: %s (forecast: %s in %s, %s)%s\n",
+				a.Name,
+				formatMetricValue(a.Name, a.Value),
+				formatMetricValue(a.Name, a.Threshold),
+				anomaly.FormatETA(time.Duration(a.Forecast.SecondsToLimit*float64(time.Second))),
+				a.Severity,
+				formatAnomalyContextInline(a),
+			)
+			continue
+		}
+		if a.RuleType == anomaly.RuleTypeRateOfChange && a.RateOfChange != nil {
+			fmt.Fprintf(&b, "- %s: %s (rate of change: %s, %s)%s\n",
+				a.Name,
+				formatMetricValue(a.Name, a.Value),
+				formatRateOfChange(a),
+				a.Severity,
+				formatAnomalyContextInline(a),
+			)
+			continue
+		}
 		fmt.Fprintf(&b, "- %s: %s (z=%.2f, %s)%s\n", a.Name, formatMetricValue(a.Name, a.Value), a.ZScore, a.Severity, formatAnomalyContextInline(a))
 	}
 	return b.String()
@@ -212,15 +464,23 @@ func FormatMarkdown(result AnalysisResult) string {
 	b.WriteString("# Endpoint Performance Report\n\n")
 	b.WriteString("## Summary\n")
 	fmt.Fprintf(&b, "- Samples: %d\n", result.Samples)
+	if len(result.SkippedLines) > 0 {
+		fmt.Fprintf(&b, "- Skipped malformed lines: %d (%s)\n", len(result.SkippedLines), formatLineNumbers(result.SkippedLines))
+	}
 	if result.Samples > 0 {
 		if result.HostID != "" {
 			fmt.Fprintf(&b, "- Host: %s\n", result.HostID)
+		} else if result.Hosts > 1 {
+			fmt.Fprintf(&b, "- Hosts: %d\n", result.Hosts)
 		}
 		if len(result.Labels) > 0 {
 			fmt.Fprintf(&b, "- Labels: %s\n", formatLabelsInline(result.Labels))
 		}
 		fmt.Fprintf(&b, "- Duration: %s\n", result.Duration)
 		fmt.Fprintf(&b, "- Window size: %d\n", result.WindowSize)
+		if !result.Algorithms.IsDefault() {
+			fmt.Fprintf(&b, "- Detectors: %s\n", formatAlgorithms(result.Algorithms))
+		}
 		fmt.Fprintf(&b, "- Z-score threshold: %.2f\n", result.ZScoreThreshold)
 		fmt.Fprintf(&b, "- First sample: %s\n", result.FirstTimestamp.Format(time.RFC3339))
 		fmt.Fprintf(&b, "- Last sample: %s\n", result.LastTimestamp.Format(time.RFC3339))
@@ -229,46 +489,142 @@ func FormatMarkdown(result AnalysisResult) string {
 	if result.TotalAnomalies > 0 && result.TotalAnomalies != len(result.Anomalies) {
 		fmt.Fprintf(&b, "- Anomalies total: %d\n", result.TotalAnomalies)
 	}
+	if n := countSilenced(result.Anomalies); n > 0 {
+		fmt.Fprintf(&b, "- Silenced: %d (kept below, marked with the silence that matched)\n", n)
+	}
+	if n := countWarmup(result.Anomalies); n > 0 {
+		fmt.Fprintf(&b, "- Held back during warm-up: %d (kept below, marked; `watch` would not have emitted them)\n", n)
+	}
 	b.WriteString("\n")
 
+	if len(result.MetricParams) > 0 {
+		b.WriteString("## Detection Parameters\n")
+		writeParamsTable(&b, result.MetricParams)
+		b.WriteString("\n")
+	}
+
 	if len(result.Baselines) > 0 {
 		b.WriteString("## Baselines\n")
-		b.WriteString("| Metric | Mean | Stddev | Min | Max | Count |\n")
-		b.WriteString("| --- | ---: | ---: | ---: | ---: | ---: |\n")
-		for _, name := range orderedMetricNames(result.Baselines) {
-			stats := result.Baselines[name]
-			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %d |\n",
-				name,
-				formatMetricValue(name, stats.Mean),
-				formatMetricValue(name, stats.Stddev),
-				formatMetricValue(name, stats.Min),
-				formatMetricValue(name, stats.Max),
-				stats.Count,
-			)
-		}
+		writeBaselinesTable(&b, result.Baselines)
 		b.WriteString("\n")
 	}
 
-	if len(result.Anomalies) == 0 {
-		b.WriteString("No anomalies detected.\n")
-		return b.String()
+	if len(result.Drift) > 0 {
+		b.WriteString("## Drift from Baseline\n")
+		fmt.Fprintf(&b, "%d of %d metrics moved at least %.2f baseline standard deviations.\n\n", countDrifted(result.Drift), len(result.Drift), result.ZScoreThreshold)
+		writeDriftTable(&b, result.Drift)
+		b.WriteString("\n")
 	}
 
-	b.WriteString("## Anomalies\n")
-	sort.Slice(result.Anomalies, func(i, j int) bool { return abs(result.Anomalies[i].ZScore) > abs(result.Anomalies[j].ZScore) })
-	for _, a := range result.Anomalies {
-		if a.RuleType == anomaly.RuleTypeStaticThreshold {
-			fmt.Fprintf(&b, "- **%s**: value %s crossed static threshold %s (%s). %s%s\n",
+	if h := result.History; h != nil && len(h.Baselines) > 0 {
+		b.WriteString("## Long-range Baselines\n")
+		fmt.Fprintf(&b, "From %d rollups covering %s to %s (%d raw samples).\n\n", h.Rollups, h.From.Format(time.RFC3339), h.To.Format(time.RFC3339), h.Samples)
+		writeBaselinesTable(&b, h.Baselines)
+		b.WriteString("\n")
+	}
+
+	spikes, changes := splitRegimeChanges(result.Anomalies)
+	if len(changes) > 0 {
+		b.WriteString("## Regime Changes\n")
+		b.WriteString("Lasting level shifts, reported once the new level has held.\n\n")
+		for _, a := range changes {
+			cp := a.ChangePoint
+			fmt.Fprintf(&b, "- **%s**: %s -> %s since %s (%s). %s%s\n",
 				a.Name,
-				formatMetricValue(a.Name, a.Value),
-				formatMetricValue(a.Name, a.Threshold),
+				formatMetricValue(a.Name, cp.BeforeMean),
+				formatMetricValue(a.Name, cp.AfterMean),
+				cp.ChangedAt.Format(time.RFC3339),
 				a.Severity,
 				a.Explanation,
 				formatAnomalyContextParagraph(a),
 			)
-			continue
 		}
-		fmt.Fprintf(&b, "- **%s**: value %s (baseline %s ± %s, z=%.2f, %s). %s%s\n",
+		b.WriteString("\n")
+	}
+
+	if len(spikes) == 0 {
+		b.WriteString("No anomalies detected.\n")
+		return b.String()
+	}
+
+	incidents, rest := groupIncidents(spikes, result)
+	if len(incidents) > 0 {
+		b.WriteString("## Incidents\n")
+		b.WriteString("Anomalies on several metrics of one host close together in time.\n\n")
+		for _, inc := range incidents {
+			fmt.Fprintf(&b, "### %s (%s)\n", inc.ID, inc.Severity)
+			fmt.Fprintf(&b, "%s to %s. %s\n\n", inc.Start.Format(time.RFC3339), inc.End.Format(time.RFC3339), inc.Explanation)
+			for _, a := range inc.Anomalies {
+				writeAnomalyItem(&b, a)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if len(rest) == 0 {
+		return b.String()
+	}
+
+	b.WriteString("## Anomalies\n")
+	sort.Slice(rest, func(i, j int) bool { return worseAnomaly(rest[i], rest[j]) })
+	for _, a := range rest {
+		writeAnomalyItem(&b, a)
+	}
+	return b.String()
+}
+
+func writeAnomalyItem(b *strings.Builder, a anomaly.Anomaly) {
+	switch a.RuleType {
+	case anomaly.RuleTypeStaticThreshold:
+		crossed := "crossed static threshold"
+		if a.Direction == anomaly.DirectionBelow {
+			crossed = "fell below static floor"
+		}
+		fmt.Fprintf(b, "- **%s**: value %s %s %s (%s). %s%s\n",
+			a.Name,
+			formatMetricValue(a.Name, a.Value),
+			crossed,
+			formatMetricValue(a.Name, a.Threshold),
+			a.Severity,
+			a.Explanation,
+			formatAnomalyContextParagraph(a),
+		)
+	case anomaly.RuleTypePercentile:
+		fmt.Fprintf(b, "- **%s**: value %s tripped a percentile rule (%s). %s%s\n",
+			a.Name,
+			formatMetricValue(a.Name, a.Value),
+			a.Severity,
+			a.Explanation,
+			formatAnomalyContextParagraph(a),
+		)
+	case anomaly.RuleTypeForecast:
+		fmt.Fprintf(b, "- **%s**: value %s is trending toward %s (%s). %s%s\n",
+			a.Name,
+			formatMetricValue(a.Name, a.Value),
+			formatMetricValue(a.Name, a.Threshold),
+			a.Severity,
+			a.Explanation,
+			formatAnomalyContextParagraph(a),
+		)
+	case anomaly.RuleTypeRateOfChange:
+		fmt.Fprintf(b, "- **%s**: value %s changed too fast (%s, %s). %s%s\n",
+			a.Name,
+			formatMetricValue(a.Name, a.Value),
+			formatRateOfChange(a),
+			a.Severity,
+			a.Explanation,
+			formatAnomalyContextParagraph(a),
+		)
+	case anomaly.RuleTypeExpression:
+		fmt.Fprintf(b, "- **%s**: %s matched `%s` (%s). %s%s\n",
+			a.Name,
+			formatAnomalyValue(a),
+			a.Expression.Expression,
+			a.Severity,
+			a.Explanation,
+			formatAnomalyContextParagraph(a),
+		)
+	default:
+		fmt.Fprintf(b, "- **%s**: value %s (baseline %s ± %s, z=%.2f, %s). %s%s\n",
 			a.Name,
 			formatMetricValue(a.Name, a.Value),
 			formatMetricValue(a.Name, a.Mean),
@@ -279,33 +635,147 @@ func FormatMarkdown(result AnalysisResult) string {
 			formatAnomalyContextParagraph(a),
 		)
 	}
-	return b.String()
 }
 
-func FormatJSON(result AnalysisResult) ([]byte, error) {
-	type analysisResultJSON struct {
-		Samples         int                    `json:"samples"`
-		Duration        string                 `json:"duration"`
-		WindowSize      int                    `json:"window_size"`
-		ZScoreThreshold float64                `json:"zscore_threshold"`
-		HostID          string                 `json:"host_id,omitempty"`
-		Labels          map[string]string      `json:"labels,omitempty"`
-		TotalAnomalies  int                    `json:"anomalies_total"`
-		FirstTimestamp  string                 `json:"first_timestamp,omitempty"`
-		LastTimestamp   string                 `json:"last_timestamp,omitempty"`
-		Anomalies       []anomaly.Anomaly      `json:"anomalies"`
-		Baselines       map[string]MetricStats `json:"baselines,omitempty"`
+// formatRateOfChange renders a rate-of-change anomaly as "+12.3pp in 60s,
+// limit 10pp per 60s".
+func formatRateOfChange(a anomaly.Anomaly) string {
+	r := a.RateOfChange
+	if r == nil {
+		return ""
 	}
+	sign := "+"
+	if r.Change < 0 {
+		sign = "-"
+	}
+	elapsed := time.Duration(r.ElapsedSeconds * float64(time.Second))
+	per := time.Duration(r.PerSeconds * float64(time.Second))
+	return fmt.Sprintf("%s%s in %s, limit %s per %s",
+		sign, anomaly.FormatChange(a.Name, math.Abs(r.Change), r.Percent), anomaly.FormatSpan(elapsed),
+		anomaly.FormatChange(a.Name, a.Threshold, r.Percent), anomaly.FormatSpan(per))
+}
+
+// formatAnomalyValue formats the value that raised a: the metric value, or
+// every metric an expression rule read.
+func formatAnomalyValue(a anomaly.Anomaly) string {
+	if a.Expression == nil {
+		return formatMetricValue(a.Name, a.Value)
+	}
+	names := make([]string, 0, len(a.Expression.Values))
+	for name := range a.Expression.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+" "+formatMetricValue(name, a.Expression.Values[name]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// splitRegimeChanges separates change-point anomalies, which reports list as
+// regime changes, from spikes and rule breaches. Both keep their order.
+func splitRegimeChanges(anomalies []anomaly.Anomaly) (spikes, changes []anomaly.Anomaly) {
+	for _, a := range anomalies {
+		if a.RuleType == anomaly.RuleTypeChangePoint && a.ChangePoint != nil {
+			changes = append(changes, a)
+			continue
+		}
+		spikes = append(spikes, a)
+	}
+	return spikes, changes
+}
+
+func writeBaselinesTable(b *strings.Builder, baselines map[string]MetricStats) {
+	b.WriteString("| Metric | Mean | Stddev | Min | Max | P95 | Count |\n")
+	b.WriteString("| --- | ---: | ---: | ---: | ---: | ---: | ---: |\n")
+	for _, name := range orderedMetricNames(baselines) {
+		stats := baselines[name]
+		fmt.Fprintf(b, "| %s | %s | %s | %s | %s | %s | %d |\n",
+			name,
+			formatMetricValue(name, stats.Mean),
+			formatMetricValue(name, stats.Stddev),
+			formatMetricValue(name, stats.Min),
+			formatMetricValue(name, stats.Max),
+			formatMetricValue(name, stats.P95),
+			stats.Count,
+		)
+	}
+}
+
+type historyJSON struct {
+	From      string                 `json:"from"`
+	To        string                 `json:"to"`
+	Rollups   int                    `json:"rollups"`
+	Samples   int                    `json:"samples"`
+	Baselines map[string]MetricStats `json:"baselines"`
+}
+
+type analysisResultJSON struct {
+	Samples          int                               `json:"samples"`
+	Duration         string                            `json:"duration"`
+	WindowSize       int                               `json:"window_size"`
+	ZScoreThreshold  float64                           `json:"zscore_threshold"`
+	Detector         string                            `json:"detector"`
+	Detectors        map[string]string                 `json:"detectors,omitempty"`
+	Contamination    map[string]anomaly.Contamination  `json:"contamination,omitempty"`
+	HostID           string                            `json:"host_id,omitempty"`
+	Labels           map[string]string                 `json:"labels,omitempty"`
+	TotalAnomalies   int                               `json:"anomalies_total"`
+	Silenced         int                               `json:"silenced,omitempty"`
+	Warmup           int                               `json:"warmup_suppressed,omitempty"`
+	FirstTimestamp   string                            `json:"first_timestamp,omitempty"`
+	LastTimestamp    string                            `json:"last_timestamp,omitempty"`
+	Incidents        []anomaly.Incident                `json:"incidents,omitempty"`
+	Anomalies        []anomaly.Anomaly                 `json:"anomalies"`
+	Baselines        map[string]MetricStats            `json:"baselines,omitempty"`
+	Hosts            int                               `json:"hosts,omitempty"`
+	HostBaselines    map[string]map[string]MetricStats `json:"host_baselines,omitempty"`
+	History          *historyJSON                      `json:"history,omitempty"`
+	Drift            []Drift                           `json:"drift,omitempty"`
+	BaselineExcluded map[string]int                    `json:"baseline_excluded,omitempty"`
+	MetricParams     map[string]MetricParams           `json:"metric_params,omitempty"`
+	SkippedRecords   int                               `json:"skipped_records,omitempty"`
+	SkippedLines     []int                             `json:"skipped_lines,omitempty"`
+}
+
+func FormatJSON(result AnalysisResult) ([]byte, error) {
+	return json.MarshalIndent(toAnalysisResultJSON(result), "", "  ")
+}
+
+func toAnalysisResultJSON(result AnalysisResult) analysisResultJSON {
 	out := analysisResultJSON{
 		Samples:         result.Samples,
 		Duration:        result.Duration.String(),
 		WindowSize:      result.WindowSize,
 		ZScoreThreshold: result.ZScoreThreshold,
+		Detector:        result.Algorithms.For(""),
+		Detectors:       perMetricAlgorithms(result.Algorithms),
 		HostID:          result.HostID,
 		Labels:          result.Labels,
 		TotalAnomalies:  result.TotalAnomalies,
+		Silenced:        countSilenced(result.Anomalies),
+		Warmup:          countWarmup(result.Anomalies),
 		Anomalies:       result.Anomalies,
 		Baselines:       result.Baselines,
+		Hosts:           result.Hosts,
+		HostBaselines:   result.HostBaselines,
+		Drift:           result.Drift,
+		Contamination:   result.Algorithms.Contamination,
+		SkippedRecords:  len(result.SkippedLines),
+		SkippedLines:    result.SkippedLines,
+	}
+	out.Incidents, _ = groupIncidents(result.Anomalies, result)
+	out.BaselineExcluded = result.BaselineExcluded
+	out.MetricParams = result.MetricParams
+	if h := result.History; h != nil {
+		out.History = &historyJSON{
+			From:      h.From.Format(time.RFC3339),
+			To:        h.To.Format(time.RFC3339),
+			Rollups:   h.Rollups,
+			Samples:   h.Samples,
+			Baselines: h.Baselines,
+		}
 	}
 	if !result.FirstTimestamp.IsZero() {
 		out.FirstTimestamp = result.FirstTimestamp.Format(time.RFC3339)
@@ -313,7 +783,40 @@ func FormatJSON(result AnalysisResult) ([]byte, error) {
 	if !result.LastTimestamp.IsZero() {
 		out.LastTimestamp = result.LastTimestamp.Format(time.RFC3339)
 	}
-	return json.MarshalIndent(out, "", "  ")
+	return out
+}
+
+// perMetricAlgorithms returns the metrics whose algorithm differs from the
+// default, or nil when every metric uses the default.
+func perMetricAlgorithms(c anomaly.AlgorithmConfig) map[string]string {
+	def := c.For("")
+	var out map[string]string
+	for metric := range c.PerMetric {
+		if name := c.For(metric); name != def {
+			if out == nil {
+				out = make(map[string]string)
+			}
+			out[metric] = name
+		}
+	}
+	return out
+}
+
+func formatAlgorithms(c anomaly.AlgorithmConfig) string {
+	perMetric := perMetricAlgorithms(c)
+	if len(perMetric) == 0 {
+		return c.For("")
+	}
+	names := make([]string, 0, len(perMetric))
+	for metric := range perMetric {
+		names = append(names, metric)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, metric := range names {
+		parts = append(parts, metric+"="+perMetric[metric])
+	}
+	return fmt.Sprintf("%s (%s)", c.For(""), strings.Join(parts, ", "))
 }
 
 func stableHostID(samples []collector.MetricSample) string {
@@ -439,6 +942,10 @@ func computeStats(values []float64) MetricStats {
 	}
 	variance = variance / float64(len(values))
 	stats.Stddev = math.Sqrt(variance)
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	stats.P95 = rollup.Percentile(sorted, 95)
 	return stats
 }
 
@@ -472,11 +979,14 @@ func orderedMetricNames(m map[string]MetricStats) []string {
 }
 
 func formatMetricValue(name string, v float64) string {
+	name = anomaly.BaseMetric(name)
 	switch {
 	case strings.HasSuffix(name, "_percent"):
 		return fmt.Sprintf("%.1f%%", v)
 	case strings.HasSuffix(name, "_bytes_per_sec"):
 		return fmt.Sprintf("%s/s", humanBytes(v))
+	case strings.HasSuffix(name, "_bytes"):
+		return humanBytes(v)
 	default:
 		return fmt.Sprintf("%.2f", v)
 	}
@@ -493,6 +1003,12 @@ func formatAnomalyContextInline(a anomaly.Anomaly) string {
 	if a.TopMemProcess != nil {
 		parts = append(parts, fmt.Sprintf("top MEM %s", formatProcessInline(*a.TopMemProcess)))
 	}
+	if a.SilencedBy != "" {
+		parts = append(parts, fmt.Sprintf("silenced by %s", a.SilencedBy))
+	}
+	if a.Warmup {
+		parts = append(parts, "held back during warm-up")
+	}
 	if len(parts) == 0 {
 		return ""
 	}
@@ -510,12 +1026,54 @@ func formatAnomalyContextParagraph(a anomaly.Anomaly) string {
 	if a.TopMemProcess != nil {
 		parts = append(parts, fmt.Sprintf("Top memory process: %s.", formatProcessDetailed(*a.TopMemProcess)))
 	}
+	if a.SilencedBy != "" {
+		parts = append(parts, fmt.Sprintf("Silenced by `%s`.", a.SilencedBy))
+	}
+	if a.Warmup {
+		parts = append(parts, "Held back during warm-up.")
+	}
 	if len(parts) == 0 {
 		return ""
 	}
 	return " " + strings.Join(parts, " ")
 }
 
+func countSilenced(anomalies []anomaly.Anomaly) int {
+	n := 0
+	for _, a := range anomalies {
+		if a.SilencedBy != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func countWarmup(anomalies []anomaly.Anomaly) int {
+	n := 0
+	for _, a := range anomalies {
+		if a.Warmup {
+			n++
+		}
+	}
+	return n
+}
+
+func formatLineNumbers(lines []int) string {
+	const maxShown = 10
+	parts := make([]string, 0, maxShown+1)
+	for i, line := range lines {
+		if i == maxShown {
+			parts = append(parts, fmt.Sprintf("+%d more", len(lines)-maxShown))
+			break
+		}
+		parts = append(parts, strconv.Itoa(line))
+	}
+	if len(lines) == 1 {
+		return "line " + parts[0]
+	}
+	return "lines " + strings.Join(parts, ", ")
+}
+
 func formatProcessInline(p anomaly.ProcessAttribution) string {
 	return fmt.Sprintf("%s(pid=%d)", p.Name, p.PID)
 }
@@ -2,11 +2,19 @@ package report
 
 import (
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/baseline"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/explain"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/rollup"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/severity"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/silence"
 )
 
 func TestAnalyzeDetectsSpike(t *testing.T) {
@@ -245,6 +253,129 @@ func TestAnalyzeAttachesAnomalyProcessContext(t *testing.T) {
 	}
 }
 
+func TestAnalyzeRendersExplanationTemplates(t *testing.T) {
+	start := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	samples := make([]collector.MetricSample, 0, 8)
+	for i, cpu := range []float64{10, 11, 9, 10, 12, 11, 10, 95} {
+		samples = append(samples, collector.MetricSample{
+			Timestamp:  start.Add(time.Duration(i) * time.Second),
+			Labels:     map[string]string{"team": "payments"},
+			CPUPercent: cpu,
+		})
+	}
+	samples[7].TopCPUProcess = &collector.ProcessAttribution{PID: 1234, Name: "cpu-hog", CPUPercent: 88.8}
+
+	path := filepath.Join(t.TempDir(), "runbooks.tmpl")
+	body := `{{define "hint:cpu_percent"}}Runbook: https://wiki.example/{{index .Labels "team"}}/cpu{{with .TopCPUProcess}} ({{.Name}}){{end}}{{end}}`
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	explainer, err := explain.New("de", []string{path})
+	if err != nil {
+		t.Fatalf("explain.New: %v", err)
+	}
+	result := AnalyzeWithOptions(samples, Options{WindowSize: 5, Threshold: 2.5, Explainer: explainer})
+	var cpu *anomaly.Anomaly
+	for i := range result.Anomalies {
+		if result.Anomalies[i].Name == "cpu_percent" {
+			cpu = &result.Anomalies[i]
+		}
+	}
+	if cpu == nil {
+		t.Fatalf("expected a cpu anomaly, got %+v", result.Anomalies)
+	}
+	hint := "Runbook: https://wiki.example/payments/cpu (cpu-hog)"
+	if !strings.HasPrefix(cpu.Explanation, "CPU-Auslastung ist auf 95.0% gestiegen") || !strings.HasSuffix(cpu.Explanation, hint) || cpu.Hint != hint {
+		t.Fatalf("expected a German explanation with the runbook hint, got %q / %q", cpu.Explanation, cpu.Hint)
+	}
+}
+
+func TestAnalyzeAnnotatesSilencedAnomalies(t *testing.T) {
+	start := time.Date(2026, 2, 1, 2, 0, 0, 0, time.UTC)
+	samples := make([]collector.MetricSample, 0, 8)
+	for i, cpu := range []float64{10, 95, 95, 10, 95} {
+		samples = append(samples, collector.MetricSample{
+			Timestamp:  start.Add(time.Duration(i) * 30 * time.Minute),
+			HostID:     "db-1",
+			CPUPercent: cpu,
+		})
+	}
+	silences := silence.List{{ID: "nightly-backup", Schedule: "0 2 * * *", Duration: "1h", Matchers: map[string]string{"host_id": "db-*"}}}
+	if err := silences.Compile(); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	result := AnalyzeWithOptions(samples, Options{
+		Rules:    anomaly.Rules{StaticThresholds: map[string]float64{"cpu_percent": 90}},
+		Silences: silences,
+	})
+	var silenced, open int
+	for _, a := range result.Anomalies {
+		switch a.SilencedBy {
+		case "nightly-backup":
+			silenced++
+		case "":
+			open++
+		}
+	}
+	if silenced != 1 || open != 2 {
+		t.Fatalf("expected 1 silenced and 2 open anomalies, got %+v", result.Anomalies)
+	}
+	if !strings.Contains(FormatSummary(result), "Silenced: 1\n") {
+		t.Fatalf("expected the summary to count silenced anomalies, got:\n%s", FormatSummary(result))
+	}
+	if !strings.Contains(FormatMarkdown(result), "Silenced by `nightly-backup`.") {
+		t.Fatalf("expected markdown to mark silenced anomalies, got:\n%s", FormatMarkdown(result))
+	}
+}
+
+func TestAnalyzeMarksAnomaliesHeldBackDuringWarmup(t *testing.T) {
+	start := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	samples := make([]collector.MetricSample, 0, 8)
+	for i, cpu := range []float64{10, 11, 9, 10, 12, 10, 60} {
+		samples = append(samples, collector.MetricSample{Timestamp: start.Add(time.Duration(i) * time.Second), CPUPercent: cpu})
+	}
+	result := AnalyzeWithOptions(samples, Options{
+		WindowSize: 5,
+		Threshold:  3,
+		Rules:      anomaly.Rules{Warmup: anomaly.Warmup{Samples: 30}},
+	})
+	if len(result.Anomalies) != 1 || !result.Anomalies[0].Warmup {
+		t.Fatalf("expected one anomaly held back during warm-up, got %+v", result.Anomalies)
+	}
+	if !strings.Contains(FormatSummary(result), "Held back during warm-up: 1\n") || !strings.Contains(FormatSummary(result), "held back during warm-up]") {
+		t.Fatalf("expected the summary to mark held-back anomalies, got:\n%s", FormatSummary(result))
+	}
+	out, err := FormatJSON(result)
+	if err != nil {
+		t.Fatalf("FormatJSON: %v", err)
+	}
+	if !strings.Contains(string(out), `"warmup_suppressed": 1`) || !strings.Contains(string(out), `"warmup": true`) {
+		t.Fatalf("expected JSON to mark held-back anomalies, got:\n%s", out)
+	}
+}
+
+func TestReportDescribesRateOfChange(t *testing.T) {
+	start := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	samples := make([]collector.MetricSample, 0, 8)
+	for i, mem := range []float64{39, 40.5, 41, 52.3} {
+		samples = append(samples, collector.MetricSample{Timestamp: start.Add(time.Duration(i) * 30 * time.Second), MemUsedPercent: mem})
+	}
+	result := AnalyzeWithOptions(samples, Options{
+		WindowSize: 5,
+		Threshold:  3,
+		Rules:      anomaly.Rules{RateRules: map[string]anomaly.RateRule{"mem_used_percent": {Change: 10, Per: time.Minute}}},
+	})
+	if len(result.Anomalies) != 1 || result.Anomalies[0].RuleType != anomaly.RuleTypeRateOfChange {
+		t.Fatalf("expected one rate-of-change anomaly, got %+v", result.Anomalies)
+	}
+	if summary := FormatSummary(result); !strings.Contains(summary, "- mem_used_percent: 52.3% (rate of change: +11.8pp in 60s, limit 10.0pp per 60s, low)") {
+		t.Fatalf("unexpected summary:\n%s", summary)
+	}
+	if md := FormatMarkdown(result); !strings.Contains(md, "Memory usage grew 11.8pp in 60s (40.5% to 52.3%), faster than 10.0pp per 60s.") {
+		t.Fatalf("unexpected markdown:\n%s", md)
+	}
+}
+
 func TestAnalyze_RespectsMetricFamilies(t *testing.T) {
 	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
 	families := &collector.MetricFamilies{CPU: true, Mem: true, Disk: false, Net: false}
@@ -305,3 +436,484 @@ func TestAnalyze_StaticThresholds(t *testing.T) {
 		t.Fatalf("expected markdown to include static threshold wording, got: %s", md)
 	}
 }
+
+func TestFormatOutputsIncludeSkippedLines(t *testing.T) {
+	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	result := Analyze([]collector.MetricSample{
+		{Timestamp: t0, CPUPercent: 10},
+		{Timestamp: t0.Add(time.Second), CPUPercent: 11},
+	}, 5, 3.0, nil)
+	result.SkippedLines = []int{4, 9}
+
+	if summary := FormatSummary(result); !strings.Contains(summary, "Skipped lines: 2 (lines 4, 9)") {
+		t.Fatalf("expected skipped lines in summary, got: %s", summary)
+	}
+	payload, err := FormatJSON(result)
+	if err != nil {
+		t.Fatalf("FormatJSON: %v", err)
+	}
+	var decoded struct {
+		SkippedRecords int   `json:"skipped_records"`
+		SkippedLines   []int `json:"skipped_lines"`
+	}
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if decoded.SkippedRecords != 2 || len(decoded.SkippedLines) != 2 || decoded.SkippedLines[1] != 9 {
+		t.Fatalf("unexpected skipped fields in json: %+v", decoded)
+	}
+}
+
+func TestAnalyzeWithRollupsReportsLongRangeBaselines(t *testing.T) {
+	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	rollups := []rollup.Record{
+		{Resolution: "1h0m0s", Start: t0, End: t0.Add(time.Hour), Samples: 720, Metrics: map[string]rollup.Aggregate{
+			"cpu_percent": {Count: 720, Min: 5, Mean: 10, Max: 40, Stddev: 2, P95: 20},
+		}},
+		{Resolution: "1h0m0s", Start: t0.Add(time.Hour), End: t0.Add(2 * time.Hour), Samples: 720, Metrics: map[string]rollup.Aggregate{
+			"cpu_percent": {Count: 720, Min: 8, Mean: 30, Max: 90, Stddev: 2, P95: 40},
+		}},
+	}
+	samples := []collector.MetricSample{
+		{Timestamp: t0.Add(3 * time.Hour), CPUPercent: 10},
+		{Timestamp: t0.Add(3*time.Hour + time.Second), CPUPercent: 11},
+	}
+
+	result := AnalyzeWithOptions(samples, Options{WindowSize: 5, Threshold: 3, Rollups: rollups})
+	if result.History == nil {
+		t.Fatal("expected history from rollups")
+	}
+	cpu := result.History.Baselines["cpu_percent"]
+	if cpu.Count != 1440 || cpu.Mean != 20 || cpu.Min != 5 || cpu.Max != 90 {
+		t.Fatalf("unexpected long-range cpu baseline: %+v", cpu)
+	}
+	if !result.History.From.Equal(t0) || !result.History.To.Equal(t0.Add(2*time.Hour)) {
+		t.Fatalf("unexpected history range: %s - %s", result.History.From, result.History.To)
+	}
+	if md := FormatMarkdown(result); !strings.Contains(md, "## Long-range Baselines") {
+		t.Fatalf("expected long-range baselines in markdown, got: %s", md)
+	}
+}
+
+func TestAnalyzeDerivesRatesPerHost(t *testing.T) {
+	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	var samples []collector.MetricSample
+	for i := 0; i < 10; i++ {
+		ts := t0.Add(time.Duration(i) * time.Second)
+		// Two hosts with very different absolute counters, interleaved by time.
+		samples = append(samples,
+			collector.MetricSample{Timestamp: ts, HostID: "a", CPUPercent: 10, NetRxBytes: uint64(i * 100)},
+			collector.MetricSample{Timestamp: ts, HostID: "b", CPUPercent: 50, NetRxBytes: 1_000_000_000 + uint64(i*100)},
+		)
+	}
+
+	result := Analyze(samples, 5, 3.0, nil)
+	if result.Hosts != 2 || result.HostID != "" {
+		t.Fatalf("expected 2 hosts and no stable host id, got %d/%q", result.Hosts, result.HostID)
+	}
+	rx := result.Baselines["net_rx_bytes_per_sec"]
+	if rx.Max != 100 || rx.Min != 100 {
+		t.Fatalf("expected per-host rx rates of 100 B/s, got min=%v max=%v", rx.Min, rx.Max)
+	}
+	if len(result.Anomalies) != 0 {
+		t.Fatalf("expected no anomalies from interleaved hosts, got %+v", result.Anomalies)
+	}
+	if got := result.HostBaselines["b"]["cpu_percent"].Mean; got != 50 {
+		t.Fatalf("expected host b cpu baseline 50, got %v", got)
+	}
+}
+
+func TestAnalyzeFleetRanksWorstHostFirst(t *testing.T) {
+	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	var samples []collector.MetricSample
+	for i := 0; i < 12; i++ {
+		ts := t0.Add(time.Duration(i) * time.Second)
+		spike := 10.0
+		if i == 11 {
+			spike = 95
+		}
+		samples = append(samples,
+			collector.MetricSample{Timestamp: ts, HostID: "quiet", CPUPercent: 10 + float64(i%2), Labels: map[string]string{"service": "web"}},
+			collector.MetricSample{Timestamp: ts, HostID: "noisy", CPUPercent: spike + float64(i%2), Labels: map[string]string{"service": "db"}},
+		)
+	}
+
+	fleet := AnalyzeFleet(samples, Options{WindowSize: 5, Threshold: 3}, "service")
+	if fleet.Hosts != 2 || len(fleet.Partitions) != 2 {
+		t.Fatalf("expected 2 hosts in 2 partitions, got %d/%d", fleet.Hosts, len(fleet.Partitions))
+	}
+	worst := fleet.Partitions[0]
+	if worst.HostID != "noisy" || worst.LabelValue != "db" || worst.Key != "noisy service=db" {
+		t.Fatalf("expected noisy host ranked first, got %+v", worst)
+	}
+	if worst.Result.Samples != 12 || len(worst.Result.Anomalies) == 0 {
+		t.Fatalf("expected anomalies on noisy partition, got %+v", worst.Result)
+	}
+	if len(fleet.Partitions[1].Result.Anomalies) != 0 {
+		t.Fatalf("expected no anomalies on quiet host, got %+v", fleet.Partitions[1].Result.Anomalies)
+	}
+
+	md := FormatFleetMarkdown(fleet)
+	for _, want := range []string{"## Worst Hosts", "| 1 | noisy service=db |", "## Hosts"} {
+		if !strings.Contains(md, want) {
+			t.Fatalf("expected %q in fleet markdown:\n%s", want, md)
+		}
+	}
+	payload, err := FormatFleetJSON(fleet)
+	if err != nil {
+		t.Fatalf("FormatFleetJSON: %v", err)
+	}
+	if !strings.Contains(string(payload), `"worst_hosts": [
+    "noisy service=db"
+  ]`) {
+		t.Fatalf("expected worst_hosts in fleet json:\n%s", payload)
+	}
+}
+
+func TestAnalyzeAppliesPercentileRules(t *testing.T) {
+	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	var samples []collector.MetricSample
+	for i := 0; i < 30; i++ {
+		cpu := 40 + float64(i%5)
+		if i == 29 {
+			cpu = 70
+		}
+		samples = append(samples, collector.MetricSample{Timestamp: t0.Add(time.Duration(i) * time.Second), HostID: "a", CPUPercent: cpu})
+	}
+
+	result := AnalyzeWithOptions(samples, Options{
+		WindowSize: 5,
+		Threshold:  100, // keep the z-score rule quiet
+		Rules: anomaly.Rules{
+			PercentileRules: map[string][]anomaly.PercentileRule{"cpu_percent": {{Percentile: 99, Margin: 0.2, Window: 20}}},
+		},
+	})
+	if len(result.Anomalies) != 1 {
+		t.Fatalf("expected one percentile anomaly, got %+v", result.Anomalies)
+	}
+	if a := result.Anomalies[0]; a.RuleType != anomaly.RuleTypePercentile || a.Value != 70 {
+		t.Fatalf("unexpected anomaly: %+v", a)
+	}
+	if !strings.Contains(FormatSummary(result), "percentile rule") {
+		t.Fatalf("expected percentile rule in summary:\n%s", FormatSummary(result))
+	}
+}
+
+func TestAnalyzeReportsDriftFromReference(t *testing.T) {
+	start := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	families := &collector.MetricFamilies{CPU: true, Mem: true}
+	reference := func(cpu float64) []collector.MetricSample {
+		var out []collector.MetricSample
+		for i := 0; i < 20; i++ {
+			out = append(out, collector.MetricSample{
+				Timestamp:      start.Add(time.Duration(i) * time.Second),
+				CPUPercent:     cpu + float64(i%3),
+				MemUsedPercent: 40 + float64(i%2),
+				MetricFamilies: families,
+			})
+		}
+		return out
+	}
+	golden := baseline.Compute(reference(10))
+	result := AnalyzeWithOptions(reference(30), Options{WindowSize: 5, Threshold: 3, Reference: &golden})
+
+	if len(result.Drift) != 2 {
+		t.Fatalf("expected drift for cpu and mem, got %+v", result.Drift)
+	}
+	cpu, mem := result.Drift[0], result.Drift[1]
+	if cpu.Metric != "cpu_percent" || !cpu.Drifted || cpu.Shift <= 3 || cpu.ReferenceMean >= cpu.Mean {
+		t.Fatalf("expected cpu to drift upward, got %+v", cpu)
+	}
+	if mem.Metric != "mem_used_percent" || mem.Drifted || mem.Shift != 0 {
+		t.Fatalf("expected mem to match the reference, got %+v", mem)
+	}
+	// The seeded detector flags the shifted CPU from the first comparison.
+	if result.TotalAnomalies == 0 {
+		t.Fatal("expected anomalies against the imported baseline")
+	}
+	if md := FormatMarkdown(result); !strings.Contains(md, "## Drift from Baseline") || !strings.Contains(md, "| cpu_percent |") {
+		t.Fatalf("expected drift table in markdown:\n%s", md)
+	}
+	if s := FormatSummary(result); !strings.Contains(s, "Drift from baseline: 1 of 2 metrics") {
+		t.Fatalf("expected drift line in summary:\n%s", s)
+	}
+	payload, err := FormatJSON(result)
+	if err != nil || !strings.Contains(string(payload), `"drift"`) {
+		t.Fatalf("expected drift in JSON (err=%v)", err)
+	}
+}
+
+func TestReportListsRegimeChangesSeparately(t *testing.T) {
+	start := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	families := &collector.MetricFamilies{Mem: true}
+	var samples []collector.MetricSample
+	for i := 0; i < 60; i++ {
+		mem := 40 + float64(i%3)
+		if i >= 30 {
+			mem += 30
+		}
+		samples = append(samples, collector.MetricSample{
+			Timestamp:      start.Add(time.Duration(i) * time.Second),
+			MemUsedPercent: mem,
+			MetricFamilies: families,
+		})
+	}
+	result := AnalyzeWithOptions(samples, Options{
+		WindowSize: 10,
+		Threshold:  3,
+		Rules:      anomaly.Rules{ChangePoints: map[string]anomaly.ChangePointRule{"mem_used_percent": {}}},
+	})
+	var changes int
+	for _, a := range result.Anomalies {
+		if a.RuleType == anomaly.RuleTypeChangePoint {
+			changes++
+		}
+	}
+	if changes != 1 {
+		t.Fatalf("expected one change point, got %d in %+v", changes, result.Anomalies)
+	}
+	md := FormatMarkdown(result)
+	if !strings.Contains(md, "## Regime Changes") || !strings.Contains(md, "41.0% -> 71.0%") {
+		t.Fatalf("expected regime change section:\n%s", md)
+	}
+	if s := FormatSummary(result); !strings.Contains(s, "Regime changes: 1") {
+		t.Fatalf("expected regime changes in summary:\n%s", s)
+	}
+}
+
+func TestReportLeadsWithIncidents(t *testing.T) {
+	start := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	families := &collector.MetricFamilies{CPU: true, Mem: true}
+	backup := &collector.ProcessAttribution{PID: 42, Name: "backup", CPUPercent: 80, RSSBytes: 1 << 30}
+	var samples []collector.MetricSample
+	for i := 0; i < 10; i++ {
+		cpu, mem := 10.0, 20.0
+		if i == 8 {
+			cpu, mem = 95, 95
+		}
+		samples = append(samples, collector.MetricSample{
+			Timestamp:      start.Add(time.Duration(i) * time.Second),
+			HostID:         "host-1",
+			CPUPercent:     cpu,
+			MemUsedPercent: mem,
+			MetricFamilies: families,
+			TopCPUProcess:  backup,
+			TopMemProcess:  backup,
+		})
+	}
+	result := AnalyzeWithOptions(samples, Options{
+		WindowSize: 5,
+		Threshold:  3,
+		Rules: anomaly.Rules{StaticThresholds: map[string]float64{
+			"cpu_percent":      90,
+			"mem_used_percent": 90,
+		}},
+	})
+	md := FormatMarkdown(result)
+	if !strings.Contains(md, "## Incidents") || !strings.Contains(md, "### host-1-20260209T000008Z") {
+		t.Fatalf("expected incident section:\n%s", md)
+	}
+	if !strings.Contains(md, "likely cause: backup (pid 42)") {
+		t.Fatalf("expected likely cause:\n%s", md)
+	}
+	if strings.Index(md, "## Incidents") > strings.Index(md, "**cpu_percent**") {
+		t.Fatalf("expected anomalies nested under the incident:\n%s", md)
+	}
+	if s := FormatSummary(result); !strings.Contains(s, "Incidents: 1") {
+		t.Fatalf("expected incidents in summary:\n%s", s)
+	}
+}
+
+func TestAnalyzeCountsValuesExcludedFromBaseline(t *testing.T) {
+	start := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	families := &collector.MetricFamilies{CPU: true}
+	var samples []collector.MetricSample
+	for i := 0; i < 30; i++ {
+		cpu := 10 + float64(i%3)
+		if i >= 20 {
+			cpu = 90
+		}
+		samples = append(samples, collector.MetricSample{
+			Timestamp:      start.Add(time.Duration(i) * time.Second),
+			CPUPercent:     cpu,
+			MetricFamilies: families,
+		})
+	}
+	result := AnalyzeWithOptions(samples, Options{
+		WindowSize: 10,
+		Threshold:  3,
+		Algorithms: anomaly.AlgorithmConfig{Contamination: map[string]anomaly.Contamination{"cpu_percent": anomaly.ContaminationSkip}},
+	})
+	if len(result.Anomalies) != 10 || result.BaselineExcluded["cpu_percent"] != 10 {
+		t.Fatalf("expected all 10 incident samples flagged and excluded, got %d anomalies, excluded %v", len(result.Anomalies), result.BaselineExcluded)
+	}
+	payload, err := FormatJSON(result)
+	if err != nil {
+		t.Fatalf("FormatJSON: %v", err)
+	}
+	var decoded struct {
+		Contamination    map[string]string `json:"contamination"`
+		BaselineExcluded map[string]int    `json:"baseline_excluded"`
+	}
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if decoded.BaselineExcluded["cpu_percent"] != 10 || decoded.Contamination["cpu_percent"] != "skip" {
+		t.Fatalf("expected contamination and excluded count in json: %s", payload)
+	}
+}
+
+func TestReportPrintsEffectivePerMetricParams(t *testing.T) {
+	start := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	families := &collector.MetricFamilies{CPU: true, Disk: true}
+	var samples []collector.MetricSample
+	for i := 0; i < 20; i++ {
+		cpu := 10 + float64(i%3)
+		if i == 15 {
+			cpu = 14
+		}
+		samples = append(samples, collector.MetricSample{
+			Timestamp:       start.Add(time.Duration(i) * time.Second),
+			CPUPercent:      cpu,
+			DiskUsedPercent: 50,
+			MetricFamilies:  families,
+		})
+	}
+	opts := Options{
+		WindowSize: 10,
+		Threshold:  3,
+		Algorithms: anomaly.AlgorithmConfig{MetricParams: map[string]anomaly.Params{"disk_used_percent": {WindowSize: 60, Threshold: 5}}},
+		Rules:      anomaly.Rules{StaticThresholds: map[string]float64{"disk_used_percent": 95}},
+	}
+	result := AnalyzeWithOptions(samples, opts)
+	if len(result.Anomalies) == 0 {
+		t.Fatal("expected the cpu spike to be flagged")
+	}
+	disk := result.MetricParams["disk_used_percent"]
+	if disk.WindowSize != 60 || disk.ZScoreThreshold != 5 || disk.StaticThreshold != 95 {
+		t.Fatalf("unexpected disk params: %+v", disk)
+	}
+	if cpu := result.MetricParams["cpu_percent"]; cpu.WindowSize != 10 || cpu.ZScoreThreshold != 3 {
+		t.Fatalf("expected cpu to keep the global params, got %+v", cpu)
+	}
+	md := FormatMarkdown(result)
+	if !strings.Contains(md, "## Detection Parameters") || !strings.Contains(md, "| disk_used_percent | 60 | 5.00 | zscore | - | 95.0% | - |") {
+		t.Fatalf("expected detection parameters table:\n%s", md)
+	}
+
+	opts.MinSeverities = map[string]string{"cpu_percent": "critical"}
+	if got := AnalyzeWithOptions(samples, opts); len(got.Anomalies) != 0 {
+		t.Fatalf("expected the per-metric min severity to drop the cpu spike, got %+v", got.Anomalies)
+	}
+}
+
+func TestAnalyzeEvaluatesExpressionRules(t *testing.T) {
+	start := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	families := &collector.MetricFamilies{CPU: true, Mem: true}
+	rule, err := anomaly.ParseExpressionRule("memory_pressure", "high", "cpu_percent > 85 && mem_used_percent > 90", func(name string) (string, bool) { return name, true })
+	if err != nil {
+		t.Fatalf("ParseExpressionRule: %v", err)
+	}
+	var samples []collector.MetricSample
+	for i := 0; i < 6; i++ {
+		cpu, mem := 90.0, 50.0
+		if i == 4 {
+			mem = 95
+		}
+		samples = append(samples, collector.MetricSample{
+			Timestamp:      start.Add(time.Duration(i) * time.Second),
+			HostID:         "host-1",
+			CPUPercent:     cpu,
+			MemUsedPercent: mem,
+			MetricFamilies: families,
+		})
+	}
+	result := AnalyzeWithOptions(samples, Options{
+		WindowSize: 30,
+		Threshold:  3,
+		Rules:      anomaly.Rules{Expressions: []anomaly.ExpressionRule{rule}},
+	})
+	if len(result.Anomalies) != 1 {
+		t.Fatalf("expected one expression anomaly, got %+v", result.Anomalies)
+	}
+	a := result.Anomalies[0]
+	if a.Name != "memory_pressure" || a.RuleType != anomaly.RuleTypeExpression || a.HostID != "host-1" || !a.Timestamp.Equal(samples[4].Timestamp) {
+		t.Fatalf("unexpected anomaly: %+v", a)
+	}
+	md := FormatMarkdown(result)
+	if !strings.Contains(md, "**memory_pressure**: cpu_percent 90.0%, mem_used_percent 95.0% matched `cpu_percent > 85 && mem_used_percent > 90` (high)") {
+		t.Fatalf("expected expression anomaly in markdown:\n%s", md)
+	}
+}
+
+func TestFleetReportUsesCustomSeverityLevels(t *testing.T) {
+	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	var samples []collector.MetricSample
+	for i := 0; i < 12; i++ {
+		cpu := 10 + float64(i%2)
+		if i == 11 {
+			cpu = 95
+		}
+		samples = append(samples, collector.MetricSample{Timestamp: t0.Add(time.Duration(i) * time.Second), HostID: "a", CPUPercent: cpu})
+	}
+	scale, err := severity.NewScale([]string{"info", "warn", "page"})
+	if err != nil {
+		t.Fatalf("NewScale: %v", err)
+	}
+	cuts := map[string][]float64{}
+	for _, ruleType := range anomaly.GradedRuleTypes() {
+		cuts[ruleType] = []float64{3, 5}
+	}
+	opts := Options{WindowSize: 5, Threshold: 3, Rules: anomaly.Rules{Severity: anomaly.SeverityRules{Scale: scale, CutPoints: cuts}}}
+
+	fleet := AnalyzeFleet(samples, opts, "")
+	anomalies := fleet.Partitions[0].Result.Anomalies
+	if len(anomalies) == 0 || anomalies[0].Severity != "page" || anomalies[0].Score < 200.0/3 {
+		t.Fatalf("expected a page-level anomaly in the top band, got %+v", anomalies)
+	}
+	md := FormatFleetMarkdown(fleet)
+	if !strings.Contains(md, "| Rank | Host | Page | Warn | Info | Top anomaly |") || !strings.Contains(md, "| 1 | a | 1 | 0 | 0 |") {
+		t.Fatalf("expected custom severity columns:\n%s", md)
+	}
+	filtered, err := ApplyFilters(fleet.Partitions[0].Result, "warn", 0)
+	if err != nil || len(filtered.Anomalies) != 1 {
+		t.Fatalf("expected the page to pass a warn filter, got %+v %v", filtered.Anomalies, err)
+	}
+	if _, err := ApplyFilters(fleet.Partitions[0].Result, "critical", 0); err == nil {
+		t.Fatal("expected a level outside the scale to be rejected")
+	}
+}
+
+func TestReportForecastsEachMount(t *testing.T) {
+	start := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	samples := make([]collector.MetricSample, 0, 37)
+	for i := 0; i <= 36; i++ {
+		samples = append(samples, collector.MetricSample{
+			Timestamp:       start.Add(time.Duration(i) * 10 * time.Minute),
+			DiskUsedPercent: 50,
+			Mounts:          []collector.MountUsage{{Mount: "/var", UsedPercent: 80 + float64(i)/6, FreeBytes: 1 << 30}},
+			MetricFamilies:  &collector.MetricFamilies{Disk: true},
+		})
+	}
+	result := AnalyzeWithOptions(samples, Options{
+		WindowSize: 5,
+		Threshold:  3,
+		Rules:      anomaly.Rules{ForecastRules: map[string]anomaly.ForecastRule{"disk_used_percent": {Horizon: 24 * time.Hour}}},
+	})
+	var forecasts int
+	for _, a := range result.Anomalies {
+		if a.RuleType != anomaly.RuleTypeForecast {
+			continue
+		}
+		if a.Name != "disk_used_percent{mount=/var}" {
+			t.Fatalf("expected forecasts for /var only, got %+v", a)
+		}
+		forecasts++
+	}
+	if forecasts == 0 {
+		t.Fatalf("expected a forecast for /var, got %+v", result.Anomalies)
+	}
+	if md := FormatMarkdown(result); !strings.Contains(md, "Disk usage (/var) is rising 1.0% per hour") {
+		t.Fatalf("unexpected markdown:\n%s", md)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/rollup"
 )
 
 // FilterSamplesByTime returns only samples in the inclusive [since, until] time range.
@@ -30,3 +31,22 @@ func FilterSamplesByTime(samples []collector.MetricSample, since, until time.Tim
 	}
 	return out, nil
 }
+
+// FilterRollupsByTime returns rollups whose bucket overlaps the inclusive
+// [since, until] range. Zero values mean "unbounded".
+func FilterRollupsByTime(records []rollup.Record, since, until time.Time) []rollup.Record {
+	if since.IsZero() && until.IsZero() {
+		return records
+	}
+	out := make([]rollup.Record, 0, len(records))
+	for _, r := range records {
+		if !since.IsZero() && r.End.Before(since) {
+			continue
+		}
+		if !until.IsZero() && r.Start.After(until) {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
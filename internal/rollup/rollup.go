@@ -0,0 +1,357 @@
+package rollup
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+)
+
+// RecordType marks rollup lines in a JSONL file that otherwise holds raw
+// samples (which carry no record_type).
+const RecordType = "rollup"
+
+// SchemaVersion is the version of Record written by this build.
+const SchemaVersion = 1
+
+const (
+	Minute = time.Minute
+	Hour   = time.Hour
+)
+
+// Aggregate summarizes one metric over a rollup bucket. Stddev is kept so
+// buckets can be merged without access to the raw values; P95 of a merged
+// bucket is the count-weighted mean of its inputs and therefore approximate.
+type Aggregate struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Mean   float64 `json:"mean"`
+	Max    float64 `json:"max"`
+	Stddev float64 `json:"stddev"`
+	P95    float64 `json:"p95"`
+}
+
+type Record struct {
+	RecordType    string               `json:"record_type"`
+	SchemaVersion int                  `json:"schema_version"`
+	Resolution    string               `json:"resolution"`
+	Start         time.Time            `json:"start"`
+	End           time.Time            `json:"end"`
+	HostID        string               `json:"host_id"`
+	Labels        map[string]string    `json:"labels,omitempty"`
+	Samples       int                  `json:"samples"`
+	Metrics       map[string]Aggregate `json:"metrics"`
+	TopCPUProcess string               `json:"top_cpu_process,omitempty"`
+	TopMemProcess string               `json:"top_mem_process,omitempty"`
+}
+
+// ResolutionDuration parses the record's resolution, returning zero when it is
+// not a valid duration.
+func (r Record) ResolutionDuration() time.Duration {
+	d, err := time.ParseDuration(r.Resolution)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+type bucketKey struct {
+	host  string
+	start time.Time
+}
+
+type bucket struct {
+	record  Record
+	values  map[string][]float64
+	cpuProc map[string]int
+	memProc map[string]int
+}
+
+// Build aggregates raw samples into buckets of the given resolution, one
+// series per host. Counter rates are derived between consecutive samples of
+// the same host, exactly as analysis does.
+func Build(samples []collector.MetricSample, resolution time.Duration) []Record {
+	if len(samples) == 0 || resolution <= 0 {
+		return nil
+	}
+	ordered := append([]collector.MetricSample(nil), samples...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Timestamp.Before(ordered[j].Timestamp) })
+
+	buckets := map[bucketKey]*bucket{}
+	prevByHost := map[string]*collector.MetricSample{}
+	for i := range ordered {
+		s := ordered[i]
+		metrics := collector.DeriveMetrics(prevByHost[s.HostID], s)
+		prevByHost[s.HostID] = &ordered[i]
+
+		key := bucketKey{host: s.HostID, start: s.Timestamp.UTC().Truncate(resolution)}
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{
+				record: Record{
+					RecordType:    RecordType,
+					SchemaVersion: SchemaVersion,
+					Resolution:    resolution.String(),
+					Start:         key.start,
+					End:           key.start.Add(resolution),
+					HostID:        s.HostID,
+					Labels:        cloneLabels(s.Labels),
+				},
+				values:  map[string][]float64{},
+				cpuProc: map[string]int{},
+				memProc: map[string]int{},
+			}
+			buckets[key] = b
+		}
+		b.record.Samples++
+		for name, value := range metrics {
+			b.values[name] = append(b.values[name], value)
+		}
+		if s.TopCPUProcess != nil && s.TopCPUProcess.Name != "" {
+			b.cpuProc[s.TopCPUProcess.Name]++
+		}
+		if s.TopMemProcess != nil && s.TopMemProcess.Name != "" {
+			b.memProc[s.TopMemProcess.Name]++
+		}
+	}
+
+	out := make([]Record, 0, len(buckets))
+	for _, b := range buckets {
+		r := b.record
+		r.Metrics = make(map[string]Aggregate, len(b.values))
+		for name, values := range b.values {
+			r.Metrics[name] = aggregate(values)
+		}
+		r.TopCPUProcess = mostFrequent(b.cpuProc)
+		r.TopMemProcess = mostFrequent(b.memProc)
+		out = append(out, r)
+	}
+	sortRecords(out)
+	return out
+}
+
+// Merge combines finer rollups into buckets of the given (coarser) resolution.
+func Merge(records []Record, resolution time.Duration) []Record {
+	if len(records) == 0 || resolution <= 0 {
+		return nil
+	}
+	type merged struct {
+		record  Record
+		parts   map[string][]Aggregate
+		cpuProc map[string]int
+		memProc map[string]int
+	}
+	buckets := map[bucketKey]*merged{}
+	for _, r := range records {
+		key := bucketKey{host: r.HostID, start: r.Start.UTC().Truncate(resolution)}
+		m, ok := buckets[key]
+		if !ok {
+			m = &merged{
+				record: Record{
+					RecordType:    RecordType,
+					SchemaVersion: SchemaVersion,
+					Resolution:    resolution.String(),
+					Start:         key.start,
+					End:           key.start.Add(resolution),
+					HostID:        r.HostID,
+					Labels:        cloneLabels(r.Labels),
+				},
+				parts:   map[string][]Aggregate{},
+				cpuProc: map[string]int{},
+				memProc: map[string]int{},
+			}
+			buckets[key] = m
+		}
+		m.record.Samples += r.Samples
+		for name, agg := range r.Metrics {
+			m.parts[name] = append(m.parts[name], agg)
+		}
+		// The finer bucket only kept its winner, so weight it by sample count.
+		if r.TopCPUProcess != "" {
+			m.cpuProc[r.TopCPUProcess] += r.Samples
+		}
+		if r.TopMemProcess != "" {
+			m.memProc[r.TopMemProcess] += r.Samples
+		}
+	}
+
+	out := make([]Record, 0, len(buckets))
+	for _, m := range buckets {
+		r := m.record
+		r.Metrics = make(map[string]Aggregate, len(m.parts))
+		for name, parts := range m.parts {
+			r.Metrics[name] = Combine(parts)
+		}
+		r.TopCPUProcess = mostFrequent(m.cpuProc)
+		r.TopMemProcess = mostFrequent(m.memProc)
+		out = append(out, r)
+	}
+	sortRecords(out)
+	return out
+}
+
+// Combine merges aggregates of the same metric using the parallel variance
+// formula for the mean and stddev.
+func Combine(parts []Aggregate) Aggregate {
+	var out Aggregate
+	var sum, sumSq, p95 float64
+	for _, p := range parts {
+		if p.Count <= 0 {
+			continue
+		}
+		n := float64(p.Count)
+		if out.Count == 0 || p.Min < out.Min {
+			out.Min = p.Min
+		}
+		if out.Count == 0 || p.Max > out.Max {
+			out.Max = p.Max
+		}
+		out.Count += p.Count
+		sum += p.Mean * n
+		sumSq += (p.Stddev*p.Stddev + p.Mean*p.Mean) * n
+		p95 += p.P95 * n
+	}
+	if out.Count == 0 {
+		return Aggregate{}
+	}
+	n := float64(out.Count)
+	out.Mean = sum / n
+	variance := sumSq/n - out.Mean*out.Mean
+	if variance < 0 {
+		variance = 0
+	}
+	out.Stddev = math.Sqrt(variance)
+	out.P95 = p95 / n
+	return out
+}
+
+// Compact splits the history at the given cutoffs: raw samples older than
+// rawBefore become minute rollups, and minute rollups older than minuteBefore
+// become hour rollups. Existing rollups are re-bucketed the same way, so
+// compacting twice is a no-op.
+func Compact(samples []collector.MetricSample, records []Record, rawBefore, minuteBefore time.Time) ([]collector.MetricSample, []Record) {
+	kept := make([]collector.MetricSample, 0, len(samples))
+	old := make([]collector.MetricSample, 0)
+	for _, s := range samples {
+		if s.Timestamp.Before(rawBefore) {
+			old = append(old, s)
+			continue
+		}
+		kept = append(kept, s)
+	}
+
+	minutes := Build(old, Minute)
+	hours := make([]Record, 0)
+	for _, r := range records {
+		if r.ResolutionDuration() >= Hour {
+			hours = append(hours, r)
+			continue
+		}
+		minutes = append(minutes, r)
+	}
+
+	recentMinutes := make([]Record, 0, len(minutes))
+	oldMinutes := make([]Record, 0)
+	for _, r := range minutes {
+		if !minuteBefore.IsZero() && r.End.Before(minuteBefore) {
+			oldMinutes = append(oldMinutes, r)
+			continue
+		}
+		recentMinutes = append(recentMinutes, r)
+	}
+	out := Merge(recentMinutes, Minute)
+	out = append(out, Merge(append(hours, oldMinutes...), Hour)...)
+	sortRecords(out)
+	return kept, out
+}
+
+func aggregate(values []float64) Aggregate {
+	if len(values) == 0 {
+		return Aggregate{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	mean := sum / float64(len(sorted))
+	var variance float64
+	for _, v := range sorted {
+		diff := v - mean
+		variance += diff * diff
+	}
+	variance = variance / float64(len(sorted))
+	return Aggregate{
+		Count:  len(sorted),
+		Min:    sorted[0],
+		Mean:   mean,
+		Max:    sorted[len(sorted)-1],
+		Stddev: math.Sqrt(variance),
+		P95:    Percentile(sorted, 95),
+	}
+}
+
+// Percentile returns the p-th percentile (0-100) of sorted values using
+// linear interpolation between closest ranks.
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return sorted[0]
+	}
+	if p >= 100 {
+		return sorted[len(sorted)-1]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	if lo == hi {
+		return sorted[lo]
+	}
+	frac := rank - float64(lo)
+	return sorted[lo] + (sorted[hi]-sorted[lo])*frac
+}
+
+func mostFrequent(counts map[string]int) string {
+	best := ""
+	bestCount := 0
+	for name, n := range counts {
+		if n > bestCount || (n == bestCount && name < best) {
+			best = name
+			bestCount = n
+		}
+	}
+	return best
+}
+
+func sortRecords(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].HostID != records[j].HostID {
+			return records[i].HostID < records[j].HostID
+		}
+		if !records[i].Start.Equal(records[j].Start) {
+			return records[i].Start.Before(records[j].Start)
+		}
+		return records[i].ResolutionDuration() < records[j].ResolutionDuration()
+	})
+}
+
+func cloneLabels(in map[string]string) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		if k == "" {
+			continue
+		}
+		out[k] = v
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package rollup
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+)
+
+func minuteOfSamples(host string, start time.Time, cpu []float64) []collector.MetricSample {
+	out := make([]collector.MetricSample, 0, len(cpu))
+	for i, v := range cpu {
+		out = append(out, collector.MetricSample{
+			Timestamp:       start.Add(time.Duration(i) * 10 * time.Second),
+			HostID:          host,
+			CPUPercent:      v,
+			MemUsedPercent:  50,
+			DiskUsedPercent: 60,
+			NetRxBytes:      uint64(i * 1000),
+			NetTxBytes:      uint64(i * 500),
+			TopCPUProcess:   &collector.ProcessAttribution{Name: "backup"},
+		})
+	}
+	return out
+}
+
+func TestBuildAggregatesPerHostAndMinute(t *testing.T) {
+	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	samples := append(minuteOfSamples("a", t0, []float64{10, 20, 30, 40, 50, 60}), minuteOfSamples("b", t0, []float64{5, 5, 5, 5, 5, 5})...)
+	samples = append(samples, minuteOfSamples("a", t0.Add(time.Minute), []float64{1, 2})...)
+
+	records := Build(samples, Minute)
+	if got, want := len(records), 3; got != want {
+		t.Fatalf("expected %d records, got %d", want, got)
+	}
+	first := records[0]
+	if first.HostID != "a" || !first.Start.Equal(t0) || first.Samples != 6 {
+		t.Fatalf("unexpected first record: %+v", first)
+	}
+	cpu := first.Metrics["cpu_percent"]
+	if cpu.Count != 6 || cpu.Min != 10 || cpu.Max != 60 || cpu.Mean != 35 {
+		t.Fatalf("unexpected cpu aggregate: %+v", cpu)
+	}
+	if cpu.P95 < 57 || cpu.P95 > 58 {
+		t.Fatalf("expected p95 ~57.5, got %v", cpu.P95)
+	}
+	// Rates are derived per host: host b's counters must not produce rates
+	// against host a's samples.
+	if rx := first.Metrics["net_rx_bytes_per_sec"]; rx.Count != 5 || rx.Mean != 100 {
+		t.Fatalf("unexpected per-host rx rate aggregate: %+v", rx)
+	}
+	if first.TopCPUProcess != "backup" {
+		t.Fatalf("expected most frequent top process, got %q", first.TopCPUProcess)
+	}
+}
+
+func TestCombineMatchesRawStats(t *testing.T) {
+	a := aggregate([]float64{1, 2, 3})
+	b := aggregate([]float64{4, 5, 6, 7})
+	got := Combine([]Aggregate{a, b})
+	want := aggregate([]float64{1, 2, 3, 4, 5, 6, 7})
+	if got.Count != want.Count || got.Min != want.Min || got.Max != want.Max {
+		t.Fatalf("unexpected combined aggregate: %+v", got)
+	}
+	if math.Abs(got.Mean-want.Mean) > 1e-9 || math.Abs(got.Stddev-want.Stddev) > 1e-9 {
+		t.Fatalf("expected mean/stddev %v/%v, got %v/%v", want.Mean, want.Stddev, got.Mean, got.Stddev)
+	}
+}
+
+func TestCompactRollsUpByAgeAndIsIdempotent(t *testing.T) {
+	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	var samples []collector.MetricSample
+	for m := 0; m < 180; m++ {
+		samples = append(samples, minuteOfSamples("a", t0.Add(time.Duration(m)*time.Minute), []float64{10, 12, 14})...)
+	}
+	newest := samples[len(samples)-1].Timestamp
+	rawBefore := newest.Add(-30 * time.Minute)
+	minuteBefore := newest.Add(-90 * time.Minute)
+
+	kept, records := Compact(samples, nil, rawBefore, minuteBefore)
+	for _, s := range kept {
+		if s.Timestamp.Before(rawBefore) {
+			t.Fatalf("expected only recent raw samples to be kept, found %s", s.Timestamp)
+		}
+	}
+	var minutes, hours, total int
+	for _, r := range records {
+		switch r.ResolutionDuration() {
+		case Minute:
+			minutes++
+			if r.End.Before(minuteBefore) {
+				t.Fatalf("expected old minute rollups to be merged into hours, found %s", r.Start)
+			}
+		case Hour:
+			hours++
+		default:
+			t.Fatalf("unexpected resolution %q", r.Resolution)
+		}
+		total += r.Samples
+	}
+	if minutes == 0 || hours == 0 {
+		t.Fatalf("expected both minute and hour rollups, got %d/%d", minutes, hours)
+	}
+	if got, want := total+len(kept), len(samples); got != want {
+		t.Fatalf("expected rollups plus raw samples to cover %d samples, got %d", want, got)
+	}
+
+	kept2, records2 := Compact(kept, records, rawBefore, minuteBefore)
+	if len(kept2) != len(kept) || len(records2) != len(records) {
+		t.Fatalf("expected second compaction to be a no-op, got %d/%d samples and %d/%d rollups", len(kept2), len(kept), len(records2), len(records))
+	}
+}
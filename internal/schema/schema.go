@@ -0,0 +1,45 @@
+package schema
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+)
+
+//go:embed sample.schema.json
+var sampleSchema []byte
+
+//go:embed alert.schema.json
+var alertSchema []byte
+
+//go:embed rollup.schema.json
+var rollupSchema []byte
+
+//go:embed seasonal.schema.json
+var seasonalSchema []byte
+
+//go:embed baseline.schema.json
+var baselineSchema []byte
+
+// Names lists the documents available from Get.
+func Names() []string {
+	return []string{"sample", "alert", "rollup", "seasonal", "baseline"}
+}
+
+// Get returns the JSON Schema document for a record type.
+func Get(name string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "sample", "samples":
+		return append([]byte(nil), sampleSchema...), nil
+	case "alert", "alerts":
+		return append([]byte(nil), alertSchema...), nil
+	case "rollup", "rollups":
+		return append([]byte(nil), rollupSchema...), nil
+	case "seasonal":
+		return append([]byte(nil), seasonalSchema...), nil
+	case "baseline", "baselines":
+		return append([]byte(nil), baselineSchema...), nil
+	default:
+		return nil, fmt.Errorf("unknown schema: %s (expected %s)", name, strings.Join(Names(), "|"))
+	}
+}
@@ -0,0 +1,124 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/alert"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/baseline"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/rollup"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/seasonal"
+)
+
+type document struct {
+	Required   []string                   `json:"required"`
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+func loadDocument(t *testing.T, name string) document {
+	t.Helper()
+	raw, err := Get(name)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", name, err)
+	}
+	var doc document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("schema %s is not valid JSON: %v", name, err)
+	}
+	return doc
+}
+
+func jsonFieldNames(v any) []string {
+	typ := reflect.TypeOf(v)
+	names := make([]string, 0, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		tag := typ.Field(i).Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func propertyNames(doc document) []string {
+	names := make([]string, 0, len(doc.Properties))
+	for name := range doc.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func schemaVersionConst(t *testing.T, doc document) int {
+	t.Helper()
+	var prop struct {
+		Const int `json:"const"`
+	}
+	if err := json.Unmarshal(doc.Properties["schema_version"], &prop); err != nil {
+		t.Fatalf("schema_version property: %v", err)
+	}
+	return prop.Const
+}
+
+func TestSampleSchemaMatchesMetricSample(t *testing.T) {
+	doc := loadDocument(t, "sample")
+	if got, want := propertyNames(doc), jsonFieldNames(collector.MetricSample{}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sample schema properties drifted from collector.MetricSample:\nschema: %v\nstruct: %v", got, want)
+	}
+	if got := schemaVersionConst(t, doc); got != collector.SchemaVersion {
+		t.Fatalf("sample schema_version const %d does not match collector.SchemaVersion %d", got, collector.SchemaVersion)
+	}
+}
+
+func TestAlertSchemaMatchesAlert(t *testing.T) {
+	doc := loadDocument(t, "alert")
+	if got, want := propertyNames(doc), jsonFieldNames(alert.Alert{}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("alert schema properties drifted from alert.Alert:\nschema: %v\nstruct: %v", got, want)
+	}
+	if got := schemaVersionConst(t, doc); got != alert.SchemaVersion {
+		t.Fatalf("alert schema_version const %d does not match alert.SchemaVersion %d", got, alert.SchemaVersion)
+	}
+}
+
+func TestRollupSchemaMatchesRecord(t *testing.T) {
+	doc := loadDocument(t, "rollup")
+	if got, want := propertyNames(doc), jsonFieldNames(rollup.Record{}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("rollup schema properties drifted from rollup.Record:\nschema: %v\nstruct: %v", got, want)
+	}
+	if got := schemaVersionConst(t, doc); got != rollup.SchemaVersion {
+		t.Fatalf("rollup schema_version const %d does not match rollup.SchemaVersion %d", got, rollup.SchemaVersion)
+	}
+}
+
+func TestSeasonalSchemaMatchesModel(t *testing.T) {
+	doc := loadDocument(t, "seasonal")
+	if got, want := propertyNames(doc), jsonFieldNames(seasonal.Model{}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("seasonal schema properties drifted from seasonal.Model:\nschema: %v\nstruct: %v", got, want)
+	}
+	if got := schemaVersionConst(t, doc); got != seasonal.SchemaVersion {
+		t.Fatalf("seasonal schema_version const %d does not match seasonal.SchemaVersion %d", got, seasonal.SchemaVersion)
+	}
+}
+
+func TestBaselineSchemaMatchesBaseline(t *testing.T) {
+	doc := loadDocument(t, "baseline")
+	if got, want := propertyNames(doc), jsonFieldNames(baseline.Baseline{}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("baseline schema properties drifted from baseline.Baseline:\nschema: %v\nstruct: %v", got, want)
+	}
+	if got := schemaVersionConst(t, doc); got != baseline.SchemaVersion {
+		t.Fatalf("baseline schema_version const %d does not match baseline.SchemaVersion %d", got, baseline.SchemaVersion)
+	}
+}
+
+func TestGetRejectsUnknownSchema(t *testing.T) {
+	if _, err := Get("nope"); err == nil {
+		t.Fatal("expected error")
+	}
+}
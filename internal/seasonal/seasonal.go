@@ -0,0 +1,110 @@
+package seasonal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/fsutil"
+)
+
+// SchemaVersion is the version of Model written by this build.
+const SchemaVersion = 1
+
+// Model holds trained seasonal baselines per host and metric. It is written
+// by `analyze --save-seasonal` and loaded by the seasonal detectors of watch,
+// analyze and report.
+type Model struct {
+	SchemaVersion int                                            `json:"schema_version"`
+	TrainedAt     time.Time                                      `json:"trained_at"`
+	From          time.Time                                      `json:"from"`
+	To            time.Time                                      `json:"to"`
+	Samples       int                                            `json:"samples"`
+	Hosts         map[string]map[string]*anomaly.SeasonalProfile `json:"hosts"`
+}
+
+// Train builds a model from raw samples, deriving rates per host exactly as
+// analysis does. Samples are processed in timestamp order.
+func Train(samples []collector.MetricSample) Model {
+	m := Model{SchemaVersion: SchemaVersion, TrainedAt: time.Now().UTC(), Hosts: map[string]map[string]*anomaly.SeasonalProfile{}}
+	if len(samples) == 0 {
+		return m
+	}
+	ordered := append([]collector.MetricSample(nil), samples...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Timestamp.Before(ordered[j].Timestamp) })
+	m.From = ordered[0].Timestamp
+	m.To = ordered[len(ordered)-1].Timestamp
+	m.Samples = len(ordered)
+
+	prevByHost := map[string]*collector.MetricSample{}
+	for i := range ordered {
+		current := ordered[i]
+		prev := prevByHost[current.HostID]
+		prevByHost[current.HostID] = &ordered[i]
+		profiles, ok := m.Hosts[current.HostID]
+		if !ok {
+			profiles = map[string]*anomaly.SeasonalProfile{}
+			m.Hosts[current.HostID] = profiles
+		}
+		for name, value := range collector.DeriveMetrics(prev, current) {
+			p, ok := profiles[name]
+			if !ok {
+				p = &anomaly.SeasonalProfile{}
+				profiles[name] = p
+			}
+			p.Add(current.Timestamp, value)
+		}
+	}
+	return m
+}
+
+// Profiles returns the per-metric profiles for hostID. A model trained on a
+// single host applies to any host ID, so a file renamed or re-tagged after
+// training still seeds watch.
+func (m *Model) Profiles(hostID string) map[string]*anomaly.SeasonalProfile {
+	if m == nil {
+		return nil
+	}
+	if p, ok := m.Hosts[hostID]; ok {
+		return p
+	}
+	if len(m.Hosts) == 1 {
+		for _, p := range m.Hosts {
+			return p
+		}
+	}
+	return nil
+}
+
+// Load reads a model written by Save.
+func Load(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Model
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("seasonal model %s: %w", path, err)
+	}
+	if m.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("seasonal model %s has schema_version %d; this build reads up to %d", path, m.SchemaVersion, SchemaVersion)
+	}
+	return &m, nil
+}
+
+// Save writes the model atomically (see fsutil.WriteFileAtomic).
+func Save(path string, m Model) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(path, append(data, '\n'), 0o644)
+}
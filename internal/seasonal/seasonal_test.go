@@ -0,0 +1,57 @@
+package seasonal
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+)
+
+func TestTrainBucketsPerHostAndHour(t *testing.T) {
+	base := time.Date(2026, 2, 2, 1, 0, 0, 0, time.UTC) // Monday
+	families := &collector.MetricFamilies{CPU: true}
+	var samples []collector.MetricSample
+	for h := 0; h < 3; h++ {
+		ts := base.Add(time.Duration(h) * time.Hour)
+		samples = append(samples,
+			collector.MetricSample{Timestamp: ts, HostID: "a", CPUPercent: float64(10 * (h + 1)), MetricFamilies: families},
+			collector.MetricSample{Timestamp: ts, HostID: "b", CPUPercent: 90, MetricFamilies: families},
+		)
+	}
+	m := Train(samples)
+	if m.Samples != 6 || len(m.Hosts) != 2 || !m.From.Equal(base) {
+		t.Fatalf("unexpected model: samples=%d hosts=%d from=%s", m.Samples, len(m.Hosts), m.From)
+	}
+	cpu := m.Profiles("a")["cpu_percent"]
+	if cpu == nil || cpu.Hourly[2].Count != 1 || cpu.Hourly[2].Mean != 20 {
+		t.Fatalf("unexpected hourly bucket: %+v", cpu)
+	}
+	if got := cpu.Weekly[1*24+3]; got.Count != 1 || got.Mean != 30 {
+		t.Fatalf("unexpected weekly bucket (Monday 03:00): %+v", got)
+	}
+	if m.Profiles("missing") != nil {
+		t.Fatal("expected no profiles for an unknown host in a multi-host model")
+	}
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	samples := []collector.MetricSample{{
+		Timestamp:      time.Date(2026, 2, 2, 1, 0, 0, 0, time.UTC),
+		HostID:         "laptop",
+		CPUPercent:     42,
+		MetricFamilies: &collector.MetricFamilies{CPU: true},
+	}}
+	path := filepath.Join(t.TempDir(), "models", "seasonal.json")
+	if err := Save(path, Train(samples)); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	m, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	// A single-host model applies to whatever host ID watch runs as.
+	if p := m.Profiles("renamed")["cpu_percent"]; p == nil || p.Hourly[1].Mean != 42 {
+		t.Fatalf("unexpected profile after round trip: %+v", p)
+	}
+}
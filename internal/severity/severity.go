@@ -0,0 +1,163 @@
+// Package severity is the severity model shared by detection, alerting and
+// reporting: the ordered levels anomalies are graded into, how a rule's
+// magnitude maps onto them through cut-points, and the numeric score that
+// orders anomalies across rule types.
+package severity
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// The default levels, least severe first.
+const (
+	Low      = "low"
+	Medium   = "medium"
+	High     = "high"
+	Critical = "critical"
+)
+
+// MaxScore bounds Score: every score is in [0, MaxScore).
+const MaxScore = 100
+
+// Scale is an ordered list of severity levels, least severe first. The zero
+// Scale is Default.
+type Scale struct {
+	levels []string
+}
+
+// Default is the low, medium, high, critical scale.
+var Default = Scale{levels: []string{Low, Medium, High, Critical}}
+
+// NewScale returns the scale with levels, least severe first. Names are
+// lowercased; nil or empty levels give Default.
+func NewScale(levels []string) (Scale, error) {
+	if len(levels) == 0 {
+		return Default, nil
+	}
+	if len(levels) < 2 {
+		return Scale{}, errors.New("severity levels need at least two entries")
+	}
+	out := make([]string, 0, len(levels))
+	seen := make(map[string]bool, len(levels))
+	for _, level := range levels {
+		level = normalize(level)
+		if level == "" {
+			return Scale{}, errors.New("severity levels must not be empty")
+		}
+		if seen[level] {
+			return Scale{}, fmt.Errorf("duplicate severity level: %s", level)
+		}
+		seen[level] = true
+		out = append(out, level)
+	}
+	return Scale{levels: out}, nil
+}
+
+func (s Scale) list() []string {
+	if len(s.levels) == 0 {
+		return Default.levels
+	}
+	return s.levels
+}
+
+// Levels returns the levels, least severe first.
+func (s Scale) Levels() []string {
+	return append([]string(nil), s.list()...)
+}
+
+// Len returns the number of levels.
+func (s Scale) Len() int { return len(s.list()) }
+
+// Lowest returns the least severe level.
+func (s Scale) Lowest() string { return s.list()[0] }
+
+// String lists the levels as "low|medium|high|critical".
+func (s Scale) String() string { return strings.Join(s.list(), "|") }
+
+// Rank returns the 1-based position of level, or false for unknown levels.
+func (s Scale) Rank(level string) (int, bool) {
+	level = normalize(level)
+	for i, l := range s.list() {
+		if l == level {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// Parse normalizes level and checks that the scale has it.
+func (s Scale) Parse(level string) (string, error) {
+	if _, ok := s.Rank(level); !ok {
+		return "", fmt.Errorf("unknown severity: %s (expected %s)", strings.TrimSpace(level), s)
+	}
+	return normalize(level), nil
+}
+
+// ValidateCuts checks that cuts has one ascending, positive cut-point per
+// level above the lowest.
+func (s Scale) ValidateCuts(cuts []float64) error {
+	if len(cuts) != s.Len()-1 {
+		return fmt.Errorf("need %d cut-points for %d severity levels, got %d", s.Len()-1, s.Len(), len(cuts))
+	}
+	for i, c := range cuts {
+		if c <= 0 || math.IsNaN(c) || math.IsInf(c, 0) {
+			return fmt.Errorf("cut-points must be positive numbers, got %v", c)
+		}
+		if i > 0 && c <= cuts[i-1] {
+			return fmt.Errorf("cut-points must be ascending, got %v", cuts)
+		}
+	}
+	return nil
+}
+
+// Grade maps a rule's magnitude (an absolute z-score or exceed ratio) to a
+// level and score: cuts[i] is where level i+2 starts, so a magnitude below
+// cuts[0] is the lowest level. cuts must pass ValidateCuts.
+//
+// The score splits [0, MaxScore) into one equal band per level and places
+// the magnitude within its band: linearly between the cut-points, from zero
+// up to the first cut-point, and approaching MaxScore as the magnitude grows
+// past the last. Scores therefore sort by level first and magnitude second,
+// whatever rule produced them.
+func (s Scale) Grade(magnitude float64, cuts []float64) (string, float64) {
+	m := math.Abs(magnitude)
+	i := 0
+	for i < len(cuts) && m >= cuts[i] {
+		i++
+	}
+	var position float64
+	switch {
+	case len(cuts) == 0:
+	case i == 0:
+		position = m / cuts[0]
+	case i == len(cuts):
+		position = 1 - cuts[i-1]/m
+	default:
+		position = (m - cuts[i-1]) / (cuts[i] - cuts[i-1])
+	}
+	level := s.list()[i]
+	return level, s.score(i, position)
+}
+
+// Score returns the score in the middle of level's band, for rules with a
+// fixed severity. Unknown levels score zero.
+func (s Scale) Score(level string) float64 {
+	rank, ok := s.Rank(level)
+	if !ok {
+		return 0
+	}
+	return s.score(rank-1, 0.5)
+}
+
+func (s Scale) score(index int, position float64) float64 {
+	position = math.Max(0, math.Min(position, 0.999))
+	score := MaxScore * (float64(index) + position) / float64(s.Len())
+	return math.Round(score*100) / 100
+}
+
+func normalize(level string) string {
+	return strings.ToLower(strings.TrimSpace(level))
+}
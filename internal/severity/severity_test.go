@@ -0,0 +1,70 @@
+package severity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewScale(t *testing.T) {
+	scale, err := NewScale([]string{"Info", " warn ", "page"})
+	if err != nil {
+		t.Fatalf("NewScale: %v", err)
+	}
+	if !reflect.DeepEqual(scale.Levels(), []string{"info", "warn", "page"}) || scale.Lowest() != "info" {
+		t.Fatalf("unexpected levels: %v", scale.Levels())
+	}
+	if rank, ok := scale.Rank("PAGE"); !ok || rank != 3 {
+		t.Fatalf("expected page to rank 3, got %d %v", rank, ok)
+	}
+	if _, err := scale.Parse("critical"); err == nil || err.Error() != "unknown severity: critical (expected info|warn|page)" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if def, err := NewScale(nil); err != nil || def.String() != "low|medium|high|critical" {
+		t.Fatalf("expected the default scale, got %v %v", def, err)
+	}
+	for _, bad := range [][]string{{"only"}, {"low", ""}, {"low", "LOW"}} {
+		if _, err := NewScale(bad); err == nil {
+			t.Fatalf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestGradeOrdersByLevelThenMagnitude(t *testing.T) {
+	cuts := []float64{3, 4, 6}
+	tests := []struct {
+		magnitude float64
+		level     string
+		score     float64
+	}{
+		{0, Low, 0},
+		{1.5, Low, 12.5},
+		{3, Medium, 25},
+		{-3.5, Medium, 37.5},
+		{4, High, 50},
+		{6, Critical, 75},
+		{12, Critical, 87.5},
+	}
+	for _, tt := range tests {
+		level, score := Default.Grade(tt.magnitude, cuts)
+		if level != tt.level || score != tt.score {
+			t.Fatalf("Grade(%v) = %s %.2f, want %s %.2f", tt.magnitude, level, score, tt.level, tt.score)
+		}
+	}
+	if _, score := Default.Grade(1e9, cuts); score >= MaxScore {
+		t.Fatalf("expected scores below %d, got %.2f", MaxScore, score)
+	}
+	if got := Default.Score(High); got != 62.5 {
+		t.Fatalf("expected the middle of the high band, got %.2f", got)
+	}
+}
+
+func TestValidateCuts(t *testing.T) {
+	if err := Default.ValidateCuts([]float64{0.2, 0.5, 1}); err != nil {
+		t.Fatalf("ValidateCuts: %v", err)
+	}
+	for _, bad := range [][]float64{{3, 4}, {3, 3, 6}, {0, 4, 6}, {6, 4, 3}} {
+		if err := Default.ValidateCuts(bad); err == nil {
+			t.Fatalf("expected %v to be rejected", bad)
+		}
+	}
+}
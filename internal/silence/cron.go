@@ -0,0 +1,166 @@
+package silence
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a standard five-field cron expression (minute, hour, day of
+// month, month, day of week). Each field is a bit set of the values it allows.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day field: when both day fields are
+	// restricted a time matches either, as in cron.
+	domAny, dowAny bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseCron parses spec such as "0 2 * * *" (02:00 daily) or "0 22 * * 5"
+// (Fridays at 22:00). Fields accept *, values, ranges (1-5), lists (1,3) and
+// steps (*/15, 0-30/10); day of week 0 and 7 are Sunday.
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("schedule %q: expected 5 fields (minute hour day-of-month month day-of-week)", spec)
+	}
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", spec, err)
+		}
+		sets[i] = set
+	}
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, f cronField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid %s step: %q", f.name, part)
+			}
+			rangePart, step = part[:i], n
+		}
+		lo, hi := f.min, f.max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = cronValue(bounds[0], f); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = cronValue(bounds[1], f); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				hi = f.max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid %s range: %q", f.name, part)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func cronValue(s string, f cronField) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s: %q (expected %d-%d)", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// matches reports whether the schedule fires at t's minute.
+func (c *cronSchedule) matches(t time.Time) bool {
+	return c.minute&(1<<uint(t.Minute())) != 0 && c.hour&(1<<uint(t.Hour())) != 0 && c.dayMatches(t)
+}
+
+// dayMatches reports whether the schedule fires on t's day.
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	if c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// within reports whether t falls in a window of length d that started when
+// the schedule fired.
+func (c *cronSchedule) within(t time.Time, d time.Duration) bool {
+	prev, ok := c.prev(t, d)
+	return ok && t.Sub(prev) < d
+}
+
+// prev returns the most recent time at or before t's minute that the
+// schedule fires, searching no further back than d. It skips a month, day
+// or hour at a time when that field does not match and jumps to the
+// previous allowed minute within an hour, so a weekly window takes a few
+// dozen steps rather than one per minute.
+func (c *cronSchedule) prev(t time.Time, d time.Duration) (time.Time, bool) {
+	loc := t.Location()
+	m := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	for t.Sub(m) < d {
+		var next time.Time
+		switch {
+		case c.month&(1<<uint(m.Month())) == 0:
+			next = time.Date(m.Year(), m.Month(), 1, 0, 0, 0, 0, loc).Add(-time.Minute)
+		case !c.dayMatches(m):
+			next = time.Date(m.Year(), m.Month(), m.Day(), 0, 0, 0, 0, loc).Add(-time.Minute)
+		case c.hour&(1<<uint(m.Hour())) == 0:
+			next = time.Date(m.Year(), m.Month(), m.Day(), m.Hour(), 0, 0, 0, loc).Add(-time.Minute)
+		default:
+			// The highest allowed minute at or below m's, if any.
+			below := c.minute & (1<<uint(m.Minute()+1) - 1)
+			if below == 0 {
+				next = time.Date(m.Year(), m.Month(), m.Day(), m.Hour(), 0, 0, 0, loc).Add(-time.Minute)
+				break
+			}
+			minute := bits.Len64(below) - 1
+			if minute == m.Minute() {
+				return m, true
+			}
+			next = m.Add(time.Duration(minute-m.Minute()) * time.Minute)
+		}
+		// Clock changes can map a wall time to the current instant or later;
+		// always move back.
+		if !next.Before(m) {
+			next = m.Add(-time.Minute)
+		}
+		m = next
+	}
+	return time.Time{}, false
+}
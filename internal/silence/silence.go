@@ -0,0 +1,284 @@
+// Package silence suppresses alerts during known events: a one-off window
+// (a host under investigation), a recurring schedule (nightly backups, patch
+// windows), or both, restricted by metric, rule type, host and label
+// matchers. Silences come from config and from a local file managed with
+// `epagent silence add|list|expire`.
+package silence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/fsutil"
+)
+
+// SchemaVersion is the version of the silences file written by this build.
+const SchemaVersion = 1
+
+// MaxWindow bounds the length of a recurring window.
+const MaxWindow = 7 * 24 * time.Hour
+
+// Matcher keys with a meaning of their own; any other key matches a label.
+const (
+	KeyMetric   = "metric"
+	KeyRuleType = "rule_type"
+	KeyHostID   = "host_id"
+)
+
+// families are the metric families a metric matcher may name instead of a
+// glob, as elsewhere in config.
+var families = map[string]bool{"cpu": true, "mem": true, "disk": true, "net": true}
+
+// Silence suppresses matching alerts while it is active. Without End it
+// never expires; without Schedule it is active from Start to End, and with
+// Schedule only for Duration after each time the schedule fires (in
+// Timezone, UTC by default). Every matcher must match: values are globs
+// ("disk_*", "db-*"), and a metric matcher may also name a family.
+type Silence struct {
+	ID        string            `json:"id"`
+	Comment   string            `json:"comment,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Matchers  map[string]string `json:"matchers,omitempty"`
+	Start     time.Time         `json:"start"`
+	End       *time.Time        `json:"end,omitempty"`
+	Schedule  string            `json:"schedule,omitempty"`
+	Duration  string            `json:"duration,omitempty"`
+	Timezone  string            `json:"timezone,omitempty"`
+
+	cron     *cronSchedule
+	window   time.Duration
+	location *time.Location
+}
+
+// Compile validates s and prepares its schedule.
+func (s *Silence) Compile() error {
+	if strings.TrimSpace(s.ID) == "" {
+		return errors.New("silence id is required")
+	}
+	for key, value := range s.Matchers {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("silence %s: empty matcher key", s.ID)
+		}
+		if _, err := path.Match(value, ""); err != nil {
+			return fmt.Errorf("silence %s: matcher %s: %w", s.ID, key, err)
+		}
+	}
+	if s.End != nil && !s.End.After(s.Start) {
+		return fmt.Errorf("silence %s: end must be after start", s.ID)
+	}
+	s.cron, s.window, s.location = nil, 0, time.UTC
+	if s.Timezone != "" {
+		loc, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return fmt.Errorf("silence %s: %w", s.ID, err)
+		}
+		s.location = loc
+	}
+	if s.Schedule == "" {
+		if s.Duration != "" {
+			return fmt.Errorf("silence %s: duration needs a schedule", s.ID)
+		}
+		return nil
+	}
+	cron, err := parseCron(s.Schedule)
+	if err != nil {
+		return fmt.Errorf("silence %s: %w", s.ID, err)
+	}
+	window, err := time.ParseDuration(s.Duration)
+	if err != nil || window <= 0 || window > MaxWindow {
+		return fmt.Errorf("silence %s: schedule needs a positive duration up to %s, got %q", s.ID, MaxWindow, s.Duration)
+	}
+	s.cron, s.window = cron, window
+	return nil
+}
+
+// Expired reports whether s has ended at t.
+func (s Silence) Expired(t time.Time) bool {
+	return s.End != nil && !t.Before(*s.End)
+}
+
+// Active reports whether s suppresses alerts at t.
+func (s Silence) Active(t time.Time) bool {
+	if t.Before(s.Start) || s.Expired(t) {
+		return false
+	}
+	if s.cron == nil {
+		return true
+	}
+	return s.cron.within(t.In(s.location), s.window)
+}
+
+// Matches reports whether every matcher of s matches a.
+func (s Silence) Matches(a *anomaly.Anomaly) bool {
+	for key, pattern := range s.Matchers {
+		var value string
+		switch key {
+		case KeyMetric:
+			if families[pattern] && strings.HasPrefix(a.Name, pattern+"_") {
+				continue
+			}
+			value = a.Name
+		case KeyRuleType:
+			value = a.RuleType
+		case KeyHostID:
+			value = a.HostID
+		default:
+			value = a.Labels[key]
+		}
+		if ok, _ := path.Match(pattern, value); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Status is "active", "pending", "scheduled" (recurring, outside a window)
+// or "expired" at t.
+func (s Silence) Status(t time.Time) string {
+	switch {
+	case s.Expired(t):
+		return "expired"
+	case t.Before(s.Start):
+		return "pending"
+	case s.Active(t):
+		return "active"
+	default:
+		return "scheduled"
+	}
+}
+
+// List is a set of silences.
+type List []Silence
+
+// Compile validates every silence and checks that IDs are unique.
+func (l List) Compile() error {
+	seen := make(map[string]bool, len(l))
+	for i := range l {
+		if err := l[i].Compile(); err != nil {
+			return err
+		}
+		if seen[l[i].ID] {
+			return fmt.Errorf("duplicate silence id: %s", l[i].ID)
+		}
+		seen[l[i].ID] = true
+	}
+	return nil
+}
+
+// Match returns the ID of the first silence active at a's timestamp that
+// matches a, or "" when none does.
+func (l List) Match(a *anomaly.Anomaly) string {
+	for _, s := range l {
+		if s.Active(a.Timestamp) && s.Matches(a) {
+			return s.ID
+		}
+	}
+	return ""
+}
+
+// NewID returns a random silence ID.
+func NewID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(b)
+}
+
+type file struct {
+	SchemaVersion int  `json:"schema_version"`
+	Silences      List `json:"silences"`
+}
+
+// Load reads the silences file at path. A missing file has no silences.
+func Load(path string) (List, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("silences %s: %w", path, err)
+	}
+	if f.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("silences %s has schema_version %d; this build reads up to %d", path, f.SchemaVersion, SchemaVersion)
+	}
+	if err := f.Silences.Compile(); err != nil {
+		return nil, fmt.Errorf("silences %s: %w", path, err)
+	}
+	return f.Silences, nil
+}
+
+// Save writes l atomically (see fsutil.WriteFileAtomic), ordered by start time.
+func Save(path string, l List) error {
+	sorted := append(List{}, l...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+	data, err := json.MarshalIndent(file{SchemaVersion: SchemaVersion, Silences: sorted}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(path, append(data, '\n'), 0o644)
+}
+
+// Reloader re-reads a silences file when it changes, so silences added or
+// expired while watch runs take effect on the next sample. Static silences
+// (from config) are always included.
+type Reloader struct {
+	path    string
+	static  List
+	modTime time.Time
+	size    int64
+	list    List
+}
+
+// NewReloader loads path and returns a reloader for it.
+func NewReloader(path string, static List) (*Reloader, error) {
+	r := &Reloader{path: path, static: static}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// List returns the static silences followed by the file's.
+func (r *Reloader) List() List { return r.list }
+
+// Reload re-reads the file if its size or modification time changed and
+// reports whether the list changed. On error the previous list is kept.
+func (r *Reloader) Reload() (bool, error) {
+	var modTime time.Time
+	var size int64
+	info, err := os.Stat(r.path)
+	switch {
+	case err == nil:
+		modTime, size = info.ModTime(), info.Size()
+	case !errors.Is(err, os.ErrNotExist):
+		return false, err
+	}
+	if r.list != nil && modTime.Equal(r.modTime) && size == r.size {
+		return false, nil
+	}
+	loaded, err := Load(r.path)
+	if err != nil {
+		return false, err
+	}
+	r.modTime, r.size = modTime, size
+	r.list = append(append(List{}, r.static...), loaded...)
+	return true, nil
+}
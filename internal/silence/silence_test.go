@@ -0,0 +1,230 @@
+package silence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+)
+
+func TestParseCron(t *testing.T) {
+	c, err := parseCron("*/15 2-4 * * 1-5")
+	if err != nil {
+		t.Fatalf("parseCron: %v", err)
+	}
+	monday := time.Date(2026, 3, 2, 3, 30, 0, 0, time.UTC)
+	if !c.matches(monday) || c.matches(monday.Add(time.Minute)) || c.matches(monday.Add(5*24*time.Hour)) {
+		t.Fatal("expected weekdays at 02:00-04:59 every 15 minutes")
+	}
+	sunday, err := parseCron("0 0 * * 7")
+	if err != nil || !sunday.matches(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected 7 to mean Sunday: %v", err)
+	}
+	// Restricted day of month and day of week match either, as in cron.
+	either, _ := parseCron("0 12 1 * 1")
+	if !either.matches(time.Date(2026, 4, 1, 12, 0, 0, 0, time.UTC)) || !either.matches(time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)) {
+		t.Fatal("expected the 1st or a Monday to match")
+	}
+	for _, bad := range []string{"0 2 * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := parseCron(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestScheduledSilenceIsActiveDuringWindows(t *testing.T) {
+	s := Silence{ID: "backup", Schedule: "0 2 * * *", Duration: "90m", Timezone: "America/New_York"}
+	if err := s.Compile(); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	loc, _ := time.LoadLocation("America/New_York")
+	tests := []struct {
+		at   time.Time
+		want bool
+	}{
+		{time.Date(2026, 5, 4, 1, 59, 0, 0, loc), false},
+		{time.Date(2026, 5, 4, 2, 0, 0, 0, loc), true},
+		{time.Date(2026, 5, 4, 3, 29, 59, 0, loc), true},
+		{time.Date(2026, 5, 4, 3, 30, 0, 0, loc), false},
+		{time.Date(2026, 5, 4, 6, 15, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		if got := s.Active(tt.at); got != tt.want {
+			t.Fatalf("Active(%s) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+	if got := s.Status(time.Date(2026, 5, 4, 12, 0, 0, 0, loc)); got != "scheduled" {
+		t.Fatalf("expected scheduled outside a window, got %s", got)
+	}
+
+	end := time.Date(2026, 5, 5, 0, 0, 0, 0, time.UTC)
+	s.End = &end
+	if s.Active(time.Date(2026, 5, 5, 2, 30, 0, 0, loc)) || s.Status(end) != "expired" {
+		t.Fatal("expected no windows after the silence expired")
+	}
+}
+
+func TestCronWithinMatchesMinuteByMinuteScan(t *testing.T) {
+	// scan is the obvious definition: some minute in (t-d, t] fires.
+	scan := func(c *cronSchedule, at time.Time, d time.Duration) bool {
+		start := time.Date(at.Year(), at.Month(), at.Day(), at.Hour(), at.Minute(), 0, 0, at.Location())
+		for m := start; at.Sub(m) < d; m = m.Add(-time.Minute) {
+			if c.matches(m) {
+				return true
+			}
+		}
+		return false
+	}
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	specs := []string{"0 2 * * *", "*/15 2-4 * * 1-5", "30 22 * * 5", "0 0 1 * *", "0 12 1 * 1", "0 0 29 2 *", "59 23 31 12 *", "0 2 * 3,11 0"}
+	durations := []time.Duration{time.Minute, 90 * time.Minute, 24 * time.Hour, 7 * 24 * time.Hour}
+	// Around the 2026 US clock changes (March 8, November 1) and a leap day.
+	starts := []time.Time{
+		time.Date(2026, 3, 8, 0, 0, 0, 0, loc),
+		time.Date(2026, 11, 1, 0, 0, 0, 0, loc),
+		time.Date(2028, 2, 28, 12, 0, 0, 0, loc),
+		time.Date(2026, 12, 31, 20, 0, 0, 0, time.UTC),
+	}
+	for _, spec := range specs {
+		c, err := parseCron(spec)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", spec, err)
+		}
+		for _, start := range starts {
+			for at := start; at.Before(start.Add(8 * time.Hour)); at = at.Add(7*time.Minute + 13*time.Second) {
+				for _, d := range durations {
+					if got, want := c.within(at, d), scan(c, at, d); got != want {
+						t.Fatalf("%q within(%s, %s) = %v, want %v", spec, at, d, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkCronWithinWeeklyWindow(b *testing.B) {
+	// A weekly maintenance window checked late in the week, the worst case
+	// for a minute-by-minute scan (about 10,000 steps).
+	c, err := parseCron("0 22 * * 5")
+	if err != nil {
+		b.Fatalf("parseCron: %v", err)
+	}
+	at := time.Date(2026, 3, 13, 21, 59, 0, 0, time.UTC)
+	for i := 0; i < b.N; i++ {
+		if !c.within(at, 7*24*time.Hour) {
+			b.Fatal("expected the previous Friday's window to cover the time")
+		}
+	}
+}
+
+func TestMatchesEveryMatcher(t *testing.T) {
+	a := &anomaly.Anomaly{
+		Name:     "disk_used_percent",
+		RuleType: anomaly.RuleTypeStaticThreshold,
+		HostID:   "db-7",
+		Labels:   map[string]string{"env": "prod"},
+	}
+	tests := []struct {
+		matchers map[string]string
+		want     bool
+	}{
+		{nil, true},
+		{map[string]string{"metric": "disk"}, true},
+		{map[string]string{"metric": "disk_*", "host_id": "db-*", "env": "prod"}, true},
+		{map[string]string{"metric": "net"}, false},
+		{map[string]string{"host_id": "db-*", "rule_type": "zscore"}, false},
+		{map[string]string{"env": "staging"}, false},
+		{map[string]string{"team": "*"}, true},
+	}
+	for _, tt := range tests {
+		if got := (Silence{Matchers: tt.matchers}).Matches(a); got != tt.want {
+			t.Fatalf("Matches(%v) = %v, want %v", tt.matchers, got, tt.want)
+		}
+	}
+}
+
+func TestListMatchHonoursStartAndEnd(t *testing.T) {
+	start := time.Date(2026, 5, 4, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	l := List{
+		{ID: "investigation", Start: start, End: &end, Matchers: map[string]string{"host_id": "db-7"}},
+	}
+	if err := l.Compile(); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	a := &anomaly.Anomaly{Name: "cpu_percent", HostID: "db-7", Timestamp: start.Add(30 * time.Minute)}
+	if got := l.Match(a); got != "investigation" {
+		t.Fatalf("expected the investigation silence, got %q", got)
+	}
+	a.Timestamp = end
+	if got := l.Match(a); got != "" {
+		t.Fatalf("expected no match after expiry, got %q", got)
+	}
+
+	for _, bad := range []List{
+		{{}},
+		{{ID: "a"}, {ID: "a"}},
+		{{ID: "a", Start: start, End: &start}},
+		{{ID: "a", Duration: "1h"}},
+		{{ID: "a", Schedule: "0 2 * * *"}},
+		{{ID: "a", Schedule: "0 2 * * *", Duration: "200h"}},
+		{{ID: "a", Matchers: map[string]string{"metric": "[disk"}}},
+		{{ID: "a", Schedule: "0 2 * * *", Duration: "1h", Timezone: "Mars/Olympus"}},
+	} {
+		if err := bad.Compile(); err == nil {
+			t.Fatalf("expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestSaveLoadAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "silences.json")
+	if l, err := Load(path); err != nil || len(l) != 0 {
+		t.Fatalf("expected a missing file to have no silences, got %v %v", l, err)
+	}
+	static := List{{ID: "config"}}
+	r, err := NewReloader(path, static)
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+	if len(r.List()) != 1 {
+		t.Fatalf("expected only the config silence, got %+v", r.List())
+	}
+
+	end := time.Date(2026, 5, 4, 11, 0, 0, 0, time.UTC)
+	saved := List{{ID: "patch", Start: end.Add(-time.Hour), End: &end, Schedule: "0 22 * * 5", Duration: "4h"}}
+	if err := Save(path, saved); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	// Make sure the change is visible even on filesystems with coarse mtimes.
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	changed, err := r.Reload()
+	if err != nil || !changed {
+		t.Fatalf("expected a reload, got %v %v", changed, err)
+	}
+	list := r.List()
+	if len(list) != 2 || list[0].ID != "config" || list[1].ID != "patch" || list[1].cron == nil {
+		t.Fatalf("expected the config and compiled file silences, got %+v", list)
+	}
+	if changed, err := r.Reload(); err != nil || changed {
+		t.Fatalf("expected no reload for an unchanged file, got %v %v", changed, err)
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Chtimes(path, future.Add(time.Minute), future.Add(time.Minute)); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	if _, err := r.Reload(); err == nil || len(r.List()) != 2 {
+		t.Fatalf("expected an error and the previous silences, got %v %+v", err, r.List())
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/rollup"
+)
+
+// MergeStats describes what Merge did with its inputs.
+type MergeStats struct {
+	Samples          int
+	DuplicateSamples int
+	Rollups          int
+	DuplicateRollups int
+	Hosts            int
+}
+
+// Merge combines record sets from several files, drops records that are
+// byte-for-byte identical after schema upgrade, and orders the result by
+// host_id and then timestamp so every host forms one contiguous series.
+func Merge(inputs ...Records) (Records, MergeStats) {
+	var out Records
+	var stats MergeStats
+	seenSamples := map[string]bool{}
+	seenRollups := map[string]bool{}
+	hosts := map[string]bool{}
+	for _, in := range inputs {
+		for _, s := range in.Samples {
+			s = UpgradeSample(s)
+			key, err := json.Marshal(s)
+			if err == nil {
+				if seenSamples[string(key)] {
+					stats.DuplicateSamples++
+					continue
+				}
+				seenSamples[string(key)] = true
+			}
+			hosts[s.HostID] = true
+			out.Samples = append(out.Samples, s)
+		}
+		for _, r := range in.Rollups {
+			r.RecordType = rollup.RecordType
+			key, err := json.Marshal(r)
+			if err == nil {
+				if seenRollups[string(key)] {
+					stats.DuplicateRollups++
+					continue
+				}
+				seenRollups[string(key)] = true
+			}
+			hosts[r.HostID] = true
+			out.Rollups = append(out.Rollups, r)
+		}
+	}
+	sort.SliceStable(out.Samples, func(i, j int) bool {
+		a, b := out.Samples[i], out.Samples[j]
+		if a.HostID != b.HostID {
+			return a.HostID < b.HostID
+		}
+		return a.Timestamp.Before(b.Timestamp)
+	})
+	sort.SliceStable(out.Rollups, func(i, j int) bool {
+		a, b := out.Rollups[i], out.Rollups[j]
+		if a.HostID != b.HostID {
+			return a.HostID < b.HostID
+		}
+		return a.Start.Before(b.Start)
+	})
+	stats.Samples = len(out.Samples)
+	stats.Rollups = len(out.Rollups)
+	stats.Hosts = len(hosts)
+	return out, stats
+}
+
+// SplitByHost partitions merged records by host_id.
+func SplitByHost(records Records) map[string]Records {
+	out := map[string]Records{}
+	for _, s := range records.Samples {
+		r := out[s.HostID]
+		r.Samples = append(r.Samples, s)
+		out[s.HostID] = r
+	}
+	for _, rec := range records.Rollups {
+		r := out[rec.HostID]
+		r.Rollups = append(r.Rollups, rec)
+		out[rec.HostID] = r
+	}
+	return out
+}
+
+// FillHostID sets host_id on records that have none. It is used to tag
+// samples from files collected without a configured host ID.
+func FillHostID(records Records, hostID string) Records {
+	if hostID == "" {
+		return records
+	}
+	out := Records{
+		Samples: make([]collector.MetricSample, len(records.Samples)),
+		Rollups: make([]rollup.Record, len(records.Rollups)),
+	}
+	copy(out.Samples, records.Samples)
+	copy(out.Rollups, records.Rollups)
+	for i := range out.Samples {
+		if out.Samples[i].HostID == "" {
+			out.Samples[i].HostID = hostID
+		}
+	}
+	for i := range out.Rollups {
+		if out.Rollups[i].HostID == "" {
+			out.Rollups[i].HostID = hostID
+		}
+	}
+	return out
+}
+
+// HostFileName returns a filesystem-safe file name for a host's split file.
+func HostFileName(hostID string) string {
+	if strings.TrimSpace(hostID) == "" {
+		return "unknown-host.jsonl"
+	}
+	var b strings.Builder
+	for _, r := range hostID {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	name := strings.Trim(b.String(), ".")
+	if name == "" {
+		name = "host"
+	}
+	return name + ".jsonl"
+}
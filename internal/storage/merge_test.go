@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+)
+
+func TestMergeDedupesAndSortsByHostThenTime(t *testing.T) {
+	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	a1 := collector.MetricSample{Timestamp: t0, HostID: "b", CPUPercent: 1}
+	a2 := collector.MetricSample{Timestamp: t0.Add(time.Second), HostID: "a", CPUPercent: 2}
+	b1 := collector.MetricSample{Timestamp: t0.Add(-time.Second), HostID: "a", CPUPercent: 3}
+
+	// a2 appears in both files (e.g. a file concatenated twice).
+	merged, stats := Merge(
+		Records{Samples: []collector.MetricSample{a1, a2}},
+		Records{Samples: []collector.MetricSample{b1, a2}},
+	)
+	if stats.DuplicateSamples != 1 || stats.Samples != 3 || stats.Hosts != 2 {
+		t.Fatalf("unexpected merge stats: %+v", stats)
+	}
+	got := merged.Samples
+	if got[0].HostID != "a" || got[0].CPUPercent != 3 || got[1].HostID != "a" || got[1].CPUPercent != 2 || got[2].HostID != "b" {
+		t.Fatalf("expected samples ordered by host then timestamp, got %+v", got)
+	}
+}
+
+func TestSplitByHostAndFillHostID(t *testing.T) {
+	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	records := FillHostID(Records{Samples: []collector.MetricSample{
+		{Timestamp: t0, HostID: "laptop-1"},
+		{Timestamp: t0},
+	}}, "from-file")
+	split := SplitByHost(records)
+	if len(split) != 2 || len(split["laptop-1"].Samples) != 1 || len(split["from-file"].Samples) != 1 {
+		t.Fatalf("unexpected split: %+v", split)
+	}
+	if got := HostFileName("a/b c"); got != "a_b_c.jsonl" {
+		t.Fatalf("unexpected sanitized file name: %q", got)
+	}
+	if got := HostFileName(""); got != "unknown-host.jsonl" {
+		t.Fatalf("unexpected file name for empty host: %q", got)
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/rollup"
 )
 
 type Writer struct {
@@ -42,7 +45,7 @@ func NewWriterWithWriter(w io.Writer) *Writer {
 }
 
 func (w *Writer) Write(sample collector.MetricSample) error {
-	payload, err := json.Marshal(sample)
+	payload, err := json.Marshal(UpgradeSample(sample))
 	if err != nil {
 		return err
 	}
@@ -52,6 +55,37 @@ func (w *Writer) Write(sample collector.MetricSample) error {
 	return w.writer.Flush()
 }
 
+func (w *Writer) WriteRollup(record rollup.Record) error {
+	record.RecordType = rollup.RecordType
+	if record.SchemaVersion == 0 {
+		record.SchemaVersion = rollup.SchemaVersion
+	}
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := w.writer.Write(append(payload, '\n')); err != nil {
+		return err
+	}
+	return w.writer.Flush()
+}
+
+// WriteRecords writes rollups followed by samples, matching the layout that
+// compaction produces (older aggregates first, then raw history).
+func (w *Writer) WriteRecords(records Records) error {
+	for _, r := range records.Rollups {
+		if err := w.WriteRollup(r); err != nil {
+			return err
+		}
+	}
+	for _, s := range records.Samples {
+		if err := w.Write(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (w *Writer) Close() error {
 	if w.writer != nil {
 		_ = w.writer.Flush()
@@ -63,30 +97,427 @@ func (w *Writer) Close() error {
 }
 
 func ReadSamples(path string) ([]collector.MetricSample, error) {
+	samples, _, err := ReadSamplesWithOptions(path, ReadOptions{})
+	return samples, err
+}
+
+// ReadOptions controls how malformed JSONL lines are handled. A malformed final
+// line is always skipped because it is the usual result of the agent being
+// killed mid-write; Tolerant extends that to every line.
+type ReadOptions struct {
+	Tolerant       bool
+	QuarantinePath string
+}
+
+type SkippedLine struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type ReadStats struct {
+	Lines   int
+	Skipped []SkippedLine
+	// Upgraded counts records written by an older schema version; NewerSchema
+	// counts records from a newer agent that were read best-effort.
+	Upgraded    int
+	NewerSchema int
+	// Rollups counts rollup records; UnknownRecords counts records with a
+	// record_type this build does not understand, which are ignored.
+	Rollups        int
+	UnknownRecords int
+}
+
+func (s ReadStats) SkippedLineNumbers() []int {
+	if len(s.Skipped) == 0 {
+		return nil
+	}
+	out := make([]int, 0, len(s.Skipped))
+	for _, sk := range s.Skipped {
+		out = append(out, sk.Line)
+	}
+	return out
+}
+
+// Records holds the contents of a JSONL file that may mix raw samples with
+// rollup records written by compaction.
+type Records struct {
+	Samples []collector.MetricSample
+	Rollups []rollup.Record
+}
+
+func ReadSamplesWithOptions(path string, opts ReadOptions) ([]collector.MetricSample, ReadStats, error) {
+	records, stats, err := ReadRecords(path, opts)
+	if err != nil {
+		return nil, stats, err
+	}
+	return records.Samples, stats, nil
+}
+
+type recordHeader struct {
+	RecordType string `json:"record_type"`
+}
+
+// maxLineSize bounds the memory one JSONL line may use. Longer lines are
+// reported as skipped instead of failing the read.
+const maxLineSize = 1024 * 1024
+
+// readLine returns the next line of r without its newline. A line longer than
+// limit is consumed but not returned, and oversized is set instead. It
+// returns io.EOF once r is exhausted.
+func readLine(r *bufio.Reader, limit int) ([]byte, bool, error) {
+	var line []byte
+	oversized := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		chunk = bytes.TrimSuffix(chunk, []byte("\n"))
+		if !oversized && len(line)+len(chunk) > limit {
+			oversized, line = true, nil
+		}
+		if !oversized {
+			line = append(line, chunk...)
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && (len(line) > 0 || oversized):
+			return line, oversized, nil
+		case err != nil:
+			return nil, false, err
+		}
+		return line, oversized, nil
+	}
+}
+
+func ReadRecords(path string, opts ReadOptions) (Records, ReadStats, error) {
+	var stats ReadStats
+	var records Records
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return records, stats, err
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
-	samples := make([]collector.MetricSample, 0)
+	var quarantined [][]byte
+	reader := bufio.NewReaderSize(file, 64*1024)
+	records.Samples = make([]collector.MetricSample, 0)
 	lineNo := 0
-	for scanner.Scan() {
+	// pending holds a malformed line that is only acceptable if it turns out to
+	// be the last non-blank line of the file.
+	var pending *SkippedLine
+	var pendingRaw []byte
+	for {
+		line, oversized, readErr := readLine(reader, maxLineSize)
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return Records{}, stats, readErr
+		}
 		lineNo++
-		line := bytes.TrimSpace(scanner.Bytes())
-		if len(line) == 0 {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 && !oversized {
 			continue
 		}
+		if pending != nil {
+			return Records{}, stats, fmt.Errorf("invalid jsonl at line %d: %s", pending.Line, pending.Error)
+		}
+		if oversized {
+			// The line is not kept, so it is skipped without being quarantined.
+			skipped := SkippedLine{Line: lineNo, Error: fmt.Sprintf("line is longer than %d bytes", maxLineSize)}
+			if opts.Tolerant {
+				stats.Skipped = append(stats.Skipped, skipped)
+			} else {
+				pending = &skipped
+			}
+			continue
+		}
+		var header recordHeader
+		if bytes.Contains(line, []byte(`"record_type"`)) {
+			// Decode errors fall through to the sample decode below so
+			// malformed lines are reported consistently.
+			_ = json.Unmarshal(line, &header)
+		}
 		var sample collector.MetricSample
-		if err := json.Unmarshal(line, &sample); err != nil {
-			return nil, fmt.Errorf("invalid jsonl at line %d: %w", lineNo, err)
+		var rec rollup.Record
+		var err error
+		switch header.RecordType {
+		case "":
+			err = json.Unmarshal(line, &sample)
+		case rollup.RecordType:
+			err = json.Unmarshal(line, &rec)
+		default:
+			stats.UnknownRecords++
+			continue
+		}
+		if err != nil {
+			skipped := SkippedLine{Line: lineNo, Error: err.Error()}
+			raw := append([]byte(nil), line...)
+			if opts.Tolerant {
+				stats.Skipped = append(stats.Skipped, skipped)
+				quarantined = append(quarantined, raw)
+				continue
+			}
+			pending = &skipped
+			pendingRaw = raw
+			continue
+		}
+		if header.RecordType == rollup.RecordType {
+			stats.Rollups++
+			records.Rollups = append(records.Rollups, rec)
+			continue
+		}
+		switch {
+		case sample.SchemaVersion < collector.SchemaVersion:
+			stats.Upgraded++
+			sample = UpgradeSample(sample)
+		case sample.SchemaVersion > collector.SchemaVersion:
+			stats.NewerSchema++
+		}
+		records.Samples = append(records.Samples, sample)
+	}
+	if pending != nil {
+		stats.Skipped = append(stats.Skipped, *pending)
+		if pendingRaw != nil {
+			quarantined = append(quarantined, pendingRaw)
+		}
+	}
+	stats.Lines = lineNo
+	if opts.QuarantinePath != "" && len(quarantined) > 0 {
+		if err := appendLines(opts.QuarantinePath, quarantined); err != nil {
+			return Records{}, stats, fmt.Errorf("quarantine: %w", err)
+		}
+	}
+	return records, stats, nil
+}
+
+// ErrChanged is returned by ReplaceRecords when the file was written to after
+// it was read.
+var ErrChanged = errors.New("file changed since it was read")
+
+// RewriteRecords atomically replaces path with the given rollups followed by
+// the samples, in the order WriteRecords writes them. The new file is written
+// next to path and renamed over it.
+func RewriteRecords(path string, records Records) error {
+	return rewriteRecords(path, records, nil)
+}
+
+// ReplaceRecords is RewriteRecords for records read from path after it was
+// stat'ed as read. It returns ErrChanged instead of renaming when path's size
+// or modification time no longer match, so samples appended in the meantime
+// (by a running collect or watch) are not silently dropped.
+func ReplaceRecords(path string, records Records, read os.FileInfo) error {
+	return rewriteRecords(path, records, func() error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Size() != read.Size() || !info.ModTime().Equal(read.ModTime()) {
+			return fmt.Errorf("%s: %w", path, ErrChanged)
+		}
+		return nil
+	})
+}
+
+func rewriteRecords(path string, records Records, check func() error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	w := &Writer{closer: tmp, writer: bufio.NewWriter(tmp)}
+	if err := w.WriteRecords(records); err != nil {
+		w.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		_ = os.Chmod(tmpPath, info.Mode().Perm())
+	}
+	if check != nil {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// UpgradeSample normalizes a record written by an older agent into the current
+// collector.SchemaVersion shape. Records that are current or newer are returned
+// unchanged.
+func UpgradeSample(sample collector.MetricSample) collector.MetricSample {
+	if sample.SchemaVersion >= collector.SchemaVersion {
+		return sample
+	}
+	// Version 0 predates schema_version: a missing metric_families object
+	// meant every family was collected.
+	if sample.MetricFamilies == nil {
+		families := collector.DefaultMetricFamilies()
+		sample.MetricFamilies = &families
+	}
+	sample.SchemaVersion = collector.SchemaVersion
+	return sample
+}
+
+func appendLines(path string, lines [][]byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, line := range lines {
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// RepairTail makes path safe to append to after an interrupted write. A final
+// line without a trailing newline is kept (newline-terminated) when it parses
+// as JSON and truncated otherwise. It returns the number of bytes removed.
+// Missing files are not an error.
+func RepairTail(path string) (int64, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
 		}
-		samples = append(samples, sample)
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if size == 0 || !info.Mode().IsRegular() {
+		return 0, nil
 	}
-	if err := scanner.Err(); err != nil {
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, size-1); err != nil {
+		return 0, err
+	}
+	if last[0] == '\n' {
+		return 0, nil
+	}
+
+	// Walk backwards to the start of the unterminated line.
+	const chunkSize = 64 * 1024
+	start := int64(0)
+	buf := make([]byte, chunkSize)
+	for end := size; end > 0; {
+		off := end - chunkSize
+		if off < 0 {
+			off = 0
+		}
+		n, err := file.ReadAt(buf[:end-off], off)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			start = off + int64(i) + 1
+			break
+		}
+		end = off
+	}
+
+	tail := make([]byte, size-start)
+	if _, err := file.ReadAt(tail, start); err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	trimmed := bytes.TrimSpace(tail)
+	if len(trimmed) > 0 && json.Valid(trimmed) {
+		if _, err := file.WriteAt([]byte{'\n'}, size); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+	if err := file.Truncate(start); err != nil {
+		return 0, err
+	}
+	return size - start, nil
+}
+
+// ReadSamplesTail returns up to the last n samples of path, oldest first,
+// reading backwards so warming from a large file stays cheap. Only samples
+// from hostID are kept unless hostID is empty. Rollups, unknown record types
+// and malformed lines (such as a torn final write) are skipped.
+func ReadSamplesTail(path string, n int, hostID string) ([]collector.MetricSample, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
 		return nil, err
 	}
-	return samples, nil
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	const chunkSize = 64 * 1024
+	out := make([]collector.MetricSample, 0, n)
+	var carry []byte // start of a line whose beginning is in an earlier chunk
+	for end := info.Size(); end > 0 && len(out) < n; {
+		off := end - chunkSize
+		if off < 0 {
+			off = 0
+		}
+		buf := make([]byte, end-off, int(end-off)+len(carry))
+		if _, err := file.ReadAt(buf, off); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		buf = append(buf, carry...)
+		lines := bytes.Split(buf, []byte{'\n'})
+		if off > 0 {
+			carry = lines[0]
+			lines = lines[1:]
+		} else {
+			carry = nil
+		}
+		for i := len(lines) - 1; i >= 0 && len(out) < n; i-- {
+			if sample, ok := parseTailSample(lines[i]); ok && (hostID == "" || sample.HostID == hostID) {
+				out = append(out, sample)
+			}
+		}
+		end = off
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
+
+func parseTailSample(line []byte) (collector.MetricSample, bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return collector.MetricSample{}, false
+	}
+	if bytes.Contains(line, []byte(`"record_type"`)) {
+		var header recordHeader
+		if err := json.Unmarshal(line, &header); err != nil || header.RecordType != "" {
+			return collector.MetricSample{}, false
+		}
+	}
+	var sample collector.MetricSample
+	if err := json.Unmarshal(line, &sample); err != nil {
+		return collector.MetricSample{}, false
+	}
+	return UpgradeSample(sample), true
 }
@@ -2,12 +2,15 @@ package storage
 
 import (
 	"bytes"
+	"errors"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/rollup"
 )
 
 func TestReadSamplesSkipsBlankLines(t *testing.T) {
@@ -43,6 +46,7 @@ func TestReadSamplesReportsLineNumberOnParseError(t *testing.T) {
 
 	payload := `{"timestamp":"2026-02-01T00:00:00Z","host_id":"","cpu_percent":1,"mem_used_percent":2,"disk_used_percent":3,"disk_read_bytes":4,"disk_write_bytes":5,"net_rx_bytes":6,"net_tx_bytes":7}
 not-json
+{"timestamp":"2026-02-01T00:00:02Z","host_id":"","cpu_percent":1}
 `
 	if _, err := f.WriteString(payload); err != nil {
 		t.Fatalf("WriteString: %v", err)
@@ -57,6 +61,254 @@ not-json
 	}
 }
 
+func TestReadSamplesSkipsTornFinalLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.jsonl")
+	payload := `{"timestamp":"2026-02-01T00:00:00Z","cpu_percent":1}
+{"timestamp":"2026-02-01T00:00:01Z","cpu_percent":2}
+{"timestamp":"2026-02-01T00:00:02Z","cpu_pe`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	samples, stats, err := ReadSamplesWithOptions(path, ReadOptions{})
+	if err != nil {
+		t.Fatalf("ReadSamplesWithOptions: %v", err)
+	}
+	if got, want := len(samples), 2; got != want {
+		t.Fatalf("expected %d samples, got %d", want, got)
+	}
+	if got := stats.SkippedLineNumbers(); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("expected line 3 to be skipped, got %v", got)
+	}
+}
+
+func TestReadSamplesTolerantSkipsAndQuarantines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "samples.jsonl")
+	quarantine := filepath.Join(dir, "bad.jsonl")
+	payload := `{"timestamp":"2026-02-01T00:00:00Z","cpu_percent":1}
+garbage-1
+{"timestamp":"2026-02-01T00:00:01Z","cpu_percent":2}
+garbage-2
+{"timestamp":"2026-02-01T00:00:02Z","cpu_percent":3}
+`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if _, _, err := ReadSamplesWithOptions(path, ReadOptions{}); err == nil {
+		t.Fatal("expected strict mode to reject malformed middle line")
+	}
+
+	samples, stats, err := ReadSamplesWithOptions(path, ReadOptions{Tolerant: true, QuarantinePath: quarantine})
+	if err != nil {
+		t.Fatalf("ReadSamplesWithOptions: %v", err)
+	}
+	if got, want := len(samples), 3; got != want {
+		t.Fatalf("expected %d samples, got %d", want, got)
+	}
+	lines := stats.SkippedLineNumbers()
+	if len(lines) != 2 || lines[0] != 2 || lines[1] != 4 {
+		t.Fatalf("expected lines 2 and 4 skipped, got %v", lines)
+	}
+	got, err := os.ReadFile(quarantine)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != "garbage-1\ngarbage-2\n" {
+		t.Fatalf("unexpected quarantine contents: %q", string(got))
+	}
+}
+
+func TestReadSamplesSkipsOversizedLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "samples.jsonl")
+	quarantine := filepath.Join(dir, "bad.jsonl")
+	oversized := `{"timestamp":"2026-02-01T00:00:01Z","note":"` + strings.Repeat("x", 2*maxLineSize) + `"}`
+	payload := `{"timestamp":"2026-02-01T00:00:00Z","cpu_percent":1}
+` + oversized + `
+{"timestamp":"2026-02-01T00:00:02Z","cpu_percent":3}
+`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if _, _, err := ReadSamplesWithOptions(path, ReadOptions{}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected strict mode to reject the oversized middle line, got %v", err)
+	}
+
+	samples, stats, err := ReadSamplesWithOptions(path, ReadOptions{Tolerant: true, QuarantinePath: quarantine})
+	if err != nil {
+		t.Fatalf("ReadSamplesWithOptions: %v", err)
+	}
+	if len(samples) != 2 || stats.Lines != 3 {
+		t.Fatalf("expected 2 samples from 3 lines, got %d samples from %d lines", len(samples), stats.Lines)
+	}
+	if got := stats.SkippedLineNumbers(); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("expected line 2 to be skipped, got %v", got)
+	}
+	if _, err := os.Stat(quarantine); !os.IsNotExist(err) {
+		t.Fatalf("expected the oversized line not to be quarantined, got %v", err)
+	}
+
+	// An oversized final line is treated like a torn write.
+	if err := os.WriteFile(path, []byte(payload[:strings.LastIndex(payload[:len(payload)-1], "\n")+1]), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	samples, stats, err = ReadSamplesWithOptions(path, ReadOptions{})
+	if err != nil || len(samples) != 1 || len(stats.Skipped) != 1 {
+		t.Fatalf("expected the oversized final line to be skipped, got %d samples, %v, %v", len(samples), stats.Skipped, err)
+	}
+}
+
+func TestRepairTailTruncatesTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.jsonl")
+	good := `{"timestamp":"2026-02-01T00:00:00Z","cpu_percent":1}` + "\n"
+	if err := os.WriteFile(path, []byte(good+`{"timestamp":"2026-02-01T00:00:01Z","cpu`), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	removed, err := RepairTail(path)
+	if err != nil {
+		t.Fatalf("RepairTail: %v", err)
+	}
+	if removed == 0 {
+		t.Fatal("expected torn bytes to be removed")
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != good {
+		t.Fatalf("unexpected repaired contents: %q", string(got))
+	}
+}
+
+func TestRepairTailTerminatesCompleteRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.jsonl")
+	record := `{"timestamp":"2026-02-01T00:00:00Z","cpu_percent":1}`
+	if err := os.WriteFile(path, []byte(record), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	removed, err := RepairTail(path)
+	if err != nil {
+		t.Fatalf("RepairTail: %v", err)
+	}
+	if removed != 0 {
+		t.Fatalf("expected nothing removed, got %d", removed)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != record+"\n" {
+		t.Fatalf("expected record to be newline-terminated, got %q", string(got))
+	}
+
+	if _, err := RepairTail(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil {
+		t.Fatalf("expected missing file to be ignored, got %v", err)
+	}
+}
+
+func TestReadSamplesUpgradesLegacyRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.jsonl")
+	payload := `{"timestamp":"2026-02-01T00:00:00Z","cpu_percent":1}
+{"schema_version":1,"timestamp":"2026-02-01T00:00:01Z","cpu_percent":2,"metric_families":{"cpu":true,"mem":false,"disk":false,"net":false}}
+{"schema_version":99,"timestamp":"2026-02-01T00:00:02Z","cpu_percent":3,"future_field":true}
+`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	samples, stats, err := ReadSamplesWithOptions(path, ReadOptions{})
+	if err != nil {
+		t.Fatalf("ReadSamplesWithOptions: %v", err)
+	}
+	if got, want := len(samples), 3; got != want {
+		t.Fatalf("expected %d samples, got %d", want, got)
+	}
+	legacy := samples[0]
+	if legacy.SchemaVersion != collector.SchemaVersion {
+		t.Fatalf("expected legacy record upgraded to version %d, got %d", collector.SchemaVersion, legacy.SchemaVersion)
+	}
+	if legacy.MetricFamilies == nil || *legacy.MetricFamilies != collector.DefaultMetricFamilies() {
+		t.Fatalf("expected legacy record to get explicit default metric families, got %+v", legacy.MetricFamilies)
+	}
+	if samples[1].MetricFamilies.Mem {
+		t.Fatalf("expected current record to keep its metric families")
+	}
+	if stats.Upgraded != 1 || stats.NewerSchema != 1 {
+		t.Fatalf("expected 1 upgraded and 1 newer record, got %+v", stats)
+	}
+}
+
+func TestWriterStampsSchemaVersion(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterWithWriter(&buf)
+	if err := w.Write(collector.MetricSample{Timestamp: time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"schema_version":1`) || !strings.Contains(buf.String(), `"metric_families"`) {
+		t.Fatalf("expected current schema version and explicit families, got: %s", buf.String())
+	}
+}
+
+func TestRewriteRecordsRoundTripsRollups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.jsonl")
+	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	in := Records{
+		Samples: []collector.MetricSample{{Timestamp: t0.Add(time.Hour), HostID: "a", CPUPercent: 5}},
+		Rollups: []rollup.Record{{
+			Resolution: "1m0s",
+			Start:      t0,
+			End:        t0.Add(time.Minute),
+			HostID:     "a",
+			Samples:    12,
+			Metrics:    map[string]rollup.Aggregate{"cpu_percent": {Count: 12, Min: 1, Mean: 2, Max: 3, P95: 3}},
+		}},
+	}
+	if err := RewriteRecords(path, in); err != nil {
+		t.Fatalf("RewriteRecords: %v", err)
+	}
+
+	out, stats, err := ReadRecords(path, ReadOptions{})
+	if err != nil {
+		t.Fatalf("ReadRecords: %v", err)
+	}
+	if len(out.Samples) != 1 || len(out.Rollups) != 1 || stats.Rollups != 1 {
+		t.Fatalf("unexpected records: %d samples, %d rollups (%+v)", len(out.Samples), len(out.Rollups), stats)
+	}
+	if out.Rollups[0].RecordType != rollup.RecordType || out.Rollups[0].Metrics["cpu_percent"].Count != 12 {
+		t.Fatalf("unexpected rollup: %+v", out.Rollups[0])
+	}
+
+	samples, err := ReadSamples(path)
+	if err != nil {
+		t.Fatalf("ReadSamples: %v", err)
+	}
+	if len(samples) != 1 {
+		t.Fatalf("expected ReadSamples to ignore rollup records, got %d samples", len(samples))
+	}
+}
+
+func TestReadRecordsIgnoresUnknownRecordTypes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.jsonl")
+	payload := `{"record_type":"from_the_future","value":1}
+{"timestamp":"2026-02-01T00:00:00Z","cpu_percent":1}
+`
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	records, stats, err := ReadRecords(path, ReadOptions{})
+	if err != nil {
+		t.Fatalf("ReadRecords: %v", err)
+	}
+	if len(records.Samples) != 1 || stats.UnknownRecords != 1 {
+		t.Fatalf("expected 1 sample and 1 unknown record, got %d/%+v", len(records.Samples), stats)
+	}
+}
+
 func TestWriterWithWriterWritesJSONL(t *testing.T) {
 	var buf bytes.Buffer
 	w := NewWriterWithWriter(&buf)
@@ -121,3 +373,94 @@ func TestNewWriterWithOptions_TruncateOverwrites(t *testing.T) {
 		t.Fatalf("expected new contents, got: %s", string(after))
 	}
 }
+
+func TestReadSamplesTailReturnsLastSamplesForHost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.jsonl")
+	w, err := NewWriter(path)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	if err := w.WriteRollup(rollup.Record{Start: base}); err != nil {
+		t.Fatalf("WriteRollup: %v", err)
+	}
+	// Enough records to span several read chunks.
+	for i := 0; i < 3000; i++ {
+		host := "a"
+		if i%2 == 1 {
+			host = "b"
+		}
+		if err := w.Write(collector.MetricSample{Timestamp: base.Add(time.Duration(i) * time.Second), HostID: host, CPUPercent: float64(i)}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	w.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	f.WriteString(`{"timestamp":"2026-02-01T01:00:00Z","host_id":"a","cpu_`)
+	f.Close()
+
+	samples, err := ReadSamplesTail(path, 5, "a")
+	if err != nil {
+		t.Fatalf("ReadSamplesTail: %v", err)
+	}
+	if len(samples) != 5 {
+		t.Fatalf("expected 5 samples, got %d", len(samples))
+	}
+	for i, s := range samples {
+		if want := float64(2990 + 2*i); s.HostID != "a" || s.CPUPercent != want {
+			t.Fatalf("sample %d: got host %q cpu %v, want a %v", i, s.HostID, s.CPUPercent, want)
+		}
+	}
+
+	all, err := ReadSamplesTail(path, 10000, "")
+	if err != nil {
+		t.Fatalf("ReadSamplesTail: %v", err)
+	}
+	if len(all) != 3000 || all[0].CPUPercent != 0 || all[2999].CPUPercent != 2999 {
+		t.Fatalf("expected all 3000 samples in order, got %d", len(all))
+	}
+}
+
+func TestReplaceRecordsRefusesAFileThatGrew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.jsonl")
+	t0 := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	w, err := NewWriter(path)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	defer w.Close()
+	if err := w.Write(collector.MetricSample{Timestamp: t0, HostID: "a"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	read, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	records := Records{Samples: []collector.MetricSample{{Timestamp: t0, HostID: "a"}}}
+
+	// A collector appends after the file was read.
+	if err := w.Write(collector.MetricSample{Timestamp: t0.Add(time.Second), HostID: "a"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := ReplaceRecords(path, records, read); !errors.Is(err, ErrChanged) {
+		t.Fatalf("expected ErrChanged, got %v", err)
+	}
+	samples, err := ReadSamples(path)
+	if err != nil || len(samples) != 2 {
+		t.Fatalf("expected the appended sample to be kept, got %d samples (%v)", len(samples), err)
+	}
+
+	read, err = os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if err := ReplaceRecords(path, records, read); err != nil {
+		t.Fatalf("ReplaceRecords: %v", err)
+	}
+	if samples, err := ReadSamples(path); err != nil || len(samples) != 1 {
+		t.Fatalf("expected the file to be replaced, got %d samples (%v)", len(samples), err)
+	}
+}
@@ -2,71 +2,150 @@ package watch
 
 import (
 	"fmt"
-	"strings"
+	"sort"
 	"time"
 
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/alert"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/explain"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/report"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/severity"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/silence"
 )
 
 type Engine struct {
-	detector         *anomaly.Detector
-	staticThresholds map[string]float64
-	minRank          int
-	cooldown         time.Duration
-	lastSent         map[string]time.Time
-	prev             *collector.MetricSample
-	window           int
-	threshold        float64
+	evaluator *anomaly.Evaluator
+	minRank   int
+	cooldown  time.Duration
+	// minRanks and cooldowns override minRank and cooldown per metric.
+	minRanks  map[string]int
+	cooldowns map[string]time.Duration
+	lastSent  map[string]time.Time
+	prev      *collector.MetricSample
+	window    int
+	threshold float64
+	incidents *anomaly.IncidentTracker
+	scale     severity.Scale
+	explainer *explain.Explainer
+	silences  silence.List
+	// expressions checks the expression rules against whole samples.
+	expressions *anomaly.ExpressionEvaluator
+	// fingerprint identifies the detector settings for checkpoints.
+	fingerprint string
+}
+
+// EngineOptions configures NewEngineWithOptions.
+type EngineOptions struct {
+	WindowSize int
+	Threshold  float64
+	// Rules are the static, percentile, direction and sustain rules applied
+	// on top of the baseline detector.
+	Rules       anomaly.Rules
+	MinSeverity string
+	Cooldown    time.Duration
+	// Algorithms selects the detector algorithm per metric (zscore when empty).
+	Algorithms anomaly.AlgorithmConfig
+	// MinSeverities and Cooldowns override MinSeverity and Cooldown per
+	// metric.
+	MinSeverities map[string]string
+	Cooldowns     map[string]time.Duration
+	// IncidentWindow groups alerts on the host this close together into an
+	// incident (anomaly.DefaultIncidentWindow when zero).
+	IncidentWindow time.Duration
+	// Explainer rewrites explanations and hints from templates; nil keeps
+	// the built-in English text.
+	Explainer *explain.Explainer
+	// Silences suppress matching alerts while active; see SetSilences.
+	Silences silence.List
 }
 
 func NewEngine(windowSize int, threshold float64, staticThresholds map[string]float64, minSeverity string, cooldown time.Duration) (*Engine, error) {
-	windowSize, threshold = report.NormalizeParams(windowSize, threshold)
+	return NewEngineWithOptions(EngineOptions{
+		WindowSize:  windowSize,
+		Threshold:   threshold,
+		Rules:       anomaly.Rules{StaticThresholds: staticThresholds},
+		MinSeverity: minSeverity,
+		Cooldown:    cooldown,
+	})
+}
+
+func NewEngineWithOptions(opts EngineOptions) (*Engine, error) {
+	windowSize, threshold := report.NormalizeParams(opts.WindowSize, opts.Threshold)
+	minSeverity, cooldown := opts.MinSeverity, opts.Cooldown
+	scale := opts.Rules.Severity.Scale
 
 	if minSeverity == "" {
-		minSeverity = "low"
+		minSeverity = scale.Lowest()
 	}
-	minSeverity = strings.ToLower(strings.TrimSpace(minSeverity))
-	minRank, ok := alert.SeverityRank(minSeverity)
-	if !ok {
-		return nil, fmt.Errorf("unknown severity: %s (expected low|medium|high|critical)", minSeverity)
+	minRank, err := parseMinSeverity(scale, minSeverity)
+	if err != nil {
+		return nil, err
 	}
 	if cooldown < 0 {
 		return nil, fmt.Errorf("cooldown must be greater than or equal to zero")
 	}
+	minRanks := make(map[string]int, len(opts.MinSeverities))
+	for metric, level := range opts.MinSeverities {
+		rank, err := parseMinSeverity(scale, level)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", metric, err)
+		}
+		minRanks[metric] = rank
+	}
+	cooldowns := make(map[string]time.Duration, len(opts.Cooldowns))
+	for metric, d := range opts.Cooldowns {
+		if d < 0 {
+			return nil, fmt.Errorf("%s: cooldown must be greater than or equal to zero", metric)
+		}
+		cooldowns[metric] = d
+	}
+	if opts.IncidentWindow < 0 {
+		return nil, fmt.Errorf("incident window must be greater than or equal to zero")
+	}
+	if err := opts.Algorithms.Validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.Rules.Validate(); err != nil {
+		return nil, err
+	}
 
+	rules := opts.Rules
+	rules.StaticThresholds = cloneThresholds(rules.StaticThresholds)
+	rules.StaticLowerThresholds = cloneThresholds(rules.StaticLowerThresholds)
 	return &Engine{
-		detector:         anomaly.NewDetector(windowSize, threshold),
-		staticThresholds: cloneThresholds(staticThresholds),
-		minRank:          minRank,
-		cooldown:         cooldown,
-		lastSent:         make(map[string]time.Time),
-		window:           windowSize,
-		threshold:        threshold,
+		evaluator: anomaly.NewEvaluator(windowSize, threshold, opts.Algorithms, rules),
+		minRank:   minRank,
+		cooldown:  cooldown,
+		minRanks:  minRanks,
+		cooldowns: cooldowns,
+		lastSent:  make(map[string]time.Time),
+		window:    windowSize,
+		threshold: threshold,
+		incidents: anomaly.NewIncidentTracker(opts.IncidentWindow),
+		scale:     scale,
+		explainer: opts.Explainer,
+		silences:  opts.Silences,
+
+		expressions: anomaly.NewExpressionEvaluator(rules),
+		fingerprint: stateFingerprint(windowSize, threshold, opts.Algorithms),
 	}, nil
 }
 
+// SetSilences replaces the silences, e.g. after the silences file changed.
+func (e *Engine) SetSilences(silences silence.List) {
+	e.silences = silences
+}
+
 func (e *Engine) Params() (windowSize int, threshold float64) {
 	return e.window, e.threshold
 }
 
 func (e *Engine) Observe(sample collector.MetricSample) []alert.Alert {
-	families := collector.DefaultMetricFamilies()
-	if sample.MetricFamilies != nil {
-		families = *sample.MetricFamilies
-	}
 	if e.prev == nil {
 		// Seed the detector with the absolute metrics so we can start learning immediately.
-		if families.CPU {
-			_ = e.detector.Check("cpu_percent", sample.CPUPercent)
-		}
-		if families.Mem {
-			_ = e.detector.Check("mem_used_percent", sample.MemUsedPercent)
-		}
-		if families.Disk {
-			_ = e.detector.Check("disk_used_percent", sample.DiskUsedPercent)
+		for name, value := range collector.DeriveMetrics(nil, sample) {
+			e.evaluator.Learn(name, sample.Timestamp, value)
 		}
 		e.prev = &sample
 		return nil
@@ -74,85 +153,93 @@ func (e *Engine) Observe(sample collector.MetricSample) []alert.Alert {
 
 	prev := *e.prev
 	e.prev = &sample
+	metrics := collector.DeriveMetrics(&prev, sample)
 
-	prevFamilies := collector.DefaultMetricFamilies()
-	if prev.MetricFamilies != nil {
-		prevFamilies = *prev.MetricFamilies
-	}
-
-	dt := sample.Timestamp.Sub(prev.Timestamp).Seconds()
-	if dt <= 0 {
-		dt = 1
-	}
-
-	metrics := map[string]float64{}
-	if families.CPU {
-		metrics["cpu_percent"] = sample.CPUPercent
-	}
-	if families.Mem {
-		metrics["mem_used_percent"] = sample.MemUsedPercent
-	}
-	if families.Disk {
-		metrics["disk_used_percent"] = sample.DiskUsedPercent
-		if prevFamilies.Disk {
-			metrics["disk_read_bytes_per_sec"] = float64(delta(sample.DiskReadBytes, prev.DiskReadBytes)) / dt
-			metrics["disk_write_bytes_per_sec"] = float64(delta(sample.DiskWriteBytes, prev.DiskWriteBytes)) / dt
-		}
-	}
-	if families.Net && prevFamilies.Net {
-		metrics["net_rx_bytes_per_sec"] = float64(delta(sample.NetRxBytes, prev.NetRxBytes)) / dt
-		metrics["net_tx_bytes_per_sec"] = float64(delta(sample.NetTxBytes, prev.NetTxBytes)) / dt
-	}
-
-	alerts := make([]alert.Alert, 0)
+	emitted := make([]anomaly.Anomaly, 0)
 	for name, value := range metrics {
-		zScoreAnomaly := e.detector.Check(name, value)
-		staticAnomaly := anomaly.CheckStaticThreshold(name, value, e.staticThresholds)
-		a := anomaly.SelectHigherSeverity(zScoreAnomaly, staticAnomaly)
+		a := e.evaluator.Evaluate(name, sample.Timestamp, value)
 		if a == nil {
 			continue
 		}
-		a.Timestamp = sample.Timestamp
-		a.TopCPUProcess = toAnomalyProcess(sample.TopCPUProcess)
-		a.TopMemProcess = toAnomalyProcess(sample.TopMemProcess)
+		if e.admit(a, sample) {
+			emitted = append(emitted, *a)
+		}
+	}
+	for _, a := range e.expressions.Check(sample.Timestamp, metrics) {
+		if e.admit(&a, sample) {
+			emitted = append(emitted, a)
+		}
+	}
+	sort.Slice(emitted, func(i, j int) bool { return emitted[i].Name < emitted[j].Name })
 
-		rank, ok := alert.SeverityRank(a.Severity)
-		if !ok || rank < e.minRank {
+	// Track every alert first so alerts from the same sample all see the
+	// incident once a second metric joins it.
+	incidents := make([]*anomaly.Incident, len(emitted))
+	for i, a := range emitted {
+		if a.RuleType == anomaly.RuleTypeChangePoint {
 			continue
 		}
-		if e.cooldown > 0 {
-			if last, ok := e.lastSent[name]; ok && sample.Timestamp.Sub(last) < e.cooldown {
-				continue
-			}
-			e.lastSent[name] = sample.Timestamp
+		incidents[i] = e.incidents.Add(a)
+		e.explainer.ExplainIncident(incidents[i])
+	}
+	alerts := make([]alert.Alert, 0, len(emitted))
+	for i, a := range emitted {
+		al := alert.FromAnomaly(a, sample.HostID, sample.Labels)
+		if inc := incidents[i]; inc != nil && inc.Correlated() {
+			al.Incident = alert.NewIncidentRef(inc)
 		}
+		alerts = append(alerts, al)
+	}
+	return alert.Nest(alerts)
+}
+
+// admit stamps a with the sample's context and reports whether it passes the
+// minimum severity and cooldown for its metric (or expression rule), was not
+// raised during warm-up, and no active silence matches it. Admitted anomalies
+// are explained with the sample's context in place. Held-back and silenced
+// anomalies do not start a cooldown.
+func (e *Engine) admit(a *anomaly.Anomaly, sample collector.MetricSample) bool {
+	a.Timestamp = sample.Timestamp
+	a.HostID = sample.HostID
+	a.Labels = sample.Labels
+	a.TopCPUProcess = toAnomalyProcess(sample.TopCPUProcess)
+	a.TopMemProcess = toAnomalyProcess(sample.TopMemProcess)
 
-		alerts = append(alerts, alert.Alert{
-			Timestamp:     sample.Timestamp,
-			HostID:        sample.HostID,
-			Labels:        sample.Labels,
-			Metric:        a.Name,
-			Value:         a.Value,
-			RuleType:      a.RuleType,
-			Threshold:     a.Threshold,
-			Mean:          a.Mean,
-			Stddev:        a.Stddev,
-			ZScore:        a.ZScore,
-			Severity:      a.Severity,
-			Explanation:   a.Explanation,
-			TopCPUProcess: a.TopCPUProcess,
-			TopMemProcess: a.TopMemProcess,
-		})
-	}
-
-	return alerts
+	if a.Warmup {
+		return false
+	}
+	minRank, ok := e.minRanks[a.Name]
+	if !ok {
+		minRank = e.minRank
+	}
+	rank, ok := e.scale.Rank(a.Severity)
+	if !ok || rank < minRank {
+		return false
+	}
+	if e.silences.Match(a) != "" {
+		return false
+	}
+	cooldown, ok := e.cooldowns[a.Name]
+	if !ok {
+		cooldown = e.cooldown
+	}
+	if cooldown > 0 {
+		if last, ok := e.lastSent[a.Name]; ok && sample.Timestamp.Sub(last) < cooldown {
+			return false
+		}
+		e.lastSent[a.Name] = sample.Timestamp
+	}
+	e.explainer.Explain(a)
+	return true
 }
 
-func delta(current, previous uint64) uint64 {
-	if current < previous {
-		return 0
+func parseMinSeverity(scale severity.Scale, level string) (int, error) {
+	level, err := scale.Parse(level)
+	if err != nil {
+		return 0, err
 	}
-	return current - previous
+	rank, _ := scale.Rank(level)
+	return rank, nil
 }
 
 func toAnomalyProcess(p *collector.ProcessAttribution) *anomaly.ProcessAttribution {
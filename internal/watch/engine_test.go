@@ -1,10 +1,19 @@
 package watch
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/alert"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/explain"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/report"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/silence"
 )
 
 func TestEngine_EmitsAlert(t *testing.T) {
@@ -155,3 +164,454 @@ func TestEngine_EmitsStaticThresholdAlert(t *testing.T) {
 		t.Fatalf("expected threshold 50, got %v", alerts[0].Threshold)
 	}
 }
+
+func TestEngine_SustainedStaticThresholdWaitsForDuration(t *testing.T) {
+	engine, err := NewEngineWithOptions(EngineOptions{
+		WindowSize: 5,
+		Threshold:  10,
+		Rules: anomaly.Rules{
+			StaticThresholds: map[string]float64{"cpu_percent": 85},
+			Sustain:          map[string]anomaly.Sustain{"cpu_percent": {For: 2 * time.Minute}},
+		},
+		MinSeverity: "low",
+	})
+	if err != nil {
+		t.Fatalf("NewEngineWithOptions: %v", err)
+	}
+
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	observe := func(offset time.Duration, cpu float64) []alert.Alert {
+		return engine.Observe(collector.MetricSample{Timestamp: base.Add(offset), CPUPercent: cpu})
+	}
+	observe(0, 10)
+	// A short spike is not enough.
+	if alerts := observe(30*time.Second, 95); len(alerts) != 0 {
+		t.Fatalf("did not expect alert for a single spike: %+v", alerts)
+	}
+	observe(time.Minute, 10)
+	for offset := 90 * time.Second; offset < 210*time.Second; offset += 30 * time.Second {
+		if alerts := observe(offset, 95); len(alerts) != 0 {
+			t.Fatalf("did not expect alert before 2m at %s: %+v", offset, alerts)
+		}
+	}
+	alerts := observe(210*time.Second, 95)
+	if len(alerts) != 1 || alerts[0].Condition == nil {
+		t.Fatalf("expected one sustained alert, got %+v", alerts)
+	}
+	if got := alerts[0].Condition; !got.Start.Equal(base.Add(90*time.Second)) || got.DurationSeconds != 120 {
+		t.Fatalf("unexpected condition: %+v", got)
+	}
+}
+
+func stateTestSample(base time.Time, i int, cpu float64) collector.MetricSample {
+	return collector.MetricSample{
+		Timestamp:       base.Add(time.Duration(i) * time.Second),
+		HostID:          "host-1",
+		CPUPercent:      cpu,
+		MemUsedPercent:  20,
+		DiskUsedPercent: 30,
+	}
+}
+
+func TestEngine_RestoreResumesBaseline(t *testing.T) {
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	first, err := NewEngine(5, 3.0, nil, "low", time.Hour)
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	values := []float64{10, 11, 9, 10, 12, 10}
+	for i, v := range values {
+		first.Observe(stateTestSample(base, i, v))
+	}
+	first.lastSent["cpu_percent"] = base
+
+	state, err := first.Checkpoint(base.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := SaveState(path, state); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	loaded, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	second, err := NewEngine(5, 3.0, nil, "low", 0)
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	if err := second.Restore(loaded, "host-1", base.Add(2*time.Minute), time.Hour); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if !second.lastSent["cpu_percent"].Equal(base) {
+		t.Fatalf("expected cooldown timestamps to be restored, got %v", second.lastSent)
+	}
+	// A fresh engine would only seed on this sample; a restored one alerts.
+	var gotCPU bool
+	for _, a := range second.Observe(stateTestSample(base, len(values), 60)) {
+		gotCPU = gotCPU || a.Metric == "cpu_percent"
+	}
+	if !gotCPU {
+		t.Fatal("expected restored engine to alert on the first sample")
+	}
+}
+
+func TestEngine_RestoreRejectsStaleOrMismatchedState(t *testing.T) {
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	engine, err := NewEngine(5, 3.0, nil, "low", 0)
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	engine.Observe(stateTestSample(base, 0, 10))
+	state, err := engine.Checkpoint(base)
+	if err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+
+	fresh, _ := NewEngine(5, 3.0, nil, "low", 0)
+	if err := fresh.Restore(state, "host-1", base.Add(2*time.Hour), time.Hour); !errors.Is(err, ErrStateStale) {
+		t.Fatalf("expected ErrStateStale, got %v", err)
+	}
+	if err := fresh.Restore(state, "host-2", base, time.Hour); !errors.Is(err, ErrStateMismatch) {
+		t.Fatalf("expected ErrStateMismatch for another host, got %v", err)
+	}
+	other, _ := NewEngineWithOptions(EngineOptions{WindowSize: 5, Threshold: 3, Algorithms: anomaly.AlgorithmConfig{Default: anomaly.AlgorithmMAD}})
+	if err := other.Restore(state, "host-1", base, time.Hour); !errors.Is(err, ErrStateMismatch) {
+		t.Fatalf("expected ErrStateMismatch for another algorithm, got %v", err)
+	}
+	if fresh.prev != nil || other.prev != nil {
+		t.Fatal("rejected state must leave the engine untouched")
+	}
+
+	smoothed := func(spec string) *Engine {
+		e, err := NewEngineWithOptions(EngineOptions{WindowSize: 5, Threshold: 3, Algorithms: anomaly.AlgorithmConfig{Default: spec}})
+		if err != nil {
+			t.Fatalf("NewEngineWithOptions(%s): %v", spec, err)
+		}
+		return e
+	}
+	saved := smoothed("ewma:10m")
+	saved.Observe(stateTestSample(base, 0, 10))
+	state, err = saved.Checkpoint(base)
+	if err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	if err := smoothed("ewma:30m").Restore(state, "host-1", base, time.Hour); !errors.Is(err, ErrStateMismatch) {
+		t.Fatalf("expected ErrStateMismatch for another half-life, got %v", err)
+	}
+	if err := smoothed("ewma:600s").Restore(state, "host-1", base, time.Hour); err != nil {
+		t.Fatalf("expected the same half-life in other units to restore, got %v", err)
+	}
+}
+
+func TestEngine_WarmSeedsBaseline(t *testing.T) {
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	engine, err := NewEngine(5, 3.0, nil, "low", 0)
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	var history []collector.MetricSample
+	for i, v := range []float64{10, 11, 9, 10, 12, 10} {
+		history = append(history, stateTestSample(base, i, v))
+	}
+	engine.Warm(history)
+
+	var gotCPU bool
+	for _, a := range engine.Observe(stateTestSample(base, len(history), 60)) {
+		gotCPU = gotCPU || a.Metric == "cpu_percent"
+	}
+	if !gotCPU {
+		t.Fatal("expected warmed engine to alert on the first observed sample")
+	}
+}
+
+func TestEngine_GroupsCoOccurringAlertsIntoIncident(t *testing.T) {
+	engine, err := NewEngineWithOptions(EngineOptions{
+		WindowSize: 5,
+		Threshold:  3,
+		Rules: anomaly.Rules{StaticThresholds: map[string]float64{
+			"cpu_percent":      90,
+			"mem_used_percent": 90,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("NewEngineWithOptions: %v", err)
+	}
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	backup := &collector.ProcessAttribution{PID: 42, Name: "backup", CPUPercent: 80, RSSBytes: 1 << 30}
+	var got []alert.Alert
+	for i := 0; i < 4; i++ {
+		cpu, mem := 10.0, 20.0
+		if i == 3 {
+			cpu, mem = 95, 95
+		}
+		got = engine.Observe(collector.MetricSample{
+			Timestamp:      base.Add(time.Duration(i) * time.Second),
+			HostID:         "host-1",
+			CPUPercent:     cpu,
+			MemUsedPercent: mem,
+			MetricFamilies: &collector.MetricFamilies{CPU: true, Mem: true},
+			TopCPUProcess:  backup,
+			TopMemProcess:  backup,
+		})
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected one incident alert, got %+v", got)
+	}
+	lead := got[0]
+	if lead.Incident == nil || len(lead.Related) != 1 {
+		t.Fatalf("expected an incident with one related alert, got %+v", lead)
+	}
+	if lead.Incident.LikelyCause == nil || lead.Incident.LikelyCause.Name != "backup" {
+		t.Fatalf("expected backup as likely cause, got %+v", lead.Incident)
+	}
+	if lead.Related[0].Incident == nil || lead.Related[0].Incident.ID != lead.Incident.ID {
+		t.Fatalf("expected related alert to reference the incident, got %+v", lead.Related[0])
+	}
+}
+
+func TestEngine_LongSustainedIncidentStaysBounded(t *testing.T) {
+	engine, err := NewEngineWithOptions(EngineOptions{
+		WindowSize: 5,
+		Threshold:  3,
+		Cooldown:   30 * time.Second,
+		Rules: anomaly.Rules{StaticThresholds: map[string]float64{
+			"cpu_percent":      90,
+			"mem_used_percent": 90,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("NewEngineWithOptions: %v", err)
+	}
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	backup := &collector.ProcessAttribution{PID: 42, Name: "backup", CPUPercent: 80, RSSBytes: 1 << 30}
+	// A breach sustained for a day keeps one incident open: every alert
+	// arrives within the incident window of the previous one.
+	alerts := 0
+	var first, last alert.Alert
+	for i := 0; i < 8640; i++ {
+		for _, a := range engine.Observe(collector.MetricSample{
+			Timestamp:      base.Add(time.Duration(i) * 10 * time.Second),
+			HostID:         "host-1",
+			CPUPercent:     95,
+			MemUsedPercent: 95,
+			MetricFamilies: &collector.MetricFamilies{CPU: true, Mem: true},
+			TopCPUProcess:  backup,
+			TopMemProcess:  backup,
+		}) {
+			if alerts == 0 {
+				first = a
+			}
+			alerts += 1 + len(a.Related)
+			last = a
+		}
+	}
+	if last.Incident == nil || first.Incident == nil || last.Incident.ID != first.Incident.ID {
+		t.Fatalf("expected one incident open since the first breach, got %+v", last.Incident)
+	}
+	if want := fmt.Sprintf("the top process in %d of %d anomalies", alerts, alerts); !strings.Contains(last.Incident.Explanation, want) {
+		t.Fatalf("expected %q in %q", want, last.Incident.Explanation)
+	}
+	// The tracker keeps counts, not the anomalies themselves.
+	inc := engine.incidents.Add(anomaly.Anomaly{Name: "cpu_percent", HostID: "host-1", Timestamp: base.Add(24 * time.Hour)})
+	if inc.ID != first.Incident.ID || len(inc.Anomalies) != 0 {
+		t.Fatalf("expected the open incident without retained anomalies, got %d anomalies", len(inc.Anomalies))
+	}
+}
+
+func TestEngine_PerMetricCooldownAndMinSeverity(t *testing.T) {
+	engine, err := NewEngineWithOptions(EngineOptions{
+		WindowSize: 5,
+		Threshold:  3,
+		Rules: anomaly.Rules{StaticThresholds: map[string]float64{
+			"cpu_percent":      40,
+			"mem_used_percent": 40,
+		}},
+		MinSeverity:   "low",
+		Cooldown:      time.Hour,
+		MinSeverities: map[string]string{"mem_used_percent": "critical"},
+		Cooldowns:     map[string]time.Duration{"cpu_percent": 0},
+	})
+	if err != nil {
+		t.Fatalf("NewEngineWithOptions: %v", err)
+	}
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	counts := map[string]int{}
+	for i, v := range []float64{10, 50, 50, 50} {
+		alerts := engine.Observe(collector.MetricSample{
+			Timestamp:      base.Add(time.Duration(i) * 10 * time.Second),
+			HostID:         "host-1",
+			CPUPercent:     v,
+			MemUsedPercent: v,
+			MetricFamilies: &collector.MetricFamilies{CPU: true, Mem: true},
+		})
+		for _, a := range alerts {
+			counts[a.Metric]++
+			for _, r := range a.Related {
+				counts[r.Metric]++
+			}
+		}
+	}
+	if counts["cpu_percent"] != 3 {
+		t.Fatalf("expected cpu_percent to alert on every breach without cooldown, got %d", counts["cpu_percent"])
+	}
+	if counts["mem_used_percent"] != 0 {
+		t.Fatalf("expected mem_used_percent alerts below critical to be dropped, got %d", counts["mem_used_percent"])
+	}
+
+	if _, err := NewEngineWithOptions(EngineOptions{MinSeverities: map[string]string{"cpu_percent": "urgent"}}); err == nil {
+		t.Fatal("expected error for unknown per-metric severity")
+	}
+}
+
+func TestEngine_EmitsExpressionAlerts(t *testing.T) {
+	rule, err := anomaly.ParseExpressionRule("memory_pressure", "high", "cpu_percent > 85 && mem_used_percent > 90", func(name string) (string, bool) { return name, true })
+	if err != nil {
+		t.Fatalf("ParseExpressionRule: %v", err)
+	}
+	engine, err := NewEngineWithOptions(EngineOptions{
+		WindowSize:  5,
+		Threshold:   3,
+		Rules:       anomaly.Rules{Expressions: []anomaly.ExpressionRule{rule}},
+		MinSeverity: "low",
+		Cooldown:    time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewEngineWithOptions: %v", err)
+	}
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	var got []alert.Alert
+	for i := 0; i < 4; i++ {
+		got = append(got, engine.Observe(collector.MetricSample{
+			Timestamp:      base.Add(time.Duration(i) * 10 * time.Second),
+			HostID:         "host-1",
+			CPUPercent:     90,
+			MemUsedPercent: 95,
+			MetricFamilies: &collector.MetricFamilies{CPU: true, Mem: true},
+		})...)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected one expression alert within the cooldown, got %+v", got)
+	}
+	a := got[0]
+	if a.Metric != "memory_pressure" || a.RuleType != anomaly.RuleTypeExpression || a.Expression == nil || a.HostID != "host-1" {
+		t.Fatalf("unexpected alert: %+v", a)
+	}
+}
+
+func TestEngine_ExplainsAlertsInLocale(t *testing.T) {
+	explainer, err := explain.New("es", nil)
+	if err != nil {
+		t.Fatalf("explain.New: %v", err)
+	}
+	engine, err := NewEngineWithOptions(EngineOptions{
+		WindowSize:  5,
+		Threshold:   3,
+		Rules:       anomaly.Rules{StaticThresholds: map[string]float64{"cpu_percent": 90, "mem_used_percent": 90}},
+		MinSeverity: "low",
+		Explainer:   explainer,
+	})
+	if err != nil {
+		t.Fatalf("NewEngineWithOptions: %v", err)
+	}
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	var alerts []alert.Alert
+	for i, v := range []float64{10, 20, 95} {
+		alerts = engine.Observe(collector.MetricSample{Timestamp: base.Add(time.Duration(i) * time.Second), CPUPercent: v})
+	}
+	if len(alerts) != 1 || alerts[0].Metric != "cpu_percent" {
+		t.Fatalf("expected one cpu alert, got %+v", alerts)
+	}
+	want := "Umbral superado para Uso de CPU: 95.0% está por encima de 90.0% (5.6% por encima). " + alerts[0].Hint
+	if alerts[0].Explanation != want || !strings.HasPrefix(alerts[0].Hint, "Revise procesos") {
+		t.Fatalf("unexpected explanation: %q", alerts[0].Explanation)
+	}
+
+	// Incident summaries are localized too.
+	alerts = engine.Observe(collector.MetricSample{Timestamp: base.Add(3 * time.Second), CPUPercent: 95, MemUsedPercent: 95})
+	if len(alerts) != 1 || alerts[0].Incident == nil {
+		t.Fatalf("expected one incident alert, got %+v", alerts)
+	}
+	if got := alerts[0].Incident.Explanation; !strings.HasPrefix(got, "Uso de CPU y Uso de memoria variaron a la vez; no se atribuyó ningún proceso") {
+		t.Fatalf("unexpected incident summary: %q", got)
+	}
+}
+
+func TestEngine_SilencesSuppressAlertsWithoutStartingCooldown(t *testing.T) {
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	end := base.Add(3 * time.Second)
+	silences := silence.List{{ID: "backup", Start: base, End: &end, Matchers: map[string]string{"metric": "cpu", "env": "prod"}}}
+	if err := silences.Compile(); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	engine, err := NewEngineWithOptions(EngineOptions{
+		WindowSize:  5,
+		Threshold:   3,
+		Rules:       anomaly.Rules{StaticThresholds: map[string]float64{"cpu_percent": 90}},
+		MinSeverity: "low",
+		Cooldown:    time.Minute,
+		Silences:    silences,
+	})
+	if err != nil {
+		t.Fatalf("NewEngineWithOptions: %v", err)
+	}
+	observe := func(i int) []alert.Alert {
+		return engine.Observe(collector.MetricSample{
+			Timestamp:  base.Add(time.Duration(i) * time.Second),
+			Labels:     map[string]string{"env": "prod"},
+			CPUPercent: 95,
+		})
+	}
+	observe(0)
+	for i := 1; i < 3; i++ {
+		if alerts := observe(i); len(alerts) != 0 {
+			t.Fatalf("expected silenced alerts to be dropped, got %+v", alerts)
+		}
+	}
+	if alerts := observe(3); len(alerts) != 1 || alerts[0].SilencedBy != "" {
+		t.Fatalf("expected an alert once the silence expired, got %+v", alerts)
+	}
+
+	engine.SetSilences(silence.List{{ID: "all"}})
+	if alerts := observe(120); len(alerts) != 0 {
+		t.Fatalf("expected replaced silences to apply, got %+v", alerts)
+	}
+}
+
+func TestEngine_WarmupHoldsBackAlertsLikeAnalyze(t *testing.T) {
+	base := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	cpu := []float64{10, 11, 9, 10, 12, 10, 60, 10, 11, 9, 10, 12, 11, 10, 60}
+	samples := make([]collector.MetricSample, len(cpu))
+	for i, v := range cpu {
+		samples[i] = stateTestSample(base, i, v)
+	}
+	rules := anomaly.Rules{Warmup: anomaly.Warmup{Samples: 8}}
+	engine, err := NewEngineWithOptions(EngineOptions{WindowSize: 5, Threshold: 3, Rules: rules, MinSeverity: "low"})
+	if err != nil {
+		t.Fatalf("NewEngineWithOptions: %v", err)
+	}
+	var emitted []time.Time
+	for _, s := range samples {
+		for _, a := range engine.Observe(s) {
+			emitted = append(emitted, a.Timestamp)
+		}
+	}
+	if len(emitted) != 1 || !emitted[0].Equal(samples[14].Timestamp) {
+		t.Fatalf("expected only the spike after warm-up to alert, got %v", emitted)
+	}
+
+	result := report.AnalyzeWithOptions(samples, report.Options{WindowSize: 5, Threshold: 3, Rules: rules})
+	var held, open []time.Time
+	for _, a := range result.Anomalies {
+		if a.Warmup {
+			held = append(held, a.Timestamp)
+		} else {
+			open = append(open, a.Timestamp)
+		}
+	}
+	if len(held) != 1 || !held[0].Equal(samples[6].Timestamp) || len(open) != 1 || !open[0].Equal(emitted[0]) {
+		t.Fatalf("expected analyze to hold back the same spike watch did, got held %v open %v", held, open)
+	}
+}
@@ -3,11 +3,13 @@ package watch
 import (
 	"context"
 	"errors"
+	"fmt"
 	"reflect"
 	"time"
 
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/alert"
 	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/silence"
 )
 
 type Sampler interface {
@@ -27,6 +29,16 @@ type Runner struct {
 	Duration time.Duration
 
 	Writer SampleWriter // optional
+
+	// StatePath, when set, receives an engine checkpoint every
+	// CheckpointInterval (every sample when zero) and when Run returns.
+	StatePath          string
+	CheckpointInterval time.Duration
+
+	// Silences, when set, is re-read before each sample so silences added
+	// or expired with `epagent silence` apply without a restart. A file that
+	// fails to load keeps the previous silences.
+	Silences *silence.Reloader
 }
 
 func (r *Runner) Run(ctx context.Context) error {
@@ -54,15 +66,21 @@ func (r *Runner) Run(ctx context.Context) error {
 		deadline = time.Now().Add(r.Duration)
 	}
 
+	var lastCheckpoint time.Time
 	for {
 		if !deadline.IsZero() && time.Now().After(deadline) {
-			return nil
+			return r.checkpoint()
 		}
 
 		sample, err := r.Sampler.Sample(ctx)
 		if err != nil {
 			return err
 		}
+		if r.Silences != nil {
+			if changed, err := r.Silences.Reload(); err == nil && changed {
+				r.Engine.SetSilences(r.Silences.List())
+			}
+		}
 		if !isNilInterface(r.Writer) {
 			if err := r.Writer.Write(sample); err != nil {
 				return err
@@ -75,14 +93,35 @@ func (r *Runner) Run(ctx context.Context) error {
 			}
 		}
 
+		if r.StatePath != "" && time.Since(lastCheckpoint) >= r.CheckpointInterval {
+			if err := r.checkpoint(); err != nil {
+				return err
+			}
+			lastCheckpoint = time.Now()
+		}
+
 		select {
 		case <-ctx.Done():
-			return nil
+			return r.checkpoint()
 		case <-ticker.C:
 		}
 	}
 }
 
+func (r *Runner) checkpoint() error {
+	if r.StatePath == "" {
+		return nil
+	}
+	state, err := r.Engine.Checkpoint(time.Now())
+	if err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	if err := SaveState(r.StatePath, state); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	return nil
+}
+
 func isNilInterface(v any) bool {
 	if v == nil {
 		return true
@@ -2,6 +2,7 @@ package watch
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -54,3 +55,32 @@ func TestRunner_IgnoresTypedNilWriter(t *testing.T) {
 		t.Fatalf("Run: %v", err)
 	}
 }
+
+func TestRunner_CheckpointsOnExit(t *testing.T) {
+	engine, err := NewEngine(5, 3.0, nil, "critical", 0)
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "state", "watch.json")
+	r := &Runner{
+		Sampler:            &cancelingSampler{cancel: cancel},
+		Engine:             engine,
+		Sink:               noopSink{},
+		Interval:           time.Second,
+		StatePath:          path,
+		CheckpointInterval: time.Hour,
+	}
+	if err := r.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	state, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if state.Prev == nil || state.Prev.CPUPercent != 10 {
+		t.Fatalf("expected checkpoint with previous sample, got %+v", state.Prev)
+	}
+}
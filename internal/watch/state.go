@@ -0,0 +1,177 @@
+package watch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/anomaly"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/collector"
+	"github.com/sarveshkapre/endpoint-perf-agent/internal/fsutil"
+)
+
+// StateVersion is the version of State written by this build.
+const StateVersion = 1
+
+var (
+	// ErrStateStale is returned by Restore when the state is older than the
+	// allowed age.
+	ErrStateStale = errors.New("state is too old")
+	// ErrStateMismatch is returned by Restore when the state was written by a
+	// watch with a different detector configuration or host.
+	ErrStateMismatch = errors.New("state was written with a different configuration")
+)
+
+// State is a checkpoint of an Engine: detector baselines, cooldown timestamps
+// and the previous sample, so a restarted watch resumes without relearning.
+type State struct {
+	SchemaVersion int                     `json:"schema_version"`
+	SavedAt       time.Time               `json:"saved_at"`
+	Fingerprint   string                  `json:"fingerprint"`
+	HostID        string                  `json:"host_id"`
+	Evaluator     anomaly.EvaluatorState  `json:"evaluator"`
+	LastSent      map[string]time.Time    `json:"last_sent,omitempty"`
+	Prev          *collector.MetricSample `json:"prev,omitempty"`
+}
+
+// Checkpoint captures the engine state at now.
+func (e *Engine) Checkpoint(now time.Time) (State, error) {
+	evaluator, err := e.evaluator.Snapshot()
+	if err != nil {
+		return State{}, err
+	}
+	s := State{
+		SchemaVersion: StateVersion,
+		SavedAt:       now.UTC(),
+		Fingerprint:   e.fingerprint,
+		Evaluator:     evaluator,
+		LastSent:      make(map[string]time.Time, len(e.lastSent)),
+	}
+	for k, v := range e.lastSent {
+		s.LastSent[k] = v
+	}
+	if e.prev != nil {
+		prev := *e.prev
+		s.Prev = &prev
+		s.HostID = prev.HostID
+	}
+	return s, nil
+}
+
+// Restore loads a checkpoint taken by a previous run for hostID. State older
+// than maxAge (when maxAge > 0), written for another host or with a different
+// window, threshold or algorithm selection is rejected and the engine is left
+// untouched.
+func (e *Engine) Restore(s State, hostID string, now time.Time, maxAge time.Duration) error {
+	if s.SchemaVersion > StateVersion {
+		return fmt.Errorf("state has schema_version %d; this build reads up to %d", s.SchemaVersion, StateVersion)
+	}
+	if maxAge > 0 && now.Sub(s.SavedAt) > maxAge {
+		return fmt.Errorf("%w: saved %s ago (max %s)", ErrStateStale, now.Sub(s.SavedAt).Round(time.Second), maxAge)
+	}
+	if s.Fingerprint != e.fingerprint {
+		return fmt.Errorf("%w: detector settings changed", ErrStateMismatch)
+	}
+	if s.HostID != hostID {
+		return fmt.Errorf("%w: host %q, expected %q", ErrStateMismatch, s.HostID, hostID)
+	}
+	if err := e.evaluator.Restore(s.Evaluator); err != nil {
+		return err
+	}
+	for k, v := range s.LastSent {
+		e.lastSent[k] = v
+	}
+	if s.Prev != nil {
+		prev := *s.Prev
+		e.prev = &prev
+	}
+	return nil
+}
+
+// Warm feeds historical samples (oldest first) into the baselines without
+// alerting, as if the engine had observed them.
+func (e *Engine) Warm(samples []collector.MetricSample) {
+	for i := range samples {
+		sample := samples[i]
+		for name, value := range collector.DeriveMetrics(e.prev, sample) {
+			e.evaluator.Learn(name, sample.Timestamp, value)
+		}
+		e.prev = &sample
+	}
+}
+
+// LoadState reads a checkpoint written by SaveState.
+func LoadState(path string) (State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return State{}, err
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return State{}, fmt.Errorf("watch state %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// SaveState writes a checkpoint atomically (see fsutil.WriteFileAtomic).
+func SaveState(path string, s State) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(path, append(data, '\n'), 0o644)
+}
+
+// stateFingerprint identifies the settings a baseline depends on. Rule
+// changes do not invalidate state; detector window, threshold, algorithm and
+// half-life changes do, since a smoothed baseline restored under another
+// half-life would decay at the wrong rate.
+func stateFingerprint(windowSize int, threshold float64, algorithms anomaly.AlgorithmConfig) string {
+	parts := []string{
+		"window=" + strconv.Itoa(windowSize),
+		"threshold=" + strconv.FormatFloat(threshold, 'g', -1, 64),
+		"default=" + algorithmFingerprint(algorithms.For("")),
+	}
+	metrics := make([]string, 0, len(algorithms.PerMetric))
+	for metric := range algorithms.PerMetric {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	for _, metric := range metrics {
+		parts = append(parts, metric+"="+algorithmFingerprint(algorithms.For(metric)))
+	}
+	overridden := make([]string, 0, len(algorithms.MetricParams))
+	for metric := range algorithms.MetricParams {
+		overridden = append(overridden, metric)
+	}
+	sort.Strings(overridden)
+	base := anomaly.Params{WindowSize: windowSize, Threshold: threshold}
+	for _, metric := range overridden {
+		p := algorithms.ParamsFor(metric, base)
+		parts = append(parts, fmt.Sprintf("%s:window=%d,threshold=%s", metric, p.WindowSize, strconv.FormatFloat(p.Threshold, 'g', -1, 64)))
+	}
+	return strings.Join(parts, ";")
+}
+
+// algorithmFingerprint writes the half-life of an "ewma:10m" spec as a
+// canonical duration, so "ewma:600s" restores state saved under "ewma:10m".
+func algorithmFingerprint(spec string) string {
+	name, rawHalfLife, ok := strings.Cut(spec, ":")
+	if !ok {
+		return spec
+	}
+	halfLife, err := time.ParseDuration(strings.TrimSpace(rawHalfLife))
+	if err != nil {
+		return spec
+	}
+	return name + ":" + halfLife.String()
+}